@@ -57,6 +57,7 @@ type HandlersAdmin struct {
 	OsqueryTables   []types.OsqueryTable
 	AdminConfig     *types.JSONConfigurationAdmin
 	DBLogger        *logging.LoggerDB
+	Alerts          *logging.AlertManager
 }
 
 type HandlersOption func(*HandlersAdmin)
@@ -198,6 +199,12 @@ func WithDBLogger(dbfile string, config *backend.JSONConfigurationDB) HandlersOp
 	}
 }
 
+func WithAlerts(alerts *logging.AlertManager) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.Alerts = alerts
+	}
+}
+
 // CreateHandlersAdmin to initialize the Admin handlers struct
 func CreateHandlersAdmin(opts ...HandlersOption) *HandlersAdmin {
 	h := &HandlersAdmin{}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// JSONAlertsHandler for alerts raised by detection rules in one environment in JSON
+func (h *HandlersAdmin) JSONAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricJSONReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		log.Info().Msg("environment is missing")
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		log.Err(err).Msgf("error getting environment %s", envVar)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey(sessions.CtxSession)).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Extract parameter for limit
+	// If parameter is not present or invalid, it defaults to 100 items
+	limit := logging.DefaultAlertsLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	// Get alerts
+	alerts, err := h.Alerts.GetByEnv(env.Name, limit)
+	if err != nil {
+		log.Err(err).Msg("error getting alerts")
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, alerts)
+	h.Inc(metricJSONOK)
+}
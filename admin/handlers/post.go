@@ -10,6 +10,7 @@ import (
 	"net/http"
 
 	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
@@ -603,9 +604,73 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		h.Inc(metricAdminErr)
 		return
 	}
+	if c.Rollout != "" {
+		// Start, promote or abort a staged rollout
+		var err error
+		switch c.Rollout {
+		case environments.RolloutStart:
+			_, err = h.Envs.StartRollout(env.UUID, 0, c.Percentage, c.Tag, ctx[sessions.CtxUser])
+		case environments.RolloutPromote:
+			_, err = h.Envs.PromoteRollout(env.UUID, ctx[sessions.CtxUser])
+		case environments.RolloutAbort:
+			_, err = h.Envs.AbortRollout(env.UUID, ctx[sessions.CtxUser])
+		default:
+			err = fmt.Errorf("invalid rollout action %s", c.Rollout)
+		}
+		if err != nil {
+			adminErrorResponse(w, "error with rollout", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Send response
+		if h.Settings.DebugService(settings.ServiceAdmin) {
+			log.Debug().Msg("DebugService: Rollout response sent")
+		}
+		adminOKResponse(w, "rollout "+c.Rollout+" successful")
+		h.Inc(metricAdminOK)
+		return
+	}
+	if c.Performance != "" {
+		// Enable or disable collecting the performance of scheduled queries, recorded as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], "performance collection "+c.Performance+"d", func(e *environments.Environment) error {
+			switch c.Performance {
+			case environments.SchedulePerfEnable:
+				return e.EnableSchedulePerf(env.UUID, c.Interval)
+			case environments.SchedulePerfDisable:
+				return e.DisableSchedulePerf(env.UUID)
+			}
+			return fmt.Errorf("invalid performance action %s", c.Performance)
+		}); err != nil {
+			adminConfErrorResponse(w, "error with performance collection", err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Send response
+		if h.Settings.DebugService(settings.ServiceAdmin) {
+			log.Debug().Msg("DebugService: Performance response sent")
+		}
+		adminOKResponse(w, "performance collection "+c.Performance+"d")
+		h.Inc(metricAdminOK)
+		return
+	}
+	if c.Rollback > 0 {
+		// Restore configuration from a prior revision
+		rev, err := h.Envs.RollbackRevision(env.UUID, c.Rollback, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error rolling back configuration", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Send response
+		if h.Settings.DebugService(settings.ServiceAdmin) {
+			log.Debug().Msg("DebugService: Rollback response sent")
+		}
+		adminOKResponse(w, fmt.Sprintf("configuration rolled back to revision %d as revision %d", c.Rollback, rev.Revision))
+		h.Inc(metricAdminOK)
+		return
+	}
 	if c.ConfigurationB64 != "" {
 		// Base64 decode received configuration
-		// TODO verify configuration
 		configuration, err := base64.StdEncoding.DecodeString(c.ConfigurationB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding configuration", http.StatusInternalServerError, err)
@@ -619,15 +684,20 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update configuration
-		if err := h.Envs.UpdateConfiguration(env.UUID, cnf); err != nil {
-			adminErrorResponse(w, "error saving configuration", http.StatusInternalServerError, err)
+		// Validate configuration before saving any of it
+		if err := h.Envs.ValidateConf(cnf); err != nil {
+			adminConfErrorResponse(w, "invalid configuration", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update all configuration parts
-		if err := h.Envs.UpdateConfigurationParts(env.UUID, cnf); err != nil {
-			adminErrorResponse(w, "error saving configuration parts", http.StatusInternalServerError, err)
+		// Update configuration and all configuration parts, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdateConfiguration(env.UUID, cnf); err != nil {
+				return err
+			}
+			return e.UpdateConfigurationParts(env.UUID, cnf)
+		}); err != nil {
+			adminConfErrorResponse(w, "error saving configuration", err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -641,22 +711,26 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 	}
 	if c.OptionsB64 != "" {
 		// Base64 decode received options
-		// TODO verify options
 		options, err := base64.StdEncoding.DecodeString(c.OptionsB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding options", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update options
-		if err := h.Envs.UpdateOptions(env.UUID, string(options)); err != nil {
-			adminErrorResponse(w, "error saving options", http.StatusInternalServerError, err)
+		// Validate options in the context of the current configuration
+		if err := h.Envs.ValidatePart(env, environments.SectionOptions, options); err != nil {
+			adminConfErrorResponse(w, "invalid options", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
-			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
+		// Update options and full configuration, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdateOptions(env.UUID, string(options)); err != nil {
+				return err
+			}
+			return e.RefreshConfiguration(env.UUID)
+		}); err != nil {
+			adminErrorResponse(w, "error saving options", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -669,7 +743,6 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if c.ScheduleB64 != "" {
-		// TODO verify schedule
 		// Decode received configuration
 		schedule, err := base64.StdEncoding.DecodeString(c.ScheduleB64)
 		if err != nil {
@@ -677,15 +750,20 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update schedule
-		if err := h.Envs.UpdateSchedule(env.UUID, string(schedule)); err != nil {
-			adminErrorResponse(w, "error saving schedule", http.StatusInternalServerError, err)
+		// Validate schedule in the context of the current configuration
+		if err := h.Envs.ValidatePart(env, environments.SectionSchedule, schedule); err != nil {
+			adminConfErrorResponse(w, "invalid schedule", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
-			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
+		// Update schedule and full configuration, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdateSchedule(env.UUID, string(schedule)); err != nil {
+				return err
+			}
+			return e.RefreshConfiguration(env.UUID)
+		}); err != nil {
+			adminErrorResponse(w, "error saving schedule", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -698,7 +776,6 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if c.PacksB64 != "" {
-		// TODO verify packs
 		// Base64 decode received packs
 		packs, err := base64.StdEncoding.DecodeString(c.PacksB64)
 		if err != nil {
@@ -706,15 +783,20 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update packs
-		if err := h.Envs.UpdatePacks(env.UUID, string(packs)); err != nil {
-			adminErrorResponse(w, "error saving packs", http.StatusInternalServerError, err)
+		// Validate packs in the context of the current configuration
+		if err := h.Envs.ValidatePart(env, environments.SectionPacks, packs); err != nil {
+			adminConfErrorResponse(w, "invalid packs", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
-			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
+		// Update packs and full configuration, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdatePacks(env.UUID, string(packs)); err != nil {
+				return err
+			}
+			return e.RefreshConfiguration(env.UUID)
+		}); err != nil {
+			adminErrorResponse(w, "error saving packs", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -728,22 +810,26 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 	}
 	if c.DecoratorsB64 != "" {
 		// Base64 decode received options
-		// TODO verify decorators
 		decorators, err := base64.StdEncoding.DecodeString(c.DecoratorsB64)
 		if err != nil {
 			adminErrorResponse(w, "error decoding decorators", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update decorators
-		if err := h.Envs.UpdateDecorators(env.UUID, string(decorators)); err != nil {
-			adminErrorResponse(w, "error saving decorators", http.StatusInternalServerError, err)
+		// Validate decorators in the context of the current configuration
+		if err := h.Envs.ValidatePart(env, environments.SectionDecorators, decorators); err != nil {
+			adminConfErrorResponse(w, "invalid decorators", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
-			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
+		// Update decorators and full configuration, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdateDecorators(env.UUID, string(decorators)); err != nil {
+				return err
+			}
+			return e.RefreshConfiguration(env.UUID)
+		}); err != nil {
+			adminErrorResponse(w, "error saving decorators", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -756,7 +842,6 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if c.ATCB64 != "" {
-		// TODO verify ATC
 		// Base64 decode received ATC
 		schedule, err := base64.StdEncoding.DecodeString(c.ATCB64)
 		if err != nil {
@@ -764,15 +849,20 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update ATC
-		if err := h.Envs.UpdateATC(env.UUID, string(schedule)); err != nil {
-			adminErrorResponse(w, "error saving ATC", http.StatusInternalServerError, err)
+		// Validate ATC in the context of the current configuration
+		if err := h.Envs.ValidatePart(env, environments.SectionATC, schedule); err != nil {
+			adminConfErrorResponse(w, "invalid ATC", err)
 			h.Inc(metricAdminErr)
 			return
 		}
-		// Update full configuration
-		if err := h.Envs.RefreshConfiguration(env.UUID); err != nil {
-			adminErrorResponse(w, "error updating configuration", http.StatusInternalServerError, err)
+		// Update ATC and full configuration, recording them as a new revision
+		if _, err := h.Envs.WithRevision(env.UUID, ctx[sessions.CtxUser], c.Comment, func(e *environments.Environment) error {
+			if err := e.UpdateATC(env.UUID, string(schedule)); err != nil {
+				return err
+			}
+			return e.RefreshConfiguration(env.UUID)
+		}); err != nil {
+			adminErrorResponse(w, "error saving ATC", http.StatusInternalServerError, err)
 			h.Inc(metricAdminErr)
 			return
 		}
@@ -1022,6 +1112,39 @@ func (h *HandlersAdmin) NodeActionsPOSTHandler(w http.ResponseWriter, r *http.Re
 			h.Inc(metricAdminErr)
 			return
 		}
+	case "move":
+		dest, err := h.Envs.Get(m.Environment)
+		if err != nil {
+			adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		okCount := 0
+		errCount := 0
+		for _, u := range m.UUIDs {
+			node, err := h.Nodes.GetByUUID(u)
+			if err != nil {
+				errCount++
+				log.Err(err).Msgf("error getting node %s", u)
+				continue
+			}
+			if node.EnvironmentID == dest.ID {
+				continue
+			}
+			if err := h.Tags.MoveNode(node, dest.Name, dest.ID, ctx[sessions.CtxUser]); err != nil {
+				errCount++
+				log.Err(err).Msgf("error moving node %s", u)
+				continue
+			}
+			okCount++
+		}
+		if errCount == 0 {
+			adminOKResponse(w, fmt.Sprintf("%d Node(s) have been moved to %s successfully", okCount, dest.Name))
+		} else {
+			adminErrorResponse(w, fmt.Sprintf("Error moving %d node(s)", errCount), http.StatusInternalServerError, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
@@ -1094,12 +1217,86 @@ func (h *HandlersAdmin) EnvsPOSTHandler(w http.ResponseWriter, r *http.Request)
 				h.Inc(metricAdminErr)
 				return
 			}
+			// Record initial configuration revision
+			if _, err := h.Envs.CommitRevision(env.UUID, ctx[sessions.CtxUser], "initial configuration"); err != nil {
+				adminErrorResponse(w, "error recording revision", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
 			adminOKResponse(w, "environment created successfully")
 		} else {
 			adminOKResponse(w, "invalid environment")
 			h.Inc(metricAdminErr)
 			return
 		}
+	case "clone", "import":
+		var tpl environments.EnvTemplate
+		var source environments.TLSEnvironment
+		var err error
+		hostname := c.Hostname
+		if c.Action == "clone" {
+			source, err = h.Envs.Get(c.Source)
+			if err != nil {
+				adminErrorResponse(w, "error getting source environment", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			tpl, err = h.envTemplate(source)
+			if err != nil {
+				adminErrorResponse(w, "error exporting source environment", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			if hostname == "" {
+				hostname = source.Hostname
+			}
+		} else {
+			tpl, err = environments.ParseTemplate([]byte(c.Template))
+			if err != nil {
+				adminErrorResponse(w, "invalid template", http.StatusBadRequest, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		env, err := h.Envs.CreateFromTemplate(tpl, c.Name, hostname)
+		if err != nil {
+			adminConfErrorResponse(w, "error creating environment", err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Generate full permissions for the user creating the environment
+		access := h.Users.GenEnvUserAccess([]string{env.UUID}, true, true, true, true)
+		perms := h.Users.GenPermissions(ctx[sessions.CtxUser], "osctrl-admin", access)
+		if err := h.Users.CreatePermissions(perms); err != nil {
+			adminErrorResponse(w, "error generating permissions", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if c.Action == "clone" && c.Permissions {
+			if err := h.Users.CopyEnvPermissions(source, env, ctx[sessions.CtxUser]); err != nil {
+				adminErrorResponse(w, "error copying permissions", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		// Create a tag for this new environment and the tags from the template
+		if err := h.Tags.NewTag(env.Name, "Tag for environment "+env.Name, "", env.Icon, ctx[sessions.CtxUser], env.ID); err != nil {
+			adminErrorResponse(w, "error generating tag", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if err := h.Tags.CreateTagsEnv(templateAdminTags(tpl), env.ID, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error creating tags", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Record initial configuration revision
+		if _, err := h.Envs.CommitRevision(env.UUID, ctx[sessions.CtxUser], "initial configuration from "+tpl.Name); err != nil {
+			adminErrorResponse(w, "error recording revision", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "environment created successfully")
 	case "delete":
 		if h.Envs.Exists(c.Name) {
 			if err := h.Envs.Delete(c.Name); err != nil {
@@ -1673,6 +1870,26 @@ func (h *HandlersAdmin) EnrollPOSTHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	switch e.Action {
+	case "platform_flags":
+		if !environments.ValidFlagsPlatforms[e.Platform] {
+			adminErrorResponse(w, "invalid platform", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Empty flags remove the overrides of the platform
+		if strings.TrimSpace(e.Flags) == "" {
+			if err := h.Envs.DeletePlatformFlags(env.ID, e.Platform); err != nil {
+				adminErrorResponse(w, "error removing platform flags", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			break
+		}
+		if _, err := h.Envs.SetPlatformFlags(env.ID, e.Platform, e.Flags, ctx[sessions.CtxUser]); err != nil {
+			adminConfErrorResponse(w, "error saving platform flags", err)
+			h.Inc(metricAdminErr)
+			return
+		}
 	case "enroll_certificate":
 		if e.CertificateB64 == "" {
 			adminErrorResponse(w, "empty certificate", http.StatusInternalServerError, nil)
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"html/template"
 	"io"
 	"net/http"
@@ -646,6 +647,73 @@ func (h *HandlersAdmin) CarvesListGETHandler(w http.ResponseWriter, r *http.Requ
 	h.Inc(metricAdminOK)
 }
 
+// AlertsGETHandler for GET requests to see alerts raised by detection rules in an environment
+func (h *HandlersAdmin) AlertsGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		h.Inc(metricAdminErr)
+		log.Info().Msg("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting environment")
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey(sessions.CtxSession)).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Info().Msgf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "alerts.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting alerts template")
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting environments")
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting platforms")
+		return
+	}
+	// Prepare template data
+	templateData := AlertsTableTemplateData{
+		Title:        "Alerts in <b>" + env.Name + "</b>",
+		EnvUUID:      env.UUID,
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("template error")
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Debug().Msg("DebugService: Alerts template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
 // QueryLogsHandler for GET requests to see query results by name
 func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
@@ -916,12 +984,51 @@ func (h *HandlersAdmin) ConfGETHandler(w http.ResponseWriter, r *http.Request) {
 		log.Err(err).Msg("error getting platforms")
 		return
 	}
+	// Get configuration revisions
+	revisions, err := h.Envs.GetRevisions(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting revisions")
+		return
+	}
+	// Get pack sources
+	packSources, err := h.Envs.GetPackSources(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting pack sources")
+		return
+	}
+	// Get performance of scheduled queries
+	performance, err := h.Nodes.GetSchedulePerf(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting performance")
+		return
+	}
+	// Get active rollout and how nodes adopted the configuration
+	var canary, others nodes.ConfigAdoption
+	rollout, err := h.Envs.ActiveRollout(env.ID)
+	if err == nil {
+		canary, others, err = h.rolloutAdoption(env, rollout)
+		if err != nil {
+			h.Inc(metricAdminErr)
+			log.Err(err).Msg("error getting rollout adoption")
+			return
+		}
+	}
 	// Prepare template data
 	templateData := ConfTemplateData{
 		Title:        env.Name + " Configuration",
 		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
 		Environment:  env,
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Revisions:    revisions,
+		PackSources:  packSources,
+		PerfEnabled:  h.Envs.SchedulePerfEnabled(env),
+		Performance:  performance,
+		Rollout:      rollout,
+		Canary:       canary,
+		Others:       others,
 		Platforms:    platforms,
 	}
 	if err := t.Execute(w, templateData); err != nil {
@@ -1013,6 +1120,7 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 		QuickRemovePowershell: powershellQuickRemove,
 		Secret:                env.Secret,
 		Flags:                 env.Flags,
+		PlatformFlags:         h.platformFlags(env),
 		Certificate:           env.Certificate,
 		Environments:          h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:             platforms,
@@ -1104,6 +1212,10 @@ func (h *HandlersAdmin) EnrollDownloadHandler(w http.ResponseWriter, r *http.Req
 		description = "osctrl flags for " + env.Name + " (FreeBSD)"
 		fName = "osctrl-" + env.Name + ".flags"
 	}
+	// Flags for a platform include the overrides of the environment for that platform
+	if platform, ok := environments.DownloadFlagsPlatforms[targetVar]; ok {
+		toDownload = []byte(h.Envs.ApplyPlatformFlags(env.ID, platform, string(toDownload)))
+	}
 	utils.HTTPDownload(w, description, fName, int64(len(toDownload)))
 	w.WriteHeader(http.StatusOK)
 	h.Inc(metricAdminOK)
@@ -1111,6 +1223,50 @@ func (h *HandlersAdmin) EnrollDownloadHandler(w http.ResponseWriter, r *http.Req
 	h.Inc(metricAdminOK)
 }
 
+// EnvTemplateDownloadHandler for GET requests to download an environment as template
+func (h *HandlersAdmin) EnvTemplateDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		h.Inc(metricAdminErr)
+		log.Info().Msg("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.Get(envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error getting environment")
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey(sessions.CtxSession)).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		log.Info().Msgf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	tpl, err := h.envTemplate(env)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error exporting template")
+		return
+	}
+	toDownload, err := json.MarshalIndent(tpl, "", "  ")
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Err(err).Msg("error serializing template")
+		return
+	}
+	utils.HTTPDownload(w, "osctrl template for "+env.Name, "osctrl-"+env.Name+".template.json", int64(len(toDownload)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, bytes.NewReader(toDownload))
+	h.Inc(metricAdminOK)
+}
+
 // NodeHandler for node view
 func (h *HandlersAdmin) NodeHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
@@ -1218,6 +1374,7 @@ func (h *HandlersAdmin) NodeHandler(w http.ResponseWriter, r *http.Request) {
 		Metadata:      h.TemplateMetadata(ctx, h.ServiceVersion),
 		LeftMetadata:  leftMetadata,
 		Node:          node,
+		ConfigState:   nodes.ConfigState(node, h.nodeConfigHash(node, env)),
 		NodeTags:      nodeTags,
 		TagsForNode:   tags,
 		Environments:  h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
@@ -1610,11 +1767,23 @@ func (h *HandlersAdmin) DashboardGETHandler(w http.ResponseWriter, r *http.Reque
 		log.Err(err).Msg("error getting user")
 		return
 	}
+	// Get configuration adoption for allowed environments
+	allowedEnvs := h.allowedEnvironments(ctx[sessions.CtxUser], envAll)
+	adoption := make(map[string]nodes.ConfigAdoption)
+	for _, e := range allowedEnvs {
+		a, err := h.envAdoption(e)
+		if err != nil {
+			log.Err(err).Msgf("error getting adoption for %s", e.Name)
+			continue
+		}
+		adoption[e.UUID] = a
+	}
 	// Prepare template data
 	templateData := DashboardTemplateData{
 		Title:        "Dashboard for " + user.Username,
 		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
-		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Environments: allowedEnvs,
+		Adoption:     adoption,
 		Platforms:    platforms,
 		CurrentUser:  user,
 	}
@@ -1,5 +1,7 @@
 package handlers
 
+import "github.com/jmpsec/osctrl/environments"
+
 // LoginRequest to receive login credentials
 type LoginRequest struct {
 	Username string `json:"username"`
@@ -51,9 +53,10 @@ type DistributedCarvesActionRequest struct {
 
 // NodeMultiActionRequest to receive node action requests
 type NodeMultiActionRequest struct {
-	CSRFToken string   `json:"csrftoken"`
-	Action    string   `json:"action"`
-	UUIDs     []string `json:"uuids"`
+	CSRFToken   string   `json:"csrftoken"`
+	Action      string   `json:"action"`
+	UUIDs       []string `json:"uuids"`
+	Environment string   `json:"environment"`
 }
 
 // SettingsRequest to receive changes to settings
@@ -75,6 +78,13 @@ type ConfigurationRequest struct {
 	PacksB64         string `json:"packs"`
 	DecoratorsB64    string `json:"decorators"`
 	ATCB64           string `json:"atc"`
+	Comment          string `json:"comment"`
+	Rollback         int    `json:"rollback"`
+	Rollout          string `json:"rollout"`
+	Percentage       int    `json:"percentage"`
+	Tag              string `json:"tag"`
+	Performance      string `json:"performance"`
+	Interval         int    `json:"interval"`
 }
 
 // EnrollRequest to receive changes to enroll certificates
@@ -83,6 +93,8 @@ type EnrollRequest struct {
 	Action         string `json:"action"`
 	CertificateB64 string `json:"certificate"`
 	PackageURL     string `json:"packageurl"`
+	Platform       string `json:"platform"`
+	Flags          string `json:"flags"`
 }
 
 // IntervalsRequest to receive changes to intervals
@@ -102,14 +114,17 @@ type ExpirationRequest struct {
 
 // EnvironmentsRequest to receive changes to environments
 type EnvironmentsRequest struct {
-	CSRFToken string `json:"csrftoken"`
-	Action    string `json:"action"`
-	UUID      string `json:"uuid"`
-	Name      string `json:"name"`
-	Hostname  string `json:"hostname"`
-	Type      string `json:"type"`
-	Icon      string `json:"icon"`
-	DebugHTTP bool   `json:"debughttp"`
+	CSRFToken   string `json:"csrftoken"`
+	Action      string `json:"action"`
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	Hostname    string `json:"hostname"`
+	Type        string `json:"type"`
+	Icon        string `json:"icon"`
+	DebugHTTP   bool   `json:"debughttp"`
+	Source      string `json:"source"`
+	Template    string `json:"template"`
+	Permissions bool   `json:"permissions"`
 }
 
 // UsersRequest to receive user action requests
@@ -157,7 +172,8 @@ type PermissionsRequest struct {
 
 // AdminResponse to be returned to requests
 type AdminResponse struct {
-	Message string `json:"message"`
+	Message string                     `json:"message"`
+	Errors  []environments.ConfigIssue `json:"errors,omitempty"`
 }
 
 // TokenRequest to receive API token related requests
@@ -62,6 +62,13 @@ type ConfTemplateData struct {
 	Title        string
 	Environment  environments.TLSEnvironment
 	Environments []environments.TLSEnvironment
+	Revisions    []environments.ConfigRevision
+	PackSources  []environments.PackSource
+	PerfEnabled  bool
+	Performance  []nodes.SchedulePerf
+	Rollout      environments.ConfigRollout
+	Canary       nodes.ConfigAdoption
+	Others       nodes.ConfigAdoption
 	Platforms    []string
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
@@ -92,6 +99,7 @@ type EnrollTemplateData struct {
 	QuickRemovePowershell string
 	Secret                string
 	Flags                 string
+	PlatformFlags         []environments.PlatformFlags
 	Certificate           string
 	Environments          []environments.TLSEnvironment
 	Platforms             []string
@@ -136,6 +144,9 @@ type SavedQueriesTemplateData GenericTableTemplateData
 // CarvesTableTemplateData for passing data to the carves template
 type CarvesTableTemplateData GenericTableTemplateData
 
+// AlertsTableTemplateData for passing data to the alerts template
+type AlertsTableTemplateData GenericTableTemplateData
+
 // CarvesDetailsTemplateData for passing data to the carves details
 type CarvesDetailsTemplateData struct {
 	Title        string
@@ -208,6 +219,7 @@ type ProfileTemplateData struct {
 type DashboardTemplateData struct {
 	Title        string
 	Environments []environments.TLSEnvironment
+	Adoption     map[string]nodes.ConfigAdoption
 	Platforms    []string
 	CurrentUser  users.AdminUser
 	Metadata     TemplateMetadata
@@ -229,6 +241,7 @@ type NodeTemplateData struct {
 	Title         string
 	EnvUUID       string
 	Node          nodes.OsqueryNode
+	ConfigState   string
 	NodeTags      []tags.AdminTag
 	TagsForNode   []tags.AdminTagForNode
 	Environments  []environments.TLSEnvironment
@@ -6,14 +6,17 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -32,6 +35,17 @@ func adminErrorResponse(w http.ResponseWriter, msg string, code int, err error)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, code, AdminResponse{Message: msg})
 }
 
+// Helper to handle admin error responses for configurations, including validation issues
+func adminConfErrorResponse(w http.ResponseWriter, msg string, err error) {
+	var verr *environments.ValidationError
+	if errors.As(err, &verr) {
+		log.Err(err).Msgf("%d:%s", http.StatusBadRequest, msg)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusBadRequest, AdminResponse{Message: msg, Errors: verr.Issues})
+		return
+	}
+	adminErrorResponse(w, msg, http.StatusInternalServerError, err)
+}
+
 // Helper to handle admin ok responses
 func adminOKResponse(w http.ResponseWriter, msg string) {
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, AdminResponse{Message: msg})
@@ -208,3 +222,132 @@ func (h *HandlersAdmin) generateFlags(flagsRaw, secretFile, certFile string) str
 	replaced := strings.Replace(flagsRaw, "__SECRET_FILE__", secretFile, 1)
 	return strings.Replace(replaced, "__CERT_FILE__", certFile, 1)
 }
+
+// Helper to get the flags overrides of an environment for all platforms, empty if a platform has none
+func (h *HandlersAdmin) platformFlags(env environments.TLSEnvironment) []environments.PlatformFlags {
+	existing, err := h.Envs.GetPlatformFlags(env.ID)
+	if err != nil {
+		log.Err(err).Msg("error getting platform flags")
+	}
+	byPlatform := make(map[string]environments.PlatformFlags)
+	for _, f := range existing {
+		byPlatform[f.Platform] = f
+	}
+	res := make([]environments.PlatformFlags, 0, len(environments.FlagsPlatforms))
+	for _, p := range environments.FlagsPlatforms {
+		f, ok := byPlatform[p]
+		if !ok {
+			f = environments.PlatformFlags{EnvironmentID: env.ID, Platform: p}
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// Helper to split the nodes of an environment between the canary of a rollout and the remaining nodes
+func (h *HandlersAdmin) rolloutNodes(env environments.TLSEnvironment, rollout environments.ConfigRollout) ([]nodes.OsqueryNode, []nodes.OsqueryNode, error) {
+	nds, err := h.Nodes.GetByNodeSelector(nodes.NodeSelector{EnvironmentID: env.ID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting nodes %v", err)
+	}
+	tagged := make(map[uint]bool)
+	if rollout.Tag != "" {
+		ids, err := h.Tags.GetTaggedNodeIDs(rollout.Tag, env.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting tagged nodes %v", err)
+		}
+		for _, id := range ids {
+			tagged[id] = true
+		}
+	}
+	var canary, others []nodes.OsqueryNode
+	for _, n := range nds {
+		if rollout.Selects(n.UUID, tagged[n.ID]) {
+			canary = append(canary, n)
+		} else {
+			others = append(others, n)
+		}
+	}
+	return canary, others, nil
+}
+
+// Helper to summarize the configuration adoption of canary and remaining nodes in a rollout
+func (h *HandlersAdmin) rolloutAdoption(env environments.TLSEnvironment, rollout environments.ConfigRollout) (nodes.ConfigAdoption, nodes.ConfigAdoption, error) {
+	canary, others, err := h.rolloutNodes(env, rollout)
+	if err != nil {
+		return nodes.ConfigAdoption{}, nodes.ConfigAdoption{}, err
+	}
+	canaryAdoption := nodes.Adoption(canary, environments.ExpectedConfigHash(env), rollout.CreatedAt)
+	othersAdoption := nodes.Adoption(others, rollout.BaseHash, rollout.CreatedAt)
+	return canaryAdoption, othersAdoption, nil
+}
+
+// Helper to summarize the configuration adoption of nodes in an environment since its last change
+// During a rollout, nodes outside of the canary are expected to keep the configuration of the base revision
+func (h *HandlersAdmin) envAdoption(env environments.TLSEnvironment) (nodes.ConfigAdoption, error) {
+	var since time.Time
+	if rev, err := h.Envs.LatestRevision(env.ID); err == nil {
+		since = rev.CreatedAt
+	}
+	rollout, err := h.Envs.ActiveRollout(env.ID)
+	if err != nil {
+		return h.Nodes.GetAdoption(env.ID, environments.ExpectedConfigHash(env), since)
+	}
+	canary, others, err := h.rolloutNodes(env, rollout)
+	if err != nil {
+		return nodes.ConfigAdoption{}, err
+	}
+	canaryAdoption := nodes.Adoption(canary, environments.ExpectedConfigHash(env), since)
+	return canaryAdoption.Add(nodes.Adoption(others, rollout.BaseHash, since)), nil
+}
+
+// Helper to get the config_hash expected from a node, which is the base revision during a rollout unless the node is part of its canary
+func (h *HandlersAdmin) nodeConfigHash(node nodes.OsqueryNode, env environments.TLSEnvironment) string {
+	rollout, err := h.Envs.ActiveRollout(env.ID)
+	if err != nil {
+		return environments.ExpectedConfigHash(env)
+	}
+	tagged := rollout.Tag != "" && h.Tags.IsTaggedID(rollout.Tag, node.ID)
+	if rollout.Selects(node.UUID, tagged) {
+		return environments.ExpectedConfigHash(env)
+	}
+	return rollout.BaseHash
+}
+
+// Helper to export an environment as template, including its tags but not the tag of the environment itself
+func (h *HandlersAdmin) envTemplate(env environments.TLSEnvironment) (environments.EnvTemplate, error) {
+	tpl, err := h.Envs.ExportTemplate(env)
+	if err != nil {
+		return tpl, err
+	}
+	envTags, err := h.Tags.GetByEnv(env.ID)
+	if err != nil {
+		return tpl, fmt.Errorf("error getting tags %v", err)
+	}
+	for _, t := range envTags {
+		if t.Name == env.Name {
+			continue
+		}
+		tpl.Tags = append(tpl.Tags, environments.TemplateTag{
+			Name:        t.Name,
+			Description: t.Description,
+			Color:       t.Color,
+			Icon:        t.Icon,
+		})
+	}
+	return tpl, nil
+}
+
+// Helper to convert the tags of a template to be created
+func templateAdminTags(tpl environments.EnvTemplate) []tags.AdminTag {
+	var res []tags.AdminTag
+	for _, t := range tpl.Tags {
+		res = append(res, tags.AdminTag{
+			Name:        t.Name,
+			Description: t.Description,
+			Color:       t.Color,
+			Icon:        t.Icon,
+		})
+	}
+	return res
+}
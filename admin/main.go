@@ -18,6 +18,7 @@ import (
 	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
@@ -102,6 +103,8 @@ const (
 	defaultExpiration int = 900
 	// Default hours to classify nodes as inactive
 	defaultInactive int = -72
+	// Default interval in seconds to check for pack sources due to be fetched
+	defaultPackSources int = 60
 )
 
 // osquery
@@ -158,6 +161,8 @@ var (
 	jwtConfigFile        string
 	osqueryTablesFile    string
 	osqueryTablesVersion string
+	packSourcesDir       string
+	packSourcesHosts     string
 	loggerFile           string
 	loggerDbSame         bool
 	staticFilesFolder    string
@@ -520,6 +525,20 @@ func init() {
 			EnvVars:     []string{"OSQUERY_TABLES"},
 			Destination: &osqueryTablesFile,
 		},
+		&cli.StringFlag{
+			Name:        "pack-sources-dir",
+			Value:       "",
+			Usage:       "Allow query packs to be loaded from local paths inside `DIR`",
+			EnvVars:     []string{"PACK_SOURCES_DIR"},
+			Destination: &packSourcesDir,
+		},
+		&cli.StringFlag{
+			Name:        "pack-sources-hosts",
+			Value:       "",
+			Usage:       "Allow query packs to be loaded from URLs of these comma separated `HOSTS`",
+			EnvVars:     []string{"PACK_SOURCES_HOSTS"},
+			Destination: &packSourcesHosts,
+		},
 		&cli.StringFlag{
 			Name:        "logger-file",
 			Aliases:     []string{"F"},
@@ -680,6 +699,13 @@ func osctrlAdminService() {
 	tagsmgr = tags.CreateTagManager(db.Conn)
 	log.Info().Msg("Initialize environments")
 	envs = environments.CreateEnvironment(db.Conn)
+	// Queries in configurations are checked against the loaded osquery tables
+	tableNames := make([]string, 0, len(osqueryTables))
+	for _, t := range osqueryTables {
+		tableNames = append(tableNames, t.Name)
+	}
+	envs.Tables = environments.NewTableSet(tableNames)
+	envs.PackSources = environments.NewPackSourcePolicy(packSourcesDir, packSourcesHosts)
 	log.Info().Msg("Initialize settings")
 	settingsmgr = settings.NewSettings(db.Conn)
 	log.Info().Msg("Initialize nodes")
@@ -773,6 +799,24 @@ func osctrlAdminService() {
 		}
 	}()
 
+	// Goroutine to fetch pack sources and refresh configurations when packs change
+	log.Info().Msg("Initialize pack sources refresh")
+	go func() {
+		for {
+			if settingsmgr.DebugService(settings.ServiceAdmin) {
+				log.Debug().Msg("DebugService: Refreshing pack sources")
+			}
+			changed, err := envs.RefreshPackSources(serviceName)
+			if err != nil {
+				log.Err(err).Msg("Error refreshing pack sources")
+			}
+			if changed > 0 {
+				log.Info().Msgf("Updated %d packs from pack sources", changed)
+			}
+			time.Sleep(time.Duration(defaultPackSources) * time.Second)
+		}
+	}()
+
 	var loggerDBConfig *backend.JSONConfigurationDB
 	// Set the logger configuration file if we have a DB logger
 	if adminConfig.Logger == settings.LoggingDB {
@@ -804,6 +848,7 @@ func osctrlAdminService() {
 		handlers.WithCarvesFolder(carvedFilesFolder),
 		handlers.WithAdminConfig(&adminConfig),
 		handlers.WithDBLogger(loggerFile, loggerDBConfig),
+		handlers.WithAlerts(logging.CreateAlerts(db.Conn)),
 	)
 
 	// ////////////////////////// ADMIN
@@ -845,6 +890,8 @@ func osctrlAdminService() {
 	adminMux.Handle("GET /json/stats/{target}/{identifier}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONStatsHandler)))
 	// Admin: JSON data for tags
 	adminMux.Handle("GET /json/tags", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONTagsHandler)))
+	// Admin: JSON data for alerts
+	adminMux.Handle("GET /json/alerts/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONAlertsHandler)))
 	// Admin: table for environments
 	adminMux.Handle("GET /environment/{env}/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvironmentHandler)))
 	// Admin: table for platforms
@@ -881,6 +928,8 @@ func osctrlAdminService() {
 	adminMux.Handle("GET /carves/{env}/details/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesDetailsHandler)))
 	// Admin: carves download
 	adminMux.Handle("GET /carves/{env}/download/{sessionid}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesDownloadHandler)))
+	// Admin: alerts raised by detection rules
+	adminMux.Handle("GET /alerts/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.AlertsGETHandler)))
 	// Admin: nodes configuration
 	adminMux.Handle("GET /conf/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfGETHandler)))
 	adminMux.Handle("POST /conf/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfPOSTHandler)))
@@ -896,6 +945,7 @@ func osctrlAdminService() {
 	// Admin: manage environments
 	adminMux.Handle("GET /environments", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvsGETHandler)))
 	adminMux.Handle("POST /environments", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvsPOSTHandler)))
+	adminMux.Handle("GET /environments/{env}/template", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvTemplateDownloadHandler)))
 	// Admin: manage users
 	adminMux.Handle("GET /users", handlerAuthCheck(http.HandlerFunc(handlersAdmin.UsersGETHandler)))
 	adminMux.Handle("POST /users", handlerAuthCheck(http.HandlerFunc(handlersAdmin.UsersPOSTHandler)))
@@ -37,6 +37,64 @@ function saveATC() {
   saveB64Blob('atc_conf', 'atc', 'atc_header');
 }
 
+function confirmRollbackRevision(_revision) {
+  var modal_message = 'Are you sure you want to rollback the configuration to revision ' + _revision + '?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    rollbackRevision(_revision);
+  });
+  $("#confirmModal").modal();
+}
+
+function rollbackRevision(_revision) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    rollback: _revision,
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function startRollout() {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    rollout: 'start',
+    percentage: parseInt($("#rollout_percentage").val()) || 0,
+    tag: $("#rollout_tag").val(),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function confirmRolloutAction(_action) {
+  var modal_message = 'Are you sure you want to ' + _action + ' the rollout?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    rolloutAction(_action);
+  });
+  $("#confirmModal").modal();
+}
+
+function rolloutAction(_action) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    rollout: _action,
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
 function addQuerySchedule() {
   $("#addquery_action").click(function () {
     $("#addQueryModal").modal("hide");
@@ -97,3 +155,16 @@ function lineCharPosition(_pos) {
   });
   return 'line ' + line;
 }
+
+function schedulePerfAction(_action) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    performance: _action,
+    interval: parseInt($("#perf_interval").val()) || 0,
+  };
+  sendPostRequest(data, _url, _url, false);
+}
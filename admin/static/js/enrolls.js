@@ -96,3 +96,18 @@ function savePackage(_package, _action) {
   };
   sendPostRequest(data, _url, window.location.pathname, false);
 }
+
+function savePlatformFlags(_platform) {
+  var _csrftoken = $("#csrftoken").val();
+  var _flags = $("#platform-flags-" + _platform).val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: "platform_flags",
+    platform: _platform,
+    flags: _flags,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
@@ -11,6 +11,7 @@ function confirmCreateEnvironment() {
   var _type = $("#environment_type").val();
   var _hostname = $("#environment_host").val();
   var _icon = $("#environment_icon").val();
+  var _source = $("#environment_source").val();
 
   var data = {
     csrftoken: _csrftoken,
@@ -20,6 +21,25 @@ function confirmCreateEnvironment() {
     hostname: _hostname,
     icon: _icon,
   };
+  if (_source === '__template__') {
+    var _file = $("#environment_template")[0].files[0];
+    if (!_file) {
+      return;
+    }
+    var reader = new FileReader();
+    reader.onload = function (e) {
+      data.action = 'import';
+      data.template = e.target.result;
+      sendPostRequest(data, _url, _url, false);
+    };
+    reader.readAsText(_file);
+    return;
+  }
+  if (_source !== '') {
+    data.action = 'clone';
+    data.source = _source;
+    data.permissions = $("#environment_permissions").is(':checked');
+  }
   sendPostRequest(data, _url, _url, false);
 }
 
@@ -19,6 +19,11 @@ function sendGetRequest(req_url, _modal, _callback) {
       var _clientmsg = 'Client: ' + errorThrown;
       var _serverJSON = $.parseJSON(jqXhr.responseText);
       var _servermsg = 'Server: ' + _serverJSON.message;
+      if (_serverJSON.errors) {
+        _servermsg += ' - ' + _serverJSON.errors.map(function (e) {
+          return e.section + (e.key ? '.' + e.key : '') + ': ' + e.message;
+        }).join('; ');
+      }
       $("#errorModalMessageClient").text(_clientmsg);
       console.log(_clientmsg);
       $("#errorModalMessageServer").text(_servermsg);
@@ -53,6 +58,11 @@ function sendPostRequest(req_data, req_url, _redir, _modal, _callback) {
       var _clientmsg = 'Client: ' + errorThrown;
       var _serverJSON = $.parseJSON(jqXhr.responseText);
       var _servermsg = 'Server: ' + _serverJSON.message;
+      if (_serverJSON.errors) {
+        _servermsg += ' - ' + _serverJSON.errors.map(function (e) {
+          return e.section + (e.key ? '.' + e.key : '') + ': ' + e.message;
+        }).join('; ');
+      }
       $("#errorModalMessageClient").text(_clientmsg);
       console.log(_clientmsg);
       $("#errorModalMessageServer").text(_servermsg);
@@ -23,6 +23,28 @@ function removeNodes(_uuids) {
   sendPostRequest(data, _url, '/', true);
 }
 
+function moveNodes(_uuids) {
+  var _csrftoken = $("#csrftoken").val();
+  var _environment = $("#move_environment").val();
+
+  var _url = '/node/actions';
+  var data = {
+    csrftoken: _csrftoken,
+    uuids: _uuids,
+    action: 'move',
+    environment: _environment
+  };
+  sendPostRequest(data, _url, window.location, true);
+}
+
+function showMoveNodes(_uuids) {
+  $('#move_action').click(function () {
+    $('#moveModal').modal('hide');
+    moveNodes(_uuids);
+  });
+  $("#moveModal").modal();
+}
+
 function nodesView(environment) {
   window.location.href = '/environment/' + environment + '/active';
 }
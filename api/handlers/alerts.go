@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// AlertsHandler - GET Handler to return alerts raised by detection rules for one environment as JSON
+// Alerts can be filtered by rule or node with the rule and uuid parameters
+func (h *HandlersApi) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIAlertsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPIAlertsErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPIAlertsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPIAlertsErr)
+		return
+	}
+	// Extract parameter for limit
+	// If parameter is not present or invalid, it defaults to 100 items
+	limit := logging.DefaultAlertsLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	// Get alerts
+	var alerts []logging.DetectionAlert
+	if rule := r.URL.Query().Get("rule"); rule != "" {
+		alerts, err = h.Alerts.GetByRule(env.Name, rule, limit)
+	} else if uuid := r.URL.Query().Get("uuid"); uuid != "" {
+		alerts, err = h.Alerts.GetByNode(env.Name, uuid, limit)
+	} else {
+		alerts, err = h.Alerts.GetByEnv(env.Name, limit)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error getting alerts", http.StatusInternalServerError, err)
+		h.Inc(metricAPIAlertsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msg("DebugService: Returned alerts")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, alerts)
+	h.Inc(metricAPIAlertsOK)
+}
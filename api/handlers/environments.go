@@ -120,6 +120,8 @@ func (h *HandlersApi) EnvEnrollHandler(w http.ResponseWriter, r *http.Request) {
 		returnData = env.Certificate
 	case settings.DownloadFlags:
 		returnData = env.Flags
+	case settings.DownloadFlagsMac, settings.DownloadFlagsLinux, settings.DownloadFlagsWin, settings.DownloadFlagsFreeBSD:
+		returnData = h.Envs.ApplyPlatformFlags(env.ID, environments.DownloadFlagsPlatforms[targetVar], env.Flags)
 	case environments.EnrollShell:
 		returnData, err = environments.QuickAddOneLinerShell((env.Certificate != ""), env)
 		if err != nil {
@@ -313,6 +315,61 @@ func (h *HandlersApi) EnvEnrollActionsHandler(w http.ResponseWriter, r *http.Req
 			return
 		}
 		msgReturn = "RPM updated successfully"
+	case settings.ApprovalOn, settings.ApprovalOff:
+		if err := h.Envs.ChangeRequireApproval(env.UUID, actionVar == settings.ApprovalOn); err != nil {
+			apiErrorResponse(w, "error changing approval", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		msgReturn = "approval for enrollments disabled"
+		if actionVar == settings.ApprovalOn {
+			msgReturn = "approval for enrollments enabled"
+		}
+	case settings.ClientCertOn, settings.ClientCertOff:
+		if actionVar == settings.ClientCertOn && env.ClientCA == "" {
+			apiErrorResponse(w, "client CA is required", http.StatusBadRequest, nil)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		if err := h.Envs.ChangeRequireClientCert(env.UUID, actionVar == settings.ClientCertOn); err != nil {
+			apiErrorResponse(w, "error changing client certificates", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		msgReturn = "client certificates not required"
+		if actionVar == settings.ClientCertOn {
+			msgReturn = "client certificates required"
+		}
+	case settings.SetClientCA:
+		if !environments.ValidClientCA(e.ClientCA) {
+			apiErrorResponse(w, "invalid client CA", http.StatusBadRequest, nil)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		if err := h.Envs.UpdateClientCA(env.UUID, e.ClientCA); err != nil {
+			apiErrorResponse(w, "error setting client CA", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		msgReturn = "client CA updated successfully"
+	case settings.TokenNew:
+		token, err := h.Envs.NewEnrollToken(env.ID, e.MaxUses, e.ExpHours, e.Comment, ctx[ctxUser])
+		if err != nil {
+			apiErrorResponse(w, "error creating enrollment token", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		// Return the new token, it is the only time it is returned along with the action
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, token)
+		h.Inc(metricAPIEnvsOK)
+		return
+	case settings.TokenRevoke:
+		if err := h.Envs.DeleteEnrollToken(e.Token, env.ID); err != nil {
+			apiErrorResponse(w, "error revoking enrollment token", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		msgReturn = "enrollment token revoked"
 	default:
 		apiErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", actionVar))
 		h.Inc(metricAPIEnvsErr)
@@ -323,6 +380,49 @@ func (h *HandlersApi) EnvEnrollActionsHandler(w http.ResponseWriter, r *http.Req
 	h.Inc(metricAPIEnvsOK)
 }
 
+// EnvTokensHandler - GET Handler to return the enrollment tokens for an environment as JSON
+func (h *HandlersApi) EnvTokensHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error getting environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Get environment by name
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	tokens, err := h.Envs.GetEnrollTokens(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting enrollment tokens", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned enrollment tokens for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, tokens)
+	h.Inc(metricAPIEnvsOK)
+}
+
 // EnvRemoveActionsHandler - POST Handler to perform actions (extend, expire) in remove values
 func (h *HandlersApi) EnvRemoveActionsHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAPIQueriesReq)
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// EnvPlatformFlagsHandler - GET Handler to return the flags overrides per platform of an environment as JSON
+func (h *HandlersApi) EnvPlatformFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	flags, err := h.Envs.GetPlatformFlags(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting platform flags", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned %d platform flags for %s", len(flags), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, flags)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvPlatformFlagsActionHandler - POST Handler to set or delete the flags overrides of a platform in an environment
+func (h *HandlersApi) EnvPlatformFlagsActionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), true)
+	env, user, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var req types.ApiPlatformFlagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	if !environments.ValidFlagsPlatforms[req.Platform] {
+		apiErrorResponse(w, "invalid platform", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	switch action := r.PathValue("action"); action {
+	case environments.PlatformFlagsSet:
+		if strings.TrimSpace(req.Flags) == "" {
+			apiErrorResponse(w, "flags are required", http.StatusBadRequest, nil)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		flags, err := h.Envs.SetPlatformFlags(env.ID, req.Platform, req.Flags, user)
+		if err != nil {
+			apiErrorResponse(w, "error saving platform flags", http.StatusBadRequest, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		// Serialize and serve JSON
+		if h.Settings.DebugService(settings.ServiceAPI) {
+			log.Debug().Msgf("DebugService: Platform flags %s saved for %s", req.Platform, env.Name)
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, flags)
+	case environments.PlatformFlagsDelete:
+		if err := h.Envs.DeletePlatformFlags(env.ID, req.Platform); err != nil {
+			apiErrorResponse(w, "error deleting platform flags", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "flags for " + req.Platform + " deleted"})
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	h.Inc(metricAPIEnvsOK)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
@@ -47,6 +48,12 @@ const (
 	metricAPIPlatformsReq = "platforms-req"
 	metricAPIPlatformsErr = "platforms-err"
 	metricAPIPlatformsOK  = "platforms-ok"
+	metricAPIAlertsReq    = "alerts-req"
+	metricAPIAlertsErr    = "alerts-err"
+	metricAPIAlertsOK     = "alerts-ok"
+	metricAPILogsReq      = "logs-req"
+	metricAPILogsErr      = "logs-err"
+	metricAPILogsOK       = "logs-ok"
 )
 
 const errorContent = "❌"
@@ -66,6 +73,9 @@ type HandlersApi struct {
 	ServiceVersion string
 	ServiceName    string
 	ApiConfig      *types.JSONConfigurationAPI
+	Alerts         *logging.AlertManager
+	Results        *logging.LoggerDB
+	BulkSecret     []byte
 }
 
 type HandlersOption func(*HandlersApi)
@@ -142,6 +152,24 @@ func WithName(name string) HandlersOption {
 	}
 }
 
+func WithAlerts(alerts *logging.AlertManager) HandlersOption {
+	return func(h *HandlersApi) {
+		h.Alerts = alerts
+	}
+}
+
+func WithResults(results *logging.LoggerDB) HandlersOption {
+	return func(h *HandlersApi) {
+		h.Results = results
+	}
+}
+
+func WithBulkSecret(secret []byte) HandlersOption {
+	return func(h *HandlersApi) {
+		h.BulkSecret = secret
+	}
+}
+
 // CreateHandlersApi to initialize the Admin handlers struct
 func CreateHandlersApi(opts ...HandlersOption) *HandlersApi {
 	h := &HandlersApi{}
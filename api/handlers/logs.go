@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// ColumnFilters to parse filters of result logs by values of columns, each one formatted as column:value
+func ColumnFilters(filters []string) (map[string]string, error) {
+	columns := make(map[string]string, len(filters))
+	for _, f := range filters {
+		column, value, ok := strings.Cut(f, ":")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid column filter %s", f)
+		}
+		columns[column] = value
+	}
+	return columns, nil
+}
+
+// ResultLogsHandler - GET Handler to return result logs for one environment as JSON
+// Logs can be filtered with the uuid, name, action, since (seconds) and column (column:value) parameters
+func (h *HandlersApi) ResultLogsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPILogsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Result logs are only available with a results DB
+	if h.Results == nil {
+		apiErrorResponse(w, "result logs not configured", http.StatusNotFound, nil)
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	// Prepare filter from parameters
+	params := r.URL.Query()
+	columns, err := ColumnFilters(params["column"])
+	if err != nil {
+		apiErrorResponse(w, "error with column filter", http.StatusBadRequest, err)
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	filter := logging.ResultFilter{
+		UUID:    params.Get("uuid"),
+		Name:    params.Get("name"),
+		Action:  params.Get("action"),
+		Columns: columns,
+		Limit:   logging.DefaultResultsLimit,
+	}
+	// If parameter is not present or invalid, it defaults to 100 items and it can not exceed 1000 items
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		filter.Limit = min(l, logging.MaxResultsLimit)
+	}
+	// Only logs in the partitions of the last seconds are scanned
+	if s, err := strconv.Atoi(params.Get("since")); err == nil && s > 0 {
+		filter.Since = time.Now().Add(-time.Duration(s) * time.Second)
+	}
+	// Get result logs
+	results, err := h.Results.FilterResultLogs(env.Name, filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting result logs", http.StatusInternalServerError, err)
+		h.Inc(metricAPILogsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msg("DebugService: Returned result logs")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, results)
+	h.Inc(metricAPILogsOK)
+}
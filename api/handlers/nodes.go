@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/types"
@@ -248,3 +250,452 @@ func (h *HandlersApi) DeleteNodeHandler(w http.ResponseWriter, r *http.Request)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node deleted"})
 	h.Inc(metricAPINodesOK)
 }
+
+// MoveNodesHandler - POST Handler to move nodes to a different environment
+func (h *HandlersApi) MoveNodesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	var m types.ApiNodeMoveRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get destination environment and check access
+	dest, err := h.Envs.Get(m.Environment)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "destination environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting destination environment", http.StatusInternalServerError, err)
+		}
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, dest.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	if dest.ID == env.ID {
+		apiErrorResponse(w, "nodes are already in that environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Collect nodes to be moved, by UUID, tag or search term
+	var targets []nodes.OsqueryNode
+	for _, u := range m.UUIDs {
+		node, err := h.Nodes.GetByUUIDEnv(u, env.ID)
+		if err != nil {
+			apiErrorResponse(w, fmt.Sprintf("node %s not found", u), http.StatusNotFound, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		targets = append(targets, node)
+	}
+	if m.Tag != "" {
+		tagged, err := h.Tags.GetTaggedNodes(m.Tag, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "error getting tagged nodes", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		targets = append(targets, tagged...)
+	}
+	if m.Search != "" {
+		found, err := h.Nodes.SearchByEnv(env.Name, m.Search)
+		if err != nil {
+			apiErrorResponse(w, "error searching nodes", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		targets = append(targets, found...)
+	}
+	if len(targets) == 0 {
+		apiErrorResponse(w, "no nodes", http.StatusNotFound, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Move nodes, skipping duplicates
+	moved := make(map[uint]bool)
+	for _, n := range targets {
+		if moved[n.ID] {
+			continue
+		}
+		if err := h.Tags.MoveNode(n, dest.Name, dest.ID, ctx[ctxUser]); err != nil {
+			apiErrorResponse(w, fmt.Sprintf("error moving node %s", n.UUID), http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		moved[n.ID] = true
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Moved %d nodes from %s to %s", len(moved), env.Name, dest.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("%d node(s) moved to %s", len(moved), dest.Name)})
+	h.Inc(metricAPINodesOK)
+}
+
+// PendingNodesHandler - GET Handler for JSON nodes waiting for approval
+func (h *HandlersApi) PendingNodesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get pending nodes
+	pending, err := h.Nodes.GetPending(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msg("DebugService: Returned pending nodes")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, pending)
+	h.Inc(metricAPINodesOK)
+}
+
+// ApproveNodesHandler - POST Handler to approve pending nodes by UUID or hardware serial
+func (h *HandlersApi) ApproveNodesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	var a types.ApiNodeApproveRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	if len(a.Identifiers) == 0 {
+		apiErrorResponse(w, "identifiers are required", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	var approved int64
+	for _, i := range a.Identifiers {
+		n, err := h.Nodes.Approve(i, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "error approving nodes", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		approved += n
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Approved %d nodes in %s", approved, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("%d node(s) approved", approved)})
+	h.Inc(metricAPINodesOK)
+}
+
+// RotateNodeKeysHandler - POST Handler to rotate the node key of one node or all nodes in an environment
+func (h *HandlersApi) RotateNodeKeysHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	var k types.ApiNodeRotateRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	if k.Grace < 0 {
+		apiErrorResponse(w, "invalid grace window", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	grace := nodes.DefaultKeyGrace
+	if k.Grace > 0 {
+		grace = time.Duration(k.Grace) * time.Minute
+	}
+	var msg string
+	switch {
+	case k.All:
+		rotated, err := h.Nodes.RotateKeysByEnv(env.ID, grace)
+		if err != nil {
+			apiErrorResponse(w, "error rotating node keys", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		msg = fmt.Sprintf("%d node key(s) rotated", rotated)
+	case k.UUID != "":
+		node, err := h.Nodes.GetByUUIDEnv(k.UUID, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		if err := h.Nodes.RotateKey(node, grace); err != nil {
+			apiErrorResponse(w, "error rotating node key", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+		msg = "node key rotated"
+	default:
+		apiErrorResponse(w, "uuid or all is required", http.StatusBadRequest, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: %s in %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	h.Inc(metricAPINodesOK)
+}
+
+// bulkSelect - Helper to retrieve the nodes matching a bulk request selector
+func (h *HandlersApi) bulkSelect(envID uint, b types.ApiNodeBulkRequest) ([]nodes.OsqueryNode, error) {
+	sel := nodes.NodeSelector{
+		EnvironmentID: envID,
+		Platform:      b.Platform,
+		Inactive:      b.Inactive,
+	}
+	if b.Tag != "" {
+		ids, err := h.Tags.GetTaggedNodeIDs(b.Tag, envID)
+		if err != nil {
+			return []nodes.OsqueryNode{}, err
+		}
+		sel.Tagged = true
+		sel.IDs = ids
+	}
+	return h.Nodes.GetByNodeSelector(sel)
+}
+
+// bulkParse - Helper to parse and validate a bulk request for an environment
+func (h *HandlersApi) bulkParse(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, types.ApiNodeBulkRequest, string, bool) {
+	var b types.ApiNodeBulkRequest
+	// Extract environment
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error with environment", http.StatusBadRequest, nil)
+		return environments.TLSEnvironment{}, b, "", false
+	}
+	// Get environment
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return env, b, "", false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, b, "", false
+	}
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		return env, b, "", false
+	}
+	if !nodes.BulkActions[b.Action] {
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		return env, b, "", false
+	}
+	if b.Action == nodes.BulkTag || b.Action == nodes.BulkUntag || b.Action == nodes.BulkMove {
+		if b.Value == "" {
+			apiErrorResponse(w, "value is required for action "+b.Action, http.StatusBadRequest, nil)
+			return env, b, "", false
+		}
+	}
+	if b.Action == nodes.BulkMove {
+		dest, err := h.Envs.Get(b.Value)
+		if err != nil {
+			apiErrorResponse(w, "error getting destination environment", http.StatusNotFound, err)
+			return env, b, "", false
+		}
+		if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, dest.UUID) {
+			apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+			return env, b, "", false
+		}
+		if dest.ID == env.ID {
+			apiErrorResponse(w, "nodes are already in that environment", http.StatusBadRequest, nil)
+			return env, b, "", false
+		}
+	}
+	return env, b, ctx[ctxUser], true
+}
+
+// BulkPreviewNodesHandler - POST Handler to preview the nodes affected by a bulk action
+func (h *HandlersApi) BulkPreviewNodesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, b, _, ok := h.bulkParse(w, r)
+	if !ok {
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	targets, err := h.bulkSelect(env.ID, b)
+	if err != nil {
+		apiErrorResponse(w, "error selecting nodes", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	preview := types.ApiNodeBulkPreviewResponse{
+		Action: b.Action,
+		Count:  len(targets),
+		Token:  nodes.BulkToken(h.BulkSecret, b.Action, b.Value, targets, time.Now().Add(nodes.DefaultBulkTokenExpiration)),
+		UUIDs:  []string{},
+	}
+	for _, n := range targets {
+		preview.UUIDs = append(preview.UUIDs, n.UUID)
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Previewed %s for %d nodes", b.Action, preview.Count)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, preview)
+	h.Inc(metricAPINodesOK)
+}
+
+// BulkNodesHandler - POST Handler to execute a bulk action on the nodes matching a selector
+func (h *HandlersApi) BulkNodesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPINodesReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, b, user, ok := h.bulkParse(w, r)
+	if !ok {
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	targets, err := h.bulkSelect(env.ID, b)
+	if err != nil {
+		apiErrorResponse(w, "error selecting nodes", http.StatusInternalServerError, err)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	// The token from the preview must match and not be expired, otherwise the selection has changed
+	if !nodes.CheckBulkToken(h.BulkSecret, b.Token, b.Action, b.Value, targets, time.Now()) {
+		apiErrorResponse(w, "invalid or stale confirmation token, preview the action again", http.StatusConflict, nil)
+		h.Inc(metricAPINodesErr)
+		return
+	}
+	var dest environments.TLSEnvironment
+	if b.Action == nodes.BulkMove {
+		if dest, err = h.Envs.Get(b.Value); err != nil {
+			apiErrorResponse(w, "error getting destination environment", http.StatusInternalServerError, err)
+			h.Inc(metricAPINodesErr)
+			return
+		}
+	}
+	res := types.ApiNodeBulkResponse{
+		Action:  b.Action,
+		Count:   len(targets),
+		Results: []types.ApiNodeBulkResult{},
+	}
+	for _, n := range targets {
+		var err error
+		switch b.Action {
+		case nodes.BulkDelete:
+			err = h.Nodes.ArchiveDeleteByUUID(n.UUID)
+		case nodes.BulkTag:
+			err = h.Tags.TagNode(b.Value, n, user, false)
+		case nodes.BulkUntag:
+			err = h.Tags.UntagNode(b.Value, n)
+		case nodes.BulkMove:
+			err = h.Tags.MoveNode(n, dest.Name, dest.ID, user)
+		}
+		result := types.ApiNodeBulkResult{
+			UUID:     n.UUID,
+			Hostname: n.Hostname,
+			Success:  err == nil,
+		}
+		if err != nil {
+			result.Error = err.Error()
+			res.Failed++
+		}
+		res.Results = append(res.Results, result)
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Executed %s for %d nodes, %d failed", b.Action, res.Count, res.Failed)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPINodesOK)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// EnvPackSourcesHandler - GET Handler to return all the pack sources of an environment as JSON
+func (h *HandlersApi) EnvPackSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	sources, err := h.Envs.GetPackSources(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting pack sources", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned %d pack sources for %s", len(sources), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, sources)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvPackSourceActionHandler - POST Handler to add, delete or sync a pack source of an environment
+func (h *HandlersApi) EnvPackSourceActionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), true)
+	env, user, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var req types.ApiPackSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	if req.Name == "" {
+		apiErrorResponse(w, "pack name is required", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var src environments.PackSource
+	var err error
+	switch action := r.PathValue("action"); action {
+	case environments.PackSourceAdd:
+		if src, err = h.Envs.AddPackSource(env.ID, req.Name, req.Source, req.Interval, user); err != nil {
+			apiErrorResponse(w, "error adding pack source", http.StatusBadRequest, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		// Fetch errors are recorded in the pack source
+		if _, err := h.Envs.SyncPackSource(src, user); err != nil {
+			log.Err(err).Msgf("error syncing pack source %s", src.Name)
+		}
+	case environments.PackSourceSync:
+		if src, err = h.Envs.GetPackSource(env.ID, req.Name); err != nil {
+			apiErrorResponse(w, "pack source not found", http.StatusNotFound, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		if _, err := h.Envs.SyncPackSource(src, user); err != nil {
+			apiErrorResponse(w, "error syncing pack source", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+	case environments.PackSourceDelete:
+		if err := h.Envs.DeletePackSource(env.ID, req.Name); err != nil {
+			apiErrorResponse(w, "error deleting pack source", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "pack source " + req.Name + " deleted"})
+		h.Inc(metricAPIEnvsOK)
+		return
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Return the pack source with the result of the sync
+	if src, err = h.Envs.GetPackSource(env.ID, req.Name); err != nil {
+		apiErrorResponse(w, "error getting pack source", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Pack source %s for %s version %s", src.Name, env.Name, src.Version)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, src)
+	h.Inc(metricAPIEnvsOK)
+}
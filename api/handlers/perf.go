@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// EnvSchedulePerfHandler - GET Handler to return the performance of the scheduled queries of an environment
+func (h *HandlersApi) EnvSchedulePerfHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	perf, err := h.Nodes.GetSchedulePerf(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting performance", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	res := types.ApiSchedulePerfResponse{
+		Enabled: h.Envs.SchedulePerfEnabled(env),
+		Queries: make([]types.ApiSchedulePerf, 0, len(perf)),
+	}
+	for _, p := range perf {
+		res.Queries = append(res.Queries, types.ApiSchedulePerf(p))
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned performance of %d queries for %s", len(perf), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvSchedulePerfActionHandler - POST Handler to enable or disable collecting the performance of scheduled queries
+func (h *HandlersApi) EnvSchedulePerfActionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), true)
+	env, user, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var req types.ApiSchedulePerfRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+	}
+	action := r.PathValue("action")
+	if action != environments.SchedulePerfEnable && action != environments.SchedulePerfDisable {
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Update schedule, recorded as a new revision
+	if _, err := h.Envs.WithRevision(env.UUID, user, "performance collection "+action+"d", func(e *environments.Environment) error {
+		if action == environments.SchedulePerfEnable {
+			return e.EnableSchedulePerf(env.UUID, req.Interval)
+		}
+		return e.DisableSchedulePerf(env.UUID)
+	}); err != nil {
+		apiErrorResponse(w, "error updating schedule", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Performance collection %s for %s", r.PathValue("action"), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "performance collection " + r.PathValue("action") + "d"})
+	h.Inc(metricAPIEnvsOK)
+}
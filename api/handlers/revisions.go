@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// revisionEnvironment to extract the environment of a revisions request and check access
+// It writes the error response and returns false if the request can not continue
+func (h *HandlersApi) revisionEnvironment(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, string, bool) {
+	envVar := r.PathValue("env")
+	if envVar == "" {
+		apiErrorResponse(w, "error getting environment", http.StatusBadRequest, nil)
+		return environments.TLSEnvironment{}, "", false
+	}
+	// Get environment by UUID
+	env, err := h.Envs.GetByUUID(envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		return env, "", false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(ContextKey(contextAPI)).(ContextValue)
+	if !h.Users.CheckPermissions(ctx[ctxUser], users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, "", false
+	}
+	return env, ctx[ctxUser], true
+}
+
+// revisionByPath to retrieve the revision referenced by a path value
+func (h *HandlersApi) revisionByPath(w http.ResponseWriter, r *http.Request, env environments.TLSEnvironment, name string) (environments.ConfigRevision, bool) {
+	num, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || num <= 0 {
+		apiErrorResponse(w, "invalid revision", http.StatusBadRequest, err)
+		return environments.ConfigRevision{}, false
+	}
+	rev, err := h.Envs.GetRevision(env.ID, num)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "revision not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting revision", http.StatusInternalServerError, err)
+		}
+		return rev, false
+	}
+	return rev, true
+}
+
+// EnvRevisionsHandler - GET Handler to return all the configuration revisions of an environment as JSON
+func (h *HandlersApi) EnvRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	revisions, err := h.Envs.GetRevisions(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting revisions", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned revisions for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, revisions)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvRevisionHandler - GET Handler to return one configuration revision of an environment as JSON
+func (h *HandlersApi) EnvRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	rev, ok := h.revisionByPath(w, r, env, "rev")
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned revision %d for %s", rev.Revision, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rev)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvRevisionsDiffHandler - GET Handler to return the diff between two configuration revisions
+func (h *HandlersApi) EnvRevisionsDiffHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	from, ok := h.revisionByPath(w, r, env, "rev")
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	to, ok := h.revisionByPath(w, r, env, "to")
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned diff %d..%d for %s", from.Revision, to.Revision, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiDataResponse{Data: environments.DiffRevisions(from, to)})
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvRollbackHandler - POST Handler to roll back the configuration of an environment to a prior revision
+func (h *HandlersApi) EnvRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, user, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	rev, ok := h.revisionByPath(w, r, env, "rev")
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	newRev, err := h.Envs.RollbackRevision(env.UUID, rev.Revision, user)
+	if err != nil {
+		apiErrorResponse(w, "error rolling back configuration", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Rolled back %s to revision %d", env.Name, rev.Revision)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, newRev)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// rolloutNodes to split the nodes of an environment between the canary of a rollout and the remaining nodes
+func (h *HandlersApi) rolloutNodes(env environments.TLSEnvironment, rollout environments.ConfigRollout) ([]nodes.OsqueryNode, []nodes.OsqueryNode, error) {
+	nds, err := h.Nodes.GetByNodeSelector(nodes.NodeSelector{EnvironmentID: env.ID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting nodes %v", err)
+	}
+	tagged := make(map[uint]bool)
+	if rollout.Tag != "" {
+		ids, err := h.Tags.GetTaggedNodeIDs(rollout.Tag, env.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting tagged nodes %v", err)
+		}
+		for _, id := range ids {
+			tagged[id] = true
+		}
+	}
+	var canary, others []nodes.OsqueryNode
+	for _, n := range nds {
+		if rollout.Selects(n.UUID, tagged[n.ID]) {
+			canary = append(canary, n)
+		} else {
+			others = append(others, n)
+		}
+	}
+	return canary, others, nil
+}
+
+// rolloutStatus to summarize the configuration adoption of canary and remaining nodes in a rollout
+func (h *HandlersApi) rolloutStatus(env environments.TLSEnvironment, rollout environments.ConfigRollout) (types.ApiRolloutResponse, error) {
+	res := types.ApiRolloutResponse{
+		Status:       rollout.Status,
+		BaseRevision: rollout.BaseRevision,
+		Percentage:   rollout.Percentage,
+		Tag:          rollout.Tag,
+		CreatedBy:    rollout.CreatedBy,
+		Started:      rollout.CreatedAt,
+	}
+	canary, others, err := h.rolloutNodes(env, rollout)
+	if err != nil {
+		return res, err
+	}
+	res.Canary = types.ApiConfigAdoption(nodes.Adoption(canary, environments.ExpectedConfigHash(env), rollout.CreatedAt))
+	res.Others = types.ApiConfigAdoption(nodes.Adoption(others, rollout.BaseHash, rollout.CreatedAt))
+	return res, nil
+}
+
+// EnvRolloutHandler - GET Handler to return the status of the active configuration rollout of an environment
+func (h *HandlersApi) EnvRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	rollout, err := h.Envs.ActiveRollout(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "no active rollout", http.StatusNotFound, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	res, err := h.rolloutStatus(env, rollout)
+	if err != nil {
+		apiErrorResponse(w, "error getting rollout status", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned rollout status for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvRolloutsHandler - GET Handler to return all the configuration rollouts of an environment as JSON
+func (h *HandlersApi) EnvRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	rollouts, err := h.Envs.GetRollouts(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting rollouts", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned rollouts for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rollouts)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// EnvRolloutActionHandler - POST Handler to start, promote or abort a configuration rollout
+func (h *HandlersApi) EnvRolloutActionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, user, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var rollout environments.ConfigRollout
+	var err error
+	switch r.PathValue("action") {
+	case environments.RolloutStart:
+		var req types.ApiRolloutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+			h.Inc(metricAPIEnvsErr)
+			return
+		}
+		rollout, err = h.Envs.StartRollout(env.UUID, req.Base, req.Percentage, req.Tag, user)
+	case environments.RolloutPromote:
+		rollout, err = h.Envs.PromoteRollout(env.UUID, user)
+	case environments.RolloutAbort:
+		rollout, err = h.Envs.AbortRollout(env.UUID, user)
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error with rollout", http.StatusBadRequest, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Refresh environment since promote and abort change the configuration
+	env, err = h.Envs.GetByUUID(env.UUID)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	res, err := h.rolloutStatus(env, rollout)
+	if err != nil {
+		apiErrorResponse(w, "error getting rollout status", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Rollout %s for %s", rollout.Status, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPIEnvsOK)
+}
+
+// envAdoption to summarize the configuration adoption of an environment and get its stale nodes
+// During a rollout, nodes outside of the canary are expected to keep the configuration of the base revision
+func (h *HandlersApi) envAdoption(env environments.TLSEnvironment, hash string, since time.Time) (nodes.ConfigAdoption, []nodes.OsqueryNode, error) {
+	rollout, err := h.Envs.ActiveRollout(env.ID)
+	if err != nil {
+		adoption, err := h.Nodes.GetAdoption(env.ID, hash, since)
+		if err != nil {
+			return adoption, nil, err
+		}
+		stale, err := h.Nodes.GetStaleConfig(env.ID, hash)
+		if err != nil {
+			return adoption, nil, fmt.Errorf("error getting stale nodes %v", err)
+		}
+		return adoption, stale, nil
+	}
+	canary, others, err := h.rolloutNodes(env, rollout)
+	if err != nil {
+		return nodes.ConfigAdoption{}, nil, err
+	}
+	adoption := nodes.Adoption(canary, hash, since).Add(nodes.Adoption(others, rollout.BaseHash, since))
+	stale := append(nodes.StaleNodes(canary, hash), nodes.StaleNodes(others, rollout.BaseHash)...)
+	return adoption, stale, nil
+}
+
+// EnvAdoptionHandler - GET Handler to return how nodes adopted the configuration of an environment
+func (h *HandlersApi) EnvAdoptionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), false)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	res := types.ApiAdoptionResponse{
+		ConfigHash: environments.ExpectedConfigHash(env),
+		StaleNodes: []string{},
+	}
+	if rev, err := h.Envs.LatestRevision(env.ID); err == nil {
+		res.Since = rev.CreatedAt
+	}
+	adoption, stale, err := h.envAdoption(env, res.ConfigHash, res.Since)
+	if err != nil {
+		apiErrorResponse(w, "error getting adoption", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	res.Adoption = types.ApiConfigAdoption(adoption)
+	for _, n := range stale {
+		res.StaleNodes = append(res.StaleNodes, n.UUID)
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Returned adoption for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPIEnvsOK)
+}
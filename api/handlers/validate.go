@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// Sections of the configuration that can be validated on their own
+var validSections = map[string]bool{
+	environments.SectionOptions:    true,
+	environments.SectionSchedule:   true,
+	environments.SectionPacks:      true,
+	environments.SectionDecorators: true,
+	environments.SectionATC:        true,
+}
+
+// EnvValidateHandler - POST Handler to validate osquery configuration for an environment without saving it
+func (h *HandlersApi) EnvValidateHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAPIEnvsReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAPI, settings.NoEnvironmentID), true)
+	env, _, ok := h.revisionEnvironment(w, r)
+	if !ok {
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var req types.ApiValidationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	if req.Section != "" && !validSections[req.Section] {
+		apiErrorResponse(w, "invalid section", http.StatusBadRequest, nil)
+		h.Inc(metricAPIEnvsErr)
+		return
+	}
+	var err error
+	if req.Section == "" {
+		var cnf environments.OsqueryConf
+		if cnf, err = h.Envs.GenStructConf([]byte(req.Data)); err != nil {
+			err = &environments.ValidationError{Issues: []environments.ConfigIssue{{Section: "configuration", Message: "invalid JSON: " + err.Error()}}}
+		} else {
+			err = h.Envs.ValidateConf(cnf)
+		}
+	} else {
+		err = h.Envs.ValidatePart(env, req.Section, []byte(req.Data))
+	}
+	res := types.ApiValidationResponse{Valid: err == nil, Issues: []types.ApiConfigIssue{}}
+	var verr *environments.ValidationError
+	if errors.As(err, &verr) {
+		for _, i := range verr.Issues {
+			res.Issues = append(res.Issues, types.ApiConfigIssue{Section: i.Section, Key: i.Key, Message: i.Message})
+		}
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAPI) {
+		log.Debug().Msgf("DebugService: Validated configuration for %s with %d issues", env.Name, len(res.Issues))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	h.Inc(metricAPIEnvsOK)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
@@ -92,6 +93,10 @@ const (
 	apiTagsPath = "/tags"
 	// API settings path
 	apiSettingsPath = "/settings"
+	// API alerts path
+	apiAlertsPath = "/alerts"
+	// API logs path
+	apiLogsPath = "/logs"
 )
 
 // Global variables
@@ -116,6 +121,8 @@ var (
 	nodesmgr          *nodes.NodeManager
 	queriesmgr        *queries.Queries
 	filecarves        *carves.Carves
+	alertsmgr         *logging.AlertManager
+	resultsLogger     *logging.LoggerDB
 	apiMetrics        *metrics.Metrics
 	handlersApi       *handlers.HandlersApi
 	app               *cli.App
@@ -136,6 +143,10 @@ var (
 	tlsServer         bool
 	tlsCertFile       string
 	tlsKeyFile        string
+	osqueryTablesFile string
+	resultsDBFile     string
+	packSourcesDir    string
+	packSourcesHosts  string
 )
 
 // Valid values for auth and logging in configuration
@@ -426,6 +437,34 @@ func init() {
 			EnvVars:     []string{"JWT_CONFIG_FILE"},
 			Destination: &jwtConfigFile,
 		},
+		&cli.StringFlag{
+			Name:        "osquery-tables",
+			Value:       "",
+			Usage:       "Load osquery tables schema from `FILE` to validate configuration queries",
+			EnvVars:     []string{"OSQUERY_TABLES"},
+			Destination: &osqueryTablesFile,
+		},
+		&cli.StringFlag{
+			Name:        "pack-sources-dir",
+			Value:       "",
+			Usage:       "Allow query packs to be loaded from local paths inside `DIR`",
+			EnvVars:     []string{"PACK_SOURCES_DIR"},
+			Destination: &packSourcesDir,
+		},
+		&cli.StringFlag{
+			Name:        "pack-sources-hosts",
+			Value:       "",
+			Usage:       "Allow query packs to be loaded from URLs of these comma separated `HOSTS`",
+			EnvVars:     []string{"PACK_SOURCES_HOSTS"},
+			Destination: &packSourcesHosts,
+		},
+		&cli.StringFlag{
+			Name:        "results-db-file",
+			Value:       "",
+			Usage:       "Load DB configuration of result logs stored by the TLS logger from `FILE`, result logs are not available if empty",
+			EnvVars:     []string{"RESULTS_DB_FILE"},
+			Destination: &resultsDBFile,
+		},
 		&cli.StringFlag{
 			Name:        "jwt-secret",
 			Usage:       "Password to be used for the backend",
@@ -485,6 +524,14 @@ func osctrlAPIService() {
 	tagsmgr = tags.CreateTagManager(db.Conn)
 	log.Info().Msg("Initialize environment")
 	envs = environments.CreateEnvironment(db.Conn)
+	if osqueryTablesFile != "" {
+		tables, err := loadOsqueryTables(osqueryTablesFile)
+		if err != nil {
+			log.Fatal().Msgf("Failed to load osquery tables - %v", err)
+		}
+		envs.Tables = tables
+	}
+	envs.PackSources = environments.NewPackSourcePolicy(packSourcesDir, packSourcesHosts)
 	// Initialize settings
 	log.Info().Msg("Initialize settings")
 	settingsmgr = settings.NewSettings(db.Conn)
@@ -494,6 +541,15 @@ func osctrlAPIService() {
 	queriesmgr = queries.CreateQueries(db.Conn)
 	log.Info().Msg("Initialize carves")
 	filecarves = carves.CreateFileCarves(db.Conn, apiConfig.Carver, nil)
+	log.Info().Msg("Initialize alerts")
+	alertsmgr = logging.CreateAlerts(db.Conn)
+	if resultsDBFile != "" {
+		log.Info().Msg("Initialize result logs")
+		resultsLogger, err = logging.CreateLoggerDBFile(resultsDBFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading result logs - %v", err)
+		}
+	}
 	log.Info().Msg("Loading service settings")
 	if err := loadingSettings(settingsmgr); err != nil {
 		log.Fatal().Msgf("Error loading settings - %v", err)
@@ -549,6 +605,9 @@ func osctrlAPIService() {
 		handlers.WithCache(redis),
 		handlers.WithVersion(serviceVersion),
 		handlers.WithName(serviceName),
+		handlers.WithAlerts(alertsmgr),
+		handlers.WithResults(resultsLogger),
+		handlers.WithBulkSecret([]byte(jwtConfig.JWTSecret)),
 	)
 
 	// ///////////////////////// API
@@ -573,6 +632,12 @@ func osctrlAPIService() {
 	muxAPI.Handle("GET "+_apiPath(apiNodesPath)+"/{env}/inactive", handlerAuthCheck(http.HandlerFunc(handlersApi.InactiveNodesHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiNodesPath)+"/{env}/node/{node}", handlerAuthCheck(http.HandlerFunc(handlersApi.NodeHandler)))
 	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/delete", handlerAuthCheck(http.HandlerFunc(handlersApi.DeleteNodeHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/move", handlerAuthCheck(http.HandlerFunc(handlersApi.MoveNodesHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiNodesPath)+"/{env}/pending", handlerAuthCheck(http.HandlerFunc(handlersApi.PendingNodesHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/approve", handlerAuthCheck(http.HandlerFunc(handlersApi.ApproveNodesHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/rotate", handlerAuthCheck(http.HandlerFunc(handlersApi.RotateNodeKeysHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/bulk/preview", handlerAuthCheck(http.HandlerFunc(handlersApi.BulkPreviewNodesHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiNodesPath)+"/{env}/bulk", handlerAuthCheck(http.HandlerFunc(handlersApi.BulkNodesHandler)))
 	// API: queries by environment
 	muxAPI.Handle("GET "+_apiPath(apiQueriesPath)+"/{env}", handlerAuthCheck(http.HandlerFunc(handlersApi.AllQueriesShowHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiQueriesPath)+"/{env}/list/{target}", handlerAuthCheck(http.HandlerFunc(handlersApi.QueryListHandler)))
@@ -598,12 +663,32 @@ func osctrlAPIService() {
 	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/enroll/{target}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvEnrollHandler)))
 	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/enroll/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvEnrollActionsHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/tokens", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvTokensHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/revisions", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionsHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/revisions/{rev}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/revisions/{rev}/diff/{to}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRevisionsDiffHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/revisions/{rev}/rollback", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRollbackHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/pack-sources", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvPackSourcesHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/pack-sources/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvPackSourceActionHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/platform-flags", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvPlatformFlagsHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/platform-flags/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvPlatformFlagsActionHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/performance", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvSchedulePerfHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/performance/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvSchedulePerfActionHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/validate", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvValidateHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/adoption", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvAdoptionHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/rollout", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutHandler)))
+	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/rollouts", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutsHandler)))
+	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/rollout/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRolloutActionHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath)+"/{env}/remove/{target}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentHandler)))
 	muxAPI.Handle("POST "+_apiPath(apiEnvironmentsPath)+"/{env}/remove/{action}", handlerAuthCheck(http.HandlerFunc(handlersApi.EnvRemoveActionsHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiEnvironmentsPath), handlerAuthCheck(http.HandlerFunc(handlersApi.EnvironmentsHandler)))
 	// API: tags by environment
 	muxAPI.Handle("GET "+_apiPath(apiTagsPath), handlerAuthCheck(http.HandlerFunc(handlersApi.AllTagsHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiTagsPath)+"/{env}", handlerAuthCheck(http.HandlerFunc(handlersApi.TagsEnvHandler)))
+	// API: alerts by environment
+	muxAPI.Handle("GET "+_apiPath(apiAlertsPath)+"/{env}", handlerAuthCheck(http.HandlerFunc(handlersApi.AlertsHandler)))
+	// API: result logs by environment
+	muxAPI.Handle("GET "+_apiPath(apiLogsPath)+"/{env}/results", handlerAuthCheck(http.HandlerFunc(handlersApi.ResultLogsHandler)))
 	// API: settings by environment
 	muxAPI.Handle("GET "+_apiPath(apiSettingsPath), handlerAuthCheck(http.HandlerFunc(handlersApi.SettingsHandler)))
 	muxAPI.Handle("GET "+_apiPath(apiSettingsPath)+"/{service}", handlerAuthCheck(http.HandlerFunc(handlersApi.SettingsServiceHandler)))
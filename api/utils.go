@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 
 	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -53,3 +55,20 @@ func apiVersion() {
 func _apiPath(target string) string {
 	return apiPrefixPath + apiVersionPath + target
 }
+
+// Helper to load the names of osquery tables from the JSON schema file
+func loadOsqueryTables(file string) (environments.TableSet, error) {
+	var tables []types.OsqueryTable
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tables))
+	for _, t := range tables {
+		names = append(names, t.Name)
+	}
+	return environments.NewTableSet(names), nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/settings"
@@ -91,3 +92,284 @@ func (api *OsctrlAPI) ActionEnrollmentRemove(identifier, action, target string,
 	}
 	return res.Message, nil
 }
+
+// ChangeApproval to enable or disable approval of new enrollments in an environment
+func (api *OsctrlAPI) ChangeApproval(identifier string, value bool) (string, error) {
+	action := settings.ApprovalOff
+	if value {
+		action = settings.ApprovalOn
+	}
+	return api.ActionEnrollmentRemove(identifier, action, "enroll", strings.NewReader("{}"))
+}
+
+// NewEnrollToken to mint a new enrollment token for an environment
+func (api *OsctrlAPI) NewEnrollToken(identifier string, maxUses, expHours int, comment string) (environments.EnrollToken, error) {
+	var token environments.EnrollToken
+	a := types.ApiActionsRequest{
+		MaxUses:  maxUses,
+		ExpHours: expHours,
+		Comment:  comment,
+	}
+	jsonMessage, err := json.Marshal(a)
+	if err != nil {
+		return token, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/enroll/%s", api.Configuration.URL, APIPath, APIEnvironments, identifier, settings.TokenNew)
+	rawT, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return token, fmt.Errorf("error api request - %v - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &token); err != nil {
+		return token, fmt.Errorf("can not parse body - %v", err)
+	}
+	return token, nil
+}
+
+// GetEnrollTokens to retrieve the enrollment tokens of an environment
+func (api *OsctrlAPI) GetEnrollTokens(identifier string) ([]environments.EnrollToken, error) {
+	var tokens []environments.EnrollToken
+	reqURL := fmt.Sprintf("%s%s%s/%s/tokens", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawT, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return tokens, fmt.Errorf("error api request - %v - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &tokens); err != nil {
+		return tokens, fmt.Errorf("can not parse body - %v", err)
+	}
+	return tokens, nil
+}
+
+// RevokeEnrollToken to revoke an enrollment token of an environment
+func (api *OsctrlAPI) RevokeEnrollToken(identifier, token string) (string, error) {
+	jsonMessage, err := json.Marshal(types.ApiActionsRequest{Token: token})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling data - %v", err)
+	}
+	return api.ActionEnrollmentRemove(identifier, settings.TokenRevoke, "enroll", strings.NewReader(string(jsonMessage)))
+}
+
+// ChangeClientCert to require or not client certificates for nodes in an environment
+func (api *OsctrlAPI) ChangeClientCert(identifier string, value bool) (string, error) {
+	action := settings.ClientCertOff
+	if value {
+		action = settings.ClientCertOn
+	}
+	return api.ActionEnrollmentRemove(identifier, action, "enroll", strings.NewReader("{}"))
+}
+
+// SetClientCA to set the CA to verify client certificates for nodes in an environment
+func (api *OsctrlAPI) SetClientCA(identifier, ca string) (string, error) {
+	jsonMessage, err := json.Marshal(types.ApiActionsRequest{ClientCA: ca})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling data - %v", err)
+	}
+	return api.ActionEnrollmentRemove(identifier, settings.SetClientCA, "enroll", strings.NewReader(string(jsonMessage)))
+}
+
+// GetRevisions to retrieve the configuration revisions of an environment
+func (api *OsctrlAPI) GetRevisions(identifier string) ([]environments.ConfigRevision, error) {
+	var revs []environments.ConfigRevision
+	reqURL := fmt.Sprintf("%s%s%s/%s/revisions", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return revs, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &revs); err != nil {
+		return revs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return revs, nil
+}
+
+// DiffRevisions to retrieve the diff between two configuration revisions of an environment
+func (api *OsctrlAPI) DiffRevisions(identifier string, from, to int) (string, error) {
+	var r types.ApiDataResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/revisions/%d/diff/%d", api.Configuration.URL, APIPath, APIEnvironments, identifier, from, to)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return "", fmt.Errorf("can not parse body - %v", err)
+	}
+	return r.Data, nil
+}
+
+// RollbackRevision to restore the configuration of an environment from a prior revision
+func (api *OsctrlAPI) RollbackRevision(identifier string, revision int) (environments.ConfigRevision, error) {
+	var rev environments.ConfigRevision
+	reqURL := fmt.Sprintf("%s%s%s/%s/revisions/%d/rollback", api.Configuration.URL, APIPath, APIEnvironments, identifier, revision)
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return rev, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rev); err != nil {
+		return rev, fmt.Errorf("can not parse body - %v", err)
+	}
+	return rev, nil
+}
+
+// GetRollout to retrieve the status of the active configuration rollout of an environment
+func (api *OsctrlAPI) GetRollout(identifier string) (types.ApiRolloutResponse, error) {
+	var r types.ApiRolloutResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/rollout", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetRollouts to retrieve all the configuration rollouts of an environment
+func (api *OsctrlAPI) GetRollouts(identifier string) ([]environments.ConfigRollout, error) {
+	var rollouts []environments.ConfigRollout
+	reqURL := fmt.Sprintf("%s%s%s/%s/rollouts", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rollouts, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &rollouts); err != nil {
+		return rollouts, fmt.Errorf("can not parse body - %v", err)
+	}
+	return rollouts, nil
+}
+
+// RolloutAction to start, promote or abort a configuration rollout of an environment
+func (api *OsctrlAPI) RolloutAction(identifier, action string, req types.ApiRolloutRequest) (types.ApiRolloutResponse, error) {
+	var r types.ApiRolloutResponse
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/rollout/%s", api.Configuration.URL, APIPath, APIEnvironments, identifier, action)
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetAdoption to retrieve the configuration adoption of an environment
+func (api *OsctrlAPI) GetAdoption(identifier string) (types.ApiAdoptionResponse, error) {
+	var r types.ApiAdoptionResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/adoption", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// ValidateConfiguration to validate osquery configuration for an environment without saving it
+func (api *OsctrlAPI) ValidateConfiguration(identifier string, req types.ApiValidationRequest) (types.ApiValidationResponse, error) {
+	var r types.ApiValidationResponse
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/validate", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetPackSources to retrieve all the pack sources of an environment
+func (api *OsctrlAPI) GetPackSources(identifier string) ([]environments.PackSource, error) {
+	var sources []environments.PackSource
+	reqURL := fmt.Sprintf("%s%s%s/%s/pack-sources", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return sources, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &sources); err != nil {
+		return sources, fmt.Errorf("can not parse body - %v", err)
+	}
+	return sources, nil
+}
+
+// PackSourceAction to add, delete or sync a pack source of an environment
+func (api *OsctrlAPI) PackSourceAction(identifier, action string, req types.ApiPackSourceRequest) ([]byte, error) {
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/pack-sources/%s", api.Configuration.URL, APIPath, APIEnvironments, identifier, action)
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return rawR, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return rawR, nil
+}
+
+// GetPlatformFlags to retrieve the flags overrides per platform of an environment
+func (api *OsctrlAPI) GetPlatformFlags(identifier string) ([]environments.PlatformFlags, error) {
+	var flags []environments.PlatformFlags
+	reqURL := fmt.Sprintf("%s%s%s/%s/platform-flags", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return flags, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &flags); err != nil {
+		return flags, fmt.Errorf("can not parse body - %v", err)
+	}
+	return flags, nil
+}
+
+// PlatformFlagsAction to set or delete the flags overrides of a platform in an environment
+func (api *OsctrlAPI) PlatformFlagsAction(identifier, action string, req types.ApiPlatformFlagsRequest) ([]byte, error) {
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/platform-flags/%s", api.Configuration.URL, APIPath, APIEnvironments, identifier, action)
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return rawR, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return rawR, nil
+}
+
+// GetSchedulePerf to retrieve the performance of the scheduled queries of an environment
+func (api *OsctrlAPI) GetSchedulePerf(identifier string) (types.ApiSchedulePerfResponse, error) {
+	var r types.ApiSchedulePerfResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/performance", api.Configuration.URL, APIPath, APIEnvironments, identifier)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// SchedulePerfAction to enable or disable collecting the performance of scheduled queries
+func (api *OsctrlAPI) SchedulePerfAction(identifier, action string, req types.ApiSchedulePerfRequest) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/performance/%s", api.Configuration.URL, APIPath, APIEnvironments, identifier, action)
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
@@ -63,3 +63,112 @@ func (api *OsctrlAPI) DeleteNode(env, identifier string) error {
 func (api *OsctrlAPI) TagNode(env, identifier, tag string) error {
 	return nil
 }
+
+// MoveNodes to move nodes to a different environment in osctrl
+func (api *OsctrlAPI) MoveNodes(env, dest string, uuids []string, tag, search string) error {
+	m := types.ApiNodeMoveRequest{
+		UUIDs:       uuids,
+		Tag:         tag,
+		Search:      search,
+		Environment: dest,
+	}
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/move", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %v", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	return nil
+}
+
+// BulkPreviewNodes to preview a bulk action on nodes in osctrl
+func (api *OsctrlAPI) BulkPreviewNodes(env string, b types.ApiNodeBulkRequest) (types.ApiNodeBulkPreviewResponse, error) {
+	var r types.ApiNodeBulkPreviewResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/bulk/preview", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(b)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %v", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// BulkNodes to execute a bulk action on nodes in osctrl
+func (api *OsctrlAPI) BulkNodes(env string, b types.ApiNodeBulkRequest) (types.ApiNodeBulkResponse, error) {
+	var r types.ApiNodeBulkResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/bulk", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(b)
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data - %v", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// RotateNodeKeys to rotate the key of one node or all nodes of an environment in osctrl
+func (api *OsctrlAPI) RotateNodeKeys(env, uuid string, all bool, grace int) (string, error) {
+	k := types.ApiNodeRotateRequest{
+		UUID:  uuid,
+		All:   all,
+		Grace: grace,
+	}
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/rotate", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(k)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling data - %v", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return "", fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &r); err != nil {
+		return "", fmt.Errorf("can not parse body - %v", err)
+	}
+	return r.Message, nil
+}
+
+// ApproveNodes to approve pending nodes in osctrl
+func (api *OsctrlAPI) ApproveNodes(env string, identifiers []string) (string, error) {
+	a := types.ApiNodeApproveRequest{
+		Identifiers: identifiers,
+	}
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/approve", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling data - %v", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return "", fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &r); err != nil {
+		return "", fmt.Errorf("can not parse body - %v", err)
+	}
+	return r.Message, nil
+}
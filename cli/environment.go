@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -60,6 +64,10 @@ func addEnvironment(c *cli.Context) error {
 			if err := envs.UpdateConfigurationParts(envName, cnf); err != nil {
 				return err
 			}
+			// Record initial configuration revision
+			if _, err := envs.CommitRevision(envName, appName, "initial configuration"); err != nil {
+				return err
+			}
 			// Create a tag for this new environment
 			if err := tagsmgr.NewTag(newEnv.Name, "Tag for environment "+newEnv.Name, tags.RandomColor(), newEnv.Icon, appName, newEnv.ID); err != nil {
 				return err
@@ -180,16 +188,37 @@ func showEnvironment(c *cli.Context) error {
 		os.Exit(1)
 	}
 	var env environments.TLSEnvironment
+	var adoption types.ApiAdoptionResponse
 	if dbFlag {
 		env, err = envs.Get(envName)
 		if err != nil {
 			return err
 		}
+		adoption.ConfigHash = environments.ExpectedConfigHash(env)
+		if rev, err := envs.LatestRevision(env.ID); err == nil {
+			adoption.Since = rev.CreatedAt
+		}
+		var a nodes.ConfigAdoption
+		if rollout, err := envs.ActiveRollout(env.ID); err == nil {
+			// Nodes outside of the canary are expected to keep the configuration of the base revision
+			canary, others, err := rolloutNodesDB(env, rollout)
+			if err != nil {
+				return err
+			}
+			a = nodes.Adoption(canary, adoption.ConfigHash, adoption.Since).Add(nodes.Adoption(others, rollout.BaseHash, adoption.Since))
+		} else if a, err = nodesmgr.GetAdoption(env.ID, adoption.ConfigHash, adoption.Since); err != nil {
+			return err
+		}
+		adoption.Adoption = types.ApiConfigAdoption(a)
 	} else if apiFlag {
 		env, err = osctrlAPI.GetEnvironment(envName)
 		if err != nil {
 			return err
 		}
+		adoption, err = osctrlAPI.GetAdoption(env.UUID)
+		if err != nil {
+			return err
+		}
 	}
 	fmt.Printf(" UUID: %s\n", env.UUID)
 	fmt.Printf(" Name: %s\n", env.Name)
@@ -215,6 +244,9 @@ func showEnvironment(c *cli.Context) error {
 	fmt.Printf(" Query Interval: %d seconds\n", env.QueryInterval)
 	fmt.Printf(" Carve Init Path: /%s/%s\n", env.UUID, env.CarverInitPath)
 	fmt.Printf(" Carve Block Path: /%s/%s\n", env.UUID, env.CarverBlockPath)
+	fmt.Printf(" Config Hash: %s\n", adoption.ConfigHash)
+	fmt.Printf(" Config Adoption: %d/%d nodes (%d stale, %d unknown, %d with errors)\n",
+		adoption.Adoption.Adopted, adoption.Adoption.Total, adoption.Adoption.Stale, adoption.Adoption.Unknown, adoption.Adoption.Errors)
 	fmt.Println(" Flags: ")
 	fmt.Printf("%s\n", env.Flags)
 	fmt.Println(" Options: ")
@@ -672,6 +704,233 @@ func certificateEnvironment(c *cli.Context) error {
 	return nil
 }
 
+func changeApprovalEnvironment(c *cli.Context, value bool) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	msg := "approval for enrollments disabled"
+	if value {
+		msg = "approval for enrollments enabled"
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if err := envs.ChangeRequireApproval(env.UUID, value); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		msg, err = osctrlAPI.ChangeApproval(envName, value)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ %s\n", msg)
+	return nil
+}
+
+func requireApprovalEnvironment(c *cli.Context) error {
+	return changeApprovalEnvironment(c, true)
+}
+
+func noApprovalEnvironment(c *cli.Context) error {
+	return changeApprovalEnvironment(c, false)
+}
+
+func requireClientCertEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var ca string
+	if caFile := c.String("ca"); caFile != "" {
+		ca = environments.ReadExternalFile(caFile)
+		if !environments.ValidClientCA(ca) {
+			fmt.Println("❌ invalid CA in " + caFile)
+			os.Exit(1)
+		}
+	}
+	msg := "client certificates required"
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if ca != "" {
+			if err := envs.UpdateClientCA(env.UUID, ca); err != nil {
+				return err
+			}
+		} else if env.ClientCA == "" {
+			return fmt.Errorf("client CA is required")
+		}
+		if err := envs.ChangeRequireClientCert(env.UUID, true); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if ca != "" {
+			if _, err := osctrlAPI.SetClientCA(envName, ca); err != nil {
+				return err
+			}
+		}
+		msg, err = osctrlAPI.ChangeClientCert(envName, true)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ %s\n", msg)
+	return nil
+}
+
+func noClientCertEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	msg := "client certificates not required"
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if err := envs.ChangeRequireClientCert(env.UUID, false); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		msg, err = osctrlAPI.ChangeClientCert(envName, false)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ %s\n", msg)
+	return nil
+}
+
+func newTokenEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	maxUses := c.Int("max-uses")
+	if maxUses < 0 {
+		fmt.Println("❌ max-uses must be positive")
+		os.Exit(1)
+	}
+	var token environments.EnrollToken
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		token, err = envs.NewEnrollToken(env.ID, maxUses, c.Int("expire"), c.String("comment"), appName)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		token, err = osctrlAPI.NewEnrollToken(envName, maxUses, c.Int("expire"), c.String("comment"))
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%s\n", token.Token)
+	return nil
+}
+
+func listTokensEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var tokens []environments.EnrollToken
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		tokens, err = envs.GetEnrollTokens(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		tokens, err = osctrlAPI.GetEnrollTokens(envName)
+		if err != nil {
+			return err
+		}
+	}
+	header := []string{
+		"Token",
+		"Uses",
+		"Max Uses",
+		"Expires",
+		"Comment",
+		"Created By",
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(tokens)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	for _, t := range tokens {
+		table.Append([]string{
+			t.Token,
+			strconv.Itoa(t.Uses),
+			strconv.Itoa(t.MaxUses),
+			t.Expires.Format(time.RFC3339),
+			t.Comment,
+			t.CreatedBy,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func revokeTokenEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	token := c.String("token")
+	if token == "" {
+		fmt.Println("❌ token is required")
+		os.Exit(1)
+	}
+	msg := "enrollment token revoked"
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if err := envs.DeleteEnrollToken(token, env.ID); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		msg, err = osctrlAPI.RevokeEnrollToken(envName, token)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ %s\n", msg)
+	return nil
+}
+
 func addScheduledQuery(c *cli.Context) error {
 	// Get environment name
 	envName := c.String("name")
@@ -704,7 +963,9 @@ func addScheduledQuery(c *cli.Context) error {
 		Platform: c.String("platform"),
 		Version:  c.String("version"),
 	}
-	if err := envs.AddScheduleConfQuery(envName, queryName, qData); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "add scheduled query "+queryName, func(e *environments.Environment) error {
+		return e.AddScheduleConfQuery(envName, queryName, qData)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was created successfully\n", queryName)
@@ -725,7 +986,9 @@ func removeScheduledQuery(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove query
-	if err := envs.RemoveScheduleConfQuery(envName, queryName); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "remove scheduled query "+queryName, func(e *environments.Environment) error {
+		return e.RemoveScheduleConfQuery(envName, queryName)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was removed successfully\n", queryName)
@@ -765,7 +1028,9 @@ func addOsqueryOption(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Add osquery option
-	if err := envs.AddOptionsConf(envName, option, optionValue); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "add option "+option, func(e *environments.Environment) error {
+		return e.AddOptionsConf(envName, option, optionValue)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ option %s was added successfully\n", option)
@@ -786,7 +1051,9 @@ func removeOsqueryOption(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove osquery option
-	if err := envs.RemoveOptionsConf(envName, option); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "remove option "+option, func(e *environments.Environment) error {
+		return e.RemoveOptionsConf(envName, option)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ option %s was added successfully\n", option)
@@ -813,7 +1080,9 @@ func addNewPack(c *cli.Context) error {
 		Shard:    json.Number(strconv.Itoa(c.Int("shard"))),
 	}
 	// Add pack to configuration
-	if err := envs.AddQueryPackConf(envName, pName, pack); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "add pack "+pName, func(e *environments.Environment) error {
+		return e.AddQueryPackConf(envName, pName, pack)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
@@ -834,7 +1103,9 @@ func removePack(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove pack from configuration
-	if err := envs.RemoveQueryPackConf(envName, pName); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "remove pack "+pName, func(e *environments.Environment) error {
+		return e.RemoveQueryPackConf(envName, pName)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
@@ -861,7 +1132,9 @@ func addLocalPack(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Add pack to configuration option
-	if err := envs.AddQueryPackConf(envName, pName, pPath); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "add local pack "+pName, func(e *environments.Environment) error {
+		return e.AddQueryPackConf(envName, pName, pPath)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ pack %s was added successfully\n", pName)
@@ -906,7 +1179,9 @@ func addPackQuery(c *cli.Context) error {
 		Platform: c.String("platform"),
 		Version:  c.String("version"),
 	}
-	if err := envs.AddQueryToPackConf(envName, packName, queryName, qData); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "add query "+queryName+" to pack "+packName, func(e *environments.Environment) error {
+		return e.AddQueryToPackConf(envName, packName, queryName, qData)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was added to pack %s successfully\n", queryName, packName)
@@ -933,9 +1208,1056 @@ func removePackQuery(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove query
-	if err := envs.RemoveQueryFromPackConf(envName, packName, queryName); err != nil {
+	if _, err := envs.WithRevision(envName, appName, "remove query "+queryName+" from pack "+packName, func(e *environments.Environment) error {
+		return e.RemoveQueryFromPackConf(envName, packName, queryName)
+	}); err != nil {
 		return err
 	}
 	fmt.Printf("✅ query %s was removed from pack %s successfully\n", queryName, packName)
 	return nil
 }
+
+func listRevisionsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var revs []environments.ConfigRevision
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		revs, err = envs.GetRevisions(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		revs, err = osctrlAPI.GetRevisions(envName)
+		if err != nil {
+			return err
+		}
+	}
+	header := []string{
+		"Revision",
+		"Created",
+		"Author",
+		"Comment",
+		"Hash",
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(revs)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	for _, r := range revs {
+		table.Append([]string{
+			strconv.Itoa(r.Revision),
+			r.CreatedAt.Format(time.RFC3339),
+			r.Author,
+			r.Comment,
+			r.Hash,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func diffRevisionsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	from := c.Int("from")
+	to := c.Int("to")
+	if from <= 0 || to <= 0 {
+		fmt.Println("❌ revisions to compare are required")
+		os.Exit(1)
+	}
+	var diff string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		revFrom, err := envs.GetRevision(env.ID, from)
+		if err != nil {
+			return fmt.Errorf("error getting revision %d - %v", from, err)
+		}
+		revTo, err := envs.GetRevision(env.ID, to)
+		if err != nil {
+			return fmt.Errorf("error getting revision %d - %v", to, err)
+		}
+		diff = environments.DiffRevisions(revFrom, revTo)
+	} else if apiFlag {
+		diff, err = osctrlAPI.DiffRevisions(envName, from, to)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Print(diff)
+	return nil
+}
+
+func rollbackRevisionEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	revision := c.Int("revision")
+	if revision <= 0 {
+		fmt.Println("❌ revision is required")
+		os.Exit(1)
+	}
+	var rev environments.ConfigRevision
+	if dbFlag {
+		rev, err = envs.RollbackRevision(envName, revision, appName)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		rev, err = osctrlAPI.RollbackRevision(envName, revision)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ configuration rolled back to revision %d as revision %d\n", revision, rev.Revision)
+	return nil
+}
+
+// Helper to split the nodes of an environment between the canary of a rollout and the remaining nodes using the DB
+func rolloutNodesDB(env environments.TLSEnvironment, rollout environments.ConfigRollout) ([]nodes.OsqueryNode, []nodes.OsqueryNode, error) {
+	nds, err := nodesmgr.GetByNodeSelector(nodes.NodeSelector{EnvironmentID: env.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	tagged := make(map[uint]bool)
+	if rollout.Tag != "" {
+		ids, err := tagsmgr.GetTaggedNodeIDs(rollout.Tag, env.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, id := range ids {
+			tagged[id] = true
+		}
+	}
+	var canary, others []nodes.OsqueryNode
+	for _, n := range nds {
+		if rollout.Selects(n.UUID, tagged[n.ID]) {
+			canary = append(canary, n)
+		} else {
+			others = append(others, n)
+		}
+	}
+	return canary, others, nil
+}
+
+// Helper to get the status of a rollout using the DB
+func rolloutStatusDB(env environments.TLSEnvironment, rollout environments.ConfigRollout) (types.ApiRolloutResponse, error) {
+	res := types.ApiRolloutResponse{
+		Status:       rollout.Status,
+		BaseRevision: rollout.BaseRevision,
+		Percentage:   rollout.Percentage,
+		Tag:          rollout.Tag,
+		CreatedBy:    rollout.CreatedBy,
+		Started:      rollout.CreatedAt,
+	}
+	canary, others, err := rolloutNodesDB(env, rollout)
+	if err != nil {
+		return res, err
+	}
+	res.Canary = types.ApiConfigAdoption(nodes.Adoption(canary, environments.ExpectedConfigHash(env), rollout.CreatedAt))
+	res.Others = types.ApiConfigAdoption(nodes.Adoption(others, rollout.BaseHash, rollout.CreatedAt))
+	return res, nil
+}
+
+// Helper to print the status of a rollout
+func printRolloutStatus(res types.ApiRolloutResponse) error {
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	fmt.Printf("Rollout %s from revision %d, started by %s at %s\n", res.Status, res.BaseRevision, res.CreatedBy, res.Started.Format(time.RFC3339))
+	fmt.Printf("Canary: %d%% of nodes", res.Percentage)
+	if res.Tag != "" {
+		fmt.Printf(" and nodes tagged %s", res.Tag)
+	}
+	fmt.Println()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Nodes", "Total", "Adopted", "Stale", "Errors"})
+	for _, r := range []struct {
+		name string
+		a    types.ApiConfigAdoption
+	}{{"Canary", res.Canary}, {"Others", res.Others}} {
+		table.Append([]string{
+			r.name,
+			strconv.Itoa(r.a.Total),
+			strconv.Itoa(r.a.Adopted),
+			strconv.Itoa(r.a.Stale),
+			strconv.Itoa(r.a.Errors),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func statusRolloutEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var res types.ApiRolloutResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		rollout, err := envs.ActiveRollout(env.ID)
+		if err != nil {
+			return err
+		}
+		res, err = rolloutStatusDB(env, rollout)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.GetRollout(envName)
+		if err != nil {
+			return err
+		}
+	}
+	return printRolloutStatus(res)
+}
+
+func listRolloutsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var rollouts []environments.ConfigRollout
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		rollouts, err = envs.GetRollouts(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		rollouts, err = osctrlAPI.GetRollouts(envName)
+		if err != nil {
+			return err
+		}
+	}
+	header := []string{
+		"Started",
+		"Status",
+		"Base Revision",
+		"Percentage",
+		"Tag",
+		"Created By",
+		"Finished By",
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(rollouts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	for _, r := range rollouts {
+		table.Append([]string{
+			r.CreatedAt.Format(time.RFC3339),
+			r.Status,
+			strconv.Itoa(r.BaseRevision),
+			strconv.Itoa(r.Percentage),
+			r.Tag,
+			r.CreatedBy,
+			r.FinishedBy,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// Helper to perform rollout actions
+func rolloutActionEnvironment(c *cli.Context, action string) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	req := types.ApiRolloutRequest{
+		Base:       c.Int("base"),
+		Percentage: c.Int("percentage"),
+		Tag:        c.String("tag"),
+	}
+	if action == environments.RolloutStart && req.Percentage == 0 && req.Tag == "" {
+		fmt.Println("❌ percentage or tag is required")
+		os.Exit(1)
+	}
+	var res types.ApiRolloutResponse
+	if dbFlag {
+		var rollout environments.ConfigRollout
+		var err error
+		switch action {
+		case environments.RolloutStart:
+			rollout, err = envs.StartRollout(envName, req.Base, req.Percentage, req.Tag, appName)
+		case environments.RolloutPromote:
+			rollout, err = envs.PromoteRollout(envName, appName)
+		case environments.RolloutAbort:
+			rollout, err = envs.AbortRollout(envName, appName)
+		}
+		if err != nil {
+			return err
+		}
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		res, err = rolloutStatusDB(env, rollout)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.RolloutAction(envName, action, req)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ rollout %s\n", res.Status)
+	return nil
+}
+
+func startRolloutEnvironment(c *cli.Context) error {
+	return rolloutActionEnvironment(c, environments.RolloutStart)
+}
+
+func promoteRolloutEnvironment(c *cli.Context) error {
+	return rolloutActionEnvironment(c, environments.RolloutPromote)
+}
+
+func abortRolloutEnvironment(c *cli.Context) error {
+	return rolloutActionEnvironment(c, environments.RolloutAbort)
+}
+
+func validateEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get configuration file to validate
+	confFile := c.String("file")
+	if confFile == "" {
+		fmt.Println("❌ configuration file is required")
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	req := types.ApiValidationRequest{
+		Section: c.String("section"),
+		Data:    string(data),
+	}
+	var res types.ApiValidationResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if tablesFile := c.String("osquery-tables"); tablesFile != "" {
+			var tables []types.OsqueryTable
+			raw, err := os.ReadFile(tablesFile)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(raw, &tables); err != nil {
+				return err
+			}
+			names := make([]string, 0, len(tables))
+			for _, t := range tables {
+				names = append(names, t.Name)
+			}
+			envs.Tables = environments.NewTableSet(names)
+		}
+		var vErr error
+		if req.Section == "" {
+			cnf, err := envs.GenStructConf(data)
+			if err != nil {
+				return fmt.Errorf("invalid JSON - %v", err)
+			}
+			vErr = envs.ValidateConf(cnf)
+		} else {
+			vErr = envs.ValidatePart(env, req.Section, data)
+		}
+		res.Valid = vErr == nil
+		if verr, ok := vErr.(*environments.ValidationError); ok {
+			for _, i := range verr.Issues {
+				res.Issues = append(res.Issues, types.ApiConfigIssue{Section: i.Section, Key: i.Key, Message: i.Message})
+			}
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.ValidateConfiguration(envName, req)
+		if err != nil {
+			return err
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+	} else if res.Valid {
+		fmt.Println("✅ configuration is valid")
+	} else {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Section", "Key", "Issue"})
+		for _, i := range res.Issues {
+			table.Append([]string{i.Section, i.Key, i.Message})
+		}
+		table.Render()
+	}
+	if !res.Valid {
+		return fmt.Errorf("configuration has %d issues", len(res.Issues))
+	}
+	return nil
+}
+
+func listPackSourcesEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var sources []environments.PackSource
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		sources, err = envs.GetPackSources(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		sources, err = osctrlAPI.GetPackSources(envName)
+		if err != nil {
+			return err
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Pack", "Source", "Interval", "Version", "Last Fetch", "Last Change", "Error"})
+	for _, s := range sources {
+		table.Append(packSourceRow(s))
+	}
+	table.Render()
+	return nil
+}
+
+func packSourceRow(s environments.PackSource) []string {
+	fetched, changed := "", ""
+	if !s.LastFetch.IsZero() {
+		fetched = s.LastFetch.Format(time.RFC3339)
+	}
+	if !s.LastChange.IsZero() {
+		changed = s.LastChange.Format(time.RFC3339)
+	}
+	version := s.Version
+	if len(version) > 12 {
+		version = version[:12]
+	}
+	return []string{s.Name, s.Source, strconv.Itoa(s.Interval), version, fetched, changed, s.LastError}
+}
+
+func packSourceActionEnvironment(c *cli.Context, action string) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get pack name
+	pName := c.String("pack")
+	if pName == "" {
+		fmt.Println("❌ pack name is required")
+		os.Exit(1)
+	}
+	req := types.ApiPackSourceRequest{
+		Name:     pName,
+		Source:   c.String("source"),
+		Interval: c.Int("interval"),
+	}
+	if action == environments.PackSourceAdd && req.Source == "" {
+		fmt.Println("❌ pack source is required")
+		os.Exit(1)
+	}
+	var src environments.PackSource
+	if dbFlag {
+		envs.PackSources = environments.NewPackSourcePolicy(c.String("sources-dir"), c.String("sources-hosts"))
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case environments.PackSourceAdd:
+			if src, err = envs.AddPackSource(env.ID, req.Name, req.Source, req.Interval, appName); err != nil {
+				return err
+			}
+		case environments.PackSourceSync:
+			if src, err = envs.GetPackSource(env.ID, req.Name); err != nil {
+				return err
+			}
+		case environments.PackSourceDelete:
+			if err := envs.DeletePackSource(env.ID, req.Name); err != nil {
+				return err
+			}
+			fmt.Printf("✅ pack source %s was deleted successfully\n", pName)
+			return nil
+		}
+		if _, err := envs.SyncPackSource(src, appName); err != nil && action == environments.PackSourceSync {
+			return err
+		}
+		if src, err = envs.GetPackSource(env.ID, req.Name); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		rawR, err := osctrlAPI.PackSourceAction(envName, action, req)
+		if err != nil {
+			return err
+		}
+		if action == environments.PackSourceDelete {
+			fmt.Printf("✅ pack source %s was deleted successfully\n", pName)
+			return nil
+		}
+		if err := json.Unmarshal(rawR, &src); err != nil {
+			return fmt.Errorf("can not parse body - %v", err)
+		}
+	}
+	if src.LastError != "" {
+		fmt.Printf("❌ pack source %s: %s\n", pName, src.LastError)
+		return nil
+	}
+	fmt.Printf("✅ pack source %s applied with version %.12s\n", pName, src.Version)
+	return nil
+}
+
+func addPackSourceEnvironment(c *cli.Context) error {
+	return packSourceActionEnvironment(c, environments.PackSourceAdd)
+}
+
+func syncPackSourceEnvironment(c *cli.Context) error {
+	return packSourceActionEnvironment(c, environments.PackSourceSync)
+}
+
+func deletePackSourceEnvironment(c *cli.Context) error {
+	return packSourceActionEnvironment(c, environments.PackSourceDelete)
+}
+
+func showPerformanceEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var res types.ApiSchedulePerfResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		res.Enabled = envs.SchedulePerfEnabled(env)
+		perf, err := nodesmgr.GetSchedulePerf(env.ID)
+		if err != nil {
+			return err
+		}
+		for _, p := range perf {
+			res.Queries = append(res.Queries, types.ApiSchedulePerf(p))
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.GetSchedulePerf(envName)
+		if err != nil {
+			return err
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if !res.Enabled {
+		fmt.Printf("Collection of osquery_schedule stats is disabled for %s\n", envName)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Query", "Interval", "Nodes", "Executions", "Wall Time ms", "Avg Wall Time ms", "User ms", "System ms", "Avg Memory", "Max Memory", "Output", "Denylisted"})
+	for _, p := range res.Queries {
+		table.Append([]string{
+			p.Name,
+			strconv.Itoa(p.Interval),
+			strconv.Itoa(p.Nodes),
+			strconv.FormatInt(p.Executions, 10),
+			strconv.FormatInt(p.WallTimeMs, 10),
+			strconv.FormatFloat(p.AvgWallTimeMs, 'f', 1, 64),
+			strconv.FormatInt(p.UserTime, 10),
+			strconv.FormatInt(p.SystemTime, 10),
+			strconv.FormatInt(p.AverageMemory, 10),
+			strconv.FormatInt(p.MaxMemory, 10),
+			strconv.FormatInt(p.OutputSize, 10),
+			strconv.Itoa(p.Denylisted),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func performanceActionEnvironment(c *cli.Context, action string) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		if _, err := envs.WithRevision(envName, appName, "performance collection "+action+"d", func(e *environments.Environment) error {
+			if action == environments.SchedulePerfEnable {
+				return e.EnableSchedulePerf(envName, c.Int("interval"))
+			}
+			return e.DisableSchedulePerf(envName)
+		}); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SchedulePerfAction(envName, action, types.ApiSchedulePerfRequest{Interval: c.Int("interval")}); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ performance collection %sd for %s\n", action, envName)
+	return nil
+}
+
+func enablePerformanceEnvironment(c *cli.Context) error {
+	return performanceActionEnvironment(c, environments.SchedulePerfEnable)
+}
+
+func disablePerformanceEnvironment(c *cli.Context) error {
+	return performanceActionEnvironment(c, environments.SchedulePerfDisable)
+}
+
+func showPlatformFlagsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	var flags []environments.PlatformFlags
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		flags, err = envs.GetPlatformFlags(env.ID)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		flags, err = osctrlAPI.GetPlatformFlags(envName)
+		if err != nil {
+			return err
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(flags)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Platform", "Flags", "Updated By", "Updated"})
+	table.SetAutoWrapText(false)
+	for _, f := range flags {
+		table.Append([]string{f.Platform, f.Flags, f.UpdatedBy, f.UpdatedAt.String()})
+	}
+	table.Render()
+	return nil
+}
+
+func setPlatformFlagsEnvironment(c *cli.Context) error {
+	// Get environment name and platform
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	platform := c.String("platform")
+	if !environments.ValidFlagsPlatforms[platform] {
+		fmt.Printf("❌ invalid platform %s, valid platforms are %s\n", platform, strings.Join(environments.FlagsPlatforms, ", "))
+		os.Exit(1)
+	}
+	flags := c.String("flags")
+	if file := c.String("file"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading file %s - %v", file, err)
+		}
+		flags = string(data)
+	}
+	if strings.TrimSpace(flags) == "" {
+		fmt.Println("❌ flags or file are required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if _, err := envs.SetPlatformFlags(env.ID, platform, flags, appName); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.PlatformFlagsAction(envName, environments.PlatformFlagsSet, types.ApiPlatformFlagsRequest{Platform: platform, Flags: flags}); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ flags for %s saved in %s\n", platform, envName)
+	return nil
+}
+
+func deletePlatformFlagsEnvironment(c *cli.Context) error {
+	// Get environment name and platform
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	platform := c.String("platform")
+	if !environments.ValidFlagsPlatforms[platform] {
+		fmt.Printf("❌ invalid platform %s, valid platforms are %s\n", platform, strings.Join(environments.FlagsPlatforms, ", "))
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if err := envs.DeletePlatformFlags(env.ID, platform); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.PlatformFlagsAction(envName, environments.PlatformFlagsDelete, types.ApiPlatformFlagsRequest{Platform: platform}); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ flags for %s deleted in %s\n", platform, envName)
+	return nil
+}
+
+// Helper to export an environment as template, including its tags but not the tag of the environment itself
+func exportEnvTemplate(env environments.TLSEnvironment) (environments.EnvTemplate, error) {
+	tpl, err := envs.ExportTemplate(env)
+	if err != nil {
+		return tpl, err
+	}
+	tpl.Tags, err = exportEnvTags(env)
+	return tpl, err
+}
+
+// Helper to export the tags of an environment but not the tag of the environment itself
+func exportEnvTags(env environments.TLSEnvironment) ([]environments.TemplateTag, error) {
+	var res []environments.TemplateTag
+	envTags, err := tagsmgr.GetByEnv(env.ID)
+	if err != nil {
+		return res, err
+	}
+	for _, t := range envTags {
+		if t.Name == env.Name {
+			continue
+		}
+		res = append(res, environments.TemplateTag{
+			Name:        t.Name,
+			Description: t.Description,
+			Color:       t.Color,
+			Icon:        t.Icon,
+		})
+	}
+	return res, nil
+}
+
+// Helper to create the tags of a template in an environment
+func createTemplateTags(tpl environments.EnvTemplate, envID uint) error {
+	var tplTags []tags.AdminTag
+	for _, t := range tpl.Tags {
+		tplTags = append(tplTags, tags.AdminTag{Name: t.Name, Description: t.Description, Color: t.Color, Icon: t.Icon})
+	}
+	return tagsmgr.CreateTagsEnv(tplTags, envID, appName)
+}
+
+// Helper to create an environment from a template with its tags and initial revision
+func createEnvFromTemplate(tpl environments.EnvTemplate, name, hostname string) (environments.TLSEnvironment, error) {
+	env, err := envs.CreateFromTemplate(tpl, name, hostname)
+	if err != nil {
+		return env, err
+	}
+	// Create a tag for this new environment and the tags from the template
+	if err := tagsmgr.NewTag(env.Name, "Tag for environment "+env.Name, tags.RandomColor(), env.Icon, appName, env.ID); err != nil {
+		return env, err
+	}
+	if err := createTemplateTags(tpl, env.ID); err != nil {
+		return env, err
+	}
+	// Record initial configuration revision
+	if _, err := envs.CommitRevision(env.UUID, appName, "initial configuration from "+tpl.Name); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+func cloneEnvironment(c *cli.Context) error {
+	// Get source environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	// Get new environment name
+	target := c.String("target")
+	if target == "" {
+		fmt.Println("❌ target environment name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		source, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		tpl, err := exportEnvTemplate(source)
+		if err != nil {
+			return err
+		}
+		hostname := c.String("hostname")
+		if hostname == "" {
+			hostname = source.Hostname
+		}
+		env, err := createEnvFromTemplate(tpl, target, hostname)
+		if err != nil {
+			return err
+		}
+		if c.Bool("permissions") {
+			if err := adminUsers.CopyEnvPermissions(source, env, appName); err != nil {
+				return err
+			}
+		}
+	} else if apiFlag {
+		fmt.Println("❌ API not supported yet for this operation")
+		os.Exit(1)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ environment %s was cloned to %s successfully\n", envName, target)
+	}
+	return nil
+}
+
+func exportEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ environment name is required")
+		os.Exit(1)
+	}
+	secrets := c.Bool("secrets")
+	bundle := c.Bool("bundle") || secrets
+	var exported interface{}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return err
+		}
+		if bundle {
+			exported, err = exportEnvBundle(env, secrets)
+		} else {
+			exported, err = exportEnvTemplate(env)
+		}
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		fmt.Println("❌ API not supported yet for this operation")
+		os.Exit(1)
+	}
+	jsonRaw, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := c.String("file")
+	if file == "" {
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	// Bundles with secrets must only be readable by the owner
+	if err := os.WriteFile(file, jsonRaw, 0600); err != nil {
+		return err
+	}
+	if !silentFlag {
+		fmt.Printf("✅ environment %s was exported to %s\n", envName, file)
+	}
+	return nil
+}
+
+// Helper to export an environment as bundle, with tags, saved queries and permissions
+func exportEnvBundle(env environments.TLSEnvironment, secrets bool) (environments.EnvBundle, error) {
+	bundle, err := envs.ExportBundle(env, secrets)
+	if err != nil {
+		return bundle, err
+	}
+	if bundle.Tags, err = exportEnvTags(env); err != nil {
+		return bundle, err
+	}
+	saved, err := queriesmgr.GetSavedByEnv(env.ID)
+	if err != nil {
+		return bundle, err
+	}
+	for _, q := range saved {
+		bundle.SavedQueries = append(bundle.SavedQueries, environments.BundleSavedQuery{
+			Name:      q.Name,
+			Creator:   q.Creator,
+			Query:     q.Query,
+			ExtraData: q.ExtraData,
+		})
+	}
+	perms, err := adminUsers.GetEnvAllPermissions(env.UUID)
+	if err != nil {
+		return bundle, err
+	}
+	for _, p := range perms {
+		bundle.Permissions = append(bundle.Permissions, environments.BundlePermission{
+			Username:    p.Username,
+			AccessType:  p.AccessType,
+			AccessValue: p.AccessValue,
+		})
+	}
+	return bundle, nil
+}
+
+func importEnvironment(c *cli.Context) error {
+	// Get bundle or template file
+	file := c.String("file")
+	if file == "" {
+		fmt.Println("❌ file is required")
+		os.Exit(1)
+	}
+	// Get conflict handling
+	conflict := c.String("on-conflict")
+	if !environments.ValidConflicts[conflict] {
+		fmt.Printf("❌ invalid conflict handling %s\n", conflict)
+		os.Exit(1)
+	}
+	var env environments.TLSEnvironment
+	var result string
+	if dbFlag {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		bundle, err := environments.ParseBundle(data)
+		if err != nil {
+			return err
+		}
+		env, result, err = envs.ImportBundle(bundle, c.String("name"), c.String("hostname"), conflict, c.Bool("new-secrets"))
+		if err != nil {
+			return err
+		}
+		if result != environments.ImportSkipped {
+			if err := importEnvBundle(bundle, env, result); err != nil {
+				return err
+			}
+		}
+	} else if apiFlag {
+		fmt.Println("❌ API not supported yet for this operation")
+		os.Exit(1)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ environment %s was %s from %s\n", env.Name, result, file)
+	}
+	return nil
+}
+
+// Helper to import tags, saved queries and permissions of a bundle and record the configuration revision
+func importEnvBundle(bundle environments.EnvBundle, env environments.TLSEnvironment, result string) error {
+	// Create a tag for this new environment and the tags from the bundle
+	if result == environments.ImportCreated {
+		if err := tagsmgr.NewTag(env.Name, "Tag for environment "+env.Name, tags.RandomColor(), env.Icon, appName, env.ID); err != nil {
+			return err
+		}
+	}
+	if err := createTemplateTags(bundle.EnvTemplate, env.ID); err != nil {
+		return err
+	}
+	for _, q := range bundle.SavedQueries {
+		saved, err := queriesmgr.GetSaved(q.Name, q.Creator, env.ID)
+		if err != nil {
+			return err
+		}
+		if saved.ID != 0 {
+			err = queriesmgr.UpdateSaved(q.Name, q.Query, q.Creator, env.ID)
+		} else {
+			err = queriesmgr.CreateSaved(q.Name, q.Query, q.Creator, env.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var perms []users.UserPermission
+	for _, p := range bundle.Permissions {
+		perms = append(perms, users.UserPermission{Username: p.Username, AccessType: p.AccessType, AccessValue: p.AccessValue})
+	}
+	if err := adminUsers.GrantEnvPermissions(perms, env, appName); err != nil {
+		return err
+	}
+	// Record the imported configuration as a new revision
+	if _, err := envs.CommitRevision(env.UUID, appName, fmt.Sprintf("%s from bundle %s", result, bundle.Name)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -478,6 +478,93 @@ func init() {
 					},
 					Action: cliWrapper(addEnvironment),
 				},
+				{
+					Name:  "clone",
+					Usage: "Clone an existing TLS environment into a new one with fresh secrets",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be cloned",
+						},
+						&cli.StringFlag{
+							Name:    "target",
+							Aliases: []string{"t"},
+							Usage:   "Environment name to be created",
+						},
+						&cli.StringFlag{
+							Name:    "hostname",
+							Aliases: []string{"host"},
+							Usage:   "Environment host for the new environment, same as the cloned one if empty",
+						},
+						&cli.BoolFlag{
+							Name:    "permissions",
+							Aliases: []string{"p"},
+							Usage:   "Copy user permissions to the new environment",
+						},
+					},
+					Action: cliWrapper(cloneEnvironment),
+				},
+				{
+					Name:  "export",
+					Usage: "Export a TLS environment as template or bundle in JSON",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be exported",
+						},
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "File to write the template or bundle, stdout if empty",
+						},
+						&cli.BoolFlag{
+							Name:    "bundle",
+							Aliases: []string{"b"},
+							Usage:   "Export a complete bundle with certificate, saved queries and permissions",
+						},
+						&cli.BoolFlag{
+							Name:    "secrets",
+							Aliases: []string{"s"},
+							Usage:   "Include UUID and secrets in the bundle so enrolled nodes keep working",
+						},
+					},
+					Action: cliWrapper(exportEnvironment),
+				},
+				{
+					Name:  "import",
+					Usage: "Add or replace a TLS environment from a template or bundle in JSON",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Template or bundle file to be read",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be added, name in the file if empty",
+						},
+						&cli.StringFlag{
+							Name:    "hostname",
+							Aliases: []string{"host"},
+							Usage:   "Environment host to be added, hostname in the bundle if empty",
+						},
+						&cli.StringFlag{
+							Name:    "on-conflict",
+							Aliases: []string{"c"},
+							Value:   environments.ConflictRename,
+							Usage:   "What to do if the environment exists: rename, overwrite or skip",
+						},
+						&cli.BoolFlag{
+							Name:    "new-secrets",
+							Aliases: []string{"S"},
+							Usage:   "Generate new secrets instead of using the secrets in the bundle",
+						},
+					},
+					Action: cliWrapper(importEnvironment),
+				},
 				{
 					Name:    "update",
 					Aliases: []string{"u"},
@@ -949,6 +1036,74 @@ func init() {
 							Usage:   "Output the certificate to enroll nodes in an environment",
 							Action:  cliWrapper(certificateEnvironment),
 						},
+						{
+							Name:   "require-approval",
+							Usage:  "New enrollments in a TLS environment stay pending until approved",
+							Action: cliWrapper(requireApprovalEnvironment),
+						},
+						{
+							Name:   "no-approval",
+							Usage:  "New enrollments in a TLS environment do not need approval",
+							Action: cliWrapper(noApprovalEnvironment),
+						},
+						{
+							Name:  "new-token",
+							Usage: "Mint a new enrollment token for a TLS environment",
+							Flags: []cli.Flag{
+								&cli.IntFlag{
+									Name:    "max-uses",
+									Aliases: []string{"m"},
+									Value:   1,
+									Usage:   "Maximum number of enrollments with the token, zero for unlimited",
+								},
+								&cli.IntFlag{
+									Name:    "expire",
+									Aliases: []string{"E"},
+									Value:   24,
+									Usage:   "Hours until the token expires",
+								},
+								&cli.StringFlag{
+									Name:    "comment",
+									Aliases: []string{"C"},
+									Usage:   "Comment for the token, like the batch of hosts",
+								},
+							},
+							Action: cliWrapper(newTokenEnvironment),
+						},
+						{
+							Name:  "require-client-cert",
+							Usage: "Nodes in a TLS environment must present a client certificate signed by the CA",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "ca",
+									Aliases: []string{"C"},
+									Usage:   "CA to verify client certificates from `FILE`, keeps the current CA if empty",
+								},
+							},
+							Action: cliWrapper(requireClientCertEnvironment),
+						},
+						{
+							Name:   "no-client-cert",
+							Usage:  "Nodes in a TLS environment do not need a client certificate",
+							Action: cliWrapper(noClientCertEnvironment),
+						},
+						{
+							Name:   "list-tokens",
+							Usage:  "List the enrollment tokens for a TLS environment",
+							Action: cliWrapper(listTokensEnvironment),
+						},
+						{
+							Name:  "revoke-token",
+							Usage: "Revoke an enrollment token for a TLS environment",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "token",
+									Aliases: []string{"t"},
+									Usage:   "Enrollment token to be revoked",
+								},
+							},
+							Action: cliWrapper(revokeTokenEnvironment),
+						},
 					},
 					Usage: "Node enroll actions for an environment",
 					Flags: []cli.Flag{
@@ -960,6 +1115,302 @@ func init() {
 						},
 					},
 				},
+				{
+					Name: "revisions",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the configuration revisions for a TLS environment",
+							Action:  cliWrapper(listRevisionsEnvironment),
+						},
+						{
+							Name:  "diff",
+							Usage: "Show the configuration changes between two revisions",
+							Flags: []cli.Flag{
+								&cli.IntFlag{
+									Name:    "from",
+									Aliases: []string{"f"},
+									Usage:   "Revision to compare from",
+								},
+								&cli.IntFlag{
+									Name:    "to",
+									Aliases: []string{"t"},
+									Usage:   "Revision to compare to",
+								},
+							},
+							Action: cliWrapper(diffRevisionsEnvironment),
+						},
+						{
+							Name:  "rollback",
+							Usage: "Restore the configuration of a TLS environment from a prior revision",
+							Flags: []cli.Flag{
+								&cli.IntFlag{
+									Name:    "revision",
+									Aliases: []string{"r"},
+									Usage:   "Revision to be restored",
+								},
+							},
+							Action: cliWrapper(rollbackRevisionEnvironment),
+						},
+					},
+					Usage: "Configuration revisions for an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Value:   "",
+							Usage:   "Environment name to be used",
+						},
+					},
+				},
+				{
+					Name: "rollout",
+					Subcommands: []*cli.Command{
+						{
+							Name:   "status",
+							Usage:  "Show the active configuration rollout and how nodes adopted it",
+							Action: cliWrapper(statusRolloutEnvironment),
+						},
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the configuration rollouts for a TLS environment",
+							Action:  cliWrapper(listRolloutsEnvironment),
+						},
+						{
+							Name:  "start",
+							Usage: "Serve configuration changes only to a percentage or tag of nodes",
+							Flags: []cli.Flag{
+								&cli.IntFlag{
+									Name:    "percentage",
+									Aliases: []string{"p"},
+									Usage:   "Percentage of nodes in the canary",
+								},
+								&cli.StringFlag{
+									Name:    "tag",
+									Aliases: []string{"t"},
+									Usage:   "Tag of nodes in the canary",
+								},
+								&cli.IntFlag{
+									Name:    "base",
+									Aliases: []string{"b"},
+									Usage:   "Revision served to nodes outside the canary, latest revision if empty",
+								},
+							},
+							Action: cliWrapper(startRolloutEnvironment),
+						},
+						{
+							Name:   "promote",
+							Usage:  "Serve the current configuration to all nodes",
+							Action: cliWrapper(promoteRolloutEnvironment),
+						},
+						{
+							Name:   "abort",
+							Usage:  "Roll back all nodes to the base revision of the rollout",
+							Action: cliWrapper(abortRolloutEnvironment),
+						},
+					},
+					Usage: "Staged configuration rollouts for an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Value:   "",
+							Usage:   "Environment name to be used",
+						},
+					},
+				},
+				{
+					Name: "performance",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "show",
+							Aliases: []string{"s"},
+							Usage:   "Show the performance of the scheduled queries of a TLS environment",
+							Action:  cliWrapper(showPerformanceEnvironment),
+						},
+						{
+							Name:  "enable",
+							Usage: "Collect osquery_schedule stats from all nodes with a built-in scheduled query",
+							Flags: []cli.Flag{
+								&cli.IntFlag{
+									Name:    "interval",
+									Aliases: []string{"i"},
+									Usage:   "Interval in seconds to collect stats, one hour if empty",
+								},
+							},
+							Action: cliWrapper(enablePerformanceEnvironment),
+						},
+						{
+							Name:   "disable",
+							Usage:  "Stop collecting osquery_schedule stats",
+							Action: cliWrapper(disablePerformanceEnvironment),
+						},
+					},
+					Usage: "Performance of scheduled queries for an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Value:   "",
+							Usage:   "Environment name to be used",
+						},
+					},
+				},
+				{
+					Name: "platform-flags",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "show",
+							Aliases: []string{"s"},
+							Usage:   "Show the flags overrides per platform of a TLS environment",
+							Action:  cliWrapper(showPlatformFlagsEnvironment),
+						},
+						{
+							Name:  "set",
+							Usage: "Set the osquery flags that override the generated flags for a platform",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "platform",
+									Aliases: []string{"p"},
+									Usage:   "Platform for the flags (darwin, linux, windows, freebsd)",
+								},
+								&cli.StringFlag{
+									Name:  "flags",
+									Usage: "Flags to override, one --flag=value per line",
+								},
+								&cli.StringFlag{
+									Name:    "file",
+									Aliases: []string{"f"},
+									Usage:   "File with the flags to override",
+								},
+							},
+							Action: cliWrapper(setPlatformFlagsEnvironment),
+						},
+						{
+							Name:  "delete",
+							Usage: "Delete the flags overrides of a platform",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "platform",
+									Aliases: []string{"p"},
+									Usage:   "Platform for the flags (darwin, linux, windows, freebsd)",
+								},
+							},
+							Action: cliWrapper(deletePlatformFlagsEnvironment),
+						},
+					},
+					Usage: "Osquery flags overrides per platform for an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Value:   "",
+							Usage:   "Environment name to be used",
+						},
+					},
+				},
+				{
+					Name: "pack-source",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the pack sources for a TLS environment",
+							Action:  cliWrapper(listPackSourcesEnvironment),
+						},
+						{
+							Name:  "add",
+							Usage: "Load a query pack from a URL or a local path and refresh it periodically",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "pack",
+									Aliases: []string{"p"},
+									Usage:   "Pack name in the configuration",
+								},
+								&cli.StringFlag{
+									Name:    "source",
+									Aliases: []string{"s"},
+									Usage:   "URL or local path of the query pack",
+								},
+								&cli.IntFlag{
+									Name:    "interval",
+									Aliases: []string{"i"},
+									Usage:   "Interval in seconds to refresh the pack, one hour if empty",
+								},
+							},
+							Action: cliWrapper(addPackSourceEnvironment),
+						},
+						{
+							Name:  "sync",
+							Usage: "Fetch a pack source now and apply it if it changed",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "pack",
+									Aliases: []string{"p"},
+									Usage:   "Pack name in the configuration",
+								},
+							},
+							Action: cliWrapper(syncPackSourceEnvironment),
+						},
+						{
+							Name:  "delete",
+							Usage: "Stop refreshing a pack, the pack stays in the configuration",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "pack",
+									Aliases: []string{"p"},
+									Usage:   "Pack name in the configuration",
+								},
+							},
+							Action: cliWrapper(deletePackSourceEnvironment),
+						},
+					},
+					Usage: "Remote and local pack sources for an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Value:   "",
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:  "sources-dir",
+							Usage: "Allow packs from local paths inside this directory, only with DB access",
+						},
+						&cli.StringFlag{
+							Name:  "sources-hosts",
+							Usage: "Allow packs from URLs of these comma separated hosts, only with DB access",
+						},
+					},
+				},
+				{
+					Name:  "validate",
+					Usage: "Validate osquery configuration for a TLS environment without saving it",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "JSON file with the configuration or the configuration part",
+						},
+						&cli.StringFlag{
+							Name:    "section",
+							Aliases: []string{"s"},
+							Usage:   "Configuration part in the file (options, schedule, packs, decorators, auto_table_construction), full configuration if empty",
+						},
+						&cli.StringFlag{
+							Name:  "osquery-tables",
+							Usage: "Load osquery tables schema from `FILE` to check table names, only with DB access",
+						},
+					},
+					Action: cliWrapper(validateEnvironment),
+				},
 				{
 					Name:    "delete",
 					Aliases: []string{"d"},
@@ -1162,6 +1613,143 @@ func init() {
 					},
 					Action: cliWrapper(tagNode),
 				},
+				{
+					Name:    "move",
+					Aliases: []string{"m"},
+					Usage:   "Move existing nodes to a different environment",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be moved, can be used multiple times",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment where the nodes are currently enrolled",
+						},
+						&cli.StringFlag{
+							Name:    "dest",
+							Aliases: []string{"d"},
+							Usage:   "Destination environment for the nodes",
+						},
+						&cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"T"},
+							Usage:   "Move all nodes with this tag",
+						},
+						&cli.StringFlag{
+							Name:    "search",
+							Aliases: []string{"S"},
+							Usage:   "Move all nodes matching this UUID or hostname search",
+						},
+					},
+					Action: cliWrapper(moveNode),
+				},
+				{
+					Name:    "bulk",
+					Aliases: []string{"b"},
+					Usage:   "Preview or execute an action on all the nodes matching a selector",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Action to execute: delete, tag, untag or move",
+						},
+						&cli.StringFlag{
+							Name:    "platform",
+							Aliases: []string{"p"},
+							Usage:   "Select nodes with this platform",
+						},
+						&cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"T"},
+							Usage:   "Select nodes with this tag",
+						},
+						&cli.Int64Flag{
+							Name:    "inactive",
+							Aliases: []string{"i"},
+							Usage:   "Select nodes not seen for at least these hours",
+						},
+						&cli.StringFlag{
+							Name:    "value",
+							Aliases: []string{"V"},
+							Usage:   "Tag to tag or untag, or destination environment to move",
+						},
+						&cli.StringFlag{
+							Name:    "token",
+							Aliases: []string{"k"},
+							Usage:   "Confirmation token from the preview, to execute the action",
+						},
+					},
+					Action: cliWrapper(bulkNode),
+				},
+				{
+					Name:    "rotate-key",
+					Aliases: []string{"r"},
+					Usage:   "Rotate the node key of one node or all nodes in an environment, forcing re-enrollment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to rotate the key",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.BoolFlag{
+							Name:    "all",
+							Aliases: []string{"A"},
+							Value:   false,
+							Usage:   "Rotate the keys of all nodes in the environment",
+						},
+						&cli.IntFlag{
+							Name:    "grace",
+							Aliases: []string{"g"},
+							Value:   60,
+							Usage:   "Minutes the rotated keys are still accepted",
+						},
+					},
+					Action: cliWrapper(rotateNodeKey),
+				},
+				{
+					Name:    "pending",
+					Aliases: []string{"P"},
+					Usage:   "List nodes waiting for approval",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(pendingNodes),
+				},
+				{
+					Name:    "approve",
+					Aliases: []string{"A"},
+					Usage:   "Approve pending nodes by UUID or hardware serial",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:    "identifier",
+							Aliases: []string{"i"},
+							Usage:   "Node UUID or hardware serial to be approved, can be used multiple times",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(approveNodes),
+				},
 				{
 					Name:    "list",
 					Aliases: []string{"l"},
@@ -1599,6 +2187,82 @@ func init() {
 				},
 			},
 		},
+		{
+			Name:  "routes",
+			Usage: "Commands for log routing rules",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "test",
+					Usage: "Test which rules and sinks match an event",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Routing configuration file",
+						},
+						&cli.StringFlag{
+							Name:    "type",
+							Aliases: []string{"t"},
+							Value:   "result",
+							Usage:   "Log type of the event (status, result or query)",
+						},
+						&cli.StringFlag{
+							Name:    "environment",
+							Aliases: []string{"e"},
+							Usage:   "Environment of the event",
+						},
+						&cli.StringFlag{
+							Name:    "query",
+							Aliases: []string{"q"},
+							Usage:   "Scheduled or on-demand query name of the event",
+						},
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Tag of the node of the event, can be repeated",
+						},
+						&cli.IntFlag{
+							Name:    "severity",
+							Aliases: []string{"s"},
+							Usage:   "Severity of status logs",
+						},
+					},
+					Action: testRoutes,
+				},
+			},
+		},
+		{
+			Name:  "rules",
+			Usage: "Commands for detection rules",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "test",
+					Usage: "Test which detection rules raise alerts for sample result logs, suppressing duplicates",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Detection configuration file",
+						},
+						&cli.StringFlag{
+							Name:    "logs",
+							Aliases: []string{"l"},
+							Usage:   "Result log file with a JSON array or one event per line",
+						},
+						&cli.StringFlag{
+							Name:    "environment",
+							Aliases: []string{"e"},
+							Usage:   "Environment of the result logs",
+						},
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID of the result logs",
+						},
+					},
+					Action: testRules,
+				},
+			},
+		},
 		{
 			Name:   "check-db",
 			Usage:  "Checks DB connection",
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -177,6 +180,423 @@ func tagNode(c *cli.Context) error {
 	return nil
 }
 
+func moveNode(c *cli.Context) error {
+	// Get values from flags
+	uuids := c.StringSlice("uuid")
+	tag := c.String("tag")
+	search := c.String("search")
+	if len(uuids) == 0 && tag == "" && search == "" {
+		fmt.Println("❌ uuid, tag or search is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	dest := c.String("dest")
+	if dest == "" {
+		fmt.Println("❌ destination environment is required")
+		os.Exit(1)
+	}
+	moved := 0
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		d, err := envs.Get(dest)
+		if err != nil {
+			return fmt.Errorf("error destination get - %s", err)
+		}
+		if e.ID == d.ID {
+			return fmt.Errorf("nodes are already in %s", d.Name)
+		}
+		var targets []nodes.OsqueryNode
+		for _, u := range uuids {
+			n, err := nodesmgr.GetByUUIDEnv(u, e.ID)
+			if err != nil {
+				return fmt.Errorf("error get uuid - %s", err)
+			}
+			targets = append(targets, n)
+		}
+		if tag != "" {
+			tagged, err := tagsmgr.GetTaggedNodes(tag, e.ID)
+			if err != nil {
+				return fmt.Errorf("error get tagged - %s", err)
+			}
+			targets = append(targets, tagged...)
+		}
+		if search != "" {
+			found, err := nodesmgr.SearchByEnv(e.Name, search)
+			if err != nil {
+				return fmt.Errorf("error search - %s", err)
+			}
+			targets = append(targets, found...)
+		}
+		seen := make(map[uint]bool)
+		for _, n := range targets {
+			if seen[n.ID] || n.EnvironmentID != e.ID {
+				continue
+			}
+			seen[n.ID] = true
+			if err := tagsmgr.MoveNode(n, d.Name, d.ID, appName); err != nil {
+				return fmt.Errorf("error moving %s - %s", n.UUID, err)
+			}
+			moved++
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.MoveNodes(env, dest, uuids, tag, search); err != nil {
+			return fmt.Errorf("error moving nodes - %s", err)
+		}
+	}
+	if !silentFlag {
+		if dbFlag {
+			fmt.Printf("✅ %d node(s) moved successfully\n", moved)
+		} else {
+			fmt.Println("✅ nodes were moved successfully")
+		}
+	}
+	return nil
+}
+
+func rotateNodeKey(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	all := c.Bool("all")
+	if uuid == "" && !all {
+		fmt.Println("❌ uuid or all is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	grace := c.Int("grace")
+	if grace < 0 {
+		fmt.Println("❌ grace must be positive")
+		os.Exit(1)
+	}
+	msg := "node key rotated"
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		g := nodes.DefaultKeyGrace
+		if grace > 0 {
+			g = time.Duration(grace) * time.Minute
+		}
+		if all {
+			rotated, err := nodesmgr.RotateKeysByEnv(e.ID, g)
+			if err != nil {
+				return fmt.Errorf("error rotating keys - %s", err)
+			}
+			msg = fmt.Sprintf("%d node key(s) rotated", rotated)
+		} else {
+			n, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+			if err != nil {
+				return fmt.Errorf("error get uuid - %s", err)
+			}
+			if err := nodesmgr.RotateKey(n, g); err != nil {
+				return fmt.Errorf("error rotating key - %s", err)
+			}
+		}
+	} else if apiFlag {
+		msg, err = osctrlAPI.RotateNodeKeys(env, uuid, all, grace)
+		if err != nil {
+			return fmt.Errorf("error rotating keys - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ " + msg)
+	}
+	return nil
+}
+
+func pendingNodes(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var nds []nodes.OsqueryNode
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		nds, err = nodesmgr.GetPending(e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %s", err)
+		}
+	} else if apiFlag {
+		nds, err = osctrlAPI.GetNodes(env, "pending")
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %s", err)
+		}
+	}
+	header := []string{
+		"Hostname",
+		"UUID",
+		"Platform",
+		"PlatformVersion",
+		"Environment",
+		"Last Seen",
+		"IPAddress",
+		"OsqueryVersion",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(nds)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := nodesToData(nds, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(nds) > 0 {
+			fmt.Printf("Pending nodes (%d):\n", len(nds))
+			table.AppendBulk(nodesToData(nds, nil))
+		} else {
+			fmt.Println("No pending nodes")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func approveNodes(c *cli.Context) error {
+	// Get values from flags
+	identifiers := c.StringSlice("identifier")
+	if len(identifiers) == 0 {
+		fmt.Println("❌ identifier is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var msg string
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		var approved int64
+		for _, i := range identifiers {
+			n, err := nodesmgr.Approve(i, e.ID)
+			if err != nil {
+				return fmt.Errorf("error approving - %s", err)
+			}
+			approved += n
+		}
+		msg = fmt.Sprintf("%d node(s) approved", approved)
+	} else if apiFlag {
+		msg, err = osctrlAPI.ApproveNodes(env, identifiers)
+		if err != nil {
+			return fmt.Errorf("error approving nodes - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ " + msg)
+	}
+	return nil
+}
+
+// Helper function to select nodes in the DB with the same criteria as the API
+func bulkSelectDB(envID uint, b types.ApiNodeBulkRequest) ([]nodes.OsqueryNode, error) {
+	sel := nodes.NodeSelector{
+		EnvironmentID: envID,
+		Platform:      b.Platform,
+		Inactive:      b.Inactive,
+	}
+	if b.Tag != "" {
+		ids, err := tagsmgr.GetTaggedNodeIDs(b.Tag, envID)
+		if err != nil {
+			return []nodes.OsqueryNode{}, err
+		}
+		sel.Tagged = true
+		sel.IDs = ids
+	}
+	return nodesmgr.GetByNodeSelector(sel)
+}
+
+// Helper function to execute a bulk action in the DB
+func bulkExecuteDB(env environments.TLSEnvironment, b types.ApiNodeBulkRequest) (types.ApiNodeBulkResponse, error) {
+	res := types.ApiNodeBulkResponse{
+		Action:  b.Action,
+		Results: []types.ApiNodeBulkResult{},
+	}
+	targets, err := bulkSelectDB(env.ID, b)
+	if err != nil {
+		return res, fmt.Errorf("error selecting nodes - %s", err)
+	}
+	if !nodes.CheckBulkToken([]byte(db.DSN), b.Token, b.Action, b.Value, targets, time.Now()) {
+		return res, fmt.Errorf("invalid or stale confirmation token, preview the action again")
+	}
+	var dest environments.TLSEnvironment
+	if b.Action == nodes.BulkMove {
+		if dest, err = envs.Get(b.Value); err != nil {
+			return res, fmt.Errorf("error destination get - %s", err)
+		}
+		if dest.ID == env.ID {
+			return res, fmt.Errorf("nodes are already in %s", dest.Name)
+		}
+	}
+	res.Count = len(targets)
+	for _, n := range targets {
+		var err error
+		switch b.Action {
+		case nodes.BulkDelete:
+			err = nodesmgr.ArchiveDeleteByUUID(n.UUID)
+		case nodes.BulkTag:
+			err = tagsmgr.TagNode(b.Value, n, appName, false)
+		case nodes.BulkUntag:
+			err = tagsmgr.UntagNode(b.Value, n)
+		case nodes.BulkMove:
+			err = tagsmgr.MoveNode(n, dest.Name, dest.ID, appName)
+		}
+		result := types.ApiNodeBulkResult{
+			UUID:     n.UUID,
+			Hostname: n.Hostname,
+			Success:  err == nil,
+		}
+		if err != nil {
+			result.Error = err.Error()
+			res.Failed++
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res, nil
+}
+
+func bulkNode(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	b := types.ApiNodeBulkRequest{
+		Action:   c.String("action"),
+		Platform: c.String("platform"),
+		Tag:      c.String("tag"),
+		Inactive: c.Int64("inactive"),
+		Value:    c.String("value"),
+		Token:    c.String("token"),
+	}
+	if !nodes.BulkActions[b.Action] {
+		fmt.Println("❌ action must be delete, tag, untag or move")
+		os.Exit(1)
+	}
+	if b.Value == "" && (b.Action == nodes.BulkTag || b.Action == nodes.BulkUntag || b.Action == nodes.BulkMove) {
+		fmt.Println("❌ value is required for " + b.Action)
+		os.Exit(1)
+	}
+	// Without token, preview the affected nodes
+	if b.Token == "" {
+		var preview types.ApiNodeBulkPreviewResponse
+		if dbFlag {
+			e, err := envs.Get(env)
+			if err != nil {
+				return fmt.Errorf("error env get - %s", err)
+			}
+			targets, err := bulkSelectDB(e.ID, b)
+			if err != nil {
+				return fmt.Errorf("error selecting nodes - %s", err)
+			}
+			preview = types.ApiNodeBulkPreviewResponse{
+				Action: b.Action,
+				Count:  len(targets),
+				Token:  nodes.BulkToken([]byte(db.DSN), b.Action, b.Value, targets, time.Now().Add(nodes.DefaultBulkTokenExpiration)),
+			}
+			for _, n := range targets {
+				preview.UUIDs = append(preview.UUIDs, n.UUID)
+			}
+		} else if apiFlag {
+			preview, err = osctrlAPI.BulkPreviewNodes(env, b)
+			if err != nil {
+				return fmt.Errorf("error previewing nodes - %s", err)
+			}
+		}
+		if formatFlag == jsonFormat {
+			jsonRaw, err := json.Marshal(preview)
+			if err != nil {
+				return fmt.Errorf("error marshaling - %s", err)
+			}
+			fmt.Println(string(jsonRaw))
+			return nil
+		}
+		fmt.Printf("%s would affect %d node(s)\n", preview.Action, preview.Count)
+		for _, u := range preview.UUIDs {
+			fmt.Println("  " + u)
+		}
+		if preview.Count > 0 {
+			fmt.Printf("To execute, run again with --token %s\n", preview.Token)
+		}
+		return nil
+	}
+	var res types.ApiNodeBulkResponse
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if res, err = bulkExecuteDB(e, b); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.BulkNodes(env, b)
+		if err != nil {
+			return fmt.Errorf("error executing bulk action - %s", err)
+		}
+	}
+	// Prepare per node report
+	header := []string{
+		"UUID",
+		"Hostname",
+		"Result",
+	}
+	var data [][]string
+	for _, r := range res.Results {
+		result := "ok"
+		if !r.Success {
+			result = r.Error
+		}
+		data = append(data, []string{r.UUID, r.Hostname, result})
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(res)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		fmt.Printf("%s executed on %d node(s), %d failed:\n", res.Action, res.Count, res.Failed)
+		table.Render()
+	}
+	return nil
+}
+
 func showNode(c *cli.Context) error {
 	// Get values from flags
 	uuid := c.String("uuid")
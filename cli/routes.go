@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func testRoutes(c *cli.Context) error {
+	// Get routing configuration file
+	routesFile := c.String("file")
+	if routesFile == "" {
+		fmt.Println("❌ routing configuration file is required")
+		os.Exit(1)
+	}
+	// Get log type of the event
+	logType := c.String("type")
+	if !logging.ValidRouteLogTypes[logType] {
+		fmt.Println("❌ log type must be status, result or query")
+		os.Exit(1)
+	}
+	routing, err := logging.LoadRouting(routesFile)
+	if err != nil {
+		return err
+	}
+	router, err := logging.NewRouter(routing)
+	if err != nil {
+		return err
+	}
+	event := logging.RouteEvent{
+		LogType:     logType,
+		Environment: c.String("environment"),
+		Query:       c.String("query"),
+		Tags:        c.StringSlice("tag"),
+	}
+	if c.IsSet("severity") {
+		severity := c.Int("severity")
+		event.Severity = &severity
+	}
+	matched := router.Match(event)
+	sinks := router.Route(event)
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(struct {
+			Rules []logging.RouteRule `json:"rules"`
+			Sinks []string            `json:"sinks"`
+		}{Rules: matched, Sinks: sinks})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if len(matched) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{
+			"Rule",
+			"Sinks",
+			"Final?",
+		})
+		for _, r := range matched {
+			table.Append([]string{
+				r.Name,
+				strings.Join(r.Sinks, ", "),
+				stringifyBool(r.Final),
+			})
+		}
+		table.Render()
+	} else {
+		fmt.Printf("No rules match, using default route\n")
+	}
+	fmt.Printf("Sinks: %s\n", strings.Join(sinks, ", "))
+	return nil
+}
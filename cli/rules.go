@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// readResultLogs to read result logs from a file with a JSON array of events or one event per line
+func readResultLogs(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		return data, nil
+	}
+	var events []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil, fmt.Errorf("invalid JSON in %s: %s", file, string(line))
+		}
+		events = append(events, json.RawMessage(append([]byte(nil), line...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(events)
+}
+
+func testRules(c *cli.Context) error {
+	// Get detection configuration file
+	rulesFile := c.String("file")
+	if rulesFile == "" {
+		fmt.Println("❌ detection configuration file is required")
+		os.Exit(1)
+	}
+	// Get sample log file
+	logFile := c.String("logs")
+	if logFile == "" {
+		fmt.Println("❌ result log file is required")
+		os.Exit(1)
+	}
+	detection, err := logging.LoadDetection(rulesFile)
+	if err != nil {
+		return err
+	}
+	detector, err := logging.NewDetector(detection)
+	if err != nil {
+		return err
+	}
+	data, err := readResultLogs(logFile)
+	if err != nil {
+		return err
+	}
+	alerts, err := detector.Evaluate(c.String("environment"), c.String("uuid"), data, time.Now())
+	if err != nil {
+		return err
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(struct {
+			Alerts     []logging.DetectionAlert `json:"alerts"`
+			Suppressed int64                    `json:"suppressed"`
+		}{Alerts: alerts, Suppressed: detector.Suppressed()})
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if len(alerts) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{
+			"Rule",
+			"Severity",
+			"Query",
+			"Action",
+			"Data",
+		})
+		for _, a := range alerts {
+			table.Append([]string{
+				a.Rule,
+				a.Severity,
+				a.Query,
+				a.Action,
+				a.Data,
+			})
+		}
+		table.Render()
+	} else {
+		fmt.Printf("No rules match\n")
+	}
+	fmt.Printf("Alerts: %d, suppressed: %d\n", len(alerts), detector.Suppressed())
+	return nil
+}
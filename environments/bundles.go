@@ -0,0 +1,196 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmpsec/osctrl/settings"
+)
+
+const (
+	// BundleKind to identify environment bundles from environment templates
+	BundleKind string = "bundle"
+)
+
+const (
+	// ConflictRename to import a bundle with a new name when the environment already exists
+	ConflictRename = "rename"
+	// ConflictOverwrite to replace an existing environment with the content of a bundle
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip to leave an existing environment untouched
+	ConflictSkip = "skip"
+)
+
+const (
+	// ImportCreated when a new environment was created from a bundle
+	ImportCreated = "created"
+	// ImportOverwritten when an existing environment was replaced from a bundle
+	ImportOverwritten = "overwritten"
+	// ImportSkipped when an existing environment was left untouched
+	ImportSkipped = "skipped"
+)
+
+// ValidConflicts to check the conflict handling when importing bundles
+var ValidConflicts = map[string]bool{
+	ConflictRename:    true,
+	ConflictOverwrite: true,
+	ConflictSkip:      true,
+}
+
+// EnvBundle to hold a complete environment for backup or to move it between osctrl deployments
+// Secrets are only included when explicitly requested
+type EnvBundle struct {
+	EnvTemplate
+	Kind         string             `json:"kind"`
+	Hostname     string             `json:"hostname"`
+	Certificate  string             `json:"certificate"`
+	Secrets      *BundleSecrets     `json:"secrets,omitempty"`
+	SavedQueries []BundleSavedQuery `json:"saved_queries,omitempty"`
+	Permissions  []BundlePermission `json:"permissions,omitempty"`
+}
+
+// BundleSecrets to hold the identifiers and secrets used by enrolled nodes
+type BundleSecrets struct {
+	UUID             string `json:"uuid"`
+	Secret           string `json:"secret"`
+	EnrollSecretPath string `json:"enroll_secret_path"`
+	RemoveSecretPath string `json:"remove_secret_path"`
+}
+
+// BundleSavedQuery to hold a saved query of an environment in a bundle
+type BundleSavedQuery struct {
+	Name      string `json:"name"`
+	Creator   string `json:"creator"`
+	Query     string `json:"query"`
+	ExtraData string `json:"extra_data,omitempty"`
+}
+
+// BundlePermission to hold a permission of a user in an environment in a bundle
+type BundlePermission struct {
+	Username    string `json:"username"`
+	AccessType  int    `json:"access_type"`
+	AccessValue bool   `json:"access_value"`
+}
+
+// ExportBundle to generate a bundle from an existing environment, optionally with its secrets
+// Tags, saved queries and permissions are kept by other managers and must be added by the caller
+func (environment *Environment) ExportBundle(env TLSEnvironment, secrets bool) (EnvBundle, error) {
+	tpl, err := environment.ExportTemplate(env)
+	if err != nil {
+		return EnvBundle{}, err
+	}
+	bundle := EnvBundle{
+		EnvTemplate: tpl,
+		Kind:        BundleKind,
+		Hostname:    env.Hostname,
+		Certificate: env.Certificate,
+	}
+	if secrets {
+		bundle.Secrets = &BundleSecrets{
+			UUID:             env.UUID,
+			Secret:           env.Secret,
+			EnrollSecretPath: env.EnrollSecretPath,
+			RemoveSecretPath: env.RemoveSecretPath,
+		}
+	}
+	return bundle, nil
+}
+
+// ParseBundle to parse a serialized environment bundle, templates are parsed as bundles without extra values
+func ParseBundle(data []byte) (EnvBundle, error) {
+	var bundle EnvBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return bundle, fmt.Errorf("error parsing bundle %v", err)
+	}
+	if bundle.Version != EnvTemplateVersion {
+		return bundle, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	if bundle.Kind != "" && bundle.Kind != BundleKind {
+		return bundle, fmt.Errorf("unsupported bundle kind %s", bundle.Kind)
+	}
+	return bundle, nil
+}
+
+// ImportBundle to create or replace an environment from a bundle, returning the environment and what was done
+// Empty name and hostname are taken from the bundle, and secrets are generated again if requested or missing
+func (environment *Environment) ImportBundle(bundle EnvBundle, name, hostname, conflict string, newSecrets bool) (TLSEnvironment, string, error) {
+	if name == "" {
+		name = bundle.Name
+	}
+	if hostname == "" {
+		hostname = bundle.Hostname
+	}
+	if !ValidConflicts[conflict] {
+		return TLSEnvironment{}, "", fmt.Errorf("invalid conflict handling %s", conflict)
+	}
+	existing, err := environment.Get(name)
+	exists := err == nil
+	if exists {
+		switch conflict {
+		case ConflictSkip:
+			return existing, ImportSkipped, nil
+		case ConflictRename:
+			name = environment.freeName(name)
+			exists = false
+		}
+	}
+	env, err := environment.FromTemplate(bundle.EnvTemplate, name, hostname)
+	if err != nil {
+		return env, "", err
+	}
+	env.Certificate = bundle.Certificate
+	if exists {
+		// Keep identifiers and secrets of the existing environment, secrets are replaced if the bundle has them
+		env.Model = existing.Model
+		env.UUID = existing.UUID
+		env.EnrollExpire = existing.EnrollExpire
+		env.RemoveExpire = existing.RemoveExpire
+		env.UserID = existing.UserID
+		if !newSecrets {
+			env.Secret = existing.Secret
+			env.EnrollSecretPath = existing.EnrollSecretPath
+			env.RemoveSecretPath = existing.RemoveSecretPath
+		}
+	}
+	if bundle.Secrets != nil && !newSecrets {
+		environment.applySecrets(&env, *bundle.Secrets, !exists)
+	}
+	if env.Flags, err = environment.templateFlags(bundle.EnvTemplate, env); err != nil {
+		return env, "", err
+	}
+	if !exists {
+		env, err = environment.createWithSettings(env, bundle.Settings)
+		return env, ImportCreated, err
+	}
+	if err := environment.DB.Save(&env).Error; err != nil {
+		return env, "", fmt.Errorf("Save TLS Environment %v", err)
+	}
+	// Settings of the environment are replaced with the settings of the bundle
+	if err := environment.DB.Unscoped().Where("environment_id = ? AND json = ?", env.ID, false).Delete(&settings.SettingValue{}).Error; err != nil {
+		return env, "", fmt.Errorf("Delete SettingValue %v", err)
+	}
+	if err := environment.createSettings(env.ID, bundle.Settings); err != nil {
+		return env, "", err
+	}
+	return env, ImportOverwritten, nil
+}
+
+// applySecrets to use the secrets of a bundle, the UUID is only used for new environments if no other environment has it
+func (environment *Environment) applySecrets(env *TLSEnvironment, secrets BundleSecrets, newEnv bool) {
+	if newEnv && secrets.UUID != "" && !environment.Exists(secrets.UUID) {
+		env.UUID = secrets.UUID
+	}
+	setString(&env.Secret, secrets.Secret)
+	setString(&env.EnrollSecretPath, secrets.EnrollSecretPath)
+	setString(&env.RemoveSecretPath, secrets.RemoveSecretPath)
+}
+
+// freeName to find a name for an environment that is not used yet
+func (environment *Environment) freeName(name string) string {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if !environment.Exists(candidate) {
+			return candidate
+		}
+	}
+}
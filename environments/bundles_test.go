@@ -0,0 +1,42 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBundle(t *testing.T) {
+	t.Run("bundle", func(t *testing.T) {
+		raw, err := json.Marshal(EnvBundle{
+			EnvTemplate: EnvTemplate{Version: EnvTemplateVersion, Name: "prod", ConfigInterval: 60},
+			Kind:        BundleKind,
+			Hostname:    "osctrl.example.com",
+			Secrets:     &BundleSecrets{UUID: "uuid-1", Secret: "secret"},
+		})
+		assert.NoError(t, err)
+		bundle, err := ParseBundle(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", bundle.Name)
+		assert.Equal(t, 60, bundle.ConfigInterval)
+		assert.Equal(t, "osctrl.example.com", bundle.Hostname)
+		assert.Equal(t, "uuid-1", bundle.Secrets.UUID)
+		// Bundles are also valid templates
+		tpl, err := ParseTemplate(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", tpl.Name)
+	})
+	t.Run("template", func(t *testing.T) {
+		bundle, err := ParseBundle([]byte(`{"version": 1, "name": "dev"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "dev", bundle.Name)
+		assert.Nil(t, bundle.Secrets)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParseBundle([]byte(`{"version": 1, "kind": "other"}`))
+		assert.EqualError(t, err, "unsupported bundle kind other")
+		_, err = ParseBundle([]byte(`{"version": 0}`))
+		assert.EqualError(t, err, "unsupported bundle version 0")
+	})
+}
@@ -0,0 +1,178 @@
+package environments
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// BuildDeb to generate a debian package with the provided files
+// The package is an ar archive with debian-binary, control.tar.gz and data.tar.gz
+func BuildDeb(meta PackageMeta, files []PackageFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteDeb(&buf, meta, files); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteDeb to write a debian package with the provided files, data.tar.gz is staged in a temporary file
+func WriteDeb(w io.Writer, meta PackageMeta, files []PackageFile) error {
+	now := time.Now()
+	data, err := os.CreateTemp("", "osctrl-deb-*")
+	if err != nil {
+		return fmt.Errorf("error creating data %v", err)
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+	size, err := debData(data, files, now)
+	if err != nil {
+		return fmt.Errorf("error generating data %v", err)
+	}
+	dataSize, err := data.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("error generating data %v", err)
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error generating data %v", err)
+	}
+	control, err := debControl(meta, files, size, now)
+	if err != nil {
+		return fmt.Errorf("error generating control %v", err)
+	}
+	if _, err := io.WriteString(w, "!<arch>\n"); err != nil {
+		return err
+	}
+	members := []struct {
+		name string
+		size int64
+		body io.Reader
+	}{
+		{"debian-binary", 4, strings.NewReader("2.0\n")},
+		{"control.tar.gz", int64(len(control)), bytes.NewReader(control)},
+		{"data.tar.gz", dataSize, data},
+	}
+	for _, m := range members {
+		if _, err := fmt.Fprintf(w, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name, now.Unix(), 0, 0, "100644", m.size); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, m.body, m.size); err != nil {
+			return fmt.Errorf("error writing %s %v", m.name, err)
+		}
+		// Members of ar archives are aligned to 2 bytes
+		if m.size%2 != 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// debData to write the data.tar.gz with the files and their parent directories, returning the installed size in KB
+func debData(w io.Writer, files []PackageFile, now time.Time) (int64, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	dirs := make(map[string]bool)
+	var size int64
+	for _, f := range files {
+		var parents []string
+		for d := path.Dir(f.Path); d != "/" && !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+			parents = append([]string{d}, parents...)
+		}
+		for _, d := range parents {
+			hdr := &tar.Header{Typeflag: tar.TypeDir, Name: "." + d + "/", Mode: 0755, ModTime: now, Uname: "root", Gname: "root"}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return 0, err
+			}
+		}
+		fSize, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "." + f.Path, Mode: int64(f.Mode), Size: fSize, ModTime: now, Uname: "root", Gname: "root"}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		if err := f.copyTo(tw, fSize); err != nil {
+			return 0, err
+		}
+		size += fSize
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return (size + 1023) / 1024, nil
+}
+
+// debControl to generate the control.tar.gz with the metadata and scripts of the package
+func debControl(meta PackageMeta, files []PackageFile, size int64, now time.Time) ([]byte, error) {
+	var control strings.Builder
+	fmt.Fprintf(&control, "Package: %s\n", meta.Name)
+	fmt.Fprintf(&control, "Version: %s-%s\n", meta.Version, meta.Release)
+	fmt.Fprintf(&control, "Architecture: %s\n", meta.Arch)
+	fmt.Fprintf(&control, "Maintainer: %s\n", meta.Maintainer)
+	fmt.Fprintf(&control, "Installed-Size: %d\n", size)
+	if len(meta.Conflicts) > 0 {
+		fmt.Fprintf(&control, "Conflicts: %s\n", strings.Join(meta.Conflicts, ", "))
+		fmt.Fprintf(&control, "Replaces: %s\n", strings.Join(meta.Conflicts, ", "))
+	}
+	control.WriteString("Section: admin\nPriority: optional\n")
+	fmt.Fprintf(&control, "Homepage: %s\n", meta.URL)
+	fmt.Fprintf(&control, "Description: %s\n %s\n", meta.Summary, meta.Description)
+	var md5sums, conffiles strings.Builder
+	for _, f := range files {
+		sum, err := f.digest(md5.New())
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&md5sums, "%x  %s\n", sum, strings.TrimPrefix(f.Path, "/"))
+		if f.Config {
+			conffiles.WriteString(f.Path + "\n")
+		}
+	}
+	members := []PackageFile{
+		{Path: "control", Mode: 0644, Body: []byte(control.String())},
+		{Path: "md5sums", Mode: 0644, Body: []byte(md5sums.String())},
+	}
+	if conffiles.Len() > 0 {
+		members = append(members, PackageFile{Path: "conffiles", Mode: 0644, Body: []byte(conffiles.String())})
+	}
+	if meta.PostInstall != "" {
+		members = append(members, PackageFile{Path: "postinst", Mode: 0755, Body: []byte("#!/bin/sh\nset -e\n" + meta.PostInstall)})
+	}
+	if meta.PreRemove != "" {
+		script := "#!/bin/sh\nset -e\nif [ \"$1\" = \"remove\" ]; then\n" + meta.PreRemove + "fi\n"
+		members = append(members, PackageFile{Path: "prerm", Mode: 0755, Body: []byte(script)})
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "./" + m.Path, Mode: int64(m.Mode), Size: int64(len(m.Body)), ModTime: now, Uname: "root", Gname: "root"}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(m.Body); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
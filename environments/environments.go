@@ -73,6 +73,7 @@ type TLSEnvironment struct {
 	Decorators       string
 	ATC              string
 	Configuration    string
+	ConfigHash       string
 	Flags            string
 	Certificate      string
 	ConfigTLS        bool
@@ -90,7 +91,11 @@ type TLSEnvironment struct {
 	CarverInitPath   string
 	CarverBlockPath  string
 	AcceptEnrolls    bool
-	UserID           uint
+	RequireApproval  bool
+	// RequireClientCert to only accept nodes presenting a client certificate signed by ClientCA
+	RequireClientCert bool
+	ClientCA          string
+	UserID            uint
 }
 
 // MapEnvironments to hold the TLS environments by name and UUID
@@ -99,6 +104,10 @@ type MapEnvironments map[string]TLSEnvironment
 // Environment keeps all TLS Environments
 type Environment struct {
 	DB *gorm.DB
+	// Tables to check the queries of configurations, if empty table names are not checked
+	Tables TableSet
+	// PackSources to restrict where query packs are fetched from
+	PackSources PackSourcePolicy
 }
 
 // CreateEnvironment to initialize the environment struct and tables
@@ -109,6 +118,30 @@ func CreateEnvironment(backend *gorm.DB) *Environment {
 	if err := backend.AutoMigrate(&TLSEnvironment{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (tls_environments): %v", err)
 	}
+	// table enroll_tokens
+	if err := backend.AutoMigrate(&EnrollToken{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (enroll_tokens): %v", err)
+	}
+	// table config_revisions
+	if err := backend.AutoMigrate(&ConfigRevision{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (config_revisions): %v", err)
+	}
+	// table config_rollouts
+	if err := backend.AutoMigrate(&ConfigRollout{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (config_rollouts): %v", err)
+	}
+	// table pack_sources
+	if err := backend.AutoMigrate(&PackSource{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (pack_sources): %v", err)
+	}
+	// table platform_flags
+	if err := backend.AutoMigrate(&PlatformFlags{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (platform_flags): %v", err)
+	}
+	// Environments created before revisions need one to roll back their first change
+	if err := e.BackfillRevisions(); err != nil {
+		log.Fatal().Msgf("Failed to backfill config_revisions: %v", err)
+	}
 	return e
 }
 
@@ -194,6 +227,7 @@ func (environment *Environment) Empty(name, hostname string) TLSEnvironment {
 
 // Create new TLS Environment
 func (environment *Environment) Create(env TLSEnvironment) error {
+	env.ConfigHash = OsqueryConfigHash(env.Configuration)
 	if err := environment.DB.Create(&env).Error; err != nil {
 		return fmt.Errorf("Create TLS Environment %v", err)
 	}
@@ -274,6 +308,9 @@ func (environment *Environment) Update(e TLSEnvironment) error {
 	if err != nil {
 		return fmt.Errorf("error getting environment %v", err)
 	}
+	if e.Configuration != "" {
+		e.ConfigHash = OsqueryConfigHash(e.Configuration)
+	}
 	if err := environment.DB.Model(&env).Updates(e).Error; err != nil {
 		return fmt.Errorf("Updates %v", err)
 	}
@@ -360,8 +397,15 @@ func (environment *Environment) UpdatePkgPackage(idEnv, pkgpackage string) error
 	return nil
 }
 
-// UpdateFlags to update flags for an environment
+// UpdateFlags to validate and update flags for an environment, including its flags overrides
 func (environment *Environment) UpdateFlags(idEnv, flags string) error {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return fmt.Errorf("error getting environment %v", err)
+	}
+	if err := environment.ValidateMergedFlags(env.ID, flags); err != nil {
+		return err
+	}
 	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("flags", flags).Error; err != nil {
 		return fmt.Errorf("Update flags %v", err)
 	}
@@ -525,3 +569,27 @@ func (environment *Environment) ChangeDebugHTTP(idEnv string, value bool) error
 	}
 	return nil
 }
+
+// ChangeRequireApproval to change if new enrollments in an environment need to be approved
+func (environment *Environment) ChangeRequireApproval(idEnv string, value bool) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(map[string]interface{}{"require_approval": value}).Error; err != nil {
+		return fmt.Errorf("UpdatesChangeRequireApproval %v", err)
+	}
+	return nil
+}
+
+// ChangeRequireClientCert to change if nodes in an environment must present a client certificate
+func (environment *Environment) ChangeRequireClientCert(idEnv string, value bool) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(map[string]interface{}{"require_client_cert": value}).Error; err != nil {
+		return fmt.Errorf("UpdatesChangeRequireClientCert %v", err)
+	}
+	return nil
+}
+
+// UpdateClientCA to update the CA used to verify client certificates for an environment
+func (environment *Environment) UpdateClientCA(idEnv, ca string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("client_ca", ca).Error; err != nil {
+		return fmt.Errorf("UpdateClientCA %v", err)
+	}
+	return nil
+}
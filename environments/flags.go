@@ -11,6 +11,9 @@ const (
 	CarverBlockSizeValue string = "5120000"
 	// FlagTLSServerCerts for the --tls_server_certs flag
 	FlagTLSServerCerts string = `--tls_server_certs={{ .CertFile }}`
+	// FlagTLSClientCert for the --tls_client_cert and --tls_client_key flags
+	FlagTLSClientCert string = `--tls_client_cert={{ .CertFile }}
+--tls_client_key={{ .KeyFile }}`
 	// FlagCarverBlockSize for the --carver_block_size flag
 	FlagCarverBlockSize string = `--carver_block_size={{ .BlockSize }}`
 	// FlagsTemplate to generate flags for enrolling nodes
@@ -41,6 +44,7 @@ const (
 --distributed_tls_write_endpoint=/{{ .Environment.UUID }}/{{ .Environment.QueryWritePath }}
 --tls_hostname={{ .Environment.Hostname }}
 {{ .FlagServerCerts }}
+{{ .FlagClientCert }}
 `
 )
 
@@ -49,12 +53,17 @@ const (
 	EmptyFlagSecret string = "__SECRET_FILE__"
 	// EmptyFlagCert to use as placeholder for the certificate file
 	EmptyFlagCert string = "__CERT_FILE__"
+	// EmptyFlagClientCert to use as placeholder for the client certificate file
+	EmptyFlagClientCert string = "__CLIENT_CERT_FILE__"
+	// EmptyFlagClientKey to use as placeholder for the client private key file
+	EmptyFlagClientKey string = "__CLIENT_KEY_FILE__"
 )
 
 type flagData struct {
 	SecretFile      string
 	Environment     TLSEnvironment
 	FlagServerCerts string
+	FlagClientCert  string
 	FlagCarverBlock string
 }
 
@@ -71,6 +80,18 @@ func GenServerCertsFlag(certificatePath string) string {
 	return GenGenericFlag("servercerts", FlagTLSServerCerts, data)
 }
 
+// GenClientCertFlag to generate the --tls_client_cert and --tls_client_key flags
+func GenClientCertFlag(certPath, keyPath string) string {
+	data := struct {
+		CertFile string
+		KeyFile  string
+	}{
+		CertFile: certPath,
+		KeyFile:  keyPath,
+	}
+	return GenGenericFlag("clientcert", FlagTLSClientCert, data)
+}
+
 // GenCarveBlockSizeFlag to generate the --carver_block_size flag
 func GenCarveBlockSizeFlag(blockSize string) string {
 	data := struct {
@@ -108,10 +129,15 @@ func (environment *Environment) GenerateFlags(env TLSEnvironment, secretPath, ce
 	if env.Certificate == "" {
 		flagServerCerts = ""
 	}
+	flagClientCert := ""
+	if env.RequireClientCert {
+		flagClientCert = GenClientCertFlag(EmptyFlagClientCert, EmptyFlagClientKey)
+	}
 	data := flagData{
 		SecretFile:      flagSecret,
 		Environment:     env,
 		FlagServerCerts: flagServerCerts,
+		FlagClientCert:  flagClientCert,
 		FlagCarverBlock: GenCarveBlockSizeFlag(CarverBlockSizeValue),
 	}
 	return GenGenericFlag("flags", FlagsTemplate, data), nil
@@ -119,8 +119,8 @@ iex ((New-Object System.Net.WebClient).DownloadString('https://{{ .TLSHost }}/{{
 	return PrepareOneLiner(s, insecure, environment, RemovePowershell)
 }
 
-// QuickAddScript to get a quick add script for a environment
-func QuickAddScript(project, script string, environment TLSEnvironment) (string, error) {
+// QuickAddScript to get a quick add script for a environment, with the flags to use for each platform
+func QuickAddScript(project, script string, environment TLSEnvironment, flags map[string]string) (string, error) {
 	if !validScript[script] {
 		return "", fmt.Errorf("invalid script - %s", script)
 	}
@@ -150,10 +150,12 @@ func QuickAddScript(project, script string, environment TLSEnvironment) (string,
 		Project        string
 		OsqueryVersion string
 		Environment    TLSEnvironment
+		Flags          map[string]string
 	}{
 		Project:        project,
 		OsqueryVersion: version.OsqueryVersion,
 		Environment:    environment,
+		Flags:          flags,
 	}
 	// Compile template into buffer
 	var tpl bytes.Buffer
@@ -54,3 +54,22 @@ func TestPrepareOneLiner(t *testing.T) {
 		assert.Equal(t, oneliner, "oneliner  1 hostname 2 name 3 rPath")
 	})
 }
+
+func TestQuickAddScriptFlags(t *testing.T) {
+	flags := map[string]string{
+		"linux":   "--linux_flag",
+		"darwin":  "--darwin_flag",
+		"freebsd": "--freebsd_flag",
+		"windows": "--windows_flag",
+	}
+	shell, err := QuickAddScript("osctrl-test", EnrollShell, TLSEnvironment{}, flags)
+	assert.NoError(t, err)
+	assert.Contains(t, shell, "--linux_flag")
+	assert.Contains(t, shell, "--darwin_flag")
+	assert.Contains(t, shell, "--freebsd_flag")
+	assert.NotContains(t, shell, "--windows_flag")
+	powershell, err := QuickAddScript("osctrl-test", EnrollPowershell, TLSEnvironment{}, flags)
+	assert.NoError(t, err)
+	assert.Contains(t, powershell, "--windows_flag")
+	assert.NotContains(t, powershell, "--linux_flag")
+}
@@ -101,7 +101,10 @@ func (environment *Environment) RefreshConfiguration(idEnv string) error {
 	if err != nil {
 		return fmt.Errorf("error serializing configuration %v", err)
 	}
-	if err := environment.DB.Model(&env).Update("configuration", indentedConf).Error; err != nil {
+	if err := environment.DB.Model(&env).Updates(map[string]interface{}{
+		"configuration": indentedConf,
+		"config_hash":   OsqueryConfigHash(indentedConf),
+	}).Error; err != nil {
 		return fmt.Errorf("Update configuration %v", err)
 	}
 	return nil
@@ -113,14 +116,21 @@ func (environment *Environment) UpdateConfiguration(idEnv string, cnf OsqueryCon
 	if err != nil {
 		return fmt.Errorf("error serializing configuration %v", err)
 	}
-	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("configuration", indentedConf).Error; err != nil {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(map[string]interface{}{
+		"configuration": indentedConf,
+		"config_hash":   OsqueryConfigHash(indentedConf),
+	}).Error; err != nil {
 		return fmt.Errorf("Update configuration %v", err)
 	}
 	return nil
 }
 
 // UpdateConfigurationParts to update all the configuration parts for an environment
+// The configuration is validated first, returning a *ValidationError with all the issues found
 func (environment *Environment) UpdateConfigurationParts(idEnv string, cnf OsqueryConf) error {
+	if err := environment.ValidateConf(cnf); err != nil {
+		return err
+	}
 	indentedOptions, err := environment.GenSerializedConf(cnf.Options, true)
 	if err != nil {
 		return fmt.Errorf("error serializing options %v", err)
@@ -308,6 +318,10 @@ func (environment *Environment) AddOptionsConf(name, option string, value interf
 	if err != nil {
 		return fmt.Errorf("error structuring options %v", err)
 	}
+	// Parts saved as null are empty
+	if _options == nil {
+		_options = make(OptionsConf)
+	}
 	// Add new option
 	_options[option] = value
 	// Generate serialized indented options
@@ -315,6 +329,10 @@ func (environment *Environment) AddOptionsConf(name, option string, value interf
 	if err != nil {
 		return fmt.Errorf("error serializing options %v", err)
 	}
+	// Validate configuration with the new options
+	if err := environment.ValidatePart(env, SectionOptions, []byte(indentedOptions)); err != nil {
+		return err
+	}
 	// Update options in environment
 	if err := environment.UpdateOptions(name, indentedOptions); err != nil {
 		return fmt.Errorf("error updating options %v", err)
@@ -366,6 +384,10 @@ func (environment *Environment) AddScheduleConfQuery(name, qName string, query S
 	if err != nil {
 		return fmt.Errorf("error structuring schedule %v", err)
 	}
+	// Parts saved as null are empty
+	if _schedule == nil {
+		_schedule = make(ScheduleConf)
+	}
 	// Add new query
 	_schedule[qName] = query
 	// Generate serialized indented schedule
@@ -373,6 +395,10 @@ func (environment *Environment) AddScheduleConfQuery(name, qName string, query S
 	if err != nil {
 		return fmt.Errorf("error serializing schedule %v", err)
 	}
+	// Validate configuration with the new schedule
+	if err := environment.ValidatePart(env, SectionSchedule, []byte(indentedSchedule)); err != nil {
+		return err
+	}
 	// Update schedule in environment
 	if err := environment.UpdateSchedule(name, indentedSchedule); err != nil {
 		return fmt.Errorf("error updating schedule %v", err)
@@ -424,6 +450,10 @@ func (environment *Environment) AddQueryPackConf(name, pName string, pack interf
 	if err != nil {
 		return fmt.Errorf("error structuring packs %v", err)
 	}
+	// Parts saved as null are empty
+	if _packs == nil {
+		_packs = make(PacksConf)
+	}
 	// Add new local pack
 	_packs[pName] = pack
 	// Generate serialized indented packs
@@ -431,6 +461,10 @@ func (environment *Environment) AddQueryPackConf(name, pName string, pack interf
 	if err != nil {
 		return fmt.Errorf("error serializing packs %v", err)
 	}
+	// Validate configuration with the new packs
+	if err := environment.ValidatePart(env, SectionPacks, []byte(indentedPacks)); err != nil {
+		return err
+	}
 	// Update schedule in environment
 	if err := environment.UpdatePacks(name, indentedPacks); err != nil {
 		return fmt.Errorf("error updating packs %v", err)
@@ -491,6 +525,10 @@ func (environment *Environment) AddQueryToPackConf(name, pName, qName string, qu
 	if err != nil {
 		return fmt.Errorf("error serializing packs %v", err)
 	}
+	// Validate configuration with the new packs
+	if err := environment.ValidatePart(env, SectionPacks, []byte(indentedPacks)); err != nil {
+		return err
+	}
 	// Update schedule in environment
 	if err := environment.UpdatePacks(name, indentedPacks); err != nil {
 		return fmt.Errorf("error updating packs %v", err)
@@ -0,0 +1,342 @@
+package environments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/version"
+)
+
+const (
+	// PackageName as name of the enrollment packages built for environments
+	PackageName string = "osquery-osctrl"
+	// PackageOsquerydPath as path for the osquery binary in enrollment packages
+	PackageOsquerydPath string = "/opt/osquery/bin/osqueryd"
+	// PackageFlagsPath as path for the flags file in enrollment packages
+	PackageFlagsPath string = "/etc/osquery/osquery.flags"
+	// PackageSecretPath as path for the enroll secret in enrollment packages
+	PackageSecretPath string = "/etc/osquery/osctrl.secret"
+	// PackageCertPath as path for the server certificate in enrollment packages
+	PackageCertPath string = "/etc/osquery/osctrl.crt"
+	// PackageUnitPath as path for the systemd unit in enrollment packages
+	PackageUnitPath string = "/usr/lib/systemd/system/osqueryd.service"
+	// DefaultPackageArch as default architecture of the osquery binary for enrollment packages
+	DefaultPackageArch string = "amd64"
+	// DefaultPackagesCached as default number of built enrollment packages kept on disk
+	DefaultPackagesCached int = 20
+)
+
+// ErrPackageClientCert when enrollment packages can not be built because nodes need a client certificate
+var ErrPackageClientCert = errors.New("environment requires client certificates, packages can not include them")
+
+const (
+	// PackageUnit as systemd unit to run osqueryd with the flags of the environment
+	PackageUnit string = `[Unit]
+Description=osquery daemon enrolled in osctrl
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=` + PackageOsquerydPath + ` --flagfile=` + PackageFlagsPath + ` --database_path=/var/osquery/osquery.db --pidfile=/var/osquery/osqueryd.pidfile
+Restart=on-failure
+KillMode=control-group
+KillSignal=SIGTERM
+TimeoutStopSec=15
+
+[Install]
+WantedBy=multi-user.target
+`
+	// PackagePostInstall as script to enable and start osqueryd after installing
+	PackagePostInstall string = `mkdir -p /var/osquery /var/log/osquery
+if command -v systemctl >/dev/null 2>&1; then
+  systemctl daemon-reload
+  systemctl enable osqueryd.service
+  systemctl restart osqueryd.service
+fi
+`
+	// PackagePreRemove as script to stop and disable osqueryd before removing, it does not run on upgrades
+	PackagePreRemove string = `if command -v systemctl >/dev/null 2>&1; then
+  systemctl disable --now osqueryd.service || true
+fi
+`
+)
+
+// rpmArchs to map architectures of debian packages to RPM packages
+var rpmArchs = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+	"386":   "i386",
+}
+
+// PackageFile to hold a file to be included in a package
+// The content is read from Source when it is a local path, otherwise it is Body
+type PackageFile struct {
+	Path   string
+	Mode   uint32
+	Body   []byte
+	Source string
+	Config bool
+}
+
+// size to get the size in bytes of the content of a file
+func (f PackageFile) size() (int64, error) {
+	if f.Source == "" {
+		return int64(len(f.Body)), nil
+	}
+	fi, err := os.Stat(f.Source)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// open to read the content of a file
+func (f PackageFile) open() (io.ReadCloser, error) {
+	if f.Source == "" {
+		return io.NopCloser(bytes.NewReader(f.Body)), nil
+	}
+	return os.Open(f.Source)
+}
+
+// copyTo to write exactly size bytes of the content of a file
+func (f PackageFile) copyTo(w io.Writer, size int64) error {
+	r, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.CopyN(w, r, size); err != nil {
+		return fmt.Errorf("error copying %s %v", f.Path, err)
+	}
+	return nil
+}
+
+// digest to hash the content of a file
+func (f PackageFile) digest(h hash.Hash) ([]byte, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// PackageMeta to hold the metadata of a package
+type PackageMeta struct {
+	Name        string
+	Version     string
+	Release     string
+	Arch        string
+	Summary     string
+	Description string
+	Maintainer  string
+	License     string
+	URL         string
+	Conflicts   []string
+	PostInstall string
+	PreRemove   string
+}
+
+// PackageBuilder to generate enrollment packages for environments with a supplied osquery binary
+// Built packages are kept in Dir until the environment or the binary change, up to MaxCached packages
+type PackageBuilder struct {
+	Envs         *Environment
+	OsquerydPath string
+	Arch         string
+	Dir          string
+	MaxCached    int
+	mutex        sync.Mutex
+	locks        map[string]*sync.Mutex
+	cache        map[string]*builtPackage
+}
+
+type builtPackage struct {
+	hash string
+	path string
+	used time.Time
+}
+
+// NewPackageBuilder to initialize the builder of enrollment packages, keeping packages in a directory
+func NewPackageBuilder(envs *Environment, osquerydPath, arch, dir string) (*PackageBuilder, error) {
+	if arch == "" {
+		arch = DefaultPackageArch
+	}
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "osctrl-packages-"); err != nil {
+			return nil, fmt.Errorf("error creating packages directory %v", err)
+		}
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating packages directory %v", err)
+	}
+	return &PackageBuilder{
+		Envs:         envs,
+		OsquerydPath: osquerydPath,
+		Arch:         arch,
+		Dir:          dir,
+		MaxCached:    DefaultPackagesCached,
+		locks:        make(map[string]*sync.Mutex),
+		cache:        make(map[string]*builtPackage),
+	}, nil
+}
+
+// EnrollPackageFiles to generate the files of an environment to be included in enrollment packages
+func (environment *Environment) EnrollPackageFiles(env TLSEnvironment) ([]PackageFile, error) {
+	if env.RequireClientCert {
+		return nil, ErrPackageClientCert
+	}
+	flags, err := environment.GeneratePlatformFlags(env, settings.PlatformLinux, PackageSecretPath, PackageCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("error generating flags %v", err)
+	}
+	files := []PackageFile{
+		{Path: PackageFlagsPath, Mode: 0644, Body: []byte(flags), Config: true},
+		{Path: PackageSecretPath, Mode: 0600, Body: []byte(env.Secret), Config: true},
+		{Path: PackageUnitPath, Mode: 0644, Body: []byte(PackageUnit)},
+	}
+	if env.Certificate != "" {
+		files = append(files, PackageFile{Path: PackageCertPath, Mode: 0644, Body: []byte(env.Certificate), Config: true})
+	}
+	return files, nil
+}
+
+// EnrollPackageMeta to generate the metadata of enrollment packages for an environment
+func EnrollPackageMeta(env TLSEnvironment, arch string) PackageMeta {
+	return PackageMeta{
+		Name:        PackageName,
+		Version:     version.OsqueryVersion,
+		Release:     "1",
+		Arch:        arch,
+		Summary:     "osquery enrolled in osctrl",
+		Description: fmt.Sprintf("osquery enrolled in the osctrl environment %s at %s", env.Name, env.Hostname),
+		Maintainer:  "osctrl",
+		License:     "Apache-2.0",
+		URL:         "https://osctrl.net",
+		Conflicts:   []string{"osquery"},
+		PostInstall: PackagePostInstall,
+		PreRemove:   PackagePreRemove,
+	}
+}
+
+// Build to generate the DEB or RPM enrollment package of an environment, returning the package file opened
+// Packages of different environments and types are built concurrently
+func (b *PackageBuilder) Build(env TLSEnvironment, pkgType string) (*os.File, error) {
+	if pkgType != settings.PackageDeb && pkgType != settings.PackageRpm {
+		return nil, fmt.Errorf("unsupported package %s", pkgType)
+	}
+	files, err := b.Envs.EnrollPackageFiles(env)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(b.OsquerydPath)
+	if err != nil {
+		return nil, fmt.Errorf("error with osquery binary %v", err)
+	}
+	// Packages are built again when any file or the binary change
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s:%o:%d:", f.Path, f.Mode, len(f.Body))
+		h.Write(f.Body)
+	}
+	fmt.Fprintf(h, "%s:%d:%d:%s", b.OsquerydPath, fi.Size(), fi.ModTime().UnixNano(), b.Arch)
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	key := env.UUID + "." + pkgType
+	lock := b.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	if f := b.cached(key, hash); f != nil {
+		return f, nil
+	}
+	files = append(files, PackageFile{Path: PackageOsquerydPath, Mode: 0755, Source: b.OsquerydPath})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	meta := EnrollPackageMeta(env, b.Arch)
+	tmp, err := os.CreateTemp(b.Dir, key+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating package %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if pkgType == settings.PackageDeb {
+		err = WriteDeb(tmp, meta, files)
+	} else {
+		if arch, ok := rpmArchs[meta.Arch]; ok {
+			meta.Arch = arch
+		}
+		err = WriteRpm(tmp, meta, files)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	pkgPath := filepath.Join(b.Dir, fmt.Sprintf("%s.%.16s", key, hash))
+	if err := os.Rename(tmp.Name(), pkgPath); err != nil {
+		return nil, fmt.Errorf("error saving package %v", err)
+	}
+	b.store(key, &builtPackage{hash: hash, path: pkgPath})
+	return os.Open(pkgPath)
+}
+
+// lock to get the lock for builds of one package
+func (b *PackageBuilder) lock(key string) *sync.Mutex {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	l, ok := b.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		b.locks[key] = l
+	}
+	return l
+}
+
+// cached to open a built package if it is up to date
+func (b *PackageBuilder) cached(key, hash string) *os.File {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p, ok := b.cache[key]
+	if !ok || p.hash != hash {
+		return nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		delete(b.cache, key)
+		return nil
+	}
+	p.used = time.Now()
+	return f
+}
+
+// store to keep a built package, removing the least recently used packages over the maximum
+// Files being served stay readable after they are removed
+func (b *PackageBuilder) store(key string, p *builtPackage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if old, ok := b.cache[key]; ok && old.path != p.path {
+		os.Remove(old.path)
+	}
+	p.used = time.Now()
+	b.cache[key] = p
+	for b.MaxCached > 0 && len(b.cache) > b.MaxCached {
+		var oldest string
+		for k, c := range b.cache {
+			if oldest == "" || c.used.Before(b.cache[oldest].used) {
+				oldest = k
+			}
+		}
+		os.Remove(b.cache[oldest].path)
+		delete(b.cache, oldest)
+	}
+}
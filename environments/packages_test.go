@@ -0,0 +1,125 @@
+package environments
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPackageFiles = []PackageFile{
+	{Path: PackageFlagsPath, Mode: 0644, Body: []byte("--tls_hostname=osctrl.example.com\n"), Config: true},
+	{Path: PackageSecretPath, Mode: 0600, Body: []byte("secret"), Config: true},
+	{Path: PackageOsquerydPath, Mode: 0755, Body: []byte("osqueryd")},
+}
+
+func TestBuildDeb(t *testing.T) {
+	meta := EnrollPackageMeta(TLSEnvironment{Name: "dev", Hostname: "osctrl.example.com"}, "amd64")
+	data, err := BuildDeb(meta, testPackageFiles)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("!<arch>\ndebian-binary   ")))
+	// Walk the ar members and read the control file
+	members := make(map[string][]byte)
+	for pos := 8; pos < len(data); {
+		name := strings.TrimSpace(string(data[pos : pos+16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(data[pos+48 : pos+58])))
+		assert.NoError(t, err)
+		members[name] = data[pos+60 : pos+60+size]
+		pos += 60 + size + size%2
+	}
+	assert.Equal(t, "2.0\n", string(members["debian-binary"]))
+	gz, err := gzip.NewReader(bytes.NewReader(members["control.tar.gz"]))
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	control := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(tr)
+		control[hdr.Name] = string(body)
+	}
+	assert.Contains(t, control["./control"], "Package: osquery-osctrl\n")
+	assert.Contains(t, control["./control"], "Architecture: amd64\n")
+	assert.Equal(t, PackageFlagsPath+"\n"+PackageSecretPath+"\n", control["./conffiles"])
+	assert.Contains(t, control["./prerm"], "if [ \"$1\" = \"remove\" ]; then\n")
+}
+
+func TestBuildRpm(t *testing.T) {
+	meta := EnrollPackageMeta(TLSEnvironment{Name: "dev", Hostname: "osctrl.example.com"}, "x86_64")
+	data, err := BuildRpm(meta, testPackageFiles)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}, data[:6])
+	// Signature header right after the lead, padded to 8 bytes
+	assert.Equal(t, []byte{0x8e, 0xad, 0xe8, 0x01}, data[96:100])
+	nindex := int(binary.BigEndian.Uint32(data[104:]))
+	hsize := int(binary.BigEndian.Uint32(data[108:]))
+	sigEnd := 96 + 16 + 16*nindex + hsize
+	sigEnd += (8 - (sigEnd-96)%8) % 8
+	assert.Equal(t, []byte{0x8e, 0xad, 0xe8, 0x01}, data[sigEnd:sigEnd+4])
+	// The region trailer of the main header points back to the start of the index
+	nindex = int(binary.BigEndian.Uint32(data[sigEnd+8:]))
+	hsize = int(binary.BigEndian.Uint32(data[sigEnd+12:]))
+	store := sigEnd + 16 + 16*nindex
+	trailer := data[store+hsize-16 : store+hsize]
+	assert.Equal(t, uint32(rpmTagHeaderRegion), binary.BigEndian.Uint32(trailer))
+	assert.Equal(t, int32(-16*nindex), int32(binary.BigEndian.Uint32(trailer[8:])))
+	// Payload is a cpio archive with the files
+	gz, err := gzip.NewReader(bytes.NewReader(data[store+hsize:]))
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(payload, []byte("070701")))
+	assert.Contains(t, string(payload), "./etc/osquery/osctrl.secret\x00")
+	assert.Contains(t, string(payload), "TRAILER!!!\x00")
+}
+
+func TestPackageFileSource(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "osqueryd")
+	assert.NoError(t, os.WriteFile(binary, []byte("osqueryd"), 0755))
+	files := append([]PackageFile(nil), testPackageFiles[:2]...)
+	files = append(files, PackageFile{Path: PackageOsquerydPath, Mode: 0755, Source: binary})
+	meta := EnrollPackageMeta(TLSEnvironment{Name: "dev", Hostname: "osctrl.example.com"}, "amd64")
+	fromSource, err := BuildRpm(meta, files)
+	assert.NoError(t, err)
+	fromBody, err := BuildRpm(meta, testPackageFiles)
+	assert.NoError(t, err)
+	// Only the build time in the lead and headers can differ
+	assert.Equal(t, len(fromBody), len(fromSource))
+	_, err = BuildDeb(meta, append(files, PackageFile{Path: "/missing", Source: filepath.Join(t.TempDir(), "missing")}))
+	assert.Error(t, err)
+}
+
+func TestPackageBuilder(t *testing.T) {
+	b, err := NewPackageBuilder(&Environment{}, "osqueryd", "", t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPackageArch, b.Arch)
+	_, err = b.Build(TLSEnvironment{RequireClientCert: true}, settings.PackageDeb)
+	assert.ErrorIs(t, err, ErrPackageClientCert)
+	// Least recently used packages are removed over the maximum
+	b.MaxCached = 2
+	for _, key := range []string{"a", "b", "c"} {
+		p := filepath.Join(b.Dir, key)
+		assert.NoError(t, os.WriteFile(p, []byte(key), 0600))
+		b.store(key, &builtPackage{hash: key, path: p})
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, b.cached("a", "a"))
+	assert.NoFileExists(t, filepath.Join(b.Dir, "a"))
+	f := b.cached("b", "b")
+	assert.NotNil(t, f)
+	f.Close()
+	assert.Nil(t, b.cached("c", "other"))
+}
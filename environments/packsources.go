@@ -0,0 +1,314 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPackRefresh as default time in seconds to refresh pack sources
+	DefaultPackRefresh int = 3600
+	// MaxPackSourceSize as the maximum size in bytes of a pack fetched from a source
+	MaxPackSourceSize int64 = 10 * 1024 * 1024
+	// packSourceTimeout for requests to fetch packs from a URL
+	packSourceTimeout = 30 * time.Second
+)
+
+const (
+	// PackSourceAdd to register a pack source and apply it
+	PackSourceAdd = "add"
+	// PackSourceDelete to stop refreshing a pack
+	PackSourceDelete = "delete"
+	// PackSourceSync to fetch a pack source now
+	PackSourceSync = "sync"
+)
+
+// PackSourcePolicy to restrict where query packs can be fetched from
+// Local paths must be inside Dir and URLs must use one of Hosts, nothing is allowed when they are empty
+type PackSourcePolicy struct {
+	Dir   string
+	Hosts []string
+}
+
+// NewPackSourcePolicy to initialize the policy of pack sources from a directory and a comma separated list of hosts
+func NewPackSourcePolicy(dir, hosts string) PackSourcePolicy {
+	p := PackSourcePolicy{Dir: dir}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			p.Hosts = append(p.Hosts, strings.ToLower(h))
+		}
+	}
+	return p
+}
+
+// isURL to check if a pack source is fetched from a URL
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// CheckURL to verify a URL uses one of the allowed hosts, as host or host:port
+func (p PackSourcePolicy) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %s", u.Scheme)
+	}
+	for _, h := range p.Hosts {
+		if h == strings.ToLower(u.Host) || h == strings.ToLower(u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed for pack sources", u.Host)
+}
+
+// CheckPath to verify a local path is inside the allowed directory, returning the path with symlinks resolved
+func (p PackSourcePolicy) CheckPath(path string) (string, error) {
+	if p.Dir == "" {
+		return "", fmt.Errorf("local pack sources are not allowed")
+	}
+	dir, err := filepath.EvalSymlinks(p.Dir)
+	if err != nil {
+		return "", fmt.Errorf("error with pack sources directory %v", err)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", fmt.Errorf("error with pack sources directory %v", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("error with pack source %v", err)
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside of %s", path, p.Dir)
+	}
+	return resolved, nil
+}
+
+// Check to verify a pack source is allowed by the policy
+func (p PackSourcePolicy) Check(source string) error {
+	if isURL(source) {
+		u, err := url.Parse(source)
+		if err != nil {
+			return fmt.Errorf("invalid URL %v", err)
+		}
+		return p.CheckURL(u)
+	}
+	_, err := p.CheckPath(strings.TrimPrefix(source, "file://"))
+	return err
+}
+
+// readPackSource to read a pack up to the maximum size
+func readPackSource(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxPackSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxPackSourceSize {
+		return nil, fmt.Errorf("pack is bigger than %d bytes", MaxPackSourceSize)
+	}
+	return data, nil
+}
+
+// PackSource to load a query pack of an environment from a URL or a local path
+// Version holds the hash of the content applied to the configuration
+type PackSource struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Name          string
+	Source        string
+	Interval      int
+	Version       string
+	LastFetch     time.Time
+	LastChange    time.Time
+	LastError     string
+	CreatedBy     string
+}
+
+// Due to check if a pack source needs to be fetched again
+func (s PackSource) Due(now time.Time) bool {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultPackRefresh
+	}
+	return now.Sub(s.LastFetch) >= time.Duration(interval)*time.Second
+}
+
+// AddPackSource to register a new pack source for an environment
+func (environment *Environment) AddPackSource(envID uint, name, source string, interval int, user string) (PackSource, error) {
+	if name == "" || source == "" {
+		return PackSource{}, fmt.Errorf("pack name and source are required")
+	}
+	if err := environment.PackSources.Check(source); err != nil {
+		return PackSource{}, err
+	}
+	if _, err := environment.GetPackSource(envID, name); err == nil {
+		return PackSource{}, fmt.Errorf("pack source %s already exists", name)
+	}
+	if interval <= 0 {
+		interval = DefaultPackRefresh
+	}
+	src := PackSource{
+		EnvironmentID: envID,
+		Name:          name,
+		Source:        source,
+		Interval:      interval,
+		CreatedBy:     user,
+	}
+	if err := environment.DB.Create(&src).Error; err != nil {
+		return src, fmt.Errorf("Create PackSource %v", err)
+	}
+	return src, nil
+}
+
+// GetPackSource to retrieve one pack source of an environment by pack name
+func (environment *Environment) GetPackSource(envID uint, name string) (PackSource, error) {
+	var sources []PackSource
+	if err := environment.DB.Where("environment_id = ? AND name = ?", envID, name).Limit(1).Find(&sources).Error; err != nil {
+		return PackSource{}, err
+	}
+	if len(sources) == 0 {
+		return PackSource{}, fmt.Errorf("pack source %s not found", name)
+	}
+	return sources[0], nil
+}
+
+// GetPackSources to retrieve all the pack sources of an environment
+func (environment *Environment) GetPackSources(envID uint) ([]PackSource, error) {
+	var sources []PackSource
+	if err := environment.DB.Where("environment_id = ?", envID).Order("name").Find(&sources).Error; err != nil {
+		return sources, err
+	}
+	return sources, nil
+}
+
+// DeletePackSource to stop refreshing a pack, the pack stays in the configuration
+func (environment *Environment) DeletePackSource(envID uint, name string) error {
+	if err := environment.DB.Unscoped().Where("environment_id = ? AND name = ?", envID, name).Delete(&PackSource{}).Error; err != nil {
+		return fmt.Errorf("Delete PackSource %v", err)
+	}
+	return nil
+}
+
+// FetchPackSource to read the content of a pack from a URL or a local path allowed by the policy
+func FetchPackSource(source string, policy PackSourcePolicy) ([]byte, error) {
+	if isURL(source) {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %v", err)
+		}
+		if err := policy.CheckURL(u); err != nil {
+			return nil, err
+		}
+		client := &http.Client{
+			Timeout: packSourceTimeout,
+			// Redirects must stay within the allowed hosts
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return fmt.Errorf("too many redirects")
+				}
+				return policy.CheckURL(req.URL)
+			},
+		}
+		resp, err := client.Get(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("Get %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP Code %d", resp.StatusCode)
+		}
+		return readPackSource(resp.Body)
+	}
+	path, err := policy.CheckPath(strings.TrimPrefix(source, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPackSource(f)
+}
+
+// SyncPackSource to fetch a pack source and apply it to the configuration if the content changed
+// The configuration is only refreshed and a revision recorded when the pack version changes
+func (environment *Environment) SyncPackSource(src PackSource, user string) (bool, error) {
+	updates := map[string]interface{}{"last_fetch": time.Now(), "last_error": ""}
+	changed, err := environment.applyPackSource(&src, user)
+	if err != nil {
+		updates["last_error"] = err.Error()
+	}
+	if changed {
+		updates["version"] = src.Version
+		updates["last_change"] = updates["last_fetch"]
+	}
+	if dbErr := environment.DB.Model(&src).Updates(updates).Error; dbErr != nil {
+		return changed, fmt.Errorf("Update PackSource %v", dbErr)
+	}
+	return changed, err
+}
+
+func (environment *Environment) applyPackSource(src *PackSource, user string) (bool, error) {
+	data, err := FetchPackSource(src.Source, environment.PackSources)
+	if err != nil {
+		return false, fmt.Errorf("error fetching pack %v", err)
+	}
+	version := ConfigHash(string(data))
+	if version == src.Version {
+		return false, nil
+	}
+	var pack map[string]interface{}
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return false, fmt.Errorf("error parsing pack %v", err)
+	}
+	env, err := environment.GetByID(src.EnvironmentID)
+	if err != nil {
+		return false, fmt.Errorf("error getting environment %v", err)
+	}
+	comment := fmt.Sprintf("pack %s from %s version %.12s", src.Name, src.Source, version)
+	if _, err := environment.WithRevision(env.UUID, user, comment, func(e *Environment) error {
+		return e.AddQueryPackConf(env.UUID, src.Name, pack)
+	}); err != nil {
+		return false, err
+	}
+	src.Version = version
+	return true, nil
+}
+
+// RefreshPackSources to sync all the pack sources that are due, returning how many changed
+func (environment *Environment) RefreshPackSources(user string) (int, error) {
+	var sources []PackSource
+	if err := environment.DB.Find(&sources).Error; err != nil {
+		return 0, err
+	}
+	changed := 0
+	var errs []string
+	now := time.Now()
+	for _, src := range sources {
+		if !src.Due(now) {
+			continue
+		}
+		ok, err := environment.SyncPackSource(src, user)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Name, err))
+		}
+		if ok {
+			changed++
+		}
+	}
+	if len(errs) > 0 {
+		return changed, fmt.Errorf("error syncing pack sources %s", strings.Join(errs, "; "))
+	}
+	return changed, nil
+}
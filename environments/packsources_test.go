@@ -0,0 +1,79 @@
+package environments
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPack = `{"queries": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 60}}}`
+
+func TestPackSourceDue(t *testing.T) {
+	now := time.Now()
+	assert.True(t, PackSource{}.Due(now))
+	assert.False(t, PackSource{Interval: 60, LastFetch: now.Add(-30 * time.Second)}.Due(now))
+	assert.True(t, PackSource{Interval: 60, LastFetch: now.Add(-60 * time.Second)}.Due(now))
+	assert.False(t, PackSource{LastFetch: now.Add(-time.Minute)}.Due(now))
+}
+
+func TestFetchPackSource(t *testing.T) {
+	t.Run("url", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/uptime.conf":
+				_, _ = w.Write([]byte(testPack))
+			case "/big.conf":
+				_, _ = w.Write(make([]byte, MaxPackSourceSize+1))
+			case "/redirect.conf":
+				http.Redirect(w, r, "http://example.com/uptime.conf", http.StatusFound)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer srv.Close()
+		policy := NewPackSourcePolicy("", "example.com, 127.0.0.1")
+		data, err := FetchPackSource(srv.URL+"/uptime.conf", policy)
+		assert.NoError(t, err)
+		assert.Equal(t, testPack, string(data))
+		_, err = FetchPackSource(srv.URL+"/missing.conf", policy)
+		assert.EqualError(t, err, "HTTP Code 404")
+		_, err = FetchPackSource(srv.URL+"/big.conf", policy)
+		assert.Error(t, err)
+		_, err = FetchPackSource(srv.URL+"/uptime.conf", NewPackSourcePolicy("", "example.com"))
+		assert.Error(t, err)
+		_, err = FetchPackSource(srv.URL+"/redirect.conf", NewPackSourcePolicy("", "127.0.0.1"))
+		assert.Error(t, err)
+	})
+	t.Run("path", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "uptime.conf")
+		assert.NoError(t, os.WriteFile(file, []byte(testPack), 0600))
+		policy := PackSourcePolicy{Dir: dir}
+		data, err := FetchPackSource(file, policy)
+		assert.NoError(t, err)
+		assert.Equal(t, testPack, string(data))
+		data, err = FetchPackSource("file://"+file, policy)
+		assert.NoError(t, err)
+		assert.Equal(t, testPack, string(data))
+		data, err = FetchPackSource("uptime.conf", policy)
+		assert.NoError(t, err)
+		assert.Equal(t, testPack, string(data))
+		_, err = FetchPackSource(file, PackSourcePolicy{})
+		assert.Error(t, err)
+		outside := filepath.Join(t.TempDir(), "outside.conf")
+		assert.NoError(t, os.WriteFile(outside, []byte(testPack), 0600))
+		_, err = FetchPackSource(outside, policy)
+		assert.Error(t, err)
+		_, err = FetchPackSource(filepath.Join(dir, "..", filepath.Base(filepath.Dir(outside)), "outside.conf"), policy)
+		assert.Error(t, err)
+		link := filepath.Join(dir, "link.conf")
+		assert.NoError(t, os.Symlink(outside, link))
+		_, err = FetchPackSource(link, policy)
+		assert.Error(t, err)
+	})
+}
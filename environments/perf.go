@@ -0,0 +1,53 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/types"
+)
+
+// DefaultSchedulePerfInterval as default interval in seconds to collect osquery_schedule stats
+const DefaultSchedulePerfInterval int = 3600
+
+const (
+	// SchedulePerfEnable to start collecting osquery_schedule stats
+	SchedulePerfEnable = "enable"
+	// SchedulePerfDisable to stop collecting osquery_schedule stats
+	SchedulePerfDisable = "disable"
+)
+
+// SchedulePerfEnabled to check if an environment collects the performance of scheduled queries
+func (environment *Environment) SchedulePerfEnabled(env TLSEnvironment) bool {
+	schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
+	if err != nil {
+		return false
+	}
+	_, ok := schedule[types.SchedulePerfQuery]
+	return ok
+}
+
+// EnableSchedulePerf to add the built-in query collecting osquery_schedule stats to the schedule
+func (environment *Environment) EnableSchedulePerf(idEnv string, interval int) error {
+	if interval <= 0 {
+		interval = DefaultSchedulePerfInterval
+	}
+	query := ScheduleQuery{
+		Query:    types.SchedulePerfSQL,
+		Interval: json.Number(strconv.Itoa(interval)),
+		Snapshot: true,
+	}
+	if err := environment.AddScheduleConfQuery(idEnv, types.SchedulePerfQuery, query); err != nil {
+		return fmt.Errorf("error adding %s %w", types.SchedulePerfQuery, err)
+	}
+	return nil
+}
+
+// DisableSchedulePerf to remove the built-in query collecting osquery_schedule stats from the schedule
+func (environment *Environment) DisableSchedulePerf(idEnv string) error {
+	if err := environment.RemoveScheduleConfQuery(idEnv, types.SchedulePerfQuery); err != nil {
+		return fmt.Errorf("error removing %s %w", types.SchedulePerfQuery, err)
+	}
+	return nil
+}
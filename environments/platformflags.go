@@ -0,0 +1,296 @@
+package environments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmpsec/osctrl/settings"
+	"gorm.io/gorm"
+)
+
+const (
+	// SectionFlags for issues in the osquery flags of an environment
+	SectionFlags = "flags"
+)
+
+const (
+	// PlatformFlagsSet to create or replace the flags overrides of a platform
+	PlatformFlagsSet = "set"
+	// PlatformFlagsDelete to remove the flags overrides of a platform
+	PlatformFlagsDelete = "delete"
+)
+
+// FlagsPlatforms to hold the platforms that can have their own flags overrides
+var FlagsPlatforms = []string{
+	settings.PlatformDarwin,
+	settings.PlatformLinux,
+	settings.PlatformWindows,
+	settings.PlatformFreeBSD,
+}
+
+// ValidFlagsPlatforms to check the platforms of flags overrides
+var ValidFlagsPlatforms = map[string]bool{
+	settings.PlatformDarwin:  true,
+	settings.PlatformLinux:   true,
+	settings.PlatformWindows: true,
+	settings.PlatformFreeBSD: true,
+}
+
+// DownloadFlagsPlatforms to map the download targets of flags to their platforms
+var DownloadFlagsPlatforms = map[string]string{
+	settings.DownloadFlagsMac:     settings.PlatformDarwin,
+	settings.DownloadFlagsLinux:   settings.PlatformLinux,
+	settings.DownloadFlagsWin:     settings.PlatformWindows,
+	settings.DownloadFlagsFreeBSD: settings.PlatformFreeBSD,
+}
+
+// OsqueryStartupFlags to hold the known osquery flags that can only be set in the command line or flagfile, with their types
+// https://osquery.readthedocs.io/en/stable/installation/cli-flags/
+var OsqueryStartupFlags = map[string]string{
+	"allow_unsafe":                          OptionBool,
+	"audit_allow_accept_socket_events":      OptionBool,
+	"audit_allow_failed_socket_events":      OptionBool,
+	"audit_allow_null_accept_socket_events": OptionBool,
+	"audit_fim_debug":                       OptionBool,
+	"audit_fim_show_accesses":               OptionBool,
+	"audit_force_reconfigure":               OptionBool,
+	"audit_force_unconfigure":               OptionBool,
+	"config_check":                          OptionBool,
+	"config_dump":                           OptionBool,
+	"config_enable_backup":                  OptionBool,
+	"config_path":                           OptionString,
+	"config_tls_accelerated_refresh":        OptionInt,
+	"config_tls_refresh":                    OptionInt,
+	"daemonize":                             OptionBool,
+	"database_dump":                         OptionBool,
+	"ebpf_buffer_storage_size":              OptionInt,
+	"ebpf_perf_event_array_exp":             OptionInt,
+	"enable_extras_logging":                 OptionBool,
+	"enable_process_events":                 OptionBool,
+	"enable_socket_events":                  OptionBool,
+	"force":                                 OptionBool,
+	"hardware_disabled_types":               OptionString,
+	"install":                               OptionBool,
+	"logger_tls_max_attempts":               OptionInt,
+	"process_events_file_events":            OptionBool,
+	"tls_disable_status_log":                OptionBool,
+	"tls_dump":                              OptionBool,
+	"uninstall":                             OptionBool,
+	"watchdog_forced_shutdown_delay":        OptionInt,
+	"watchdog_max_delay":                    OptionInt,
+}
+
+// PlatformFlags to hold osquery flags of an environment that override the generated flags for one platform
+type PlatformFlags struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_platform_flags_env"`
+	Platform      string `gorm:"uniqueIndex:idx_platform_flags_env"`
+	Flags         string
+	UpdatedBy     string
+}
+
+// FlagsPlatform to get the platform of flags overrides from a platform reported by osquery or Go
+func FlagsPlatform(platform string) string {
+	platform = strings.ToLower(platform)
+	if ValidFlagsPlatforms[platform] {
+		return platform
+	}
+	if IsPlatformLinux(platform) {
+		return settings.PlatformLinux
+	}
+	return ""
+}
+
+// GetPlatformFlags to retrieve all the flags overrides of an environment
+func (environment *Environment) GetPlatformFlags(envID uint) ([]PlatformFlags, error) {
+	var flags []PlatformFlags
+	if err := environment.DB.Where("environment_id = ?", envID).Order("platform").Find(&flags).Error; err != nil {
+		return flags, err
+	}
+	return flags, nil
+}
+
+// GetPlatformFlagsFor to retrieve the flags overrides of an environment for one platform
+func (environment *Environment) GetPlatformFlagsFor(envID uint, platform string) (PlatformFlags, error) {
+	var flags []PlatformFlags
+	if err := environment.DB.Where("environment_id = ? AND platform = ?", envID, platform).Limit(1).Find(&flags).Error; err != nil {
+		return PlatformFlags{}, err
+	}
+	if len(flags) == 0 {
+		return PlatformFlags{}, fmt.Errorf("no flags for platform %s", platform)
+	}
+	return flags[0], nil
+}
+
+// SetPlatformFlags to validate and save the flags overrides of an environment for one platform
+func (environment *Environment) SetPlatformFlags(envID uint, platform, flags, user string) (PlatformFlags, error) {
+	if !ValidFlagsPlatforms[platform] {
+		return PlatformFlags{}, fmt.Errorf("invalid platform %s", platform)
+	}
+	flags = strings.TrimSpace(flags)
+	if err := ValidateFlags(flags); err != nil {
+		return PlatformFlags{}, err
+	}
+	env, err := environment.GetByID(envID)
+	if err != nil {
+		return PlatformFlags{}, fmt.Errorf("error getting environment %v", err)
+	}
+	generated, err := environment.GenerateFlags(env, "", "")
+	if err != nil {
+		return PlatformFlags{}, fmt.Errorf("error generating flags %v", err)
+	}
+	// Overrides are merged with the stored flags for downloads and with the generated flags for nodes
+	for _, base := range []string{env.Flags, generated} {
+		if err := ValidateFlags(MergeFlags(base, flags)); err != nil {
+			return PlatformFlags{}, err
+		}
+	}
+	existing, err := environment.GetPlatformFlagsFor(envID, platform)
+	if err != nil {
+		existing = PlatformFlags{EnvironmentID: envID, Platform: platform}
+	}
+	existing.Flags = flags
+	existing.UpdatedBy = user
+	if err := environment.DB.Save(&existing).Error; err != nil {
+		return existing, fmt.Errorf("Save PlatformFlags %v", err)
+	}
+	return existing, nil
+}
+
+// DeletePlatformFlags to remove the flags overrides of an environment for one platform
+func (environment *Environment) DeletePlatformFlags(envID uint, platform string) error {
+	if err := environment.DB.Unscoped().Where("environment_id = ? AND platform = ?", envID, platform).Delete(&PlatformFlags{}).Error; err != nil {
+		return fmt.Errorf("Delete PlatformFlags %v", err)
+	}
+	return nil
+}
+
+// ApplyPlatformFlags to merge the overrides of an environment for a platform into flags
+// Merged flags are validated when overrides or flags are saved, so they are not checked on every request
+func (environment *Environment) ApplyPlatformFlags(envID uint, platform, flags string) string {
+	if p := FlagsPlatform(platform); p != "" {
+		if overrides, err := environment.GetPlatformFlagsFor(envID, p); err == nil {
+			flags = MergeFlags(flags, overrides.Flags)
+		}
+	}
+	return flags
+}
+
+// GeneratePlatformFlags to generate flags with the overrides of the environment for a platform
+func (environment *Environment) GeneratePlatformFlags(env TLSEnvironment, platform, secretPath, certPath string) (string, error) {
+	flags, err := environment.GenerateFlags(env, secretPath, certPath)
+	if err != nil {
+		return "", err
+	}
+	return environment.ApplyPlatformFlags(env.ID, platform, flags), nil
+}
+
+// ScriptPlatformFlags to generate the flags with overrides for every platform, to be embedded in quick add scripts
+func (environment *Environment) ScriptPlatformFlags(env TLSEnvironment) (map[string]string, error) {
+	flags := make(map[string]string)
+	for _, platform := range FlagsPlatforms {
+		f, err := environment.GeneratePlatformFlags(env, platform, "", "")
+		if err != nil {
+			return flags, err
+		}
+		flags[platform] = f
+	}
+	return flags, nil
+}
+
+// MergeFlags to replace flags with the values of overrides, flags not present are added at the end
+func MergeFlags(flags, overrides string) string {
+	values := make(map[string]string)
+	var order []string
+	for _, line := range strings.Split(overrides, "\n") {
+		name, _, ok := parseFlag(line)
+		if !ok {
+			continue
+		}
+		if _, seen := values[name]; !seen {
+			order = append(order, name)
+		}
+		values[name] = strings.TrimSpace(line)
+	}
+	lines := strings.Split(flags, "\n")
+	for i, line := range lines {
+		if name, _, ok := parseFlag(line); ok {
+			if override, found := values[name]; found {
+				lines[i] = override
+				delete(values, name)
+			}
+		}
+	}
+	merged := strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+	for _, name := range order {
+		if override, found := values[name]; found {
+			merged += override + "\n"
+		}
+	}
+	return merged
+}
+
+// ValidateMergedFlags to check flags merged with every flags override of an environment
+func (environment *Environment) ValidateMergedFlags(envID uint, flags string) error {
+	if err := ValidateFlags(flags); err != nil {
+		return err
+	}
+	overrides, err := environment.GetPlatformFlags(envID)
+	if err != nil {
+		return fmt.Errorf("error getting flags overrides %v", err)
+	}
+	for _, o := range overrides {
+		if err := ValidateFlags(MergeFlags(flags, o.Flags)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateFlags to check that all flags are known osquery flags with valid values
+// Empty lines and comments are skipped and flags without value are booleans
+func ValidateFlags(flags string) error {
+	var issues []ConfigIssue
+	for _, line := range strings.Split(flags, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := parseFlag(line)
+		if !ok {
+			issues = append(issues, ConfigIssue{Section: SectionFlags, Key: line, Message: "flags must start with --"})
+			continue
+		}
+		if msg := checkFlag(name, value); msg != "" {
+			issues = append(issues, ConfigIssue{Section: SectionFlags, Key: name, Message: msg})
+		}
+	}
+	if len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// Helper to split a line of flags in name and value, flags without value are set to true
+func parseFlag(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "--") || len(line) == 2 {
+		return "", "", false
+	}
+	name, value, found := strings.Cut(line[2:], "=")
+	if !found {
+		value = "true"
+	}
+	return name, value, true
+}
+
+// Helper to check one flag against the known osquery flags and options
+func checkFlag(name, value string) string {
+	kind, ok := OsqueryOptions[name]
+	if !ok {
+		if kind, ok = OsqueryStartupFlags[name]; !ok {
+			return "unknown osquery flag"
+		}
+	}
+	return checkValue(kind, value)
+}
@@ -0,0 +1,38 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeFlags(t *testing.T) {
+	flags := "--host_identifier=uuid\n--disable_events=true\n--utc=true\n"
+	overrides := "--disable_events=false\n--audit_allow_process_events=true\n"
+	assert.Equal(t, "--host_identifier=uuid\n--disable_events=false\n--utc=true\n--audit_allow_process_events=true\n", MergeFlags(flags, overrides))
+	assert.Equal(t, flags, MergeFlags(flags, ""))
+}
+
+func TestValidateFlags(t *testing.T) {
+	env := TLSEnvironment{UUID: "uuid", Hostname: "osctrl.example.com", Certificate: "cert", RequireClientCert: true}
+	flags, err := (&Environment{}).GenerateFlags(env, "", "")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateFlags(flags))
+	assert.NoError(t, ValidateFlags("# audit\n--disable_audit=false\n--force\n"))
+	err = ValidateFlags("--unknown_flag=1\n--utc=maybe\n--config_tls_refresh=soon\nverbose")
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []ConfigIssue{
+		{Section: SectionFlags, Key: "unknown_flag", Message: "unknown osquery flag"},
+		{Section: SectionFlags, Key: "utc", Message: "value must be a boolean"},
+		{Section: SectionFlags, Key: "config_tls_refresh", Message: "value must be an integer"},
+		{Section: SectionFlags, Key: "verbose", Message: "flags must start with --"},
+	}, verr.Issues)
+}
+
+func TestFlagsPlatform(t *testing.T) {
+	assert.Equal(t, "linux", FlagsPlatform("ubuntu"))
+	assert.Equal(t, "darwin", FlagsPlatform("darwin"))
+	assert.Equal(t, "windows", FlagsPlatform("Windows"))
+	assert.Equal(t, "", FlagsPlatform("plan9"))
+}
@@ -0,0 +1,334 @@
+package environments
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// RevisionBaseline as comment of the revision recorded for environments without revisions
+	RevisionBaseline = "baseline configuration"
+	// RevisionAuthor as author of the revisions recorded by osctrl itself
+	RevisionAuthor = "osctrl"
+)
+
+// ConfigRevision to hold an immutable snapshot of the configuration of an environment
+type ConfigRevision struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index;uniqueIndex:idx_config_revisions_number"`
+	Revision      int  `gorm:"uniqueIndex:idx_config_revisions_number"`
+	Hash          string
+	Author        string
+	Comment       string
+	Options       string
+	Schedule      string
+	Packs         string
+	Decorators    string
+	ATC           string
+	Configuration string
+}
+
+// ConfigHash to generate the hash used to identify a configuration
+func ConfigHash(configuration string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(configuration)))
+}
+
+// OsqueryConfigHash to calculate the config_hash that osquery reports for a served configuration
+// osquery re-serializes the configuration compacted and without HTML escaping, then calculates
+// the SHA1 of the configuration blob and the SHA1 hash of that
+// https://github.com/osquery/osquery/blob/master/osquery/config/config.cpp
+func OsqueryConfigHash(configuration string) string {
+	var buf bytes.Buffer
+	blob := []byte(configuration)
+	if err := json.Compact(&buf, blob); err == nil {
+		blob = []byte(htmlUnescaper.Replace(buf.String()))
+	}
+	first := sha1.Sum(blob)
+	second := sha1.Sum([]byte(hex.EncodeToString(first[:])))
+	return hex.EncodeToString(second[:])
+}
+
+// ExpectedConfigHash to get the config_hash expected from nodes in an environment
+// Environments created before tracking adoption get the hash calculated on demand
+func ExpectedConfigHash(env TLSEnvironment) string {
+	if env.ConfigHash != "" {
+		return env.ConfigHash
+	}
+	return OsqueryConfigHash(env.Configuration)
+}
+
+var htmlUnescaper = strings.NewReplacer(`\u003c`, "<", `\u003e`, ">", `\u0026`, "&")
+
+// CommitRevision to record the current configuration of an environment as a new revision
+// If the configuration did not change since the latest revision, that revision is returned
+func (environment *Environment) CommitRevision(idEnv, author, comment string) (ConfigRevision, error) {
+	var rev ConfigRevision
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		// Revision numbers are assigned one at a time for each environment
+		var env TLSEnvironment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ? OR uuid = ?", idEnv, idEnv).First(&env).Error; err != nil {
+			return fmt.Errorf("error getting environment %v", err)
+		}
+		var latest ConfigRevision
+		err := tx.Where("environment_id = ?", env.ID).Order("revision desc").First(&latest).Error
+		if err == nil && latest.Hash == ConfigHash(env.Configuration) {
+			rev = latest
+			return nil
+		}
+		rev = ConfigRevision{
+			EnvironmentID: env.ID,
+			Revision:      latest.Revision + 1,
+			Hash:          ConfigHash(env.Configuration),
+			Author:        author,
+			Comment:       comment,
+			Options:       env.Options,
+			Schedule:      env.Schedule,
+			Packs:         env.Packs,
+			Decorators:    env.Decorators,
+			ATC:           env.ATC,
+			Configuration: env.Configuration,
+		}
+		if err := tx.Create(&rev).Error; err != nil {
+			return fmt.Errorf("Create ConfigRevision %v", err)
+		}
+		return nil
+	})
+	return rev, err
+}
+
+// WithRevision to change the configuration of an environment and record it as a new revision in one transaction
+// Environments without revisions get the configuration before the change recorded first, so it can be rolled back
+func (environment *Environment) WithRevision(idEnv, author, comment string, change func(e *Environment) error) (ConfigRevision, error) {
+	var rev ConfigRevision
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		e := *environment
+		e.DB = tx
+		env, err := e.Get(idEnv)
+		if err != nil {
+			return fmt.Errorf("error getting environment %v", err)
+		}
+		if _, err := e.LatestRevision(env.ID); errors.Is(err, gorm.ErrRecordNotFound) {
+			if _, err := e.CommitRevision(env.UUID, author, RevisionBaseline); err != nil {
+				return err
+			}
+		}
+		if err := change(&e); err != nil {
+			return err
+		}
+		rev, err = e.CommitRevision(env.UUID, author, comment)
+		return err
+	})
+	return rev, err
+}
+
+// BackfillRevisions to record the current configuration of environments without revisions
+func (environment *Environment) BackfillRevisions() error {
+	var envs []TLSEnvironment
+	if err := environment.DB.Where("id NOT IN (?)", environment.DB.Model(&ConfigRevision{}).Select("environment_id")).Find(&envs).Error; err != nil {
+		return fmt.Errorf("error getting environments without revisions %v", err)
+	}
+	for _, env := range envs {
+		if _, err := environment.CommitRevision(env.UUID, RevisionAuthor, RevisionBaseline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatestRevision to retrieve the most recent configuration revision of an environment
+func (environment *Environment) LatestRevision(envID uint) (ConfigRevision, error) {
+	var rev ConfigRevision
+	if err := environment.DB.Where("environment_id = ?", envID).Order("revision desc").First(&rev).Error; err != nil {
+		return rev, err
+	}
+	return rev, nil
+}
+
+// GetRevision to retrieve one configuration revision of an environment by number
+func (environment *Environment) GetRevision(envID uint, revision int) (ConfigRevision, error) {
+	var rev ConfigRevision
+	if err := environment.DB.Where("environment_id = ? AND revision = ?", envID, revision).First(&rev).Error; err != nil {
+		return rev, err
+	}
+	return rev, nil
+}
+
+// GetRevisions to retrieve all the configuration revisions of an environment, newest first
+func (environment *Environment) GetRevisions(envID uint) ([]ConfigRevision, error) {
+	var revs []ConfigRevision
+	if err := environment.DB.Where("environment_id = ?", envID).Order("revision desc").Find(&revs).Error; err != nil {
+		return revs, err
+	}
+	return revs, nil
+}
+
+// RollbackRevision to restore the configuration of an environment from a prior revision
+// The rollback is recorded as a new revision, so the history is never rewritten
+func (environment *Environment) RollbackRevision(idEnv string, revision int, author string) (ConfigRevision, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRevision{}, fmt.Errorf("error getting environment %v", err)
+	}
+	rev, err := environment.GetRevision(env.ID, revision)
+	if err != nil {
+		return rev, fmt.Errorf("error getting revision %d %v", revision, err)
+	}
+	return environment.WithRevision(env.UUID, author, fmt.Sprintf("rollback to revision %d", revision), func(e *Environment) error {
+		if err := e.DB.Model(&env).Updates(map[string]interface{}{
+			"options":       rev.Options,
+			"schedule":      rev.Schedule,
+			"packs":         rev.Packs,
+			"decorators":    rev.Decorators,
+			"atc":           rev.ATC,
+			"configuration": rev.Configuration,
+			"config_hash":   OsqueryConfigHash(rev.Configuration),
+		}).Error; err != nil {
+			return fmt.Errorf("Updates %v", err)
+		}
+		return nil
+	})
+}
+
+// DiffRevisions to generate a line based diff of the configuration between two revisions
+// Removed lines are prefixed with "-", added lines with "+" and unchanged lines with a space
+func DiffRevisions(from, to ConfigRevision) string {
+	a := strings.Split(from.Configuration, "\n")
+	b := strings.Split(to.Configuration, "\n")
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- revision %d\n+++ revision %d\n", from.Revision, to.Revision)
+	i, j := 0, 0
+	// Lines between matches are removed from the first revision and added in the second
+	for _, m := range diffMatches(a, b) {
+		for ; i < m[0]; i++ {
+			out.WriteString("-" + a[i] + "\n")
+		}
+		for ; j < m[1]; j++ {
+			out.WriteString("+" + b[j] + "\n")
+		}
+		out.WriteString(" " + a[i] + "\n")
+		i++
+		j++
+	}
+	for ; i < len(a); i++ {
+		out.WriteString("-" + a[i] + "\n")
+	}
+	for ; j < len(b); j++ {
+		out.WriteString("+" + b[j] + "\n")
+	}
+	return out.String()
+}
+
+// lineDiffer to find the lines in common of two texts with the linear space variant of the Myers diff
+type lineDiffer struct {
+	a, b    []int
+	matches [][2]int
+}
+
+// diffMatches to get the positions of the lines in common of two texts, in order
+func diffMatches(a, b []string) [][2]int {
+	// Lines are compared as numbers
+	ids := make(map[string]int)
+	toIDs := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+	d := &lineDiffer{a: toIDs(a), b: toIDs(b)}
+	d.compare(0, len(a), 0, len(b))
+	return d.matches
+}
+
+// compare to find the lines in common between two ranges of lines
+func (d *lineDiffer) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.matches = append(d.matches, [2]int{aLo, bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aHi-suffix > aLo && bHi-suffix > bLo && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+	// With both ranges not empty and different ends there are at least two edits, so both halves are smaller
+	if aLo < aHi && bLo < bHi {
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.matches = append(d.matches, [2]int{x, y})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+	for i := 0; i < suffix; i++ {
+		d.matches = append(d.matches, [2]int{aHi + i, bHi + i})
+	}
+}
+
+// middleSnake to find the diagonal in the middle of the shortest edit script between two ranges of lines
+// Searching forward from the start and backward from the end only needs memory for one diagonal each
+func (d *lineDiffer) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	off := limit + 1
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+	for e := 0; e <= limit; e++ {
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1]
+			} else {
+				x = forward[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+			if odd && k >= delta-(e-1) && k <= delta+(e-1) && x+backward[off+delta-k] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && backward[off+k-1] < backward[off+k+1]) {
+				x = backward[off+k+1]
+			} else {
+				x = backward[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			backward[off+k] = x
+			if !odd && delta-k >= -e && delta-k <= e && forward[off+delta-k]+x >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	// Not reached, the forward and backward searches always overlap
+	return aLo, bLo, aLo, bLo
+}
@@ -0,0 +1,69 @@
+package environments
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	from := ConfigRevision{Revision: 1, Configuration: "{\n  \"a\": 1,\n  \"b\": 2\n}"}
+	to := ConfigRevision{Revision: 2, Configuration: "{\n  \"a\": 1,\n  \"b\": 3\n}"}
+	t.Run("same", func(t *testing.T) {
+		diff := DiffRevisions(from, from)
+		assert.Equal(t, "--- revision 1\n+++ revision 1\n {\n   \"a\": 1,\n   \"b\": 2\n }\n", diff)
+	})
+	t.Run("changed", func(t *testing.T) {
+		diff := DiffRevisions(from, to)
+		assert.Equal(t, "--- revision 1\n+++ revision 2\n {\n   \"a\": 1,\n-  \"b\": 2\n+  \"b\": 3\n }\n", diff)
+	})
+}
+
+func TestDiffMatches(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		res := make([]string, rnd.Intn(12))
+		for i := range res {
+			res[i] = string(rune('a' + rnd.Intn(3)))
+		}
+		return res
+	}
+	for n := 0; n < 500; n++ {
+		a, b := lines(), lines()
+		// Longest common subsequence to compare with the number of lines in common
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		matches := diffMatches(a, b)
+		assert.Len(t, matches, lcs[0][0], "%v %v", a, b)
+		for i, m := range matches {
+			assert.Equal(t, a[m[0]], b[m[1]])
+			if i > 0 {
+				assert.Less(t, matches[i-1][0], m[0])
+				assert.Less(t, matches[i-1][1], m[1])
+			}
+		}
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ConfigHash(""))
+}
+
+func TestOsqueryConfigHash(t *testing.T) {
+	compact := OsqueryConfigHash(`{"schedule":{"q":{"query":"SELECT 1 WHERE 1 < 2;"}}}`)
+	indented := OsqueryConfigHash("{\n  \"schedule\": {\n    \"q\": {\n      \"query\": \"SELECT 1 WHERE 1 \\u003c 2;\"\n    }\n  }\n}")
+	assert.Equal(t, compact, indented)
+	assert.NotEqual(t, compact, OsqueryConfigHash(`{}`))
+}
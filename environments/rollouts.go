@@ -0,0 +1,219 @@
+package environments
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// RolloutActive for rollouts serving the new configuration only to canary nodes
+	RolloutActive = "active"
+	// RolloutPromoted for rollouts where all nodes got the new configuration
+	RolloutPromoted = "promoted"
+	// RolloutAborted for rollouts where the configuration was rolled back to the base revision
+	RolloutAborted = "aborted"
+)
+
+const (
+	// RolloutStart to start a staged rollout
+	RolloutStart = "start"
+	// RolloutPromote to serve the new configuration to all nodes
+	RolloutPromote = "promote"
+	// RolloutAbort to roll back to the base revision
+	RolloutAbort = "abort"
+)
+
+// ConfigRollout to stage a configuration change to a subset of nodes of an environment
+// While active, canary nodes get the current configuration and the rest get the base revision
+type ConfigRollout struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	BaseRevision  int
+	BaseHash      string
+	Percentage    int
+	Tag           string
+	Status        string
+	CreatedBy     string
+	FinishedBy    string
+}
+
+// Selects to check if a node is part of the canary of a rollout
+// Nodes are picked by tag, or by a stable hash of the UUID for the percentage
+func (r ConfigRollout) Selects(uuid string, tagged bool) bool {
+	if r.Tag != "" && tagged {
+		return true
+	}
+	if r.Percentage <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.ToUpper(uuid)))
+	return int(h.Sum32()%100) < r.Percentage
+}
+
+// StartRollout to start a staged rollout, pinning the base revision for non canary nodes
+// If base is zero, the latest revision is used as base
+func (environment *Environment) StartRollout(idEnv string, base, percentage int, tag, user string) (ConfigRollout, error) {
+	if percentage < 0 || percentage > 100 {
+		return ConfigRollout{}, fmt.Errorf("invalid percentage %d", percentage)
+	}
+	if percentage == 0 && tag == "" {
+		return ConfigRollout{}, fmt.Errorf("percentage or tag is required")
+	}
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRollout{}, fmt.Errorf("error getting environment %v", err)
+	}
+	if _, err := environment.ActiveRollout(env.ID); err == nil {
+		return ConfigRollout{}, fmt.Errorf("rollout already active for %s", env.Name)
+	}
+	if base == 0 {
+		latest, err := environment.CommitRevision(env.UUID, user, "rollout base")
+		if err != nil {
+			return ConfigRollout{}, err
+		}
+		base = latest.Revision
+	}
+	rev, err := environment.GetRevision(env.ID, base)
+	if err != nil {
+		return ConfigRollout{}, fmt.Errorf("error getting revision %d %v", base, err)
+	}
+	rollout := ConfigRollout{
+		EnvironmentID: env.ID,
+		BaseRevision:  rev.Revision,
+		BaseHash:      OsqueryConfigHash(rev.Configuration),
+		Percentage:    percentage,
+		Tag:           tag,
+		Status:        RolloutActive,
+		CreatedBy:     user,
+	}
+	if err := environment.DB.Create(&rollout).Error; err != nil {
+		return rollout, fmt.Errorf("Create ConfigRollout %v", err)
+	}
+	return rollout, environment.touchRollout(env.ID)
+}
+
+// ActiveRollout to retrieve the active rollout of an environment, if any
+func (environment *Environment) ActiveRollout(envID uint) (ConfigRollout, error) {
+	var rollouts []ConfigRollout
+	if err := environment.DB.Where("environment_id = ? AND status = ?", envID, RolloutActive).Limit(1).Find(&rollouts).Error; err != nil {
+		return ConfigRollout{}, err
+	}
+	if len(rollouts) == 0 {
+		return ConfigRollout{}, fmt.Errorf("no active rollout")
+	}
+	return rollouts[0], nil
+}
+
+// GetRollouts to retrieve all the rollouts of an environment, newest first
+func (environment *Environment) GetRollouts(envID uint) ([]ConfigRollout, error) {
+	var rollouts []ConfigRollout
+	if err := environment.DB.Where("environment_id = ?", envID).Order("id desc").Find(&rollouts).Error; err != nil {
+		return rollouts, err
+	}
+	return rollouts, nil
+}
+
+// PromoteRollout to finish the active rollout serving the current configuration to all nodes
+func (environment *Environment) PromoteRollout(idEnv, user string) (ConfigRollout, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRollout{}, fmt.Errorf("error getting environment %v", err)
+	}
+	rollout, err := environment.ActiveRollout(env.ID)
+	if err != nil {
+		return rollout, err
+	}
+	if _, err := environment.CommitRevision(env.UUID, user, "rollout promoted"); err != nil {
+		return rollout, err
+	}
+	return rollout, environment.finishRollout(&rollout, RolloutPromoted, user)
+}
+
+// AbortRollout to finish the active rollout rolling back all nodes to the base revision
+func (environment *Environment) AbortRollout(idEnv, user string) (ConfigRollout, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigRollout{}, fmt.Errorf("error getting environment %v", err)
+	}
+	rollout, err := environment.ActiveRollout(env.ID)
+	if err != nil {
+		return rollout, err
+	}
+	if _, err := environment.RollbackRevision(env.UUID, rollout.BaseRevision, user); err != nil {
+		return rollout, err
+	}
+	return rollout, environment.finishRollout(&rollout, RolloutAborted, user)
+}
+
+func (environment *Environment) finishRollout(rollout *ConfigRollout, status, user string) error {
+	rollout.Status = status
+	rollout.FinishedBy = user
+	if err := environment.DB.Model(rollout).Updates(map[string]interface{}{"status": status, "finished_by": user}).Error; err != nil {
+		return fmt.Errorf("Update rollout %v", err)
+	}
+	return environment.touchRollout(rollout.EnvironmentID)
+}
+
+// touchRollout to update the environment when its rollout changes, so cached rollouts are refreshed
+func (environment *Environment) touchRollout(envID uint) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("id = ?", envID).Update("updated_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("Update environment %v", err)
+	}
+	return nil
+}
+
+// RolloutCache to keep in memory the active rollout of environments and the configuration of its base revision
+// Entries are refreshed when the environment is updated, which happens every time its rollout changes
+type RolloutCache struct {
+	envs    *Environment
+	mutex   sync.Mutex
+	entries map[uint]rolloutEntry
+}
+
+type rolloutEntry struct {
+	updated time.Time
+	active  bool
+	rollout ConfigRollout
+	base    string
+}
+
+// NewRolloutCache to initialize the cache of active rollouts
+func NewRolloutCache(envs *Environment) *RolloutCache {
+	return &RolloutCache{
+		envs:    envs,
+		entries: make(map[uint]rolloutEntry),
+	}
+}
+
+// Get to retrieve the active rollout of an environment and the configuration of its base revision
+func (c *RolloutCache) Get(env TLSEnvironment) (ConfigRollout, string, bool) {
+	c.mutex.Lock()
+	entry, ok := c.entries[env.ID]
+	c.mutex.Unlock()
+	if ok && entry.updated.Equal(env.UpdatedAt) {
+		return entry.rollout, entry.base, entry.active
+	}
+	entry = rolloutEntry{updated: env.UpdatedAt}
+	if rollout, err := c.envs.ActiveRollout(env.ID); err == nil {
+		base, err := c.envs.GetRevision(env.ID, rollout.BaseRevision)
+		if err != nil {
+			// Not cached so it is retried
+			log.Err(err).Msgf("error getting base revision %d for %s", rollout.BaseRevision, env.Name)
+			return ConfigRollout{}, "", false
+		}
+		entry.active = true
+		entry.rollout = rollout
+		entry.base = base.Configuration
+	}
+	c.mutex.Lock()
+	c.entries[env.ID] = entry
+	c.mutex.Unlock()
+	return entry.rollout, entry.base, entry.active
+}
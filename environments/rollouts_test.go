@@ -0,0 +1,33 @@
+package environments
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutSelects(t *testing.T) {
+	t.Run("tag", func(t *testing.T) {
+		r := ConfigRollout{Tag: "canary"}
+		assert.True(t, r.Selects("uuid", true))
+		assert.False(t, r.Selects("uuid", false))
+	})
+	t.Run("percentage", func(t *testing.T) {
+		none := ConfigRollout{Percentage: 0}
+		all := ConfigRollout{Percentage: 100}
+		half := ConfigRollout{Percentage: 50}
+		selected := 0
+		for i := 0; i < 1000; i++ {
+			uuid := fmt.Sprintf("node-%d", i)
+			assert.False(t, none.Selects(uuid, false))
+			assert.True(t, all.Selects(uuid, false))
+			if half.Selects(uuid, false) {
+				selected++
+			}
+			// Selection is stable
+			assert.Equal(t, half.Selects(uuid, false), half.Selects(uuid, false))
+		}
+		assert.InDelta(t, 500, selected, 100)
+	})
+}
@@ -0,0 +1,450 @@
+package environments
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// RPM header tags used in enrollment packages, see lib/rpmtag.h in rpm
+const (
+	rpmTagSigRegion         int32 = 62
+	rpmTagHeaderRegion      int32 = 63
+	rpmTagI18NTable         int32 = 100
+	rpmSigTagSHA1           int32 = 269
+	rpmSigTagSHA256         int32 = 273
+	rpmSigTagSize           int32 = 1000
+	rpmSigTagMD5            int32 = 1004
+	rpmSigTagPayloadSize    int32 = 1007
+	rpmTagName              int32 = 1000
+	rpmTagVersion           int32 = 1001
+	rpmTagRelease           int32 = 1002
+	rpmTagSummary           int32 = 1004
+	rpmTagDescription       int32 = 1005
+	rpmTagBuildTime         int32 = 1006
+	rpmTagBuildHost         int32 = 1007
+	rpmTagSize              int32 = 1009
+	rpmTagLicense           int32 = 1014
+	rpmTagGroup             int32 = 1016
+	rpmTagURL               int32 = 1020
+	rpmTagOS                int32 = 1021
+	rpmTagArch              int32 = 1022
+	rpmTagPostIn            int32 = 1024
+	rpmTagPreUn             int32 = 1025
+	rpmTagFileSizes         int32 = 1028
+	rpmTagFileModes         int32 = 1030
+	rpmTagFileRdevs         int32 = 1033
+	rpmTagFileMtimes        int32 = 1034
+	rpmTagFileDigests       int32 = 1035
+	rpmTagFileLinkTos       int32 = 1036
+	rpmTagFileFlags         int32 = 1037
+	rpmTagFileUsername      int32 = 1039
+	rpmTagFileGroupname     int32 = 1040
+	rpmTagSourceRPM         int32 = 1044
+	rpmTagProvideName       int32 = 1047
+	rpmTagRequireFlags      int32 = 1048
+	rpmTagRequireName       int32 = 1049
+	rpmTagRequireVersion    int32 = 1050
+	rpmTagConflictFlags     int32 = 1053
+	rpmTagConflictName      int32 = 1054
+	rpmTagConflictVersion   int32 = 1055
+	rpmTagRPMVersion        int32 = 1064
+	rpmTagPostInProg        int32 = 1085
+	rpmTagPreUnProg         int32 = 1086
+	rpmTagFileDevices       int32 = 1095
+	rpmTagFileInodes        int32 = 1096
+	rpmTagFileLangs         int32 = 1097
+	rpmTagProvideFlags      int32 = 1112
+	rpmTagProvideVersion    int32 = 1113
+	rpmTagDirIndexes        int32 = 1116
+	rpmTagBaseNames         int32 = 1117
+	rpmTagDirNames          int32 = 1118
+	rpmTagPayloadFormat     int32 = 1124
+	rpmTagPayloadCompressor int32 = 1125
+	rpmTagPayloadFlags      int32 = 1126
+	rpmTagFileDigestAlgo    int32 = 5011
+)
+
+// RPM header types
+const (
+	rpmTypeInt16       int32 = 3
+	rpmTypeInt32       int32 = 4
+	rpmTypeString      int32 = 6
+	rpmTypeBin         int32 = 7
+	rpmTypeStringArray int32 = 8
+	rpmTypeI18NString  int32 = 9
+)
+
+const (
+	// rpmSenseLibRequire for rpmlib() dependencies, as RPMSENSE_LESS | RPMSENSE_EQUAL | RPMSENSE_RPMLIB
+	rpmSenseLibRequire int32 = 2 | 8 | 1<<24
+	// rpmSenseEqual for dependencies on an exact version
+	rpmSenseEqual int32 = 8
+	// rpmFileConfig for configuration files that are not replaced if modified, as RPMFILE_CONFIG | RPMFILE_NOREPLACE
+	rpmFileConfig int32 = 1 | 16
+	// rpmDigestSHA256 as digest algorithm for files
+	rpmDigestSHA256 int32 = 8
+)
+
+// rpmLibRequires as features of rpm needed to install the generated packages
+var rpmLibRequires = [][2]string{
+	{"rpmlib(CompressedFileNames)", "3.0.4-1"},
+	{"rpmlib(FileDigests)", "4.6.0-1"},
+	{"rpmlib(PayloadFilesHavePrefix)", "4.0-1"},
+}
+
+type rpmEntry struct {
+	tag   int32
+	typ   int32
+	count int
+	data  []byte
+}
+
+type rpmHeader struct {
+	entries []rpmEntry
+}
+
+func (h *rpmHeader) add(tag, typ int32, count int, data []byte) {
+	h.entries = append(h.entries, rpmEntry{tag: tag, typ: typ, count: count, data: data})
+}
+
+func (h *rpmHeader) addString(tag int32, value string) {
+	h.add(tag, rpmTypeString, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addI18NString(tag int32, value string) {
+	h.add(tag, rpmTypeI18NString, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addStrings(tag int32, values []string) {
+	var data []byte
+	for _, v := range values {
+		data = append(append(data, v...), 0)
+	}
+	h.add(tag, rpmTypeStringArray, len(values), data)
+}
+
+func (h *rpmHeader) addInt32(tag int32, values ...int32) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	h.add(tag, rpmTypeInt32, len(values), data)
+}
+
+func (h *rpmHeader) addInt16(tag int32, values ...int16) {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	h.add(tag, rpmTypeInt16, len(values), data)
+}
+
+// bytes to serialize the header as an immutable region, with entries sorted by tag and aligned values
+func (h *rpmHeader) bytes(region int32) []byte {
+	sort.Slice(h.entries, func(i, j int) bool { return h.entries[i].tag < h.entries[j].tag })
+	var store bytes.Buffer
+	index := make([]int32, 0, 4*(len(h.entries)+1))
+	for _, e := range h.entries {
+		align := 1
+		switch e.typ {
+		case rpmTypeInt16:
+			align = 2
+		case rpmTypeInt32:
+			align = 4
+		}
+		for store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		index = append(index, e.tag, e.typ, int32(store.Len()), int32(e.count))
+		store.Write(e.data)
+	}
+	// The trailer of the region goes at the end of the store and points back to the start of the index
+	nindex := len(h.entries) + 1
+	trailerOffset := int32(store.Len())
+	trailer := make([]byte, 16)
+	binary.BigEndian.PutUint32(trailer[0:], uint32(region))
+	binary.BigEndian.PutUint32(trailer[4:], uint32(rpmTypeBin))
+	binary.BigEndian.PutUint32(trailer[8:], uint32(int32(-16*nindex)))
+	binary.BigEndian.PutUint32(trailer[12:], 16)
+	store.Write(trailer)
+	var buf bytes.Buffer
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, uint32(nindex))
+	binary.Write(&buf, binary.BigEndian, uint32(store.Len()))
+	binary.Write(&buf, binary.BigEndian, []int32{region, rpmTypeBin, trailerOffset, 16})
+	binary.Write(&buf, binary.BigEndian, index)
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+// BuildRpm to generate a RPM package with the provided files
+// The package has a lead, a signature header with digests, the main header and a gzip compressed cpio payload
+func BuildRpm(meta PackageMeta, files []PackageFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteRpm(&buf, meta, files); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteRpm to write a RPM package with the provided files, the payload is staged in a temporary file
+func WriteRpm(w io.Writer, meta PackageMeta, files []PackageFile) error {
+	files = append([]PackageFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	now := time.Now()
+	payload, err := os.CreateTemp("", "osctrl-rpm-*")
+	if err != nil {
+		return fmt.Errorf("error creating payload %v", err)
+	}
+	defer os.Remove(payload.Name())
+	defer payload.Close()
+	payloadSize, err := rpmPayload(payload, files, now)
+	if err != nil {
+		return fmt.Errorf("error generating payload %v", err)
+	}
+	header, err := rpmMainHeader(meta, files, now)
+	if err != nil {
+		return fmt.Errorf("error generating header %v", err)
+	}
+	// Signature header with sizes and digests of the main header and payload
+	md5sum := md5.New()
+	md5sum.Write(header)
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading payload %v", err)
+	}
+	compressed, err := io.Copy(md5sum, payload)
+	if err != nil {
+		return fmt.Errorf("error reading payload %v", err)
+	}
+	var sig rpmHeader
+	sig.addString(rpmSigTagSHA1, fmt.Sprintf("%x", sha1.Sum(header)))
+	sig.addString(rpmSigTagSHA256, fmt.Sprintf("%x", sha256.Sum256(header)))
+	sig.addInt32(rpmSigTagSize, int32(int64(len(header))+compressed))
+	sig.add(rpmSigTagMD5, rpmTypeBin, 16, md5sum.Sum(nil))
+	sig.addInt32(rpmSigTagPayloadSize, int32(payloadSize))
+	signature := sig.bytes(rpmTagSigRegion)
+	var buf bytes.Buffer
+	buf.Write(rpmLead(meta))
+	buf.Write(signature)
+	// The signature header is padded to 8 bytes
+	buf.Write(make([]byte, (8-len(signature)%8)%8))
+	buf.Write(header)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading payload %v", err)
+	}
+	if _, err := io.CopyN(w, payload, compressed); err != nil {
+		return fmt.Errorf("error writing payload %v", err)
+	}
+	return nil
+}
+
+// rpmLead to generate the legacy lead of a binary RPM package
+func rpmLead(meta PackageMeta) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	// Type binary and architecture number, which is not used by rpm anymore
+	binary.BigEndian.PutUint16(lead[6:], 0)
+	binary.BigEndian.PutUint16(lead[8:], 1)
+	name := fmt.Sprintf("%s-%s-%s", meta.Name, meta.Version, meta.Release)
+	if len(name) > 65 {
+		name = name[:65]
+	}
+	copy(lead[10:76], name)
+	// Operating system linux and signature in a header
+	binary.BigEndian.PutUint16(lead[76:], 1)
+	binary.BigEndian.PutUint16(lead[78:], 5)
+	return lead
+}
+
+// rpmMainHeader to generate the header with the metadata, dependencies, scripts and files of the package
+func rpmMainHeader(meta PackageMeta, files []PackageFile, now time.Time) ([]byte, error) {
+	var h rpmHeader
+	evr := meta.Version + "-" + meta.Release
+	h.addStrings(rpmTagI18NTable, []string{"C"})
+	h.addString(rpmTagName, meta.Name)
+	h.addString(rpmTagVersion, meta.Version)
+	h.addString(rpmTagRelease, meta.Release)
+	h.addI18NString(rpmTagSummary, meta.Summary)
+	h.addI18NString(rpmTagDescription, meta.Description)
+	h.addInt32(rpmTagBuildTime, int32(now.Unix()))
+	h.addString(rpmTagBuildHost, "osctrl")
+	h.addString(rpmTagLicense, meta.License)
+	h.addI18NString(rpmTagGroup, "Applications/System")
+	h.addString(rpmTagURL, meta.URL)
+	h.addString(rpmTagOS, "linux")
+	h.addString(rpmTagArch, meta.Arch)
+	h.addString(rpmTagSourceRPM, fmt.Sprintf("%s-%s.src.rpm", meta.Name, evr))
+	h.addString(rpmTagRPMVersion, "4.16.1")
+	h.addStrings(rpmTagProvideName, []string{meta.Name})
+	h.addInt32(rpmTagProvideFlags, rpmSenseEqual)
+	h.addStrings(rpmTagProvideVersion, []string{evr})
+	// Dependencies on features of rpm and the shell for scripts
+	var reqNames, reqVersions []string
+	var reqFlags []int32
+	if meta.PostInstall != "" || meta.PreRemove != "" {
+		reqNames = append(reqNames, "/bin/sh")
+		reqVersions = append(reqVersions, "")
+		reqFlags = append(reqFlags, 0)
+	}
+	for _, r := range rpmLibRequires {
+		reqNames = append(reqNames, r[0])
+		reqVersions = append(reqVersions, r[1])
+		reqFlags = append(reqFlags, rpmSenseLibRequire)
+	}
+	h.addStrings(rpmTagRequireName, reqNames)
+	h.addInt32(rpmTagRequireFlags, reqFlags...)
+	h.addStrings(rpmTagRequireVersion, reqVersions)
+	if len(meta.Conflicts) > 0 {
+		h.addStrings(rpmTagConflictName, meta.Conflicts)
+		h.addInt32(rpmTagConflictFlags, make([]int32, len(meta.Conflicts))...)
+		h.addStrings(rpmTagConflictVersion, make([]string, len(meta.Conflicts)))
+	}
+	if meta.PostInstall != "" {
+		h.addString(rpmTagPostIn, meta.PostInstall)
+		h.addString(rpmTagPostInProg, "/bin/sh")
+	}
+	if meta.PreRemove != "" {
+		// Only when the package is erased, not upgraded
+		h.addString(rpmTagPreUn, "if [ \"$1\" -eq 0 ]; then\n"+meta.PreRemove+"fi\n")
+		h.addString(rpmTagPreUnProg, "/bin/sh")
+	}
+	// Files, with paths split in directories and base names
+	n := len(files)
+	sizes := make([]int32, n)
+	modes := make([]int16, n)
+	mtimes := make([]int32, n)
+	flags := make([]int32, n)
+	devices := make([]int32, n)
+	inodes := make([]int32, n)
+	dirIndexes := make([]int32, n)
+	digests := make([]string, n)
+	owners := make([]string, n)
+	baseNames := make([]string, n)
+	var dirNames []string
+	dirs := make(map[string]int32)
+	var total int64
+	for i, f := range files {
+		size, err := f.size()
+		if err != nil {
+			return nil, err
+		}
+		digest, err := f.digest(sha256.New())
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = int32(size)
+		modes[i] = int16(0100000 | f.Mode&07777)
+		mtimes[i] = int32(now.Unix())
+		if f.Config {
+			flags[i] = rpmFileConfig
+		}
+		devices[i] = 1
+		inodes[i] = int32(i + 1)
+		digests[i] = fmt.Sprintf("%x", digest)
+		owners[i] = "root"
+		dir := path.Dir(f.Path) + "/"
+		idx, ok := dirs[dir]
+		if !ok {
+			idx = int32(len(dirNames))
+			dirs[dir] = idx
+			dirNames = append(dirNames, dir)
+		}
+		dirIndexes[i] = idx
+		baseNames[i] = path.Base(f.Path)
+		total += size
+	}
+	h.addInt32(rpmTagSize, int32(total))
+	h.addInt32(rpmTagFileSizes, sizes...)
+	h.addInt16(rpmTagFileModes, modes...)
+	h.addInt16(rpmTagFileRdevs, make([]int16, n)...)
+	h.addInt32(rpmTagFileMtimes, mtimes...)
+	h.addStrings(rpmTagFileDigests, digests)
+	h.addStrings(rpmTagFileLinkTos, make([]string, n))
+	h.addInt32(rpmTagFileFlags, flags...)
+	h.addStrings(rpmTagFileUsername, owners)
+	h.addStrings(rpmTagFileGroupname, owners)
+	h.addInt32(rpmTagFileDevices, devices...)
+	h.addInt32(rpmTagFileInodes, inodes...)
+	h.addStrings(rpmTagFileLangs, make([]string, n))
+	h.addInt32(rpmTagDirIndexes, dirIndexes...)
+	h.addStrings(rpmTagBaseNames, baseNames)
+	h.addStrings(rpmTagDirNames, dirNames)
+	h.addInt32(rpmTagFileDigestAlgo, rpmDigestSHA256)
+	h.addString(rpmTagPayloadFormat, "cpio")
+	h.addString(rpmTagPayloadCompressor, "gzip")
+	h.addString(rpmTagPayloadFlags, "9")
+	return h.bytes(rpmTagHeaderRegion), nil
+}
+
+// countingWriter to keep the number of bytes written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rpmPayload to write the gzip compressed cpio archive in newc format with the files, returning the uncompressed size
+func rpmPayload(w io.Writer, files []PackageFile, now time.Time) (int64, error) {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	archive := &countingWriter{w: gz}
+	pad := func() error {
+		if r := archive.n % 4; r != 0 {
+			_, err := archive.Write(make([]byte, 4-r))
+			return err
+		}
+		return nil
+	}
+	entry := func(ino int, name string, mode uint32, f PackageFile, size int64) error {
+		if _, err := fmt.Fprintf(archive, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			ino, mode, 0, 0, 1, now.Unix(), size, 0, 0, 0, 0, len(name)+1, 0); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(archive, name+"\x00"); err != nil {
+			return err
+		}
+		if err := pad(); err != nil {
+			return err
+		}
+		if err := f.copyTo(archive, size); err != nil {
+			return err
+		}
+		return pad()
+	}
+	for i, f := range files {
+		size, err := f.size()
+		if err != nil {
+			return 0, err
+		}
+		if err := entry(i+1, "."+f.Path, 0100000|f.Mode&07777, f, size); err != nil {
+			return 0, err
+		}
+	}
+	if err := entry(0, "TRAILER!!!", 0, PackageFile{}, 0); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return archive.n, nil
+}
@@ -179,9 +179,21 @@ prepareSecret() {
 
 prepareFlags() {
   log "Preparing osquery flags in $_FLAGS"
-  sudo sh -c "cat <<EOF | sed -e 's@__SECRET_FILE__@$_SECRET_FILE@g' | sed 's@__CERT_FILE__@$_CERT@g' > $_FLAGS
-{{ .Environment.Flags }}
+  if [ "$_OS" = "linux" ]; then
+    sudo sh -c "cat <<EOF | sed -e 's@__SECRET_FILE__@$_SECRET_FILE@g' | sed 's@__CERT_FILE__@$_CERT@g' > $_FLAGS
+{{ .Flags.linux }}
+EOF"
+  fi
+  if [ "$_OS" = "darwin" ]; then
+    sudo sh -c "cat <<EOF | sed -e 's@__SECRET_FILE__@$_SECRET_FILE@g' | sed 's@__CERT_FILE__@$_CERT@g' > $_FLAGS
+{{ .Flags.darwin }}
 EOF"
+  fi
+  if [ "$_OS" = "freebsd" ]; then
+    sudo sh -c "cat <<EOF | sed -e 's@__SECRET_FILE__@$_SECRET_FILE@g' | sed 's@__CERT_FILE__@$_CERT@g' > $_FLAGS
+{{ .Flags.freebsd }}
+EOF"
+  fi
 }
 
 prepareCert() {
@@ -272,7 +284,7 @@ $osqueryTempMSI = "C:\Windows\Temp\osquery-{{ .OsqueryVersion }}.msi"
 $serviceName = "osqueryd"
 $serviceDescription = "osquery daemon service"
 $osqueryFlags = @"
-{{ .Environment.Flags }}
+{{ .Flags.windows }}
 "@
 $osqueryFlags = $osqueryFlags -replace "__SECRET_FILE__", $secretFile
 $osqueryFlags = $osqueryFlags -replace "__CERT_FILE__", $certFile
@@ -0,0 +1,297 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmpsec/osctrl/settings"
+)
+
+const (
+	// EnvTemplateVersion as current version of the format for environment templates
+	EnvTemplateVersion int = 1
+	// TemplateFlagUUID to use as placeholder for the environment UUID in the flags of a template
+	TemplateFlagUUID string = "__ENV_UUID__"
+	// TemplateFlagHostname to use as placeholder for the environment hostname in the flags of a template
+	TemplateFlagHostname string = "__ENV_HOSTNAME__"
+	// flagTLSHostname to replace the hostname in flags
+	flagTLSHostname string = "--tls_hostname="
+)
+
+// EnvTemplate to hold all the portable values of an environment, without secrets or identifiers
+type EnvTemplate struct {
+	Version           int               `json:"version"`
+	Name              string            `json:"name"`
+	Type              string            `json:"type"`
+	Icon              string            `json:"icon"`
+	Configuration     OsqueryConf       `json:"configuration"`
+	Flags             string            `json:"flags"`
+	ConfigTLS         bool              `json:"config_tls"`
+	ConfigInterval    int               `json:"config_interval"`
+	LoggingTLS        bool              `json:"logging_tls"`
+	LogInterval       int               `json:"log_interval"`
+	QueryTLS          bool              `json:"query_tls"`
+	QueryInterval     int               `json:"query_interval"`
+	CarvesTLS         bool              `json:"carves_tls"`
+	EnrollPath        string            `json:"enroll_path"`
+	LogPath           string            `json:"log_path"`
+	ConfigPath        string            `json:"config_path"`
+	QueryReadPath     string            `json:"query_read_path"`
+	QueryWritePath    string            `json:"query_write_path"`
+	CarverInitPath    string            `json:"carver_init_path"`
+	CarverBlockPath   string            `json:"carver_block_path"`
+	DebPackage        string            `json:"deb_package"`
+	RpmPackage        string            `json:"rpm_package"`
+	MsiPackage        string            `json:"msi_package"`
+	PkgPackage        string            `json:"pkg_package"`
+	DebugHTTP         bool              `json:"debug_http"`
+	AcceptEnrolls     bool              `json:"accept_enrolls"`
+	RequireApproval   bool              `json:"require_approval"`
+	RequireClientCert bool              `json:"require_client_cert"`
+	ClientCA          string            `json:"client_ca"`
+	Tags              []TemplateTag     `json:"tags,omitempty"`
+	Settings          []TemplateSetting `json:"settings,omitempty"`
+}
+
+// TemplateTag to hold a tag of an environment in a template
+type TemplateTag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
+}
+
+// TemplateSetting to hold a settings value of an environment in a template
+type TemplateSetting struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	String  string `json:"string,omitempty"`
+	Boolean bool   `json:"boolean,omitempty"`
+	Integer int64  `json:"integer,omitempty"`
+	Info    string `json:"info,omitempty"`
+}
+
+// ExportTemplate to generate a template from an existing environment, including its settings
+// Tags are kept by the tags manager and must be added by the caller
+func (environment *Environment) ExportTemplate(env TLSEnvironment) (EnvTemplate, error) {
+	cnf, err := environment.GenStructConf([]byte(env.Configuration))
+	if err != nil {
+		return EnvTemplate{}, fmt.Errorf("error parsing configuration %v", err)
+	}
+	tpl := EnvTemplate{
+		Version:           EnvTemplateVersion,
+		Name:              env.Name,
+		Type:              env.Type,
+		Icon:              env.Icon,
+		Configuration:     cnf,
+		Flags:             PortableFlags(env),
+		ConfigTLS:         env.ConfigTLS,
+		ConfigInterval:    env.ConfigInterval,
+		LoggingTLS:        env.LoggingTLS,
+		LogInterval:       env.LogInterval,
+		QueryTLS:          env.QueryTLS,
+		QueryInterval:     env.QueryInterval,
+		CarvesTLS:         env.CarvesTLS,
+		EnrollPath:        env.EnrollPath,
+		LogPath:           env.LogPath,
+		ConfigPath:        env.ConfigPath,
+		QueryReadPath:     env.QueryReadPath,
+		QueryWritePath:    env.QueryWritePath,
+		CarverInitPath:    env.CarverInitPath,
+		CarverBlockPath:   env.CarverBlockPath,
+		DebPackage:        env.DebPackage,
+		RpmPackage:        env.RpmPackage,
+		MsiPackage:        env.MsiPackage,
+		PkgPackage:        env.PkgPackage,
+		DebugHTTP:         env.DebugHTTP,
+		AcceptEnrolls:     env.AcceptEnrolls,
+		RequireApproval:   env.RequireApproval,
+		RequireClientCert: env.RequireClientCert,
+		ClientCA:          env.ClientCA,
+	}
+	// JSON values hold the configuration of services and are not part of environments
+	var values []settings.SettingValue
+	if err := environment.DB.Where("environment_id = ? AND json = ?", env.ID, false).Order("service, name").Find(&values).Error; err != nil {
+		return tpl, fmt.Errorf("error getting settings %v", err)
+	}
+	for _, v := range values {
+		tpl.Settings = append(tpl.Settings, TemplateSetting{
+			Service: v.Service,
+			Name:    v.Name,
+			Type:    v.Type,
+			String:  v.String,
+			Boolean: v.Boolean,
+			Integer: v.Integer,
+			Info:    v.Info,
+		})
+	}
+	return tpl, nil
+}
+
+// ParseTemplate to parse and check a serialized environment template
+func ParseTemplate(data []byte) (EnvTemplate, error) {
+	var tpl EnvTemplate
+	if err := json.Unmarshal(data, &tpl); err != nil {
+		return tpl, fmt.Errorf("error parsing template %v", err)
+	}
+	if tpl.Version != EnvTemplateVersion {
+		return tpl, fmt.Errorf("unsupported template version %d", tpl.Version)
+	}
+	return tpl, nil
+}
+
+// CreateFromTemplate to create a new environment with fresh secrets and UUID from a template
+// The configuration is validated first and the settings of the template are created for the new environment
+func (environment *Environment) CreateFromTemplate(tpl EnvTemplate, name, hostname string) (TLSEnvironment, error) {
+	if environment.Exists(name) {
+		return TLSEnvironment{}, fmt.Errorf("environment %s already exists", name)
+	}
+	env, err := environment.FromTemplate(tpl, name, hostname)
+	if err != nil {
+		return env, err
+	}
+	return environment.createWithSettings(env, tpl.Settings)
+}
+
+// FromTemplate to generate a new environment with fresh secrets and UUID from a template, without creating it
+func (environment *Environment) FromTemplate(tpl EnvTemplate, name, hostname string) (TLSEnvironment, error) {
+	if name == "" || hostname == "" {
+		return TLSEnvironment{}, fmt.Errorf("environment name and hostname are required")
+	}
+	if err := environment.ValidateConf(tpl.Configuration); err != nil {
+		return TLSEnvironment{}, err
+	}
+	env := environment.Empty(name, hostname)
+	if tpl.Type != "" {
+		env.Type = tpl.Type
+	}
+	if tpl.Icon != "" {
+		env.Icon = tpl.Icon
+	}
+	env.ConfigTLS = tpl.ConfigTLS
+	env.LoggingTLS = tpl.LoggingTLS
+	env.QueryTLS = tpl.QueryTLS
+	env.CarvesTLS = tpl.CarvesTLS
+	setInt(&env.ConfigInterval, tpl.ConfigInterval)
+	setInt(&env.LogInterval, tpl.LogInterval)
+	setInt(&env.QueryInterval, tpl.QueryInterval)
+	setString(&env.EnrollPath, tpl.EnrollPath)
+	setString(&env.LogPath, tpl.LogPath)
+	setString(&env.ConfigPath, tpl.ConfigPath)
+	setString(&env.QueryReadPath, tpl.QueryReadPath)
+	setString(&env.QueryWritePath, tpl.QueryWritePath)
+	setString(&env.CarverInitPath, tpl.CarverInitPath)
+	setString(&env.CarverBlockPath, tpl.CarverBlockPath)
+	env.DebPackage = tpl.DebPackage
+	env.RpmPackage = tpl.RpmPackage
+	env.MsiPackage = tpl.MsiPackage
+	env.PkgPackage = tpl.PkgPackage
+	env.DebugHTTP = tpl.DebugHTTP
+	env.AcceptEnrolls = tpl.AcceptEnrolls
+	env.RequireApproval = tpl.RequireApproval
+	env.RequireClientCert = tpl.RequireClientCert
+	env.ClientCA = tpl.ClientCA
+	// Configuration and parts
+	parts := []struct {
+		dst  *string
+		data interface{}
+	}{
+		{&env.Options, tpl.Configuration.Options},
+		{&env.Schedule, tpl.Configuration.Schedule},
+		{&env.Packs, tpl.Configuration.Packs},
+		{&env.Decorators, tpl.Configuration.Decorators},
+		{&env.ATC, tpl.Configuration.ATC},
+		{&env.Configuration, tpl.Configuration},
+	}
+	for _, p := range parts {
+		serialized, err := environment.GenSerializedConf(p.data, true)
+		if err != nil {
+			return env, fmt.Errorf("error serializing configuration %v", err)
+		}
+		if serialized == "null" {
+			serialized = "{}"
+		}
+		*p.dst = serialized
+	}
+	env.ConfigHash = OsqueryConfigHash(env.Configuration)
+	flags, err := environment.templateFlags(tpl, env)
+	if err != nil {
+		return env, err
+	}
+	env.Flags = flags
+	return env, nil
+}
+
+// templateFlags to generate the flags of an environment from the flags of a template
+func (environment *Environment) templateFlags(tpl EnvTemplate, env TLSEnvironment) (string, error) {
+	if tpl.Flags != "" {
+		return ApplyPortableFlags(tpl.Flags, env), nil
+	}
+	flags, err := environment.GenerateFlags(env, "", "")
+	if err != nil {
+		return "", fmt.Errorf("error generating flags %v", err)
+	}
+	return flags, nil
+}
+
+// createWithSettings to create an environment and its settings values
+func (environment *Environment) createWithSettings(env TLSEnvironment, values []TemplateSetting) (TLSEnvironment, error) {
+	if err := environment.Create(env); err != nil {
+		return env, err
+	}
+	// Get the ID of the created environment
+	env, err := environment.GetByUUID(env.UUID)
+	if err != nil {
+		return env, err
+	}
+	if err := environment.createSettings(env.ID, values); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// createSettings to create the settings values of a template for an environment
+func (environment *Environment) createSettings(envID uint, values []TemplateSetting) error {
+	for _, s := range values {
+		value := settings.SettingValue{
+			Name:          s.Name,
+			Service:       s.Service,
+			EnvironmentID: envID,
+			Type:          s.Type,
+			String:        s.String,
+			Boolean:       s.Boolean,
+			Integer:       s.Integer,
+			Info:          s.Info,
+		}
+		if err := environment.DB.Create(&value).Error; err != nil {
+			return fmt.Errorf("Create SettingValue %v", err)
+		}
+	}
+	return nil
+}
+
+// PortableFlags to replace the UUID and hostname of an environment in its flags with placeholders
+func PortableFlags(env TLSEnvironment) string {
+	flags := strings.ReplaceAll(env.Flags, env.UUID, TemplateFlagUUID)
+	return strings.ReplaceAll(flags, flagTLSHostname+env.Hostname+"\n", flagTLSHostname+TemplateFlagHostname+"\n")
+}
+
+// ApplyPortableFlags to replace the placeholders in flags with the UUID and hostname of an environment
+func ApplyPortableFlags(flags string, env TLSEnvironment) string {
+	flags = strings.ReplaceAll(flags, TemplateFlagUUID, env.UUID)
+	return strings.ReplaceAll(flags, TemplateFlagHostname, env.Hostname)
+}
+
+func setInt(dst *int, value int) {
+	if value > 0 {
+		*dst = value
+	}
+}
+
+func setString(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
@@ -0,0 +1,30 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortableFlags(t *testing.T) {
+	env := TLSEnvironment{UUID: "uuid-1", Hostname: "osctrl.example.com", ConfigPath: "config", ConfigInterval: 300}
+	env.Flags = GenGenericFlag("flags", FlagsTemplate, flagData{Environment: env})
+	portable := PortableFlags(env)
+	assert.NotContains(t, portable, env.UUID)
+	assert.Contains(t, portable, "--config_tls_endpoint=/"+TemplateFlagUUID+"/config\n")
+	assert.Contains(t, portable, "--tls_hostname="+TemplateFlagHostname+"\n")
+	clone := TLSEnvironment{UUID: "uuid-2", Hostname: "new.example.com", ConfigPath: "config", ConfigInterval: 300}
+	assert.Equal(t, GenGenericFlag("flags", FlagsTemplate, flagData{Environment: clone}), ApplyPortableFlags(portable, clone))
+}
+
+func TestParseTemplate(t *testing.T) {
+	tpl, err := ParseTemplate([]byte(`{"version": 1, "name": "dev", "configuration": {"options": {"utc": true}}, "tags": [{"name": "laptops"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", tpl.Name)
+	assert.Equal(t, true, tpl.Configuration.Options["utc"])
+	assert.Equal(t, []TemplateTag{{Name: "laptops"}}, tpl.Tags)
+	_, err = ParseTemplate([]byte(`{"version": 2}`))
+	assert.EqualError(t, err, "unsupported template version 2")
+	_, err = ParseTemplate([]byte(`{`))
+	assert.Error(t, err)
+}
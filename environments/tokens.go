@@ -0,0 +1,96 @@
+package environments
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultTokenLength as default length for enrollment tokens
+	DefaultTokenLength int = 48
+	// DefaultTokenExpire as default time in hours to expire enrollment tokens
+	DefaultTokenExpire int = 24
+)
+
+// EnrollToken to hold enrollment tokens that can be used instead of the environment secret
+// A token with MaxUses set to zero can be used until it expires
+type EnrollToken struct {
+	gorm.Model
+	Token         string `gorm:"index"`
+	EnvironmentID uint
+	MaxUses       int
+	Uses          int
+	Expires       time.Time
+	Comment       string
+	CreatedBy     string
+}
+
+// NewEnrollToken to mint a new enrollment token for an environment
+func (environment *Environment) NewEnrollToken(envID uint, maxUses, expireHours int, comment, user string) (EnrollToken, error) {
+	if expireHours <= 0 {
+		expireHours = DefaultTokenExpire
+	}
+	token := EnrollToken{
+		Token:         utils.GenRandomString(DefaultTokenLength),
+		EnvironmentID: envID,
+		MaxUses:       maxUses,
+		Expires:       time.Now().Add(time.Duration(expireHours) * time.Hour),
+		Comment:       comment,
+		CreatedBy:     user,
+	}
+	if err := environment.DB.Create(&token).Error; err != nil {
+		return token, fmt.Errorf("Create EnrollToken %v", err)
+	}
+	return token, nil
+}
+
+// validEnrollToken to select an enrollment token of an environment that is not expired or used up
+func (environment *Environment) validEnrollToken(token string, envID uint) *gorm.DB {
+	return environment.DB.Model(&EnrollToken{}).
+		Where("token = ? AND environment_id = ? AND expires > ?", token, envID, time.Now()).
+		Where("max_uses = 0 OR uses < max_uses")
+}
+
+// UseEnrollToken to consume one use of an enrollment token, returning false if it is not valid
+// The use is reserved with a conditional update, so concurrent enrolls can not exceed the maximum uses
+func (environment *Environment) UseEnrollToken(token string, envID uint) bool {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false
+	}
+	res := environment.validEnrollToken(token, envID).Update("uses", gorm.Expr("uses + 1"))
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// ReleaseEnrollToken to give back one use of an enrollment token when enrolling the node fails
+func (environment *Environment) ReleaseEnrollToken(token string, envID uint) bool {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false
+	}
+	res := environment.DB.Model(&EnrollToken{}).
+		Where("token = ? AND environment_id = ? AND uses > 0", token, envID).
+		Update("uses", gorm.Expr("uses - 1"))
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// GetEnrollTokens to retrieve all the enrollment tokens of an environment
+func (environment *Environment) GetEnrollTokens(envID uint) ([]EnrollToken, error) {
+	var tokens []EnrollToken
+	if err := environment.DB.Where("environment_id = ?", envID).Find(&tokens).Error; err != nil {
+		return tokens, err
+	}
+	return tokens, nil
+}
+
+// DeleteEnrollToken to revoke an enrollment token of an environment
+func (environment *Environment) DeleteEnrollToken(token string, envID uint) error {
+	if err := environment.DB.Unscoped().Where("token = ? AND environment_id = ?", token, envID).Delete(&EnrollToken{}).Error; err != nil {
+		return fmt.Errorf("Delete EnrollToken %v", err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package environments
 
 import (
+	"crypto/x509"
 	"fmt"
 	"os"
 	"strings"
@@ -68,3 +69,8 @@ func EnvironmentFinder(envID uint, envs []TLSEnvironment) string {
 	}
 	return "Unknown"
 }
+
+// ValidClientCA to check if a PEM string contains at least one certificate to verify client certificates
+func ValidClientCA(ca string) bool {
+	return x509.NewCertPool().AppendCertsFromPEM([]byte(ca))
+}
@@ -0,0 +1,683 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OptionBool for osquery options with boolean values
+	OptionBool = "bool"
+	// OptionInt for osquery options with integer values
+	OptionInt = "int"
+	// OptionString for osquery options with string values
+	OptionString = "string"
+)
+
+const (
+	// SectionOptions for issues in the options of the configuration
+	SectionOptions = "options"
+	// SectionSchedule for issues in the schedule of the configuration
+	SectionSchedule = "schedule"
+	// SectionPacks for issues in the packs of the configuration
+	SectionPacks = "packs"
+	// SectionDecorators for issues in the decorators of the configuration
+	SectionDecorators = "decorators"
+	// SectionATC for issues in the auto table construction of the configuration
+	SectionATC = "auto_table_construction"
+)
+
+const (
+	// MaxQueryInterval as the maximum interval in seconds osquery accepts for scheduled queries
+	MaxQueryInterval = 604800
+	// customOptionPrefix for options osquery allows without being a known flag
+	customOptionPrefix = "custom_"
+)
+
+// OsqueryOptions to hold the known osquery flags that can be set as configuration options, with their types
+// https://osquery.readthedocs.io/en/stable/installation/cli-flags/
+var OsqueryOptions = map[string]string{
+	"alarm_timeout":                           OptionInt,
+	"audit_allow_config":                      OptionBool,
+	"audit_allow_fim_events":                  OptionBool,
+	"audit_allow_process_events":              OptionBool,
+	"audit_allow_sockets":                     OptionBool,
+	"audit_allow_unix":                        OptionBool,
+	"audit_allow_user_events":                 OptionBool,
+	"audit_backlog_limit":                     OptionInt,
+	"audit_persist":                           OptionBool,
+	"augeas_lenses":                           OptionString,
+	"buffered_log_max":                        OptionInt,
+	"carver_block_size":                       OptionInt,
+	"carver_compression":                      OptionBool,
+	"carver_continue_endpoint":                OptionString,
+	"carver_disable_function":                 OptionBool,
+	"carver_expiry":                           OptionInt,
+	"carver_start_endpoint":                   OptionString,
+	"config_accelerated_refresh":              OptionInt,
+	"config_plugin":                           OptionString,
+	"config_refresh":                          OptionInt,
+	"config_tls_endpoint":                     OptionString,
+	"config_tls_max_attempts":                 OptionInt,
+	"database_path":                           OptionString,
+	"decorations_top_level":                   OptionBool,
+	"disable_audit":                           OptionBool,
+	"disable_caching":                         OptionBool,
+	"disable_carver":                          OptionBool,
+	"disable_database":                        OptionBool,
+	"disable_decorators":                      OptionBool,
+	"disable_distributed":                     OptionBool,
+	"disable_endpointsecurity":                OptionBool,
+	"disable_endpointsecurity_fim":            OptionBool,
+	"disable_enrollment":                      OptionBool,
+	"disable_events":                          OptionBool,
+	"disable_extensions":                      OptionBool,
+	"disable_hash_cache":                      OptionBool,
+	"disable_logging":                         OptionBool,
+	"disable_memory":                          OptionBool,
+	"disable_reenrollment":                    OptionBool,
+	"disable_tables":                          OptionString,
+	"disable_watchdog":                        OptionBool,
+	"distributed_denylist_duration":           OptionInt,
+	"distributed_interval":                    OptionInt,
+	"distributed_plugin":                      OptionString,
+	"distributed_tls_max_attempts":            OptionInt,
+	"distributed_tls_read_endpoint":           OptionString,
+	"distributed_tls_write_endpoint":          OptionString,
+	"docker_socket":                           OptionString,
+	"enable_bpf_events":                       OptionBool,
+	"enable_file_events":                      OptionBool,
+	"enable_foreign":                          OptionBool,
+	"enable_keyboard_events":                  OptionBool,
+	"enable_mouse_events":                     OptionBool,
+	"enable_ntfs_event_publisher":             OptionBool,
+	"enable_numeric_monitoring":               OptionBool,
+	"enable_powershell_events_subscriber":     OptionBool,
+	"enable_syslog":                           OptionBool,
+	"enable_tables":                           OptionString,
+	"enable_windows_events_publisher":         OptionBool,
+	"enable_windows_events_subscriber":        OptionBool,
+	"enroll_always":                           OptionBool,
+	"enroll_secret_env":                       OptionString,
+	"enroll_secret_path":                      OptionString,
+	"enroll_tls_endpoint":                     OptionString,
+	"ephemeral":                               OptionBool,
+	"es_fim_enable_open_events":               OptionBool,
+	"es_fim_mute_path_literal":                OptionString,
+	"events_expiry":                           OptionInt,
+	"events_max":                              OptionInt,
+	"events_optimize":                         OptionBool,
+	"extensions_autoload":                     OptionString,
+	"extensions_default_index":                OptionBool,
+	"extensions_interval":                     OptionInt,
+	"extensions_require":                      OptionString,
+	"extensions_socket":                       OptionString,
+	"extensions_timeout":                      OptionInt,
+	"hash_cache_max":                          OptionInt,
+	"hash_delay":                              OptionInt,
+	"host_identifier":                         OptionString,
+	"logger_event_type":                       OptionBool,
+	"logger_kafka_acks":                       OptionString,
+	"logger_kafka_brokers":                    OptionString,
+	"logger_kafka_compression":                OptionString,
+	"logger_kafka_topic":                      OptionString,
+	"logger_min_status":                       OptionInt,
+	"logger_min_stderr":                       OptionInt,
+	"logger_mode":                             OptionString,
+	"logger_numerics":                         OptionBool,
+	"logger_path":                             OptionString,
+	"logger_plugin":                           OptionString,
+	"logger_rotate":                           OptionBool,
+	"logger_rotate_max_files":                 OptionInt,
+	"logger_rotate_size":                      OptionInt,
+	"logger_secondary_status_only":            OptionBool,
+	"logger_snapshot_event_type":              OptionBool,
+	"logger_stderr":                           OptionBool,
+	"logger_tls_compress":                     OptionBool,
+	"logger_tls_endpoint":                     OptionString,
+	"logger_tls_max_lines":                    OptionInt,
+	"logger_tls_max_linesize":                 OptionInt,
+	"logger_tls_period":                       OptionInt,
+	"malloc_trim_threshold":                   OptionInt,
+	"numeric_monitoring_filesystem_path":      OptionString,
+	"numeric_monitoring_plugins":              OptionString,
+	"numeric_monitoring_pre_aggregation_time": OptionInt,
+	"pack_delimiter":                          OptionString,
+	"pack_refresh_interval":                   OptionInt,
+	"pidfile":                                 OptionString,
+	"proxy_hostname":                          OptionString,
+	"read_max":                                OptionInt,
+	"schedule_default_interval":               OptionInt,
+	"schedule_epoch":                          OptionInt,
+	"schedule_lognames":                       OptionBool,
+	"schedule_max_drift":                      OptionInt,
+	"schedule_reload":                         OptionInt,
+	"schedule_splay_percent":                  OptionInt,
+	"schedule_timeout":                        OptionInt,
+	"syslog_events_expiry":                    OptionInt,
+	"syslog_pipe_path":                        OptionString,
+	"syslog_rate_limit":                       OptionInt,
+	"table_delay":                             OptionInt,
+	"table_exceptions":                        OptionBool,
+	"tls_client_cert":                         OptionString,
+	"tls_client_key":                          OptionString,
+	"tls_enroll_max_attempts":                 OptionInt,
+	"tls_enroll_max_interval":                 OptionInt,
+	"tls_hostname":                            OptionString,
+	"tls_server_certs":                        OptionString,
+	"tls_session_reuse":                       OptionBool,
+	"tls_session_timeout":                     OptionInt,
+	"utc":                                     OptionBool,
+	"value_max":                               OptionInt,
+	"verbose":                                 OptionBool,
+	"watchdog_delay":                          OptionInt,
+	"watchdog_latency_limit":                  OptionInt,
+	"watchdog_level":                          OptionInt,
+	"watchdog_memory_limit":                   OptionInt,
+	"watchdog_utilization_limit":              OptionInt,
+	"windows_event_channels":                  OptionString,
+	"worker_threads":                          OptionInt,
+	"yara_delay":                              OptionInt,
+}
+
+// ValidPlatforms to hold the platform values osquery accepts for queries and packs
+var ValidPlatforms = map[string]bool{
+	"all":     true,
+	"any":     true,
+	"darwin":  true,
+	"freebsd": true,
+	"linux":   true,
+	"posix":   true,
+	"windows": true,
+}
+
+var versionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,2}$`)
+
+// ConfigIssue to describe one problem found validating an osquery configuration
+type ConfigIssue struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// String to format a configuration issue as section.key: message
+func (i ConfigIssue) String() string {
+	if i.Key == "" {
+		return fmt.Sprintf("%s: %s", i.Section, i.Message)
+	}
+	return fmt.Sprintf("%s.%s: %s", i.Section, i.Key, i.Message)
+}
+
+// ValidationError to return all the issues found validating an osquery configuration
+type ValidationError struct {
+	Issues []ConfigIssue `json:"issues"`
+}
+
+// Error to implement the error interface with all the issues
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Issues))
+	for _, i := range e.Issues {
+		msgs = append(msgs, i.String())
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// TableSet to hold the names of the osquery tables that queries can use
+type TableSet map[string]bool
+
+// NewTableSet to generate a set of table names
+func NewTableSet(names []string) TableSet {
+	tables := make(TableSet, len(names))
+	for _, n := range names {
+		tables[strings.ToLower(n)] = true
+	}
+	return tables
+}
+
+// CheckConf to check an osquery configuration, returning all the issues found
+// Queries are checked against tables when the set is not empty, plus the ATC tables of the configuration
+func CheckConf(cnf OsqueryConf, tables TableSet) []ConfigIssue {
+	var issues []ConfigIssue
+	add := func(section, key, format string, a ...interface{}) {
+		issues = append(issues, ConfigIssue{Section: section, Key: key, Message: fmt.Sprintf(format, a...)})
+	}
+	// ATC tables are available to every other query
+	if len(tables) > 0 {
+		extended := make(TableSet, len(tables)+len(cnf.ATC))
+		for t := range tables {
+			extended[t] = true
+		}
+		for t := range cnf.ATC {
+			extended[strings.ToLower(t)] = true
+		}
+		tables = extended
+	}
+	// Options
+	for _, k := range sortedKeys(cnf.Options) {
+		if msg := checkOption(k, cnf.Options[k]); msg != "" {
+			add(SectionOptions, k, "%s", msg)
+		}
+	}
+	// Schedule
+	for _, k := range sortedKeys(cnf.Schedule) {
+		for _, msg := range checkScheduleQuery(cnf.Schedule[k], tables) {
+			add(SectionSchedule, k, "%s", msg)
+		}
+	}
+	// Packs
+	for _, k := range sortedKeys(cnf.Packs) {
+		switch v := cnf.Packs[k].(type) {
+		case string:
+			if strings.TrimSpace(v) == "" {
+				add(SectionPacks, k, "empty local pack path")
+			}
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				add(SectionPacks, k, "invalid pack: %v", err)
+				continue
+			}
+			var pack PackEntry
+			if err := json.Unmarshal(raw, &pack); err != nil {
+				add(SectionPacks, k, "invalid pack: %v", err)
+				continue
+			}
+			if msg := checkPlatform(pack.Platform); msg != "" {
+				add(SectionPacks, k, "%s", msg)
+			}
+			if msg := checkVersion(pack.Version); msg != "" {
+				add(SectionPacks, k, "%s", msg)
+			}
+			if msg := checkShard(pack.Shard); msg != "" {
+				add(SectionPacks, k, "%s", msg)
+			}
+			for i, d := range pack.Discovery {
+				if msg := CheckQuery(d, tables); msg != "" {
+					add(SectionPacks, fmt.Sprintf("%s.discovery.%d", k, i), "%s", msg)
+				}
+			}
+			if len(pack.Queries) == 0 {
+				add(SectionPacks, k, "pack without queries")
+			}
+			for _, q := range sortedKeys(pack.Queries) {
+				for _, msg := range checkScheduleQuery(pack.Queries[q], tables) {
+					add(SectionPacks, k+".queries."+q, "%s", msg)
+				}
+			}
+		}
+	}
+	// Decorators
+	for i, q := range cnf.Decorators.Load {
+		if msg := CheckQuery(q, tables); msg != "" {
+			add(SectionDecorators, fmt.Sprintf("load.%d", i), "%s", msg)
+		}
+	}
+	for i, q := range cnf.Decorators.Always {
+		if msg := CheckQuery(q, tables); msg != "" {
+			add(SectionDecorators, fmt.Sprintf("always.%d", i), "%s", msg)
+		}
+	}
+	if cnf.Decorators.Interval != nil {
+		intervals, ok := cnf.Decorators.Interval.(map[string]interface{})
+		if !ok {
+			add(SectionDecorators, "interval", "must be an object of intervals to queries")
+		}
+		for _, k := range sortedKeys(intervals) {
+			if n, err := strconv.Atoi(k); err != nil || n <= 0 || n%60 != 0 {
+				add(SectionDecorators, "interval."+k, "interval must be a positive multiple of 60")
+			}
+			queries, ok := intervals[k].([]interface{})
+			if !ok {
+				add(SectionDecorators, "interval."+k, "must be a list of queries")
+				continue
+			}
+			for i, q := range queries {
+				s, _ := q.(string)
+				if msg := CheckQuery(s, tables); msg != "" {
+					add(SectionDecorators, fmt.Sprintf("interval.%s.%d", k, i), "%s", msg)
+				}
+			}
+		}
+	}
+	// Auto table construction
+	for _, k := range sortedKeys(cnf.ATC) {
+		for _, msg := range checkATC(cnf.ATC[k]) {
+			add(SectionATC, k, "%s", msg)
+		}
+	}
+	return issues
+}
+
+// ValidateConf to validate an osquery configuration using the tables of the environment manager
+// Returns a *ValidationError with all the issues found, or nil if the configuration is valid
+func (environment *Environment) ValidateConf(cnf OsqueryConf) error {
+	if issues := CheckConf(cnf, environment.Tables); len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// ValidatePart to validate one serialized part of the configuration of an environment
+// The part replaces the current one and is validated in the context of the whole configuration
+func (environment *Environment) ValidatePart(env TLSEnvironment, section string, data []byte) error {
+	cnf := OsqueryConf{}
+	var err error
+	parse := func(s string, current string) []byte {
+		if s == section {
+			return data
+		}
+		return []byte(current)
+	}
+	if cnf.Options, err = environment.GenStructOptions(parse(SectionOptions, env.Options)); err != nil {
+		return parseIssue(SectionOptions, err)
+	}
+	if cnf.Schedule, err = environment.GenStructSchedule(parse(SectionSchedule, env.Schedule)); err != nil {
+		return parseIssue(SectionSchedule, err)
+	}
+	if cnf.Packs, err = environment.GenStructPacks(parse(SectionPacks, env.Packs)); err != nil {
+		return parseIssue(SectionPacks, err)
+	}
+	if cnf.Decorators, err = environment.GenStructDecorators(parse(SectionDecorators, env.Decorators)); err != nil {
+		return parseIssue(SectionDecorators, err)
+	}
+	if cnf.ATC, err = environment.GenStructATC(parse(SectionATC, env.ATC)); err != nil {
+		return parseIssue(SectionATC, err)
+	}
+	// Only issues in the validated part are reported, so older issues do not block changes
+	var issues []ConfigIssue
+	for _, i := range CheckConf(cnf, environment.Tables) {
+		if i.Section == section {
+			issues = append(issues, i)
+		}
+	}
+	if len(issues) > 0 {
+		return &ValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// CheckQuery to run basic SQL checks on an osquery query, returning the problem found or empty
+func CheckQuery(query string, tables TableSet) string {
+	q := strings.TrimSpace(query)
+	if q == "" {
+		return "empty query"
+	}
+	tokens, msg := sqlTokens(q)
+	if msg != "" {
+		return msg
+	}
+	if tokens[0] != "select" && tokens[0] != "with" {
+		return "query must start with SELECT or WITH"
+	}
+	if len(tables) == 0 {
+		return ""
+	}
+	for _, t := range queryTables(tokens) {
+		if !tables[t] {
+			return fmt.Sprintf("unknown table %s", t)
+		}
+	}
+	return ""
+}
+
+// Helper to check one scheduled query, in the schedule or in a pack
+func checkScheduleQuery(q ScheduleQuery, tables TableSet) []string {
+	var msgs []string
+	if msg := CheckQuery(q.Query, tables); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	interval, err := q.Interval.Int64()
+	if q.Interval == "" || err != nil || interval <= 0 {
+		msgs = append(msgs, "interval must be a positive integer")
+	} else if interval > MaxQueryInterval {
+		msgs = append(msgs, fmt.Sprintf("interval must not exceed %d", MaxQueryInterval))
+	}
+	if msg := checkPlatform(q.Platform); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if msg := checkVersion(q.Version); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if msg := checkShard(q.Shard); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// Helper to check the name and the type of the value of an option
+func checkOption(name string, value interface{}) string {
+	if strings.HasPrefix(name, customOptionPrefix) {
+		return ""
+	}
+	kind, ok := OsqueryOptions[name]
+	if !ok {
+		return "unknown osquery option"
+	}
+	return checkValue(kind, value)
+}
+
+// Helper to check the value of an option or flag for its type
+func checkValue(kind string, value interface{}) string {
+	switch kind {
+	case OptionBool:
+		switch v := value.(type) {
+		case bool:
+			return ""
+		case string:
+			if _, err := strconv.ParseBool(v); err == nil {
+				return ""
+			}
+		}
+		return "value must be a boolean"
+	case OptionInt:
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				return ""
+			}
+		case string:
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return ""
+			}
+		}
+		return "value must be an integer"
+	case OptionString:
+		if _, ok := value.(string); ok {
+			return ""
+		}
+		return "value must be a string"
+	}
+	return ""
+}
+
+// Helper to check a comma separated list of platforms
+func checkPlatform(platform string) string {
+	if platform == "" {
+		return ""
+	}
+	for _, p := range strings.Split(platform, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if !ValidPlatforms[p] && !IsPlatformLinux(p) {
+			return fmt.Sprintf("invalid platform %s", p)
+		}
+	}
+	return ""
+}
+
+// Helper to check a minimum osquery version
+func checkVersion(version string) string {
+	if version == "" || versionRegexp.MatchString(version) {
+		return ""
+	}
+	return fmt.Sprintf("invalid version %s", version)
+}
+
+// Helper to check a shard percentage
+func checkShard(shard json.Number) string {
+	if shard == "" {
+		return ""
+	}
+	if n, err := shard.Int64(); err != nil || n < 1 || n > 100 {
+		return "shard must be an integer between 1 and 100"
+	}
+	return ""
+}
+
+// Helper to check one auto table construction definition
+func checkATC(value interface{}) []string {
+	def, ok := value.(map[string]interface{})
+	if !ok {
+		return []string{"definition must be an object"}
+	}
+	var msgs []string
+	if q, _ := def["query"].(string); q == "" {
+		msgs = append(msgs, "query is required")
+	} else if msg := CheckQuery(q, nil); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if p, _ := def["path"].(string); strings.TrimSpace(p) == "" {
+		msgs = append(msgs, "path is required")
+	}
+	columns, _ := def["columns"].([]interface{})
+	if len(columns) == 0 {
+		msgs = append(msgs, "columns are required")
+	}
+	for _, c := range columns {
+		if s, ok := c.(string); !ok || s == "" {
+			msgs = append(msgs, "columns must be non empty strings")
+			break
+		}
+	}
+	if p, ok := def["platform"]; ok {
+		s, _ := p.(string)
+		if msg := checkPlatform(s); msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Helper to generate a validation error for a part that can not be parsed
+func parseIssue(section string, err error) error {
+	return &ValidationError{Issues: []ConfigIssue{{Section: section, Message: fmt.Sprintf("invalid JSON: %v", err)}}}
+}
+
+// Helper to split a query in lowercase tokens, skipping string literals and comments
+// Returns a message if quotes or parentheses are not balanced
+func sqlTokens(query string) ([]string, string) {
+	var tokens []string
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return nil, "unbalanced quotes"
+			}
+			tokens = append(tokens, "'")
+			i += end + 1
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+		case isIdentChar(c):
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			tokens = append(tokens, strings.ToLower(query[i:j]))
+			i = j - 1
+		case c == '(':
+			depth++
+			tokens = append(tokens, "(")
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, "unbalanced parentheses"
+			}
+			tokens = append(tokens, ")")
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			tokens = append(tokens, string(c))
+		}
+	}
+	if depth != 0 {
+		return nil, "unbalanced parentheses"
+	}
+	if len(tokens) == 0 {
+		return nil, "empty query"
+	}
+	return tokens, ""
+}
+
+// Helper to extract the tables used in FROM and JOIN clauses, excluding CTEs and table functions
+func queryTables(tokens []string) []string {
+	ctes := make(map[string]bool)
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i+1] == "as" && tokens[i+2] == "(" {
+			ctes[tokens[i]] = true
+		}
+	}
+	var tables []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "from" && tokens[i] != "join" {
+			continue
+		}
+		for i+1 < len(tokens) {
+			i++
+			t := tokens[i]
+			if t == "(" || sqlKeywords[t] || !isIdentChar(t[0]) {
+				break
+			}
+			// Table valued functions like json_each(...)
+			if i+1 < len(tokens) && tokens[i+1] == "(" {
+				break
+			}
+			if !ctes[t] {
+				tables = append(tables, t)
+			}
+			// Skip an optional alias
+			if i+1 < len(tokens) && tokens[i+1] == "as" {
+				i++
+			}
+			if i+1 < len(tokens) && isIdentChar(tokens[i+1][0]) && !sqlKeywords[tokens[i+1]] {
+				i++
+			}
+			// Continue with comma separated tables
+			if i+1 < len(tokens) && tokens[i+1] == "," {
+				i++
+				continue
+			}
+			break
+		}
+	}
+	return tables
+}
+
+var sqlKeywords = map[string]bool{
+	"as": true, "cross": true, "except": true, "from": true, "group": true, "having": true,
+	"inner": true, "intersect": true, "join": true, "left": true, "limit": true, "natural": true,
+	"on": true, "order": true, "outer": true, "select": true, "union": true, "using": true,
+	"where": true, "window": true,
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckQuery(t *testing.T) {
+	tables := NewTableSet([]string{"users", "processes", "osquery_info", "system_info", "logged_in_users", "hash"})
+	t.Run("decorators", func(t *testing.T) {
+		for _, q := range []string{DecoratorUsers, DecoratorHostname, DecoratorLoggedInUser, DecoratorOsqueryVersionHash, DecoratorMD5Process} {
+			assert.Equal(t, "", CheckQuery(q, tables), q)
+		}
+	})
+	t.Run("cte and functions", func(t *testing.T) {
+		q := "WITH u AS (SELECT * FROM users) SELECT * FROM u, processes p JOIN json_each('[1]') WHERE p.name = 'from x';"
+		assert.Equal(t, "", CheckQuery(q, tables))
+	})
+	t.Run("unknown table", func(t *testing.T) {
+		assert.Equal(t, "unknown table userz", CheckQuery("SELECT * FROM userz;", tables))
+		assert.Equal(t, "", CheckQuery("SELECT * FROM userz;", nil))
+	})
+	t.Run("malformed", func(t *testing.T) {
+		assert.Equal(t, "empty query", CheckQuery(" ", tables))
+		assert.Equal(t, "unbalanced parentheses", CheckQuery("SELECT (1;", tables))
+		assert.Equal(t, "unbalanced quotes", CheckQuery("SELECT 'a;", tables))
+		assert.Equal(t, "query must start with SELECT or WITH", CheckQuery("DELETE FROM users;", tables))
+	})
+}
+
+func TestCheckConf(t *testing.T) {
+	var cnf OsqueryConf
+	raw := `{
+		"options": {"host_identifier": "uuid", "utc": true, "config_refresh": "60", "custom_x": 1, "bogus_flag": true, "verbose": 3},
+		"schedule": {
+			"ok": {"query": "SELECT * FROM users;", "interval": 60, "platform": "linux,darwin", "version": "5.1.0"},
+			"bad": {"query": "SELECT * FROM users;", "interval": 0, "platform": "macos", "version": "five", "shard": 200}
+		},
+		"packs": {
+			"local": "/etc/osquery/packs/local.conf",
+			"remote": {"platform": "windows", "queries": {"q": {"query": "SELECT * FROM atc_table;", "interval": 3600}}}
+		},
+		"decorators": {"interval": {"90": ["SELECT 1;"]}},
+		"auto_table_construction": {
+			"atc_table": {"query": "SELECT a FROM t;", "path": "/tmp/db", "columns": ["a"]},
+			"broken": {"query": "", "columns": []}
+		}
+	}`
+	assert.NoError(t, json.Unmarshal([]byte(raw), &cnf))
+	issues := CheckConf(cnf, NewTableSet([]string{"users"}))
+	assert.Equal(t, []ConfigIssue{
+		{Section: SectionOptions, Key: "bogus_flag", Message: "unknown osquery option"},
+		{Section: SectionOptions, Key: "verbose", Message: "value must be a boolean"},
+		{Section: SectionSchedule, Key: "bad", Message: "interval must be a positive integer"},
+		{Section: SectionSchedule, Key: "bad", Message: "invalid platform macos"},
+		{Section: SectionSchedule, Key: "bad", Message: "invalid version five"},
+		{Section: SectionSchedule, Key: "bad", Message: "shard must be an integer between 1 and 100"},
+		{Section: SectionDecorators, Key: "interval.90", Message: "interval must be a positive multiple of 60"},
+		{Section: SectionATC, Key: "broken", Message: "query is required"},
+		{Section: SectionATC, Key: "broken", Message: "path is required"},
+		{Section: SectionATC, Key: "broken", Message: "columns are required"},
+	}, issues)
+	err := &ValidationError{Issues: issues[:1]}
+	assert.Equal(t, "invalid configuration: options.bogus_flag: unknown osquery option", err.Error())
+}
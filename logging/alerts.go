@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultAlertsLimit as default number of alerts to retrieve
+	DefaultAlertsLimit = 100
+)
+
+// DetectionAlert to store alerts raised by detection rules
+type DetectionAlert struct {
+	gorm.Model
+	Rule        string `gorm:"index"`
+	Description string
+	Severity    string
+	Environment string `gorm:"index"`
+	UUID        string `gorm:"index"`
+	Query       string
+	Action      string
+	Data        string
+	DedupKey    string
+}
+
+// AlertManager to handle alerts raised by detection rules
+type AlertManager struct {
+	DB *gorm.DB
+}
+
+// CreateAlerts to initialize the alerts struct and tables
+func CreateAlerts(backend *gorm.DB) *AlertManager {
+	a := &AlertManager{DB: backend}
+	// table detection_alerts
+	if err := backend.AutoMigrate(&DetectionAlert{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (detection_alerts): %v", err)
+	}
+	return a
+}
+
+// Create to store a new alert
+func (a *AlertManager) Create(alert *DetectionAlert) error {
+	if err := a.DB.Create(alert).Error; err != nil {
+		return fmt.Errorf("error creating alert %v", err)
+	}
+	return nil
+}
+
+// GetByEnv to retrieve the latest alerts of an environment
+func (a *AlertManager) GetByEnv(environment string, limit int) ([]DetectionAlert, error) {
+	var alerts []DetectionAlert
+	if err := a.DB.Where("environment = ?", environment).Order("created_at desc").Limit(limit).Find(&alerts).Error; err != nil {
+		return alerts, err
+	}
+	return alerts, nil
+}
+
+// GetByNode to retrieve the latest alerts of a node in an environment
+func (a *AlertManager) GetByNode(environment, uuid string, limit int) ([]DetectionAlert, error) {
+	var alerts []DetectionAlert
+	if err := a.DB.Where("environment = ? AND uuid = ?", environment, uuid).Order("created_at desc").Limit(limit).Find(&alerts).Error; err != nil {
+		return alerts, err
+	}
+	return alerts, nil
+}
+
+// GetByRule to retrieve the latest alerts raised by a rule in an environment
+func (a *AlertManager) GetByRule(environment, rule string, limit int) ([]DetectionAlert, error) {
+	var alerts []DetectionAlert
+	if err := a.DB.Where("environment = ? AND rule = ?", environment, rule).Order("created_at desc").Limit(limit).Find(&alerts).Error; err != nil {
+		return alerts, err
+	}
+	return alerts, nil
+}
@@ -0,0 +1,167 @@
+package logging
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultBatchSize as default maximum number of events in a batch
+	DefaultBatchSize = 500
+	// DefaultBatchBytes as default maximum size in bytes of a batch
+	DefaultBatchBytes = 8 * 1024 * 1024
+	// DefaultBatchInterval as default time between flushes of a batch
+	DefaultBatchInterval = 5 * time.Second
+	// DefaultBatchRetained as default number of full batches kept to be written again when flushes fail
+	DefaultBatchRetained = 10
+)
+
+// ErrBatchDropped when items that failed to be written were dropped because too many were waiting
+var ErrBatchDropped = errors.New("batched items dropped")
+
+// BatchConfiguration to hold the limits for batched writes of loggers, whatever is reached first flushes the batch
+type BatchConfiguration struct {
+	Size     int
+	Bytes    int
+	Interval time.Duration
+}
+
+// DefaultBatchConfiguration to get the default limits for batched writes
+func DefaultBatchConfiguration() BatchConfiguration {
+	return BatchConfiguration{
+		Size:     DefaultBatchSize,
+		Bytes:    DefaultBatchBytes,
+		Interval: DefaultBatchInterval,
+	}
+}
+
+// Batcher to group items and flush them together when the batch is full or after an interval
+// Items of a failed flush are written again with the next one, so only the logger that failed retries them
+// Items added after the batcher is closed are flushed right away
+type Batcher[T any] struct {
+	Config BatchConfiguration
+	flush  func([]T) error
+	// flushing is held while a batch is taken and written, so Sync waits for batches taken before it
+	flushing sync.Mutex
+	mutex    sync.Mutex
+	items    []T
+	bytes    int
+	err      error
+	failing  bool
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewBatcher to initialize a batcher and start flushing on interval, missing limits use the defaults
+func NewBatcher[T any](config BatchConfiguration, flush func([]T) error) *Batcher[T] {
+	if config.Size <= 0 {
+		config.Size = DefaultBatchSize
+	}
+	if config.Bytes <= 0 {
+		config.Bytes = DefaultBatchBytes
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultBatchInterval
+	}
+	b := &Batcher[T]{
+		Config: config,
+		flush:  flush,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *Batcher[T]) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.Config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			b.Flush()
+			return
+		}
+	}
+}
+
+// Add to append items to the batch, flushing it when it reaches its limits
+// While flushes are failing, the batch is only written again on interval
+func (b *Batcher[T]) Add(size int, items ...T) {
+	b.mutex.Lock()
+	b.items = append(b.items, items...)
+	b.bytes += size
+	if !b.closed && (b.failing || (len(b.items) < b.Config.Size && b.bytes < b.Config.Bytes)) {
+		b.mutex.Unlock()
+		return
+	}
+	b.mutex.Unlock()
+	b.Flush()
+}
+
+// Flush to write all items in the batch, items are kept to be written again if it fails
+func (b *Batcher[T]) Flush() error {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+	b.mutex.Lock()
+	batch, size := b.take()
+	b.mutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := b.flush(batch)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failing = err != nil
+	if err == nil {
+		return nil
+	}
+	if len(batch) > b.Config.Size*DefaultBatchRetained {
+		log.Error().Msgf("dropping %d batched items after failing to write them", len(batch))
+		b.err = ErrBatchDropped
+		return err
+	}
+	b.items = append(batch, b.items...)
+	b.bytes += size
+	return err
+}
+
+// Sync to write all items in the batch, returning an error if they could not be written
+// or if items were dropped since the last sync
+func (b *Batcher[T]) Sync() error {
+	err := b.Flush()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err != nil {
+		err = b.err
+		b.err = nil
+	}
+	return err
+}
+
+// take to empty the batch, mutex must be held
+func (b *Batcher[T]) take() ([]T, int) {
+	batch, size := b.items, b.bytes
+	b.items = nil
+	b.bytes = 0
+	return batch, size
+}
+
+// Close to stop flushing on interval and write all pending items
+func (b *Batcher[T]) Close() {
+	b.once.Do(func() {
+		b.mutex.Lock()
+		b.closed = true
+		b.mutex.Unlock()
+		close(b.stop)
+	})
+	<-b.done
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	var mutex sync.Mutex
+	var batches [][]int
+	b := NewBatcher(BatchConfiguration{Size: 3, Interval: time.Hour}, func(items []int) error {
+		mutex.Lock()
+		batches = append(batches, items)
+		mutex.Unlock()
+		return nil
+	})
+	b.Add(1, 1, 2)
+	assert.Empty(t, batches)
+	b.Add(1, 3, 4)
+	assert.Equal(t, [][]int{{1, 2, 3, 4}}, batches)
+	b.Add(1, 5)
+	b.Close()
+	assert.Equal(t, [][]int{{1, 2, 3, 4}, {5}}, batches)
+	// Items added after closing are not lost
+	b.Add(1, 6)
+	assert.Equal(t, [][]int{{1, 2, 3, 4}, {5}, {6}}, batches)
+}
+
+func TestBatcherBytes(t *testing.T) {
+	flushed := 0
+	b := NewBatcher(BatchConfiguration{Bytes: 10, Interval: time.Hour}, func(items []string) error {
+		flushed += len(items)
+		return nil
+	})
+	b.Add(6, "a")
+	b.Add(6, "b")
+	assert.Equal(t, 2, flushed)
+	b.Close()
+	assert.Equal(t, 2, flushed)
+}
+
+func TestBatcherSync(t *testing.T) {
+	fail := true
+	var written []int
+	b := NewBatcher(BatchConfiguration{Size: 2, Interval: time.Hour}, func(items []int) error {
+		if fail {
+			return errors.New("unavailable")
+		}
+		written = append(written, items...)
+		return nil
+	})
+	defer b.Close()
+	// Items of batches that failed when full are written again by the next sync
+	b.Add(1, 1, 2)
+	assert.EqualError(t, b.Sync(), "unavailable")
+	fail = false
+	b.Add(1, 3)
+	assert.NoError(t, b.Sync())
+	assert.Equal(t, []int{1, 2, 3}, written)
+}
+
+func TestBatcherDropped(t *testing.T) {
+	b := NewBatcher(BatchConfiguration{Size: 1, Interval: time.Hour}, func(items []int) error {
+		return errors.New("unavailable")
+	})
+	defer b.Close()
+	for i := 0; i <= DefaultBatchRetained; i++ {
+		b.Add(1, i)
+		b.Flush()
+	}
+	assert.ErrorIs(t, b.Sync(), ErrBatchDropped)
+}
@@ -0,0 +1,302 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition to evaluate one row of results, as parsed from expressions like
+// name == "pack_incident_shell_history" AND columns.command contains "curl"
+type Condition interface {
+	Eval(row map[string]interface{}) bool
+}
+
+// Operators supported in conditions, words are case insensitive
+const (
+	OpEqual      = "=="
+	OpNotEqual   = "!="
+	OpGreater    = ">"
+	OpGreaterEq  = ">="
+	OpLess       = "<"
+	OpLessEq     = "<="
+	OpContains   = "contains"
+	OpMatches    = "matches"
+	OpStartsWith = "startswith"
+	OpEndsWith   = "endswith"
+	OpExists     = "exists"
+)
+
+var conditionOperators = map[string]bool{
+	OpEqual:      true,
+	OpNotEqual:   true,
+	OpGreater:    true,
+	OpGreaterEq:  true,
+	OpLess:       true,
+	OpLessEq:     true,
+	OpContains:   true,
+	OpMatches:    true,
+	OpStartsWith: true,
+	OpEndsWith:   true,
+	OpExists:     true,
+}
+
+type andCondition struct{ left, right Condition }
+
+func (c andCondition) Eval(row map[string]interface{}) bool {
+	return c.left.Eval(row) && c.right.Eval(row)
+}
+
+type orCondition struct{ left, right Condition }
+
+func (c orCondition) Eval(row map[string]interface{}) bool {
+	return c.left.Eval(row) || c.right.Eval(row)
+}
+
+type notCondition struct{ cond Condition }
+
+func (c notCondition) Eval(row map[string]interface{}) bool {
+	return !c.cond.Eval(row)
+}
+
+type compareCondition struct {
+	field string
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func (c compareCondition) Eval(row map[string]interface{}) bool {
+	v, ok := FieldValue(row, c.field)
+	if c.op == OpExists {
+		return ok
+	}
+	if !ok {
+		return c.op == OpNotEqual
+	}
+	switch c.op {
+	case OpEqual:
+		return v == c.value
+	case OpNotEqual:
+		return v != c.value
+	case OpContains:
+		return strings.Contains(v, c.value)
+	case OpStartsWith:
+		return strings.HasPrefix(v, c.value)
+	case OpEndsWith:
+		return strings.HasSuffix(v, c.value)
+	case OpMatches:
+		return c.regex.MatchString(v)
+	}
+	left, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	right, err := strconv.ParseFloat(c.value, 64)
+	if err != nil {
+		return false
+	}
+	switch c.op {
+	case OpGreater:
+		return left > right
+	case OpGreaterEq:
+		return left >= right
+	case OpLess:
+		return left < right
+	case OpLessEq:
+		return left <= right
+	}
+	return false
+}
+
+// FieldValue to get the value of a field of a row as text, nested fields are separated by dots
+func FieldValue(row map[string]interface{}, field string) (string, bool) {
+	var current interface{} = row
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if current, ok = m[part]; !ok {
+			return "", false
+		}
+	}
+	if current == nil {
+		return "", true
+	}
+	return fmt.Sprint(current), true
+}
+
+type conditionToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeCondition to split a condition in fields, operators, quoted values and parentheses
+func tokenizeCondition(s string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, conditionToken{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d %v", i, err)
+			}
+			tokens = append(tokens, conditionToken{text: value, quoted: true})
+			i = j + 1
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, conditionToken{text: s[i:j]})
+			i = j
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()\"=!<>", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, conditionToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+// ParseCondition to parse a condition with comparisons joined by AND, OR, NOT and parentheses
+func ParseCondition(s string) (Condition, error) {
+	tokens, err := tokenizeCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	p := &conditionParser{tokens: tokens}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	return cond, nil
+}
+
+func (p *conditionParser) peekWord(word string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+func (p *conditionParser) parseOr() (Condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (Condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (Condition, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	if p.peekWord("NOT") {
+		p.pos++
+		cond, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{cond: cond}, nil
+	}
+	if p.peekWord("(") {
+		p.pos++
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekWord(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return cond, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (Condition, error) {
+	field := p.tokens[p.pos]
+	if field.quoted || field.text == ")" {
+		return nil, fmt.Errorf("expected field instead of %s", field.text)
+	}
+	p.pos++
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing operator after %s", field.text)
+	}
+	op := strings.ToLower(p.tokens[p.pos].text)
+	if p.tokens[p.pos].quoted || !conditionOperators[op] {
+		return nil, fmt.Errorf("invalid operator %s after %s", p.tokens[p.pos].text, field.text)
+	}
+	p.pos++
+	cond := compareCondition{field: field.text, op: op}
+	if op == OpExists {
+		return cond, nil
+	}
+	if p.pos >= len(p.tokens) || (!p.tokens[p.pos].quoted && (p.tokens[p.pos].text == "(" || p.tokens[p.pos].text == ")")) {
+		return nil, fmt.Errorf("missing value after %s %s", field.text, op)
+	}
+	cond.value = p.tokens[p.pos].text
+	p.pos++
+	if op == OpMatches {
+		regex, err := regexp.Compile(cond.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s %v", cond.value, err)
+		}
+		cond.regex = regex
+	}
+	return cond, nil
+}
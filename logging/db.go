@@ -2,6 +2,7 @@ package logging
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -19,9 +20,16 @@ import (
 const (
 	// Default interval in seconds for cleanup old logs
 	defaultCleanupInterval = 86400
+	// Default number of rows for each insert of batched logs
+	defaultInsertBatch = 100
+	// DefaultResultsLimit as default number of result logs to retrieve with filters
+	DefaultResultsLimit = 100
+	// MaxResultsLimit as maximum number of result logs to retrieve with filters
+	MaxResultsLimit = 1000
 )
 
 // OsqueryResultData to log result data to database
+// Stored in daily partitions with columns as JSONB to filter by values of columns
 type OsqueryResultData struct {
 	gorm.Model
 	UUID        string `gorm:"index"`
@@ -29,10 +37,20 @@ type OsqueryResultData struct {
 	Name        string
 	Action      string
 	Epoch       int64
-	Columns     string
+	Columns     string `gorm:"type:jsonb"`
 	Counter     int
 }
 
+// ResultFilter to filter result logs by node, query, action and values of columns
+type ResultFilter struct {
+	UUID    string
+	Name    string
+	Action  string
+	Columns map[string]string
+	Since   time.Time
+	Limit   int
+}
+
 // OsqueryStatusData to log status data to database
 type OsqueryStatusData struct {
 	gorm.Model
@@ -57,8 +75,11 @@ type OsqueryQueryData struct {
 
 // LoggerDB will be used to log data using a database
 type LoggerDB struct {
-	Database *backend.DBManager
-	Enabled  bool
+	Database       *backend.DBManager
+	Enabled        bool
+	statusBatch    *Batcher[OsqueryStatusData]
+	resultBatch    *Batcher[OsqueryResultData]
+	partitionsDone chan struct{}
 }
 
 // CreateLoggerDB to initialize the logger
@@ -91,9 +112,17 @@ func CreateLoggerDB(backend *backend.DBManager) (*LoggerDB, error) {
 	if err := backend.Conn.AutoMigrate(&OsqueryStatusData{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (osquery_status_data): %v", err)
 	}
-	// table osquery_result_data
-	if err := backend.Conn.AutoMigrate(&OsqueryResultData{}); err != nil {
-		log.Fatal().Msgf("Failed to AutoMigrate table (osquery_result_data): %v", err)
+	// table osquery_result_data, partitioned by day
+	legacy, err := migrateResults(backend.Conn, time.Now())
+	if err != nil {
+		log.Fatal().Msgf("Failed to migrate table (osquery_result_data): %v", err)
+	}
+	if legacy {
+		go func() {
+			if err := copyLegacyResults(backend.Conn); err != nil {
+				log.Err(err).Msg("error copying result logs to daily partitions")
+			}
+		}()
 	}
 	// table osquery_query_data
 	if err := backend.Conn.AutoMigrate(&OsqueryQueryData{}); err != nil {
@@ -107,6 +136,38 @@ func (logDB *LoggerDB) Settings(mgr *settings.Settings) {
 	log.Info().Msg("Setting DB logging settings")
 }
 
+// StartBatching to group status and result logs before inserting them in the DB
+func (logDB *LoggerDB) StartBatching(config BatchConfiguration) {
+	logDB.statusBatch = NewBatcher(config, logDB.insertStatus)
+	logDB.resultBatch = NewBatcher(config, logDB.insertResult)
+}
+
+// Sync to insert all batched logs, returning an error if they could not be inserted
+func (logDB *LoggerDB) Sync() error {
+	var errs []error
+	if logDB.statusBatch != nil {
+		errs = append(errs, logDB.statusBatch.Sync())
+	}
+	if logDB.resultBatch != nil {
+		errs = append(errs, logDB.resultBatch.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close to insert all batched logs
+func (logDB *LoggerDB) Close() {
+	if logDB.statusBatch != nil {
+		logDB.statusBatch.Close()
+	}
+	if logDB.resultBatch != nil {
+		logDB.resultBatch.Close()
+	}
+	if logDB.partitionsDone != nil {
+		close(logDB.partitionsDone)
+		logDB.partitionsDone = nil
+	}
+}
+
 // Log - Function that sends JSON result/status/query logs to the configured DB
 func (logDB *LoggerDB) Log(logType string, data []byte, environment, uuid string, debug bool) {
 	if debug {
@@ -127,9 +188,9 @@ func (logDB *LoggerDB) Status(data []byte, environment, uuid string, debug bool)
 	if err := json.Unmarshal(data, &logs); err != nil {
 		log.Err(err).Msgf("error parsing logs %s %v", string(data), err)
 	}
-	// Iterate and insert in DB
+	entries := make([]OsqueryStatusData, 0, len(logs))
 	for _, l := range logs {
-		entry := OsqueryStatusData{
+		entries = append(entries, OsqueryStatusData{
 			UUID:        strings.ToUpper(l.HostIdentifier),
 			Environment: environment,
 			Line:        strconv.Itoa(int(l.Line)),
@@ -137,11 +198,25 @@ func (logDB *LoggerDB) Status(data []byte, environment, uuid string, debug bool)
 			Version:     l.Version,
 			Filename:    l.Filename,
 			Severity:    strconv.Itoa(int(l.Severity)),
-		}
-		if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
-			log.Err(err).Msg("Error creating status log entry")
-		}
+		})
+	}
+	if logDB.statusBatch != nil {
+		logDB.statusBatch.Add(len(data), entries...)
+		return
 	}
+	logDB.insertStatus(entries)
+}
+
+// insertStatus to insert status logs in the DB in batches
+func (logDB *LoggerDB) insertStatus(entries []OsqueryStatusData) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := logDB.Database.Conn.CreateInBatches(entries, defaultInsertBatch).Error; err != nil {
+		log.Err(err).Msgf("Error creating %d status log entries", len(entries))
+		return err
+	}
+	return nil
 }
 
 // Result - Function that sends JSON result logs to the configured DB
@@ -151,21 +226,55 @@ func (logDB *LoggerDB) Result(data []byte, environment, uuid string, debug bool)
 	if err := json.Unmarshal(data, &logs); err != nil {
 		log.Err(err).Msgf("error parsing logs %s", string(data))
 	}
-	// Iterate and insert in DB
-	for _, l := range logs {
-		entry := OsqueryResultData{
+	entries := ResultEntries(logs, environment)
+	if logDB.resultBatch != nil {
+		logDB.resultBatch.Add(len(data), entries...)
+		return
+	}
+	logDB.insertResult(entries)
+}
+
+// ResultEntries to prepare result logs to be stored, with one entry for each row of snapshot logs
+func ResultEntries(logs []types.LogResultData, environment string) []OsqueryResultData {
+	entries := make([]OsqueryResultData, 0, len(logs))
+	entry := func(l types.LogResultData, action string, columns json.RawMessage) OsqueryResultData {
+		// Columns are JSONB and can not be empty
+		if len(columns) == 0 || string(columns) == "null" {
+			columns = json.RawMessage("{}")
+		}
+		return OsqueryResultData{
 			UUID:        strings.ToUpper(l.HostIdentifier),
 			Environment: environment,
 			Name:        l.Name,
-			Action:      l.Action,
+			Action:      action,
 			Epoch:       l.Epoch,
-			Columns:     string(l.Columns),
+			Columns:     string(columns),
 			Counter:     l.Counter,
 		}
-		if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
-			log.Err(err).Msg("Error creating result log entry")
+	}
+	for _, l := range logs {
+		var snapshot []json.RawMessage
+		if len(l.Columns) == 0 && len(l.Snapshot) > 0 && json.Unmarshal(l.Snapshot, &snapshot) == nil {
+			for _, row := range snapshot {
+				entries = append(entries, entry(l, "snapshot", row))
+			}
+			continue
 		}
+		entries = append(entries, entry(l, l.Action, l.Columns))
 	}
+	return entries
+}
+
+// insertResult to insert result logs in the DB in batches
+func (logDB *LoggerDB) insertResult(entries []OsqueryResultData) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := logDB.Database.Conn.CreateInBatches(entries, defaultInsertBatch).Error; err != nil {
+		log.Err(err).Msgf("Error creating %d result log entries", len(entries))
+		return err
+	}
+	return nil
 }
 
 // Query - Function that sends JSON query logs to the configured DB
@@ -240,13 +349,41 @@ func (logDB *LoggerDB) CleanStatusLogs(environment string, seconds int64) error
 	return nil
 }
 
-// CleanResultLogs will delete old status logs
-func (logDB *LoggerDB) CleanResultLogs(environment string, seconds int64) error {
-	minusSeconds := time.Now().Add(time.Duration(-seconds) * time.Second)
-	if err := logDB.Database.Conn.Unscoped().Where("environment = ?", environment).Where("created_at < ?", minusSeconds).Delete(&OsqueryResultData{}).Error; err != nil {
-		return fmt.Errorf("CleanResultLogs %v", err)
+// FilterResultLogs will retrieve the latest result logs of an environment matching a filter
+// Values of columns are matched with the GIN index of columns
+func (logDB *LoggerDB) FilterResultLogs(environment string, filter ResultFilter) ([]OsqueryResultData, error) {
+	var logs []OsqueryResultData
+	query := logDB.Database.Conn.Where("environment = ?", environment)
+	if filter.UUID != "" {
+		query = query.Where("uuid = ?", strings.ToUpper(filter.UUID))
 	}
-	return nil
+	if filter.Name != "" {
+		query = query.Where("name = ?", filter.Name)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if len(filter.Columns) > 0 {
+		columns, err := json.Marshal(filter.Columns)
+		if err != nil {
+			return logs, fmt.Errorf("error serializing columns %v", err)
+		}
+		query = query.Where("columns @> ?::jsonb", string(columns))
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultResultsLimit
+	}
+	if limit > MaxResultsLimit {
+		limit = MaxResultsLimit
+	}
+	if err := query.Order("created_at desc").Limit(limit).Find(&logs).Error; err != nil {
+		return logs, err
+	}
+	return logs, nil
 }
 
 // CleanQueryLogs will delete old query logs
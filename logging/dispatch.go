@@ -19,7 +19,7 @@ func (l *LoggerTLS) DispatchLogs(data []byte, uuid, logType, environment string,
 	if debug {
 		log.Debug().Msgf("dispatching logs to %s", l.Logging)
 	}
-	l.Log(logType, data, environment, uuid, debug)
+	l.Log(logType, l.enrich(logType, data, uuid, nil), environment, uuid, debug)
 	// Refresh last logging request
 	if logType == types.StatusLog {
 		// Update metadata for node
@@ -53,7 +53,7 @@ func (l *LoggerTLS) DispatchQueries(queryData types.QueryWriteData, node nodes.O
 	}
 	l.QueryLog(
 		types.QueryLog,
-		data,
+		l.enrich(types.QueryLog, data, node.UUID, &node),
 		node.Environment,
 		node.UUID,
 		queryData.Name,
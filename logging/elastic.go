@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -29,6 +30,19 @@ type LoggerElastic struct {
 	Configuration ElasticConfiguration
 	Enabled       bool
 	Client        *elasticsearch.Client
+	batch         *Batcher[[]byte]
+}
+
+// elasticBulkResponse to parse the errors in responses from the bulk API
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
 }
 
 // CreateLoggerElastic to initialize the logger
@@ -92,6 +106,71 @@ func (logE *LoggerElastic) Settings(mgr *settings.Settings) {
 	log.Info().Msg("Setting Elastic logging settings")
 }
 
+// StartBatching to group events before sending them with the bulk API
+func (logE *LoggerElastic) StartBatching(config BatchConfiguration) {
+	logE.batch = NewBatcher(config, logE.bulk)
+}
+
+// Sync to send all batched events, returning an error if they could not be indexed
+func (logE *LoggerElastic) Sync() error {
+	if logE.batch == nil {
+		return nil
+	}
+	return logE.batch.Sync()
+}
+
+// Close to send all batched events
+func (logE *LoggerElastic) Close() {
+	if logE.batch != nil {
+		logE.batch.Close()
+	}
+}
+
+// bulk to index events with one request to the bulk API, each event is already formatted as action and document
+func (logE *LoggerElastic) bulk(events [][]byte) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	for _, e := range events {
+		body.Write(e)
+	}
+	req := esapi.BulkRequest{
+		Body: &body,
+	}
+	res, err := req.Do(context.Background(), logE.Client)
+	if err != nil {
+		log.Err(err).Msgf("Error indexing %d documents", len(events))
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		log.Error().Msgf("Error response from Elasticsearch: %s", res.String())
+		return fmt.Errorf("error response from Elasticsearch %s", res.Status())
+	}
+	var bulkRes elasticBulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&bulkRes); err != nil {
+		log.Err(err).Msg("Error parsing response from Elasticsearch")
+		return err
+	}
+	if !bulkRes.Errors {
+		return nil
+	}
+	failed := 0
+	for _, item := range bulkRes.Items {
+		for _, r := range item {
+			if r.Status > 299 {
+				failed++
+				if failed == 1 {
+					log.Error().Msgf("Error indexing document: %s %s", r.Error.Type, r.Error.Reason)
+				}
+			}
+		}
+	}
+	log.Error().Msgf("Failed to index %d of %d documents", failed, len(events))
+	return fmt.Errorf("failed to index %d of %d documents", failed, len(events))
+}
+
 // Send - Function that sends JSON logs to Elastic
 func (logE *LoggerElastic) Send(logType string, data []byte, environment, uuid string, debug bool) {
 	if debug {
@@ -114,25 +193,27 @@ func (logE *LoggerElastic) Send(logType string, data []byte, environment, uuid s
 			log.Err(err).Msgf("error parsing log %s", string(data))
 		}
 	}
+	action := fmt.Sprintf(`{"index":{"_index":%q}}`, logE.IndexName())
+	events := make([][]byte, 0, len(logs))
+	size := 0
 	for _, l := range logs {
 		jsonEvent, err := json.Marshal(l)
 		if err != nil {
 			log.Err(err).Msg("Error parsing data")
 			continue
 		}
-		req := esapi.IndexRequest{
-			Index:   logE.IndexName(),
-			Body:    strings.NewReader(string(jsonEvent)),
-			Refresh: "true",
-		}
-		res, err := req.Do(context.Background(), logE.Client)
-		if err != nil {
-			log.Err(err).Msg("Error indexing document")
-		}
-		defer res.Body.Close()
-		if res.IsError() {
-			log.Error().Msgf("Error response from Elasticsearch: %s", res.String())
-		}
+		event := make([]byte, 0, len(action)+len(jsonEvent)+2)
+		event = append(event, action...)
+		event = append(event, '\n')
+		event = append(event, jsonEvent...)
+		event = append(event, '\n')
+		events = append(events, event)
+		size += len(event)
+	}
+	if logE.batch != nil {
+		logE.batch.Add(size, events...)
+	} else {
+		logE.bulk(events)
 	}
 	if debug {
 		log.Debug().Msgf("DebugService: Sent %d bytes of %s to Elastic from %s:%s", len(data), logType, uuid, environment)
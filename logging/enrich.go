@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultEnrichKey as default key for osctrl fields added to every event
+	DefaultEnrichKey = "osctrl"
+	// DefaultEnrichCache as default time to keep the osctrl fields of a node
+	DefaultEnrichCache = 5 * time.Minute
+)
+
+// NodeTagsFunc to retrieve the names of the tags of a node
+type NodeTagsFunc func(node nodes.OsqueryNode) ([]string, error)
+
+// EnvironmentUUIDFunc to retrieve the UUID of an environment by name
+type EnvironmentUUIDFunc func(name string) (string, error)
+
+// LogEnrichment to hold the osctrl fields added to every event sent to loggers
+type LogEnrichment struct {
+	Environment     string   `json:"environment"`
+	EnvironmentUUID string   `json:"environment_uuid"`
+	NodeID          uint     `json:"node_id"`
+	Platform        string   `json:"platform"`
+	OsqueryVersion  string   `json:"osquery_version"`
+	Tags            []string `json:"tags"`
+}
+
+type cachedEnrichment struct {
+	fields  LogEnrichment
+	expires time.Time
+}
+
+// Enricher to add osctrl fields of nodes to events, keeping them in memory to avoid DB lookups per event
+type Enricher struct {
+	Key     string
+	TTL     time.Duration
+	Nodes   *nodes.NodeManager
+	Tags    NodeTagsFunc
+	EnvUUID EnvironmentUUIDFunc
+	mutex   sync.Mutex
+	cache   map[string]cachedEnrichment
+}
+
+// NewEnricher to initialize the enrichment of events, missing key and TTL use the defaults
+func NewEnricher(key string, ttl time.Duration, nodesmgr *nodes.NodeManager, tags NodeTagsFunc, envUUID EnvironmentUUIDFunc) *Enricher {
+	if key == "" {
+		key = DefaultEnrichKey
+	}
+	if ttl <= 0 {
+		ttl = DefaultEnrichCache
+	}
+	return &Enricher{
+		Key:     key,
+		TTL:     ttl,
+		Nodes:   nodesmgr,
+		Tags:    tags,
+		EnvUUID: envUUID,
+		cache:   make(map[string]cachedEnrichment),
+	}
+}
+
+// EnableEnrichment to add osctrl fields to every event before sending it to loggers
+func (l *LoggerTLS) EnableEnrichment(key string, ttl time.Duration, tags NodeTagsFunc, envUUID EnvironmentUUIDFunc) {
+	l.Enricher = NewEnricher(key, ttl, l.Nodes, tags, envUUID)
+}
+
+// Fields to get the osctrl fields of a node by UUID
+func (e *Enricher) Fields(uuid string) (LogEnrichment, error) {
+	uuid = strings.ToUpper(uuid)
+	e.mutex.Lock()
+	cached, ok := e.cache[uuid]
+	e.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.fields, nil
+	}
+	node, err := e.Nodes.GetByUUID(uuid)
+	if err != nil {
+		return LogEnrichment{}, fmt.Errorf("error getting node %v", err)
+	}
+	return e.NodeFields(node), nil
+}
+
+// NodeFields to get the osctrl fields of a node
+func (e *Enricher) NodeFields(node nodes.OsqueryNode) LogEnrichment {
+	uuid := strings.ToUpper(node.UUID)
+	e.mutex.Lock()
+	cached, ok := e.cache[uuid]
+	e.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.fields
+	}
+	fields := LogEnrichment{
+		Environment:    node.Environment,
+		NodeID:         node.ID,
+		Platform:       node.Platform,
+		OsqueryVersion: node.OsqueryVersion,
+		Tags:           []string{},
+	}
+	if e.EnvUUID != nil {
+		envUUID, err := e.EnvUUID(node.Environment)
+		if err != nil {
+			log.Err(err).Msgf("error getting environment %s", node.Environment)
+		}
+		fields.EnvironmentUUID = envUUID
+	}
+	if e.Tags != nil {
+		tags, err := e.Tags(node)
+		if err != nil {
+			log.Err(err).Msgf("error getting tags for %s", node.UUID)
+		}
+		if tags != nil {
+			fields.Tags = tags
+		}
+	}
+	e.mutex.Lock()
+	e.cache[uuid] = cachedEnrichment{fields: fields, expires: time.Now().Add(e.TTL)}
+	e.mutex.Unlock()
+	return fields
+}
+
+// EnrichLogs to add osctrl fields to each event of status or result logs
+func (e *Enricher) EnrichLogs(data []byte, fields LogEnrichment) ([]byte, error) {
+	var events []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return data, fmt.Errorf("error parsing logs %v", err)
+	}
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return data, fmt.Errorf("error serializing fields %v", err)
+	}
+	for _, event := range events {
+		event[e.Key] = value
+	}
+	return json.Marshal(events)
+}
+
+// EnrichQuery to add osctrl fields to on-demand query results
+func (e *Enricher) EnrichQuery(data []byte, fields LogEnrichment) ([]byte, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		return data, fmt.Errorf("error parsing query %v", err)
+	}
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return data, fmt.Errorf("error serializing fields %v", err)
+	}
+	event[e.Key] = value
+	return json.Marshal(event)
+}
+
+// enrich to add osctrl fields to logs of a node, returning the original logs if it fails
+func (l *LoggerTLS) enrich(logType string, data []byte, uuid string, node *nodes.OsqueryNode) []byte {
+	if l.Enricher == nil {
+		return data
+	}
+	var fields LogEnrichment
+	if node != nil {
+		fields = l.Enricher.NodeFields(*node)
+	} else {
+		var err error
+		if fields, err = l.Enricher.Fields(uuid); err != nil {
+			log.Err(err).Msg("error enriching logs")
+			return data
+		}
+	}
+	var enriched []byte
+	var err error
+	if logType == types.QueryLog {
+		enriched, err = l.Enricher.EnrichQuery(data, fields)
+	} else {
+		enriched, err = l.Enricher.EnrichLogs(data, fields)
+	}
+	if err != nil {
+		log.Err(err).Msg("error enriching logs")
+		return data
+	}
+	return enriched
+}
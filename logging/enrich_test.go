@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichLogs(t *testing.T) {
+	calls := 0
+	e := NewEnricher("", time.Minute, nil, func(node nodes.OsqueryNode) ([]string, error) {
+		calls++
+		return []string{"prod", "web"}, nil
+	}, func(name string) (string, error) {
+		return "env-uuid", nil
+	})
+	node := nodes.OsqueryNode{UUID: "abc", Environment: "prod", Platform: "ubuntu", OsqueryVersion: "5.12.1"}
+	node.ID = 7
+	fields := e.NodeFields(node)
+	e.NodeFields(node)
+	assert.Equal(t, 1, calls)
+	enriched, err := e.EnrichLogs([]byte(`[{"name":"q1"},{"name":"q2"}]`), fields)
+	assert.NoError(t, err)
+	expected := `{"environment":"prod","environment_uuid":"env-uuid","node_id":7,"platform":"ubuntu","osquery_version":"5.12.1","tags":["prod","web"]}`
+	assert.JSONEq(t, `[{"name":"q1","osctrl":`+expected+`},{"name":"q2","osctrl":`+expected+`}]`, string(enriched))
+	enriched, err = e.EnrichQuery([]byte(`{"name":"q3","status":0}`), fields)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"q3","status":0,"osctrl":`+expected+`}`, string(enriched))
+	_, err = e.EnrichLogs([]byte(`{}`), fields)
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -82,6 +83,24 @@ func CreateLoggerKafka(config types.KafkaConfiguration) (*LoggerKafka, error) {
 	}, nil
 }
 
+// LoadKafka - Function to load the Kafka configuration from JSON file
+func LoadKafka(file string) (types.KafkaConfiguration, error) {
+	var cfg map[string]types.KafkaConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return types.KafkaConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return types.KafkaConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	kafkaCfg, ok := cfg[settings.LoggingKafka]
+	if !ok {
+		return types.KafkaConfiguration{}, fmt.Errorf("missing %s in %s", settings.LoggingKafka, file)
+	}
+	return kafkaCfg, nil
+}
+
 func (l *LoggerKafka) Settings(mgr *settings.Settings) {
 	log.Warn().Msg("No kafka logging settings")
 }
@@ -1,6 +1,9 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
@@ -21,15 +24,46 @@ type LoggerTLS struct {
 	AlwaysLogger *LoggerDB
 	Nodes        *nodes.NodeManager
 	Queries      *queries.Queries
+	Spool        *Spool
+	Enricher     *Enricher
+	Router       *Router
+	Sinks        map[string]*LogSink
+	Redactor     *Redactor
+	Detector     *Detector
+	Alerts       *AlertManager
+	tagsCache    *Enricher
+	alertHooks   map[string]*LoggerWebhook
 }
 
 // CreateLoggerTLS to instantiate a new logger for the TLS endpoint
-func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration, kafkaConf types.KafkaConfiguration, loggerSame, alwaysLog bool, dbConf backend.JSONConfigurationDB, mgr *settings.Settings, nodes *nodes.NodeManager, queries *queries.Queries) (*LoggerTLS, error) {
+func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration, kafkaConf types.KafkaConfiguration, batchConf BatchConfiguration, loggerSame, alwaysLog bool, dbConf backend.JSONConfigurationDB, mgr *settings.Settings, nodes *nodes.NodeManager, queries *queries.Queries) (*LoggerTLS, error) {
 	l := &LoggerTLS{
 		Logging: logging,
 		Nodes:   nodes,
 		Queries: queries,
 	}
+	logger, err := CreateLogger(logging, loggingFile, s3Conf, kafkaConf, batchConf, loggerSame, dbConf, mgr)
+	if err != nil {
+		return nil, err
+	}
+	l.Logger = logger
+	attachNodes(logger, nodes)
+	// Initialize the logger that will always log to DB
+	if alwaysLog {
+		always, err := CreateLoggerDBConfig(dbConf)
+		if err != nil {
+			return nil, err
+		}
+		always.Settings(mgr)
+		always.StartBatching(batchConf)
+		l.AlwaysLogger = always
+	}
+	return l, nil
+}
+
+// CreateLogger to instantiate one logger of the given type
+func CreateLogger(logging, loggingFile string, s3Conf types.S3Configuration, kafkaConf types.KafkaConfiguration, batchConf BatchConfiguration, loggerSame bool, dbConf backend.JSONConfigurationDB, mgr *settings.Settings) (interface{}, error) {
+	var logger interface{}
 	switch logging {
 	case settings.LoggingSplunk:
 		s, err := CreateLoggerSplunk(loggingFile)
@@ -37,14 +71,14 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 			return nil, err
 		}
 		s.Settings(mgr)
-		l.Logger = s
+		logger = s
 	case settings.LoggingGraylog:
 		g, err := CreateLoggerGraylog(loggingFile)
 		if err != nil {
 			return nil, err
 		}
 		g.Settings(mgr)
-		l.Logger = g
+		logger = g
 	case settings.LoggingDB:
 		if loggerSame {
 			d, err := CreateLoggerDBConfig(dbConf)
@@ -52,14 +86,16 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 				return nil, err
 			}
 			d.Settings(mgr)
-			l.Logger = d
+			d.StartBatching(batchConf)
+			logger = d
 		} else {
 			d, err := CreateLoggerDBFile(loggingFile)
 			if err != nil {
 				return nil, err
 			}
 			d.Settings(mgr)
-			l.Logger = d
+			d.StartBatching(batchConf)
+			logger = d
 		}
 	case settings.LoggingStdout:
 		d, err := CreateLoggerStdout()
@@ -67,7 +103,7 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 			return nil, err
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		logger = d
 	case settings.LoggingFile:
 		// TODO: All this should be customizable
 		rotateCfg := LumberjackConfig{
@@ -81,21 +117,21 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 			return nil, err
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		logger = d
 	case settings.LoggingNone:
 		d, err := CreateLoggerNone()
 		if err != nil {
 			return nil, err
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		logger = d
 	case settings.LoggingKinesis:
 		d, err := CreateLoggerKinesis(loggingFile)
 		if err != nil {
 			return nil, err
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		logger = d
 	case settings.LoggingS3:
 		var d *LoggerS3
 		var err error
@@ -111,54 +147,227 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 			}
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		d.StartBatching(batchConf)
+		logger = d
 	case settings.LoggingLogstash:
 		d, err := CreateLoggerLogstash(loggingFile)
 		if err != nil {
 			return nil, err
 		}
 		d.Settings(mgr)
-		l.Logger = d
+		logger = d
 	case settings.LoggingKafka:
 		k, err := CreateLoggerKafka(kafkaConf)
 		if err != nil {
 			return nil, err
 		}
 		k.Settings(mgr)
-		l.Logger = k
+		logger = k
 	case settings.LoggingElastic:
 		e, err := CreateLoggerElastic(loggingFile)
 		if err != nil {
 			return nil, err
 		}
 		e.Settings(mgr)
-		l.Logger = e
-	}
-	// Initialize the logger that will always log to DB
-	if alwaysLog {
-		always, err := CreateLoggerDBConfig(dbConf)
+		e.StartBatching(batchConf)
+		logger = e
+	case settings.LoggingSyslog:
+		l, err := CreateLoggerSyslog(loggingFile)
 		if err != nil {
 			return nil, err
 		}
-		always.Settings(mgr)
-		l.AlwaysLogger = always
+		l.Settings(mgr)
+		logger = l
+	case settings.LoggingWebhook:
+		w, err := CreateLoggerWebhook(loggingFile)
+		if err != nil {
+			return nil, err
+		}
+		w.Settings(mgr)
+		w.StartBatching(batchConf)
+		logger = w
+	case settings.LoggingOTLP:
+		o, err := CreateLoggerOTLP(loggingFile)
+		if err != nil {
+			return nil, err
+		}
+		o.Settings(mgr)
+		o.StartBatching(batchConf)
+		logger = o
+	default:
+		return nil, fmt.Errorf("unknown logger %s", logging)
+	}
+	return logger, nil
+}
+
+// Close to stop the spool and write all logs waiting in batches
+func (logTLS *LoggerTLS) Close() {
+	if logTLS.Spool != nil {
+		logTLS.Spool.Close()
+	}
+	closeLogger(logTLS.Logger)
+	for name, sink := range logTLS.Sinks {
+		if name != DefaultSinkName {
+			closeLogger(sink.Logger)
+		}
+	}
+	if logTLS.AlwaysLogger != nil {
+		logTLS.AlwaysLogger.Close()
+	}
+	for _, wh := range logTLS.alertHooks {
+		wh.Close()
+	}
+}
+
+// Sync to write all logs waiting in batches, returning an error if any logger could not write them
+// Logs processed before Sync is called are safely stored once it returns without error
+func (logTLS *LoggerTLS) Sync() error {
+	errs := []error{syncLogger(logTLS.Logger)}
+	for name, sink := range logTLS.Sinks {
+		if name != DefaultSinkName {
+			errs = append(errs, syncLogger(sink.Logger))
+		}
+	}
+	if logTLS.AlwaysLogger != nil {
+		errs = append(errs, logTLS.AlwaysLogger.Sync())
+	}
+	for _, wh := range logTLS.alertHooks {
+		errs = append(errs, wh.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// syncLogger to write all logs waiting in batches of one logger
+func syncLogger(logger interface{}) error {
+	if l, ok := logger.(interface{ Sync() error }); ok {
+		return l.Sync()
+	}
+	return nil
+}
+
+// closeLogger to write all logs waiting in batches of one logger
+func closeLogger(logger interface{}) {
+	switch l := logger.(type) {
+	case *LoggerDB:
+		l.Close()
+	case *LoggerElastic:
+		l.Close()
+	case *LoggerS3:
+		l.Close()
+	case *LoggerSyslog:
+		l.Close()
+	case *LoggerWebhook:
+		l.Close()
+	case *LoggerOTLP:
+		l.Close()
+	}
+}
+
+// attachNodes to give loggers that add details of nodes to logs access to nodes
+func attachNodes(logger interface{}, nodesmgr *nodes.NodeManager) {
+	if l, ok := logger.(*LoggerOTLP); ok {
+		l.Nodes = nodesmgr
 	}
-	return l, nil
 }
 
 // Log will send status/result logs via the configured method of logging
 func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid string, debug bool) {
-	switch logTLS.Logging {
+	// Redaction and drop rules apply before any logger
+	if logTLS.Redactor != nil {
+		if data = logTLS.redactLogs(logType, data, environment, uuid); data == nil {
+			return
+		}
+	}
+	// Detection runs after redaction, so alerts never carry values that rules remove from logs
+	// and events dropped by rules are not evaluated
+	logTLS.detectResults(logType, data, environment, uuid, debug)
+	if logTLS.Router != nil {
+		logTLS.routeLogs(logType, data, environment, uuid, debug)
+	} else {
+		sendLog(logTLS.Logging, logTLS.Logger, logType, data, environment, uuid, debug)
+	}
+	// If logs are status, write via always logger
+	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled && logType == types.StatusLog {
+		// Check if configured logger is DB so we skip logging the same data twice
+		logAlways := true
+		if logTLS.Logger == settings.LoggingDB {
+			l, ok := logTLS.Logger.(*LoggerDB)
+			if ok {
+				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
+			}
+		}
+		if logAlways {
+			logTLS.AlwaysLogger.Log(logType, data, environment, uuid, debug)
+		}
+	}
+}
+
+// QueryLog will send query result logs via the configured method of logging
+func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid, name string, status int, debug bool) {
+	// Redaction and drop rules apply before any logger
+	if logTLS.Redactor != nil {
+		if data = logTLS.redactQueryLog(logType, data, environment, uuid, name); data == nil {
+			return
+		}
+	}
+	if logTLS.Router != nil {
+		logTLS.routeQueryLog(logType, data, environment, uuid, name, status, debug)
+	} else {
+		sendQueryLog(logTLS.Logging, logTLS.Logger, logType, data, environment, uuid, name, status, debug)
+	}
+	// Always log results to DB if always logger is enabled
+	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled {
+		// Check if configured logger is DB so we skip logging the same data twice
+		logAlways := true
+		if logTLS.Logger == settings.LoggingDB {
+			l, ok := logTLS.Logger.(*LoggerDB)
+			if ok {
+				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
+			}
+		}
+		if logAlways {
+			logTLS.AlwaysLogger.Query(data, environment, uuid, name, status, debug)
+		}
+	}
+}
+
+// sendLog to send status/result logs to one logger
+func sendLog(logging string, logger interface{}, logType string, data []byte, environment, uuid string, debug bool) {
+	switch logging {
 	case settings.LoggingSplunk:
-		l, ok := logTLS.Logger.(*LoggerSplunk)
+		l, ok := logger.(*LoggerSplunk)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingSplunk)
 		}
 		if l.Enabled {
 			l.Send(logType, data, environment, uuid, debug)
 		}
+	case settings.LoggingSyslog:
+		l, ok := logger.(*LoggerSyslog)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingSyslog)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
+	case settings.LoggingWebhook:
+		l, ok := logger.(*LoggerWebhook)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingWebhook)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
+	case settings.LoggingOTLP:
+		l, ok := logger.(*LoggerOTLP)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingOTLP)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
 	case settings.LoggingGraylog:
-		l, ok := logTLS.Logger.(*LoggerGraylog)
+		l, ok := logger.(*LoggerGraylog)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingGraylog)
 		}
@@ -166,7 +375,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingDB:
-		l, ok := logTLS.Logger.(*LoggerDB)
+		l, ok := logger.(*LoggerDB)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingDB)
 		}
@@ -174,7 +383,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Log(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingStdout:
-		l, ok := logTLS.Logger.(*LoggerStdout)
+		l, ok := logger.(*LoggerStdout)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingStdout)
 		}
@@ -182,7 +391,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Log(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingFile:
-		l, ok := logTLS.Logger.(*LoggerFile)
+		l, ok := logger.(*LoggerFile)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingFile)
 		}
@@ -190,7 +399,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Log(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingNone:
-		l, ok := logTLS.Logger.(*LoggerNone)
+		l, ok := logger.(*LoggerNone)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingNone)
 		}
@@ -198,7 +407,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Log(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingKinesis:
-		l, ok := logTLS.Logger.(*LoggerKinesis)
+		l, ok := logger.(*LoggerKinesis)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingKinesis)
 		}
@@ -206,7 +415,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingS3:
-		l, ok := logTLS.Logger.(*LoggerS3)
+		l, ok := logger.(*LoggerS3)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingS3)
 		}
@@ -214,7 +423,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingKafka:
-		k, ok := logTLS.Logger.(*LoggerKafka)
+		k, ok := logger.(*LoggerKafka)
 		if !ok {
 			log.Printf("error casting logger to %s", settings.LoggingKafka)
 		}
@@ -222,7 +431,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			k.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingElastic:
-		k, ok := logTLS.Logger.(*LoggerElastic)
+		k, ok := logger.(*LoggerElastic)
 		if !ok {
 			log.Printf("error casting logger to %s", settings.LoggingElastic)
 		}
@@ -230,35 +439,45 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			k.Send(logType, data, environment, uuid, debug)
 		}
 	}
-	// If logs are status, write via always logger
-	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled && logType == types.StatusLog {
-		// Check if configured logger is DB so we skip logging the same data twice
-		logAlways := true
-		if logTLS.Logger == settings.LoggingDB {
-			l, ok := logTLS.Logger.(*LoggerDB)
-			if ok {
-				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
-			}
-		}
-		if logAlways {
-			logTLS.AlwaysLogger.Log(logType, data, environment, uuid, debug)
-		}
-	}
 }
 
-// QueryLog will send query result logs via the configured method of logging
-func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid, name string, status int, debug bool) {
-	switch logTLS.Logging {
+// sendQueryLog to send query result logs to one logger
+func sendQueryLog(logging string, logger interface{}, logType string, data []byte, environment, uuid, name string, status int, debug bool) {
+	switch logging {
 	case settings.LoggingSplunk:
-		l, ok := logTLS.Logger.(*LoggerSplunk)
+		l, ok := logger.(*LoggerSplunk)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingSplunk)
 		}
 		if l.Enabled {
 			l.Send(logType, data, environment, uuid, debug)
 		}
+	case settings.LoggingSyslog:
+		l, ok := logger.(*LoggerSyslog)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingSyslog)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
+	case settings.LoggingWebhook:
+		l, ok := logger.(*LoggerWebhook)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingWebhook)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
+	case settings.LoggingOTLP:
+		l, ok := logger.(*LoggerOTLP)
+		if !ok {
+			log.Error().Msgf("error casting logger to %s", settings.LoggingOTLP)
+		}
+		if l.Enabled {
+			l.Send(logType, data, environment, uuid, debug)
+		}
 	case settings.LoggingGraylog:
-		l, ok := logTLS.Logger.(*LoggerGraylog)
+		l, ok := logger.(*LoggerGraylog)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingGraylog)
 		}
@@ -266,7 +485,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingDB:
-		l, ok := logTLS.Logger.(*LoggerDB)
+		l, ok := logger.(*LoggerDB)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingDB)
 		}
@@ -274,7 +493,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Query(data, environment, uuid, name, status, debug)
 		}
 	case settings.LoggingStdout:
-		l, ok := logTLS.Logger.(*LoggerStdout)
+		l, ok := logger.(*LoggerStdout)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingStdout)
 		}
@@ -282,7 +501,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Query(data, environment, uuid, name, status, debug)
 		}
 	case settings.LoggingFile:
-		l, ok := logTLS.Logger.(*LoggerFile)
+		l, ok := logger.(*LoggerFile)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingFile)
 		}
@@ -290,7 +509,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Query(data, environment, uuid, name, status, debug)
 		}
 	case settings.LoggingNone:
-		l, ok := logTLS.Logger.(*LoggerNone)
+		l, ok := logger.(*LoggerNone)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingNone)
 		}
@@ -298,7 +517,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Query(data, environment, uuid, name, status, debug)
 		}
 	case settings.LoggingKinesis:
-		l, ok := logTLS.Logger.(*LoggerKinesis)
+		l, ok := logger.(*LoggerKinesis)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingKinesis)
 		}
@@ -306,7 +525,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingS3:
-		l, ok := logTLS.Logger.(*LoggerS3)
+		l, ok := logger.(*LoggerS3)
 		if !ok {
 			log.Error().Msgf("error casting logger to %s", settings.LoggingS3)
 		}
@@ -314,7 +533,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingKafka:
-		k, ok := logTLS.Logger.(*LoggerKafka)
+		k, ok := logger.(*LoggerKafka)
 		if !ok {
 			log.Printf("error casting logger to %s", settings.LoggingKafka)
 		}
@@ -322,18 +541,4 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			k.Send(logType, data, environment, uuid, debug)
 		}
 	}
-	// Always log results to DB if always logger is enabled
-	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled {
-		// Check if configured logger is DB so we skip logging the same data twice
-		logAlways := true
-		if logTLS.Logger == settings.LoggingDB {
-			l, ok := logTLS.Logger.(*LoggerDB)
-			if ok {
-				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
-			}
-		}
-		if logAlways {
-			logTLS.AlwaysLogger.Query(data, environment, uuid, name, status, debug)
-		}
-	}
 }
@@ -0,0 +1,499 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// OTLPHTTP to export logs with OTLP/HTTP using protobuf
+	OTLPHTTP = "http"
+	// OTLPGRPC to export logs with OTLP/gRPC
+	OTLPGRPC = "grpc"
+	// OTLPLogsPath as default path of the OTLP/HTTP logs endpoint
+	OTLPLogsPath = "/v1/logs"
+	// DefaultOTLPServiceName as default service.name of exported logs
+	DefaultOTLPServiceName = "osctrl-tls"
+	// DefaultOTLPTimeout as default seconds to wait for each export
+	DefaultOTLPTimeout = 30
+	// OTLPColumnPrefix as prefix of the attributes with result columns
+	OTLPColumnPrefix = "osquery.column."
+	// OTLPScopeName as name of the instrumentation scope of exported logs
+	OTLPScopeName = "github.com/jmpsec/osctrl/logging"
+)
+
+const otlpNodeCache = 5 * time.Minute
+
+// OTLPConfiguration to hold all OTLP configuration values
+// Endpoint is the URL of the logs endpoint for http and host:port for grpc
+type OTLPConfiguration struct {
+	Endpoint           string            `json:"endpoint"`
+	Protocol           string            `json:"protocol"`
+	Headers            map[string]string `json:"headers"`
+	Insecure           bool              `json:"insecure"`
+	CAFile             string            `json:"caFile"`
+	Gzip               bool              `json:"gzip"`
+	Timeout            int               `json:"timeout"`
+	ServiceName        string            `json:"serviceName"`
+	ResourceAttributes map[string]string `json:"resourceAttributes"`
+}
+
+type cachedOTLPNode struct {
+	hostname string
+	platform string
+	expires  time.Time
+}
+
+// LoggerOTLP will be used to export logs to an OpenTelemetry collector
+type LoggerOTLP struct {
+	Configuration OTLPConfiguration
+	Enabled       bool
+	Nodes         *nodes.NodeManager
+	Client        *http.Client
+	conn          *grpc.ClientConn
+	logsClient    collogspb.LogsServiceClient
+	batch         *Batcher[*logspb.ResourceLogs]
+	mutex         sync.Mutex
+	nodeCache     map[string]cachedOTLPNode
+}
+
+// LoadOTLP - Function to load the OTLP configuration from JSON file
+func LoadOTLP(file string) (OTLPConfiguration, error) {
+	var cfg map[string]OTLPConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return OTLPConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return OTLPConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	otlpCfg, ok := cfg[settings.LoggingOTLP]
+	if !ok {
+		return OTLPConfiguration{}, fmt.Errorf("missing %s in %s", settings.LoggingOTLP, file)
+	}
+	return otlpCfg, nil
+}
+
+// CreateLoggerOTLP to initialize the logger
+func CreateLoggerOTLP(otlpFile string) (*LoggerOTLP, error) {
+	config, err := LoadOTLP(otlpFile)
+	if err != nil {
+		return nil, err
+	}
+	return CreateLoggerOTLPConfig(config)
+}
+
+// CreateLoggerOTLPConfig to initialize the logger without reading a config file
+func CreateLoggerOTLPConfig(config OTLPConfiguration) (*LoggerOTLP, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("missing otlp endpoint")
+	}
+	if config.Protocol == "" {
+		config.Protocol = OTLPHTTP
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultOTLPServiceName
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultOTLPTimeout
+	}
+	tlsConfig := &tls.Config{}
+	if config.CAFile != "" {
+		ca, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	l := &LoggerOTLP{
+		Configuration: config,
+		Enabled:       true,
+		nodeCache:     make(map[string]cachedOTLPNode),
+	}
+	switch config.Protocol {
+	case OTLPHTTP:
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otlp endpoint %v", err)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = OTLPLogsPath
+		}
+		l.Configuration.Endpoint = u.String()
+		tlsConfig.InsecureSkipVerify = config.Insecure
+		l.Client = &http.Client{
+			Timeout:   time.Duration(config.Timeout) * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+	case OTLPGRPC:
+		creds := credentials.NewTLS(tlsConfig)
+		if config.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(config.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("error creating otlp client %v", err)
+		}
+		l.conn = conn
+		l.logsClient = collogspb.NewLogsServiceClient(conn)
+	default:
+		return nil, fmt.Errorf("invalid otlp protocol %s", config.Protocol)
+	}
+	return l, nil
+}
+
+// Settings - Function to prepare settings for the logger
+func (logO *LoggerOTLP) Settings(mgr *settings.Settings) {
+	log.Info().Msg("No OTLP logging settings")
+}
+
+// StartBatching to group logs of several nodes in one export
+func (logO *LoggerOTLP) StartBatching(config BatchConfiguration) {
+	logO.batch = NewBatcher(config, logO.export)
+}
+
+// Sync to export all batched logs, returning an error if they could not be exported
+func (logO *LoggerOTLP) Sync() error {
+	if logO.batch == nil {
+		return nil
+	}
+	return logO.batch.Sync()
+}
+
+// Close to export all batched logs and close the connection to the collector
+func (logO *LoggerOTLP) Close() {
+	if logO.batch != nil {
+		logO.batch.Close()
+	}
+	if logO.conn != nil {
+		logO.conn.Close()
+	}
+}
+
+// Send - Function that exports status/result/query logs of a node as OpenTelemetry log records
+func (logO *LoggerOTLP) Send(logType string, data []byte, environment, uuid string, debug bool) {
+	if debug {
+		log.Debug().Msgf("DebugService: Sending %d bytes to OTLP for %s - %s", len(data), environment, uuid)
+	}
+	records, err := OTLPRecords(logType, data, time.Now())
+	if err != nil {
+		log.Err(err).Msgf("error parsing log %s", string(data))
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+	resourceLogs := &logspb.ResourceLogs{
+		Resource: &resourcepb.Resource{Attributes: logO.resource(environment, uuid)},
+		ScopeLogs: []*logspb.ScopeLogs{
+			{
+				Scope:      &commonpb.InstrumentationScope{Name: OTLPScopeName},
+				LogRecords: records,
+			},
+		},
+	}
+	if logO.batch != nil {
+		logO.batch.Add(len(data), resourceLogs)
+		return
+	}
+	logO.export([]*logspb.ResourceLogs{resourceLogs})
+}
+
+// resource to get the resource attributes of a node
+func (logO *LoggerOTLP) resource(environment, uuid string) []*commonpb.KeyValue {
+	hostname, platform := logO.node(uuid)
+	attrs := []*commonpb.KeyValue{
+		otlpString("service.name", logO.Configuration.ServiceName),
+		otlpString("deployment.environment.name", environment),
+		otlpString("host.id", uuid),
+	}
+	if hostname != "" {
+		attrs = append(attrs, otlpString("host.name", hostname))
+	}
+	if platform != "" {
+		attrs = append(attrs, otlpString("os.type", platform))
+	}
+	keys := make([]string, 0, len(logO.Configuration.ResourceAttributes))
+	for k := range logO.Configuration.ResourceAttributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpString(k, logO.Configuration.ResourceAttributes[k]))
+	}
+	return attrs
+}
+
+// node to get hostname and platform of a node, keeping them in memory to avoid DB lookups per request
+func (logO *LoggerOTLP) node(uuid string) (string, string) {
+	if logO.Nodes == nil || uuid == "" {
+		return "", ""
+	}
+	uuid = strings.ToUpper(uuid)
+	logO.mutex.Lock()
+	cached, ok := logO.nodeCache[uuid]
+	logO.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.hostname, cached.platform
+	}
+	node, err := logO.Nodes.GetByUUID(uuid)
+	if err != nil {
+		log.Err(err).Msgf("error getting node %s", uuid)
+		return "", ""
+	}
+	logO.mutex.Lock()
+	logO.nodeCache[uuid] = cachedOTLPNode{hostname: node.Hostname, platform: node.Platform, expires: time.Now().Add(otlpNodeCache)}
+	logO.mutex.Unlock()
+	return node.Hostname, node.Platform
+}
+
+// export to send logs to the collector with one request
+func (logO *LoggerOTLP) export(resourceLogs []*logspb.ResourceLogs) error {
+	if len(resourceLogs) == 0 {
+		return nil
+	}
+	req := &collogspb.ExportLogsServiceRequest{ResourceLogs: resourceLogs}
+	var err error
+	if logO.Configuration.Protocol == OTLPGRPC {
+		err = logO.exportGRPC(req)
+	} else {
+		err = logO.exportHTTP(req)
+	}
+	if err != nil {
+		log.Err(err).Msgf("error exporting logs of %d nodes to OTLP", len(resourceLogs))
+	}
+	return err
+}
+
+func (logO *LoggerOTLP) exportHTTP(req *collogspb.ExportLogsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error serializing logs %v", err)
+	}
+	if logO.Configuration.Gzip {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		if _, err := w.Write(body); err != nil {
+			return fmt.Errorf("error compressing logs %v", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error compressing logs %v", err)
+		}
+		body = gz.Bytes()
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, logO.Configuration.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error preparing request %v", err)
+	}
+	httpReq.Header.Set(utils.UserAgent, utils.OsctrlUserAgent)
+	httpReq.Header.Set(utils.ContentType, "application/x-protobuf")
+	if logO.Configuration.Gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range logO.Configuration.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	resp, err := logO.Client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("error sending request %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func (logO *LoggerOTLP) exportGRPC(req *collogspb.ExportLogsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(logO.Configuration.Timeout)*time.Second)
+	defer cancel()
+	if len(logO.Configuration.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(logO.Configuration.Headers))
+	}
+	var opts []grpc.CallOption
+	if logO.Configuration.Gzip {
+		opts = append(opts, grpc.UseCompressor(grpcgzip.Name))
+	}
+	if _, err := logO.logsClient.Export(ctx, req, opts...); err != nil {
+		return fmt.Errorf("error exporting logs %v", err)
+	}
+	return nil
+}
+
+// OTLPRecords to map osquery logs to OpenTelemetry log records
+// Status logs keep their message as body, results and on-demand queries get one record per row, with the row as body
+// and columns as attributes, like the rows evaluated by detection rules
+func OTLPRecords(logType string, data []byte, observed time.Time) ([]*logspb.LogRecord, error) {
+	var records []*logspb.LogRecord
+	if logType == types.QueryLog {
+		values, err := decodeEvent(data)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprint(values["name"])
+		rows, _ := values["result"].([]interface{})
+		for _, row := range rows {
+			columns, ok := row.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			body, _ := json.Marshal(columns)
+			record := otlpRecord(logType, observed, observed, logspb.SeverityNumber_SEVERITY_NUMBER_INFO, string(body))
+			record.Attributes = append(record.Attributes, otlpString("osquery.query.name", name))
+			record.Attributes = append(record.Attributes, otlpColumns(columns)...)
+			records = append(records, record)
+		}
+		if len(records) == 0 {
+			records = append(records, otlpRecord(logType, observed, observed, logspb.SeverityNumber_SEVERITY_NUMBER_INFO, string(data)))
+			records[0].Attributes = append(records[0].Attributes, otlpString("osquery.query.name", name))
+		}
+		return records, nil
+	}
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		values, err := decodeEvent(e)
+		if err != nil {
+			log.Err(err).Msg("error parsing event for OTLP")
+			continue
+		}
+		t := otlpTime(values["unixTime"], observed)
+		if logType == types.StatusLog {
+			record := otlpRecord(logType, t, observed, OTLPSeverity(e), fmt.Sprint(values["message"]))
+			for _, k := range []string{"filename", "line", "version", "hostIdentifier"} {
+				if v, ok := values[k]; ok {
+					record.Attributes = append(record.Attributes, otlpString("osquery.status."+k, fmt.Sprint(v)))
+				}
+			}
+			records = append(records, record)
+			continue
+		}
+		rows := ResultRows(values)
+		if len(rows) == 0 {
+			records = append(records, otlpResultRecord(logType, t, observed, string(e), values))
+			continue
+		}
+		for _, row := range rows {
+			body, err := json.Marshal(row)
+			if err != nil {
+				log.Err(err).Msg("error preparing row for OTLP")
+				continue
+			}
+			records = append(records, otlpResultRecord(logType, t, observed, string(body), row))
+		}
+	}
+	return records, nil
+}
+
+// otlpResultRecord to get the record of one result row, with its query, action and columns as attributes
+func otlpResultRecord(logType string, t, observed time.Time, body string, row map[string]interface{}) *logspb.LogRecord {
+	record := otlpRecord(logType, t, observed, logspb.SeverityNumber_SEVERITY_NUMBER_INFO, body)
+	for k, attr := range map[string]string{"name": "osquery.query.name", "action": "osquery.query.action", "hostIdentifier": "osquery.host_identifier"} {
+		if v, ok := row[k]; ok {
+			record.Attributes = append(record.Attributes, otlpString(attr, fmt.Sprint(v)))
+		}
+	}
+	if columns, ok := row["columns"].(map[string]interface{}); ok {
+		record.Attributes = append(record.Attributes, otlpColumns(columns)...)
+	}
+	sort.Slice(record.Attributes, func(i, j int) bool { return record.Attributes[i].Key < record.Attributes[j].Key })
+	return record
+}
+
+// OTLPSeverity to map the severity of osquery status logs to OpenTelemetry severities
+func OTLPSeverity(event json.RawMessage) logspb.SeverityNumber {
+	var status struct {
+		Severity types.StringInt `json:"severity"`
+	}
+	if err := json.Unmarshal(event, &status); err != nil {
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	}
+	switch {
+	case status.Severity >= 3:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	case status.Severity == types.SeverityError:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	case status.Severity == 1:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	}
+	return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+}
+
+func otlpRecord(logType string, t, observed time.Time, severity logspb.SeverityNumber, body string) *logspb.LogRecord {
+	return &logspb.LogRecord{
+		TimeUnixNano:         uint64(t.UnixNano()),
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		SeverityNumber:       severity,
+		SeverityText:         strings.TrimPrefix(severity.String(), "SEVERITY_NUMBER_"),
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}},
+		Attributes:           []*commonpb.KeyValue{otlpString("osquery.log_type", logType)},
+	}
+}
+
+// otlpColumns to get attributes from the columns of a result row, sorted by name
+func otlpColumns(columns map[string]interface{}) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(columns))
+	for k := range columns {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, otlpString(OTLPColumnPrefix+k, fmt.Sprint(columns[k])))
+	}
+	return attrs
+}
+
+// otlpTime to parse the unixTime of osquery events, which can be a number or a string
+func otlpTime(value interface{}, fallback time.Time) time.Time {
+	if value == nil {
+		return fallback
+	}
+	seconds, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+	return time.Unix(seconds, 0)
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
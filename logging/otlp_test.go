@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpAttributes(r *logspb.LogRecord) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range r.Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attrs
+}
+
+func TestOTLPRecords(t *testing.T) {
+	now := time.Unix(1700000100, 0)
+	records, err := OTLPRecords("status", []byte(`[{"severity":"2","message":"failed","filename":"x.cpp","unixTime":"1700000000"}]`), now)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, records[0].SeverityNumber)
+	assert.Equal(t, "ERROR", records[0].SeverityText)
+	assert.Equal(t, "failed", records[0].Body.GetStringValue())
+	assert.Equal(t, uint64(time.Unix(1700000000, 0).UnixNano()), records[0].TimeUnixNano)
+	assert.Equal(t, "x.cpp", otlpAttributes(records[0])["osquery.status.filename"])
+	records, err = OTLPRecords("result", []byte(`[{"name":"processes","action":"added","unixTime":1700000000,"columns":{"pid":"1","name":"init"}}]`), now)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	attrs := otlpAttributes(records[0])
+	assert.Equal(t, "processes", attrs["osquery.query.name"])
+	assert.Equal(t, "added", attrs["osquery.query.action"])
+	assert.Equal(t, "1", attrs["osquery.column.pid"])
+	assert.Equal(t, "result", attrs["osquery.log_type"])
+	records, err = OTLPRecords("result", []byte(`[{"name":"users","action":"snapshot","snapshot":[{"user":"root"},{"user":"admin"}]},{"name":"ports","diffResults":{"added":[{"port":"22"}],"removed":[{"port":"80"}]}}]`), now)
+	assert.NoError(t, err)
+	assert.Len(t, records, 4)
+	assert.Equal(t, "admin", otlpAttributes(records[1])["osquery.column.user"])
+	assert.JSONEq(t, `{"name":"users","action":"snapshot","columns":{"user":"admin"}}`, records[1].Body.GetStringValue())
+	assert.Equal(t, "removed", otlpAttributes(records[3])["osquery.query.action"])
+	assert.Equal(t, "80", otlpAttributes(records[3])["osquery.column.port"])
+	records, err = OTLPRecords("query", []byte(`{"name":"users","result":[{"user":"root"},{"user":"admin"}],"status":0}`), now)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "admin", otlpAttributes(records[1])["osquery.column.user"])
+	assert.Equal(t, uint64(now.UnixNano()), records[1].TimeUnixNano)
+}
+
+func TestOTLPSendHTTP(t *testing.T) {
+	received := make(chan *collogspb.ExportLogsServiceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, OTLPLogsPath, r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		req := &collogspb.ExportLogsServiceRequest{}
+		assert.NoError(t, proto.Unmarshal(body, req))
+		received <- req
+	}))
+	defer server.Close()
+	l, err := CreateLoggerOTLPConfig(OTLPConfiguration{Endpoint: server.URL, ResourceAttributes: map[string]string{"team": "sec"}})
+	assert.NoError(t, err)
+	l.Send("status", []byte(`[{"severity":"0","message":"a"},{"severity":"1","message":"b"}]`), "prod", "UUID1", false)
+	req := <-received
+	assert.Len(t, req.ResourceLogs, 1)
+	resource := make(map[string]string)
+	for _, kv := range req.ResourceLogs[0].Resource.Attributes {
+		resource[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, map[string]string{"service.name": DefaultOTLPServiceName, "deployment.environment.name": "prod", "host.id": "UUID1", "team": "sec"}, resource)
+	assert.Len(t, req.ResourceLogs[0].ScopeLogs[0].LogRecords, 2)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, req.ResourceLogs[0].ScopeLogs[0].LogRecords[1].SeverityNumber)
+}
+
+func TestOTLPInsecureHTTP(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	l, err := CreateLoggerOTLPConfig(OTLPConfiguration{Endpoint: server.URL})
+	assert.NoError(t, err)
+	assert.Error(t, l.export(otlpTestLogs()))
+	l, err = CreateLoggerOTLPConfig(OTLPConfiguration{Endpoint: server.URL, Insecure: true})
+	assert.NoError(t, err)
+	assert.NoError(t, l.export(otlpTestLogs()))
+}
+
+func otlpTestLogs() []*logspb.ResourceLogs {
+	records, _ := OTLPRecords("status", []byte(`[{"severity":"0","message":"a"}]`), time.Now())
+	return []*logspb.ResourceLogs{{ScopeLogs: []*logspb.ScopeLogs{{LogRecords: records}}}}
+}
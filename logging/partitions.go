@@ -0,0 +1,345 @@
+package logging
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// ResultsTable as table partitioned by day for result logs
+	ResultsTable = "osquery_result_data"
+	// ResultsDefaultPartition as partition for result logs outside of daily partitions
+	ResultsDefaultPartition = ResultsTable + "_default"
+	// DefaultPartitionsAhead as default number of daily partitions created ahead of time
+	DefaultPartitionsAhead = 3
+)
+
+const (
+	resultsLegacyTable   = ResultsTable + "_legacy"
+	resultsPartitionDay  = "20060102"
+	resultsPartitionName = ResultsTable + "_p"
+	partitionMaintenance = time.Hour
+	resultsCopyBatch     = 1000
+)
+
+// Indexes of the unpartitioned table of result logs, renamed during migration
+var resultsLegacyIndexes = []string{
+	ResultsTable + "_pkey",
+	"idx_" + ResultsTable + "_uuid",
+	"idx_" + ResultsTable + "_deleted_at",
+}
+
+// Statements to create the partitioned table of result logs, columns are JSONB with a GIN index
+var resultsTableSQL = []string{
+	`CREATE TABLE IF NOT EXISTS ` + ResultsTable + ` (
+		id bigserial,
+		created_at timestamptz NOT NULL,
+		updated_at timestamptz,
+		deleted_at timestamptz,
+		uuid text,
+		environment text,
+		name text,
+		action text,
+		epoch bigint,
+		columns jsonb,
+		counter bigint,
+		PRIMARY KEY (id, created_at)
+	) PARTITION BY RANGE (created_at)`,
+	`CREATE TABLE IF NOT EXISTS ` + ResultsDefaultPartition + ` PARTITION OF ` + ResultsTable + ` DEFAULT`,
+}
+
+// Statements to create the indexes of the partitioned table of result logs
+var resultsIndexesSQL = []string{
+	`CREATE INDEX IF NOT EXISTS idx_` + ResultsTable + `_uuid ON ` + ResultsTable + ` (uuid)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + ResultsTable + `_deleted_at ON ` + ResultsTable + ` (deleted_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + ResultsTable + `_environment ON ` + ResultsTable + ` (environment, name, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_` + ResultsTable + `_columns ON ` + ResultsTable + ` USING GIN (columns jsonb_path_ops)`,
+}
+
+// Statement to read a batch of result logs from the unpartitioned table, locked rows are copied by other services
+const resultsLegacySQL = `SELECT id, COALESCE(created_at, now()) AS created_at, COALESCE(updated_at, created_at, now()) AS updated_at, deleted_at,
+	COALESCE(uuid, '') AS uuid, COALESCE(environment, '') AS environment, COALESCE(name, '') AS name, COALESCE(action, '') AS action,
+	COALESCE(epoch, 0) AS epoch, COALESCE(NULLIF(columns::text, ''), '{}') AS columns, COALESCE(counter, 0) AS counter
+	FROM ` + resultsLegacyTable + ` WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`
+
+// ResultPartitionName to get the name of the partition of result logs for one day
+func ResultPartitionName(day time.Time) string {
+	return resultsPartitionName + day.UTC().Format(resultsPartitionDay)
+}
+
+// ResultPartitionDay to get the day of a partition of result logs from its name
+func ResultPartitionDay(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, resultsPartitionName) {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(resultsPartitionDay, strings.TrimPrefix(name, resultsPartitionName))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// ResultPartitionSQL to get the statement creating the partition of result logs for one day
+func ResultPartitionSQL(day time.Time) string {
+	start := day.UTC().Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, 1)
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		ResultPartitionName(start), ResultsTable, start.Format(time.RFC3339), end.Format(time.RFC3339))
+}
+
+// migrateResults to create the partitioned table of result logs, renaming the unpartitioned table
+// Logs in the unpartitioned table are copied afterwards by copyLegacyResults, it returns true if there are logs to copy
+func migrateResults(db *gorm.DB, now time.Time) (bool, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Services sharing the DB can start at the same time
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", ResultsTable).Error; err != nil {
+			return fmt.Errorf("error locking %s %v", ResultsTable, err)
+		}
+		kind, err := tableKind(tx, ResultsTable)
+		if err != nil {
+			return err
+		}
+		legacy := kind == "r"
+		if legacy {
+			log.Info().Msgf("Migrating %s to daily partitions", ResultsTable)
+			if err := tx.Exec("ALTER TABLE " + ResultsTable + " RENAME TO " + resultsLegacyTable).Error; err != nil {
+				return fmt.Errorf("error renaming %s %v", ResultsTable, err)
+			}
+			for _, idx := range resultsLegacyIndexes {
+				renamed := strings.Replace(idx, ResultsTable, resultsLegacyTable, 1)
+				if err := tx.Exec("ALTER INDEX IF EXISTS " + idx + " RENAME TO " + renamed).Error; err != nil {
+					return fmt.Errorf("error renaming %s %v", idx, err)
+				}
+			}
+			if err := tx.Exec("ALTER SEQUENCE IF EXISTS " + ResultsTable + "_id_seq RENAME TO " + resultsLegacyTable + "_id_seq").Error; err != nil {
+				return fmt.Errorf("error renaming sequence of %s %v", ResultsTable, err)
+			}
+		}
+		for _, stmt := range resultsTableSQL {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("error creating %s %v", ResultsTable, err)
+			}
+		}
+		if err := createResultPartitions(tx, now, now.AddDate(0, 0, DefaultPartitionsAhead)); err != nil {
+			return err
+		}
+		if legacy {
+			// New logs must not reuse the IDs of logs still to be copied
+			if err := tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE((SELECT MAX(id) FROM "+resultsLegacyTable+"), 0) + 1, false)", ResultsTable).Error; err != nil {
+				return fmt.Errorf("error updating sequence of %s %v", ResultsTable, err)
+			}
+		}
+		for _, stmt := range resultsIndexesSQL {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("error creating index of %s %v", ResultsTable, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	// A previous copy may have been interrupted
+	kind, err := tableKind(db, resultsLegacyTable)
+	if err != nil {
+		return false, err
+	}
+	return kind == "r", nil
+}
+
+// tableKind to get the kind of a table in the current schema, empty if it does not exist
+func tableKind(db *gorm.DB, table string) (string, error) {
+	var kind string
+	if err := db.Raw(
+		"SELECT c.relkind::text FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE c.relname = ? AND n.nspname = current_schema()",
+		table).Scan(&kind).Error; err != nil {
+		return "", fmt.Errorf("error checking %s %v", table, err)
+	}
+	return kind, nil
+}
+
+// ValidResultColumns to check if the columns of a result log can be stored as JSONB
+func ValidResultColumns(columns string) bool {
+	// JSONB does not support NULL characters in strings
+	return json.Valid([]byte(columns)) && !strings.Contains(columns, `\u0000`)
+}
+
+// copyLegacyResults to copy result logs from the unpartitioned table in batches, dropping it once empty
+// Logs with invalid columns are skipped and kept in the unpartitioned table
+func copyLegacyResults(db *gorm.DB) error {
+	// Partitions must exist for every day of the logs being copied
+	var oldest sql.NullTime
+	if err := db.Raw("SELECT MIN(created_at) FROM " + resultsLegacyTable).Scan(&oldest).Error; err != nil {
+		return fmt.Errorf("error reading %s %v", resultsLegacyTable, err)
+	}
+	if oldest.Valid {
+		if err := createResultPartitions(db, oldest.Time, time.Now()); err != nil {
+			return err
+		}
+	}
+	var after uint
+	var copied, skipped int
+	for {
+		var batch []OsqueryResultData
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Raw(resultsLegacySQL, after, resultsCopyBatch).Scan(&batch).Error; err != nil {
+				return fmt.Errorf("error reading %s %v", resultsLegacyTable, err)
+			}
+			valid := make([]OsqueryResultData, 0, len(batch))
+			ids := make([]uint, 0, len(batch))
+			for _, r := range batch {
+				if !ValidResultColumns(r.Columns) {
+					log.Warn().Msgf("Skipping result log %d with invalid columns in %s", r.ID, resultsLegacyTable)
+					skipped++
+					continue
+				}
+				valid = append(valid, r)
+				ids = append(ids, r.ID)
+			}
+			if len(valid) == 0 {
+				return nil
+			}
+			if err := tx.Table(ResultsTable).Create(&valid).Error; err != nil {
+				return fmt.Errorf("error copying %s %v", resultsLegacyTable, err)
+			}
+			if err := tx.Exec("DELETE FROM "+resultsLegacyTable+" WHERE id IN ?", ids).Error; err != nil {
+				return fmt.Errorf("error deleting copied logs from %s %v", resultsLegacyTable, err)
+			}
+			copied += len(valid)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		after = batch[len(batch)-1].ID
+	}
+	log.Info().Msgf("Copied %d result logs to daily partitions", copied)
+	if skipped > 0 {
+		log.Warn().Msgf("Kept %d result logs with invalid columns in %s", skipped, resultsLegacyTable)
+		return nil
+	}
+	// Other services may still be copying logs
+	var remaining bool
+	if err := db.Raw("SELECT EXISTS (SELECT 1 FROM " + resultsLegacyTable + ")").Scan(&remaining).Error; err != nil {
+		return fmt.Errorf("error reading %s %v", resultsLegacyTable, err)
+	}
+	if !remaining {
+		if err := db.Exec("DROP TABLE IF EXISTS " + resultsLegacyTable).Error; err != nil {
+			return fmt.Errorf("error dropping %s %v", resultsLegacyTable, err)
+		}
+	}
+	return nil
+}
+
+// createResultPartitions to create the daily partitions of result logs between two days, both included
+func createResultPartitions(db *gorm.DB, from, to time.Time) error {
+	to = to.UTC().Truncate(24 * time.Hour)
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.AddDate(0, 0, 1) {
+		if err := db.Exec(ResultPartitionSQL(day)).Error; err != nil {
+			return fmt.Errorf("error creating %s %v", ResultPartitionName(day), err)
+		}
+	}
+	return nil
+}
+
+// ResultPartitions to get the names of the daily partitions of result logs
+func (logDB *LoggerDB) ResultPartitions() ([]string, error) {
+	var names []string
+	if err := logDB.Database.Conn.Raw(
+		"SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent WHERE p.relname = ? ORDER BY c.relname",
+		ResultsTable).Scan(&names).Error; err != nil {
+		return nil, fmt.Errorf("error getting partitions of %s %v", ResultsTable, err)
+	}
+	partitions := make([]string, 0, len(names))
+	for _, n := range names {
+		if _, ok := ResultPartitionDay(n); ok {
+			partitions = append(partitions, n)
+		}
+	}
+	return partitions, nil
+}
+
+// DropResultPartitions to drop the daily partitions of result logs with all logs older than a time
+// Result logs older than that time in the default partition are deleted
+func (logDB *LoggerDB) DropResultPartitions(before time.Time) ([]string, error) {
+	partitions, err := logDB.ResultPartitions()
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, p := range partitions {
+		day, _ := ResultPartitionDay(p)
+		if day.AddDate(0, 0, 1).After(before) {
+			continue
+		}
+		if err := logDB.Database.Conn.Exec("DROP TABLE IF EXISTS " + p).Error; err != nil {
+			return dropped, fmt.Errorf("error dropping %s %v", p, err)
+		}
+		dropped = append(dropped, p)
+	}
+	if err := logDB.Database.Conn.Exec("DELETE FROM "+ResultsDefaultPartition+" WHERE created_at < ?", before).Error; err != nil {
+		return dropped, fmt.Errorf("error cleaning %s %v", ResultsDefaultPartition, err)
+	}
+	return dropped, nil
+}
+
+// maintainPartitions to create the partitions of the next days and drop partitions past the retention
+func (logDB *LoggerDB) maintainPartitions(retention time.Duration, now time.Time) {
+	if err := createResultPartitions(logDB.Database.Conn, now, now.AddDate(0, 0, DefaultPartitionsAhead)); err != nil {
+		log.Err(err).Msg("error creating partitions of result logs")
+	}
+	if retention <= 0 {
+		return
+	}
+	dropped, err := logDB.DropResultPartitions(now.Add(-retention))
+	if err != nil {
+		log.Err(err).Msg("error dropping partitions of result logs")
+	}
+	if len(dropped) > 0 {
+		log.Info().Msgf("Dropped %d partitions of result logs older than %s", len(dropped), retention)
+	}
+}
+
+// StartPartitions to keep partitions of result logs ready ahead of time, dropping partitions past the retention
+// Result logs are kept forever with a retention of zero
+func (logDB *LoggerDB) StartPartitions(retention time.Duration) {
+	if logDB.partitionsDone != nil {
+		return
+	}
+	logDB.partitionsDone = make(chan struct{})
+	logDB.maintainPartitions(retention, time.Now())
+	go func(done chan struct{}) {
+		ticker := time.NewTicker(partitionMaintenance)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				logDB.maintainPartitions(retention, now)
+			}
+		}
+	}(logDB.partitionsDone)
+}
+
+// StartPartitions to maintain the partitions of result logs for all DB loggers
+func (logTLS *LoggerTLS) StartPartitions(retention time.Duration) {
+	if l, ok := logTLS.Logger.(*LoggerDB); ok {
+		l.StartPartitions(retention)
+	}
+	for name, sink := range logTLS.Sinks {
+		if l, ok := sink.Logger.(*LoggerDB); ok && name != DefaultSinkName {
+			l.StartPartitions(retention)
+		}
+	}
+}
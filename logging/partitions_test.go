@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultPartitions(t *testing.T) {
+	day := time.Date(2024, time.March, 31, 23, 30, 0, 0, time.FixedZone("x", -3600))
+	assert.Equal(t, "osquery_result_data_p20240401", ResultPartitionName(day))
+	assert.Equal(t,
+		"CREATE TABLE IF NOT EXISTS osquery_result_data_p20240401 PARTITION OF osquery_result_data FOR VALUES FROM ('2024-04-01T00:00:00Z') TO ('2024-04-02T00:00:00Z')",
+		ResultPartitionSQL(day))
+	parsed, ok := ResultPartitionDay("osquery_result_data_p20240401")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC), parsed)
+	for _, name := range []string{ResultsDefaultPartition, ResultsTable, "osquery_result_data_p2024", "osquery_status_data_p20240401"} {
+		_, ok := ResultPartitionDay(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestResultEntries(t *testing.T) {
+	var logs []types.LogResultData
+	data := `[
+		{"name":"users","action":"added","columns":{"username":"root"},"hostIdentifier":"abc"},
+		{"name":"procs","action":"snapshot","snapshot":[{"pid":"1"},{"pid":"2"}],"hostIdentifier":"abc"},
+		{"name":"empty","action":"removed","hostIdentifier":"abc"}
+	]`
+	assert.NoError(t, json.Unmarshal([]byte(data), &logs))
+	entries := ResultEntries(logs, "prod")
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "ABC", entries[0].UUID)
+	assert.Equal(t, "prod", entries[0].Environment)
+	assert.JSONEq(t, `{"username":"root"}`, entries[0].Columns)
+	assert.Equal(t, "snapshot", entries[2].Action)
+	assert.JSONEq(t, `{"pid":"2"}`, entries[2].Columns)
+	assert.Equal(t, "{}", entries[3].Columns)
+}
+
+func TestValidResultColumns(t *testing.T) {
+	assert.True(t, ValidResultColumns(`{"username":"root"}`))
+	assert.True(t, ValidResultColumns(`{}`))
+	assert.False(t, ValidResultColumns(`{"username":"root"`))
+	assert.False(t, ValidResultColumns(`username=root`))
+	assert.False(t, ValidResultColumns(`{"path":"a\u0000b"}`))
+}
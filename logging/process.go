@@ -1,7 +1,10 @@
 package logging
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/types"
@@ -21,6 +24,7 @@ func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipad
 	}
 	// Iterate through received messages to extract metadata
 	var uuid, hostname, localname, username, osqueryuser, confighash, daemonhash, osqueryversion string
+	var lastError time.Time
 	for _, l := range logs {
 		uuid = metadataVerification(uuid, l.HostIdentifier)
 		hostname = metadataVerification(hostname, l.Decorations.Hostname)
@@ -30,23 +34,87 @@ func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipad
 		confighash = metadataVerification(confighash, l.Decorations.ConfigHash)
 		daemonhash = metadataVerification(daemonhash, l.Decorations.DaemonHash)
 		osqueryversion = metadataVerification(osqueryversion, l.Decorations.OsqueryVersion)
+		if logType == types.StatusLog && l.Severity >= types.SeverityError {
+			lastError = time.Now()
+		}
 	}
 	if debug {
 		log.Debug().Msgf("metadata and dispatch for %s", uuid)
 	}
 	metadata := nodes.NodeMetadata{
-		IPAddress:      ipaddress,
-		Username:       username,
-		OsqueryUser:    osqueryuser,
-		Hostname:       hostname,
-		Localname:      localname,
-		ConfigHash:     confighash,
-		DaemonHash:     daemonhash,
-		OsqueryVersion: osqueryversion,
-		BytesReceived:  dataLen,
+		IPAddress:       ipaddress,
+		Username:        username,
+		OsqueryUser:     osqueryuser,
+		Hostname:        hostname,
+		Localname:       localname,
+		ConfigHash:      confighash,
+		DaemonHash:      daemonhash,
+		OsqueryVersion:  osqueryversion,
+		BytesReceived:   dataLen,
+		LastStatusError: lastError,
 	}
 	// Dispatch logs and update metadata
 	l.DispatchLogs(data, uuid, logType, environment, metadata, debug)
+	// Collect the performance of scheduled queries from the built-in query
+	if logType == types.ResultLog && bytes.Contains(data, []byte(types.SchedulePerfQuery)) {
+		l.ProcessSchedulePerf(data, uuid)
+	}
+}
+
+// ProcessSchedulePerf - Helper to record osquery_schedule stats from snapshot result logs
+func (l *LoggerTLS) ProcessSchedulePerf(data json.RawMessage, uuid string) {
+	var logs []types.LogResultData
+	if err := json.Unmarshal(data, &logs); err != nil {
+		log.Err(err).Msg("error parsing result logs")
+		return
+	}
+	// Only snapshots have all the scheduled queries, the latest one is recorded
+	var snapshot json.RawMessage
+	for _, r := range logs {
+		if r.Name == types.SchedulePerfQuery && len(r.Snapshot) > 0 {
+			snapshot = r.Snapshot
+		}
+	}
+	if snapshot == nil {
+		return
+	}
+	rows, err := SnapshotRows(snapshot)
+	if err != nil {
+		log.Err(err).Msg("error parsing osquery_schedule snapshot")
+		return
+	}
+	if err := l.Nodes.RecordScheduleStats(uuid, rows); err != nil {
+		log.Err(err).Msg("error recording schedule stats")
+	}
+}
+
+// SnapshotRows - Helper to parse the rows of a snapshot with all values as strings
+// Values are numbers instead of strings when osquery runs with logger_numerics
+func SnapshotRows(snapshot json.RawMessage) ([]map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(snapshot))
+	decoder.UseNumber()
+	var raw []map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(raw))
+	for _, r := range raw {
+		row := make(map[string]string, len(r))
+		for k, v := range r {
+			switch value := v.(type) {
+			case string:
+				row[k] = value
+			case json.Number:
+				row[k] = value.String()
+			case nil:
+				row[k] = ""
+			default:
+				row[k] = fmt.Sprint(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
 }
 
 // ProcessLogQueryResult - Helper to process on-demand query result logs
@@ -69,7 +137,7 @@ func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest,
 			Status:  queriesWrite.Statuses[q],
 			Message: queriesWrite.Messages[q],
 		}
-		go l.DispatchQueries(d, node, debug)
+		l.DispatchQueries(d, node, debug)
 		// TODO: need be refactored
 		// Update internal metrics per query
 		var err error
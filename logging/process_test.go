@@ -0,0 +1,19 @@
+package logging
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRows(t *testing.T) {
+	rows, err := SnapshotRows(json.RawMessage(`[{"name":"users","interval":"60","executions":"5"},{"name":"procs","interval":3600,"wall_time_ms":12345678901,"denylisted":false,"path":null}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"name": "users", "interval": "60", "executions": "5"},
+		{"name": "procs", "interval": "3600", "wall_time_ms": "12345678901", "denylisted": "false", "path": ""},
+	}, rows)
+	_, err = SnapshotRows(json.RawMessage(`{"name":"users"}`))
+	assert.Error(t, err)
+}
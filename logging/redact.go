@@ -0,0 +1,317 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RedactionKey as key in the JSON file for the redaction configuration
+	RedactionKey = "redaction"
+)
+
+// MaskRule to replace the parts of a column matching a regular expression
+type MaskRule struct {
+	Column  string `json:"column"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+	regex   *regexp.Regexp
+}
+
+// RedactRule to drop or redact events matching all conditions
+// Columns are the columns of results, or the fields of status logs
+type RedactRule struct {
+	Name        string     `json:"name"`
+	Match       RouteMatch `json:"match"`
+	Drop        bool       `json:"drop"`
+	DropColumns []string   `json:"dropColumns,omitempty"`
+	Mask        []MaskRule `json:"mask,omitempty"`
+	Hash        []string   `json:"hash,omitempty"`
+}
+
+// RedactionConfiguration to hold the rules applied to events before they are sent to loggers
+type RedactionConfiguration struct {
+	HMACKey string       `json:"hmacKey"`
+	Rules   []RedactRule `json:"rules"`
+}
+
+// Redactor to apply redaction and drop rules to events, counting redacted and dropped events
+type Redactor struct {
+	Config   RedactionConfiguration
+	tags     bool
+	redacted atomic.Int64
+	dropped  atomic.Int64
+}
+
+// LoadRedaction - Function to load the redaction configuration from JSON file
+func LoadRedaction(file string) (RedactionConfiguration, error) {
+	var cfg map[string]RedactionConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return RedactionConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return RedactionConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	redaction, ok := cfg[RedactionKey]
+	if !ok {
+		return RedactionConfiguration{}, fmt.Errorf("missing %s in %s", RedactionKey, file)
+	}
+	return redaction, nil
+}
+
+// NewRedactor to validate the redaction configuration and compile its patterns
+func NewRedactor(config RedactionConfiguration) (*Redactor, error) {
+	r := &Redactor{Config: config}
+	for i := range r.Config.Rules {
+		rule := &r.Config.Rules[i]
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if !rule.Drop && len(rule.DropColumns) == 0 && len(rule.Mask) == 0 && len(rule.Hash) == 0 {
+			return nil, fmt.Errorf("no actions in %s", name)
+		}
+		if len(rule.Hash) > 0 && config.HMACKey == "" {
+			return nil, fmt.Errorf("hmacKey is required to hash values in %s", name)
+		}
+		if err := ValidateRouting(RoutingConfiguration{Rules: []RouteRule{{Name: name, Match: rule.Match, Sinks: []string{DefaultSinkName}}}}); err != nil {
+			return nil, err
+		}
+		for j := range rule.Mask {
+			regex, err := regexp.Compile(rule.Mask[j].Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mask %s in %s %v", rule.Mask[j].Pattern, name, err)
+			}
+			rule.Mask[j].regex = regex
+		}
+		if len(rule.Match.Tag) > 0 {
+			r.tags = true
+		}
+	}
+	return r, nil
+}
+
+// NeedsTags to know if any rule matches on node tags
+func (r *Redactor) NeedsTags() bool {
+	return r.tags
+}
+
+// Redacted to get the number of events with redacted values
+func (r *Redactor) Redacted() int64 {
+	return r.redacted.Load()
+}
+
+// Dropped to get the number of events dropped by rules or because they could not be redacted
+func (r *Redactor) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// discard to count events that are dropped because they could not be parsed or redacted
+func (r *Redactor) discard(events int) {
+	r.dropped.Add(int64(events))
+}
+
+// Apply to drop or redact one event, returning if it must be kept and if it was redacted
+func (r *Redactor) Apply(event RouteEvent, values map[string]interface{}) (bool, bool) {
+	redacted := false
+	for _, rule := range r.Config.Rules {
+		if !rule.Match.matches(event) {
+			continue
+		}
+		if rule.Drop {
+			r.dropped.Add(1)
+			return false, false
+		}
+		for _, row := range eventRows(event.LogType, values) {
+			if r.redactRow(rule, row) {
+				redacted = true
+			}
+		}
+	}
+	if redacted {
+		r.redacted.Add(1)
+	}
+	return true, redacted
+}
+
+// redactRow to apply the actions of a rule to one row, returning true if anything changed
+func (r *Redactor) redactRow(rule RedactRule, row map[string]interface{}) bool {
+	changed := false
+	for _, c := range rule.DropColumns {
+		if _, ok := row[c]; ok {
+			delete(row, c)
+			changed = true
+		}
+	}
+	for _, m := range rule.Mask {
+		if v, ok := row[m.Column].(string); ok {
+			if masked := m.regex.ReplaceAllString(v, m.Replace); masked != v {
+				row[m.Column] = masked
+				changed = true
+			}
+		}
+	}
+	for _, c := range rule.Hash {
+		if v, ok := row[c].(string); ok {
+			mac := hmac.New(sha256.New, []byte(r.Config.HMACKey))
+			mac.Write([]byte(v))
+			row[c] = hex.EncodeToString(mac.Sum(nil))
+			changed = true
+		}
+	}
+	return changed
+}
+
+// eventRows to get the rows of an event where columns are redacted
+// Results have their rows in columns, snapshot or diffResults and status logs are one row
+func eventRows(logType string, values map[string]interface{}) []map[string]interface{} {
+	var rows []map[string]interface{}
+	appendRows := func(v interface{}) {
+		switch r := v.(type) {
+		case map[string]interface{}:
+			rows = append(rows, r)
+		case []interface{}:
+			for _, row := range r {
+				if m, ok := row.(map[string]interface{}); ok {
+					rows = append(rows, m)
+				}
+			}
+		}
+	}
+	switch logType {
+	case types.ResultLog:
+		appendRows(values["columns"])
+		appendRows(values["snapshot"])
+		if diff, ok := values["diffResults"].(map[string]interface{}); ok {
+			appendRows(diff["added"])
+			appendRows(diff["removed"])
+		}
+	case types.QueryLog:
+		appendRows(values["result"])
+	default:
+		rows = append(rows, values)
+	}
+	return rows
+}
+
+// decodeEvent to parse an event keeping numbers as they were received
+func decodeEvent(data []byte) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// EnableRedaction to drop or redact events with rules before sending them to loggers
+func (logTLS *LoggerTLS) EnableRedaction(config RedactionConfiguration, tags NodeTagsFunc) error {
+	redactor, err := NewRedactor(config)
+	if err != nil {
+		return err
+	}
+	if redactor.NeedsTags() {
+		logTLS.cacheTags(tags)
+	}
+	logTLS.Redactor = redactor
+	return nil
+}
+
+// redactLogs to apply redaction and drop rules to status/result logs, returning nil if all events are dropped
+func (logTLS *LoggerTLS) redactLogs(logType string, data []byte, environment, uuid string) []byte {
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		log.Err(err).Msg("error parsing logs for redaction")
+		logTLS.Redactor.discard(1)
+		return nil
+	}
+	var tags []string
+	if logTLS.Redactor.NeedsTags() {
+		tags = logTLS.nodeTags(uuid)
+	}
+	kept := make([]json.RawMessage, 0, len(events))
+	modified := false
+	for _, e := range events {
+		values, err := decodeEvent(e)
+		if err != nil {
+			// Events that can not be redacted are not sent
+			log.Err(err).Msg("error parsing event for redaction")
+			logTLS.Redactor.discard(1)
+			continue
+		}
+		keep, changed := logTLS.Redactor.Apply(NewRouteEvent(logType, environment, e, tags), values)
+		if !keep {
+			continue
+		}
+		if !changed {
+			kept = append(kept, e)
+			continue
+		}
+		modified = true
+		redacted, err := json.Marshal(values)
+		if err != nil {
+			log.Err(err).Msg("error preparing redacted event")
+			logTLS.Redactor.discard(1)
+			continue
+		}
+		kept = append(kept, redacted)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if !modified && len(kept) == len(events) {
+		return data
+	}
+	redacted, err := json.Marshal(kept)
+	if err != nil {
+		log.Err(err).Msg("error preparing redacted logs")
+		logTLS.Redactor.discard(len(kept))
+		return nil
+	}
+	return redacted
+}
+
+// redactQueryLog to apply redaction and drop rules to on-demand query results, returning nil if they are dropped
+func (logTLS *LoggerTLS) redactQueryLog(logType string, data []byte, environment, uuid, name string) []byte {
+	values, err := decodeEvent(data)
+	if err != nil {
+		log.Err(err).Msg("error parsing query for redaction")
+		logTLS.Redactor.discard(1)
+		return nil
+	}
+	event := RouteEvent{
+		LogType:     logType,
+		Environment: environment,
+		Query:       name,
+	}
+	if logTLS.Redactor.NeedsTags() {
+		event.Tags = logTLS.nodeTags(uuid)
+	}
+	keep, changed := logTLS.Redactor.Apply(event, values)
+	if !keep {
+		return nil
+	}
+	if !changed {
+		return data
+	}
+	redacted, err := json.Marshal(values)
+	if err != nil {
+		log.Err(err).Msg("error preparing redacted query")
+		logTLS.Redactor.discard(1)
+		return nil
+	}
+	return redacted
+}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactLogs(t *testing.T) {
+	l := &LoggerTLS{}
+	err := l.EnableRedaction(RedactionConfiguration{
+		HMACKey: "secret",
+		Rules: []RedactRule{
+			{Name: "noisy", Match: RouteMatch{LogType: []string{"status"}, Severity: []int{0}}, Drop: true},
+			{
+				Name:        "processes",
+				Match:       RouteMatch{Environment: []string{"prod"}, Query: []string{"processes"}},
+				DropColumns: []string{"env"},
+				Mask:        []MaskRule{{Column: "cmdline", Pattern: `password=\S+`, Replace: "password=***"}},
+				Hash:        []string{"user"},
+			},
+		},
+	}, nil)
+	assert.NoError(t, err)
+	status := l.redactLogs("status", []byte(`[{"severity":0,"message":"info"},{"severity":"2","message":"error"}]`), "prod", "uuid")
+	assert.JSONEq(t, `[{"severity":"2","message":"error"}]`, string(status))
+	results := l.redactLogs("result", []byte(`[{"name":"processes","epoch":1700000000123,"columns":{"cmdline":"app --password=hunter2 -v","env":"TOKEN=abc","user":"root"}}]`), "prod", "uuid")
+	assert.JSONEq(t, `[{"name":"processes","epoch":1700000000123,"columns":{"cmdline":"app --password=*** -v","user":"c7281924378679dc02ca4ea71f987ade058e351da00b9cf1e1d0aecc4a0b8988"}}]`, string(results))
+	untouched := []byte(`[{"name":"processes","columns":{"cmdline":"app"}}]`)
+	assert.Equal(t, untouched, l.redactLogs("result", untouched, "dev", "uuid"))
+	assert.Equal(t, int64(1), l.Redactor.Dropped())
+	assert.Equal(t, int64(1), l.Redactor.Redacted())
+	query := l.redactQueryLog("query", []byte(`{"name":"processes","result":[{"env":"A=1","cmdline":"ls"}],"status":0}`), "prod", "uuid", "processes")
+	assert.JSONEq(t, `{"name":"processes","result":[{"cmdline":"ls"}],"status":0}`, string(query))
+	// Events that can not be parsed are counted as dropped
+	invalid := l.redactLogs("status", []byte(`[{"severity":0,"message":"info"},"invalid"]`), "dev", "uuid")
+	assert.Nil(t, invalid)
+	assert.Nil(t, l.redactQueryLog("query", []byte(`invalid`), "prod", "uuid", "processes"))
+	assert.Equal(t, int64(4), l.Redactor.Dropped())
+}
+
+func TestNewRedactor(t *testing.T) {
+	_, err := NewRedactor(RedactionConfiguration{Rules: []RedactRule{{Name: "empty"}}})
+	assert.Error(t, err)
+	_, err = NewRedactor(RedactionConfiguration{Rules: []RedactRule{{Name: "hash", Hash: []string{"user"}}}})
+	assert.Error(t, err)
+	_, err = NewRedactor(RedactionConfiguration{Rules: []RedactRule{{Name: "mask", Mask: []MaskRule{{Column: "c", Pattern: "("}}}}})
+	assert.Error(t, err)
+}
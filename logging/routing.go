@@ -0,0 +1,414 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// RoutingKey as key in the JSON file for the routing configuration
+	RoutingKey = "routing"
+	// DefaultSinkName as name of the sink for the logger configured in the TLS service
+	DefaultSinkName = "default"
+)
+
+// ValidRouteLogTypes to check the log types in routing rules
+var ValidRouteLogTypes = map[string]bool{
+	types.StatusLog: true,
+	types.ResultLog: true,
+	types.QueryLog:  true,
+}
+
+// SinkConfiguration to hold the type and configuration file of a named sink
+type SinkConfiguration struct {
+	Type string `json:"type"`
+	File string `json:"file"`
+}
+
+// RouteMatch to hold the conditions of a routing rule, empty conditions match everything
+// Environments, queries and tags are glob patterns
+type RouteMatch struct {
+	LogType     []string `json:"logType,omitempty"`
+	Environment []string `json:"environment,omitempty"`
+	Query       []string `json:"query,omitempty"`
+	Tag         []string `json:"tag,omitempty"`
+	Severity    []int    `json:"severity,omitempty"`
+}
+
+// RouteRule to send events matching all conditions to named sinks
+// Rules are evaluated in order and a final rule stops the evaluation
+type RouteRule struct {
+	Name  string     `json:"name"`
+	Match RouteMatch `json:"match"`
+	Sinks []string   `json:"sinks"`
+	Final bool       `json:"final"`
+}
+
+// RoutingConfiguration to hold the named sinks, the rules and the default route
+type RoutingConfiguration struct {
+	Sinks   map[string]SinkConfiguration `json:"sinks"`
+	Rules   []RouteRule                  `json:"rules"`
+	Default []string                     `json:"default"`
+}
+
+// RouteEvent to hold the values of one event used by routing rules
+type RouteEvent struct {
+	LogType     string
+	Environment string
+	Query       string
+	Tags        []string
+	Severity    *int
+}
+
+// LogSink to hold one named logger used by routing rules
+type LogSink struct {
+	Name    string
+	Logging string
+	Logger  interface{}
+}
+
+// Router to select the sinks for events with routing rules
+type Router struct {
+	Config RoutingConfiguration
+	tags   bool
+}
+
+// LoadRouting - Function to load the routing configuration from JSON file
+func LoadRouting(file string) (RoutingConfiguration, error) {
+	var cfg map[string]RoutingConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return RoutingConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return RoutingConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	routing, ok := cfg[RoutingKey]
+	if !ok {
+		return RoutingConfiguration{}, fmt.Errorf("missing %s in %s", RoutingKey, file)
+	}
+	return routing, nil
+}
+
+// NewRouter to validate the routing configuration and prepare the router
+func NewRouter(config RoutingConfiguration) (*Router, error) {
+	if err := ValidateRouting(config); err != nil {
+		return nil, err
+	}
+	if len(config.Default) == 0 {
+		config.Default = []string{DefaultSinkName}
+	}
+	r := &Router{Config: config}
+	for _, rule := range config.Rules {
+		if len(rule.Match.Tag) > 0 {
+			r.tags = true
+		}
+	}
+	return r, nil
+}
+
+// ValidateRouting to check that rules use known log types, valid patterns and configured sinks
+func ValidateRouting(config RoutingConfiguration) error {
+	for name, sink := range config.Sinks {
+		if name == DefaultSinkName {
+			return fmt.Errorf("sink name %s is reserved", DefaultSinkName)
+		}
+		if sink.Type == "" {
+			return fmt.Errorf("sink %s has no type", name)
+		}
+	}
+	checkSinks := func(rule string, sinks []string) error {
+		for _, s := range sinks {
+			if _, ok := config.Sinks[s]; !ok && s != DefaultSinkName {
+				return fmt.Errorf("unknown sink %s in %s", s, rule)
+			}
+		}
+		return nil
+	}
+	for i, rule := range config.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Sinks) == 0 {
+			return fmt.Errorf("no sinks in %s", name)
+		}
+		if err := checkSinks(name, rule.Sinks); err != nil {
+			return err
+		}
+		for _, t := range rule.Match.LogType {
+			if !ValidRouteLogTypes[t] {
+				return fmt.Errorf("invalid log type %s in %s", t, name)
+			}
+		}
+		for _, patterns := range [][]string{rule.Match.Environment, rule.Match.Query, rule.Match.Tag} {
+			for _, p := range patterns {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("invalid pattern %s in %s", p, name)
+				}
+			}
+		}
+	}
+	return checkSinks("default route", config.Default)
+}
+
+// NeedsTags to know if any rule matches on node tags
+func (r *Router) NeedsTags() bool {
+	return r.tags
+}
+
+// Match to get the rules matching an event, in order and until the first final rule
+func (r *Router) Match(event RouteEvent) []RouteRule {
+	var matched []RouteRule
+	for _, rule := range r.Config.Rules {
+		if !rule.Match.matches(event) {
+			continue
+		}
+		matched = append(matched, rule)
+		if rule.Final {
+			break
+		}
+	}
+	return matched
+}
+
+// Route to get the names of the sinks for an event, using the default route when no rules match
+func (r *Router) Route(event RouteEvent) []string {
+	matched := r.Match(event)
+	if len(matched) == 0 {
+		return r.Config.Default
+	}
+	var sinks []string
+	seen := make(map[string]bool)
+	for _, rule := range matched {
+		for _, s := range rule.Sinks {
+			if !seen[s] {
+				seen[s] = true
+				sinks = append(sinks, s)
+			}
+		}
+	}
+	return sinks
+}
+
+// matches to check all conditions against an event
+func (m RouteMatch) matches(event RouteEvent) bool {
+	if len(m.LogType) > 0 && !containsString(m.LogType, event.LogType) {
+		return false
+	}
+	if len(m.Environment) > 0 && !matchPatterns(m.Environment, event.Environment) {
+		return false
+	}
+	if len(m.Query) > 0 && (event.Query == "" || !matchPatterns(m.Query, event.Query)) {
+		return false
+	}
+	if len(m.Tag) > 0 {
+		found := false
+		for _, t := range event.Tags {
+			if matchPatterns(m.Tag, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(m.Severity) > 0 {
+		if event.Severity == nil {
+			return false
+		}
+		found := false
+		for _, s := range m.Severity {
+			if s == *event.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper to check if a value matches any glob pattern
+func matchPatterns(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to check if a slice contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableRouting to create the named sinks and send events to them with routing rules
+func (logTLS *LoggerTLS) EnableRouting(config RoutingConfiguration, batchConf BatchConfiguration, dbConf backend.JSONConfigurationDB, mgr *settings.Settings, tags NodeTagsFunc) error {
+	router, err := NewRouter(config)
+	if err != nil {
+		return err
+	}
+	sinks := map[string]*LogSink{
+		DefaultSinkName: {Name: DefaultSinkName, Logging: logTLS.Logging, Logger: logTLS.Logger},
+	}
+	for name, sinkCfg := range config.Sinks {
+		var kafkaConf types.KafkaConfiguration
+		if sinkCfg.Type == settings.LoggingKafka {
+			if kafkaConf, err = LoadKafka(sinkCfg.File); err != nil {
+				return fmt.Errorf("error loading sink %s %v", name, err)
+			}
+		}
+		// DB sinks without configuration file use the same DB as the service
+		loggerSame := sinkCfg.Type == settings.LoggingDB && sinkCfg.File == ""
+		logger, err := CreateLogger(sinkCfg.Type, sinkCfg.File, types.S3Configuration{}, kafkaConf, batchConf, loggerSame, dbConf, mgr)
+		if err != nil {
+			return fmt.Errorf("error creating sink %s %v", name, err)
+		}
+		attachNodes(logger, logTLS.Nodes)
+		sinks[name] = &LogSink{Name: name, Logging: sinkCfg.Type, Logger: logger}
+	}
+	if router.NeedsTags() {
+		logTLS.cacheTags(tags)
+	}
+	logTLS.Sinks = sinks
+	logTLS.Router = router
+	return nil
+}
+
+// cacheTags to keep the tags of nodes in memory for rules matching on tags
+func (logTLS *LoggerTLS) cacheTags(tags NodeTagsFunc) {
+	if logTLS.Enricher == nil && logTLS.tagsCache == nil {
+		// Enricher without key only keeps the tags of nodes in memory
+		logTLS.tagsCache = NewEnricher("", 0, logTLS.Nodes, tags, nil)
+	}
+}
+
+// nodeTags to get the tags of a node for routing and redaction rules
+func (logTLS *LoggerTLS) nodeTags(uuid string) []string {
+	e := logTLS.Enricher
+	if e == nil {
+		e = logTLS.tagsCache
+	}
+	if e == nil {
+		return nil
+	}
+	fields, err := e.Fields(uuid)
+	if err != nil {
+		log.Err(err).Msg("error getting tags of node")
+		return nil
+	}
+	return fields.Tags
+}
+
+// NewRouteEvent to prepare the values used by rules from one event of status/result logs
+func NewRouteEvent(logType, environment string, event json.RawMessage, tags []string) RouteEvent {
+	var values struct {
+		Name     string           `json:"name"`
+		Severity *types.StringInt `json:"severity"`
+	}
+	if err := json.Unmarshal(event, &values); err != nil {
+		log.Err(err).Msg("error parsing event for rules")
+	}
+	e := RouteEvent{
+		LogType:     logType,
+		Environment: environment,
+		Query:       values.Name,
+		Tags:        tags,
+	}
+	if logType == types.StatusLog {
+		severity := 0
+		if values.Severity != nil {
+			severity = int(*values.Severity)
+		}
+		e.Severity = &severity
+	}
+	return e
+}
+
+// routeLogs to send each event of status/result logs to the sinks of the rules it matches
+func (logTLS *LoggerTLS) routeLogs(logType string, data []byte, environment, uuid string, debug bool) {
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		log.Err(err).Msg("error parsing logs for routing")
+		logTLS.sendSinks(logTLS.Router.Config.Default, logType, data, environment, uuid, debug)
+		return
+	}
+	var tags []string
+	if logTLS.Router.NeedsTags() {
+		tags = logTLS.nodeTags(uuid)
+	}
+	var order []string
+	grouped := make(map[string][]json.RawMessage)
+	for _, e := range events {
+		for _, s := range logTLS.Router.Route(NewRouteEvent(logType, environment, e, tags)) {
+			if _, ok := grouped[s]; !ok {
+				order = append(order, s)
+			}
+			grouped[s] = append(grouped[s], e)
+		}
+	}
+	for _, s := range order {
+		routed, err := json.Marshal(grouped[s])
+		if err != nil {
+			log.Err(err).Msg("error preparing routed logs")
+			continue
+		}
+		logTLS.sendSinks([]string{s}, logType, routed, environment, uuid, debug)
+	}
+}
+
+// sendSinks to send status/result logs to named sinks
+func (logTLS *LoggerTLS) sendSinks(sinks []string, logType string, data []byte, environment, uuid string, debug bool) {
+	for _, s := range sinks {
+		sink, ok := logTLS.Sinks[s]
+		if !ok {
+			log.Error().Msgf("unknown sink %s", s)
+			continue
+		}
+		if debug {
+			log.Debug().Msgf("routing %s logs to %s", logType, s)
+		}
+		sendLog(sink.Logging, sink.Logger, logType, data, environment, uuid, debug)
+	}
+}
+
+// routeQueryLog to send query result logs to the sinks of the rules they match
+func (logTLS *LoggerTLS) routeQueryLog(logType string, data []byte, environment, uuid, name string, status int, debug bool) {
+	event := RouteEvent{
+		LogType:     logType,
+		Environment: environment,
+		Query:       name,
+	}
+	if logTLS.Router.NeedsTags() {
+		event.Tags = logTLS.nodeTags(uuid)
+	}
+	for _, s := range logTLS.Router.Route(event) {
+		sink, ok := logTLS.Sinks[s]
+		if !ok {
+			log.Error().Msgf("unknown sink %s", s)
+			continue
+		}
+		if debug {
+			log.Debug().Msgf("routing %s logs to %s", logType, s)
+		}
+		sendQueryLog(sink.Logging, sink.Logger, logType, data, environment, uuid, name, status, debug)
+	}
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	r, err := NewRouter(RoutingConfiguration{
+		Sinks: map[string]SinkConfiguration{
+			"secops": {Type: "splunk", File: "splunk.json"},
+			"infra":  {Type: "elastic", File: "elastic.json"},
+		},
+		Rules: []RouteRule{
+			{Name: "incidents", Match: RouteMatch{LogType: []string{"result"}, Query: []string{"pack_ir_*"}}, Sinks: []string{"secops"}, Final: true},
+			{Name: "prod", Match: RouteMatch{Environment: []string{"prod*"}}, Sinks: []string{"infra", "default"}},
+			{Name: "errors", Match: RouteMatch{Severity: []int{2, 3}}, Sinks: []string{"secops"}},
+			{Name: "web", Match: RouteMatch{Tag: []string{"web-*"}}, Sinks: []string{"infra"}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, r.NeedsTags())
+	assert.Equal(t, []string{"secops"}, r.Route(RouteEvent{LogType: "result", Environment: "prod", Query: "pack_ir_processes"}))
+	assert.Equal(t, []string{"infra", "default"}, r.Route(RouteEvent{LogType: "result", Environment: "prod-eu", Query: "uptime"}))
+	severity := 2
+	assert.Equal(t, []string{"infra", "default", "secops"}, r.Route(RouteEvent{LogType: "status", Environment: "prod", Severity: &severity}))
+	assert.Equal(t, []string{"default"}, r.Route(RouteEvent{LogType: "result", Environment: "dev", Query: "uptime"}))
+	assert.Equal(t, []string{"infra"}, r.Route(RouteEvent{LogType: "query", Environment: "dev", Tags: []string{"db", "web-front"}}))
+}
+
+func TestValidateRouting(t *testing.T) {
+	assert.NoError(t, ValidateRouting(RoutingConfiguration{}))
+	assert.Error(t, ValidateRouting(RoutingConfiguration{Default: []string{"missing"}}))
+	assert.Error(t, ValidateRouting(RoutingConfiguration{Rules: []RouteRule{{Name: "r", Sinks: []string{"default"}, Match: RouteMatch{LogType: []string{"other"}}}}}))
+	assert.Error(t, ValidateRouting(RoutingConfiguration{Rules: []RouteRule{{Name: "r", Sinks: []string{"default"}, Match: RouteMatch{Query: []string{"[bad"}}}}}))
+	assert.Error(t, ValidateRouting(RoutingConfiguration{Sinks: map[string]SinkConfiguration{"default": {Type: "db"}}}))
+}
@@ -2,9 +2,13 @@ package logging
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"net/http"
-	"strconv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmpsec/osctrl/settings"
@@ -27,6 +31,15 @@ type LoggerS3 struct {
 	Uploader  *manager.Uploader
 	Enabled   bool
 	Debug     bool
+	host      string
+	seq       atomic.Int64
+	batch     *Batcher[s3Record]
+}
+
+// s3Record to hold one event as a line of NDJSON and the partition of the object for it
+type s3Record struct {
+	partition string
+	line      []byte
 }
 
 // CreateLoggerS3 to initialize the logger
@@ -42,6 +55,11 @@ func CreateLoggerS3(s3Config types.S3Configuration) (*LoggerS3, error) {
 	}
 	client := s3.NewFromConfig(cfg)
 	uploader := manager.NewUploader(client)
+	// Hostname keeps objects from multiple instances apart
+	host, err := os.Hostname()
+	if err != nil {
+		host = "osctrl"
+	}
 	l := &LoggerS3{
 		S3Config:  s3Config,
 		AWSConfig: cfg,
@@ -49,6 +67,7 @@ func CreateLoggerS3(s3Config types.S3Configuration) (*LoggerS3, error) {
 		Uploader:  uploader,
 		Enabled:   true,
 		Debug:     false,
+		host:      host,
 	}
 	return l, nil
 }
@@ -84,24 +103,99 @@ func (logS3 *LoggerS3) Settings(mgr *settings.Settings) {
 	log.Info().Msg("No s3 logging settings")
 }
 
+// StartBatching to roll logs in compressed objects by size or time
+func (logS3 *LoggerS3) StartBatching(config BatchConfiguration) {
+	logS3.batch = NewBatcher(config, logS3.upload)
+}
+
+// Sync to upload all batched logs, returning an error if they could not be uploaded
+func (logS3 *LoggerS3) Sync() error {
+	if logS3.batch == nil {
+		return nil
+	}
+	return logS3.batch.Sync()
+}
+
+// Close to upload all batched logs
+func (logS3 *LoggerS3) Close() {
+	if logS3.batch != nil {
+		logS3.batch.Close()
+	}
+}
+
 // Send - Function that sends JSON logs to S3
 func (logS3 *LoggerS3) Send(logType string, data []byte, environment, uuid string, debug bool) {
-	ctx := context.Background()
 	if debug {
 		log.Debug().Msgf("DebugService: Sending %d bytes to S3 for %s - %s", len(data), environment, uuid)
 	}
-	ptrContentLength := int64(len(data))
-	result, err := logS3.Uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(logS3.S3Config.Bucket),
-		Key:           aws.String(environment + "/" + logType + "/" + uuid + ":" + strconv.FormatInt(time.Now().UnixMilli(), 10) + ".json"),
-		Body:          bytes.NewBuffer(data),
-		ContentLength: &ptrContentLength,
-		ContentType:   aws.String(http.DetectContentType(data)),
-	})
-	if err != nil {
-		log.Err(err).Msg("Error sending data to s3")
+	var events []json.RawMessage
+	if logType == types.QueryLog {
+		events = append(events, json.RawMessage(data))
+	} else if err := json.Unmarshal(data, &events); err != nil {
+		log.Err(err).Msgf("error parsing log %s", string(data))
+		return
 	}
-	if debug {
-		log.Debug().Msgf("DebugService: S3 Upload %+v", result)
+	partition := path.Join(environment, logType, time.Now().UTC().Format("2006/01/02"))
+	records := make([]s3Record, 0, len(events))
+	size := 0
+	for _, e := range events {
+		var line bytes.Buffer
+		if err := json.Compact(&line, e); err != nil {
+			log.Err(err).Msg("Error parsing data")
+			continue
+		}
+		line.WriteByte('\n')
+		records = append(records, s3Record{partition: partition, line: line.Bytes()})
+		size += line.Len()
+	}
+	if logS3.batch != nil {
+		logS3.batch.Add(size, records...)
+	} else {
+		logS3.upload(records)
+	}
+}
+
+// upload to write records as one gzip-compressed NDJSON object for each partition
+func (logS3 *LoggerS3) upload(records []s3Record) error {
+	var uploadErr error
+	var partitions []string
+	lines := make(map[string][][]byte)
+	for _, r := range records {
+		if _, ok := lines[r.partition]; !ok {
+			partitions = append(partitions, r.partition)
+		}
+		lines[r.partition] = append(lines[r.partition], r.line)
+	}
+	for _, p := range partitions {
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		for _, l := range lines[p] {
+			if _, err := gz.Write(l); err != nil {
+				log.Err(err).Msg("Error compressing data for s3")
+			}
+		}
+		if err := gz.Close(); err != nil {
+			log.Err(err).Msg("Error compressing data for s3")
+			uploadErr = err
+			continue
+		}
+		key := fmt.Sprintf("%s/%s-%d-%d.ndjson.gz", p, logS3.host, time.Now().UnixMilli(), logS3.seq.Add(1))
+		ptrContentLength := int64(body.Len())
+		result, err := logS3.Uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket:        aws.String(logS3.S3Config.Bucket),
+			Key:           aws.String(key),
+			Body:          &body,
+			ContentLength: &ptrContentLength,
+			ContentType:   aws.String("application/gzip"),
+		})
+		if err != nil {
+			log.Err(err).Msgf("Error sending %d logs to s3", len(lines[p]))
+			uploadErr = err
+			continue
+		}
+		if logS3.Debug {
+			log.Debug().Msgf("DebugService: S3 Upload %+v", result)
+		}
 	}
+	return uploadErr
 }
@@ -0,0 +1,433 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SpoolKindLog for status and result logs in the spool
+	SpoolKindLog = "log"
+	// SpoolKindQuery for on-demand query results in the spool
+	SpoolKindQuery = "query"
+	// DefaultSpoolMaxSize as default maximum size in bytes of all entries in the spool
+	DefaultSpoolMaxSize int64 = 512 * 1024 * 1024
+	// DefaultSpoolWorkers as default number of workers processing entries from the spool
+	DefaultSpoolWorkers = 8
+	// spoolExt as extension for entries in the spool
+	spoolExt = ".spool"
+	// spoolTmpExt as extension for entries being written, they are discarded on startup
+	spoolTmpExt = ".tmp"
+)
+
+var (
+	// ErrSpoolFull when an entry does not fit in the spool and must be sent again later
+	ErrSpoolFull = errors.New("spool is full")
+	// ErrSpoolTooLarge when an entry is larger than the spool and can never fit in it
+	ErrSpoolTooLarge = errors.New("entry is larger than the spool")
+	// ErrSpoolClosed when the spool is not accepting entries anymore
+	ErrSpoolClosed = errors.New("spool is closed")
+)
+
+// SpoolEntry to hold one request from osquery nodes waiting to be processed
+type SpoolEntry struct {
+	Kind        string                   `json:"kind"`
+	Data        json.RawMessage          `json:"data,omitempty"`
+	LogType     string                   `json:"log_type,omitempty"`
+	Environment string                   `json:"environment,omitempty"`
+	IPAddress   string                   `json:"ip_address,omitempty"`
+	DataLen     int                      `json:"data_len,omitempty"`
+	EnvID       uint                     `json:"env_id,omitempty"`
+	Query       *types.QueryWriteRequest `json:"query,omitempty"`
+	Debug       bool                     `json:"debug,omitempty"`
+}
+
+type spoolItem struct {
+	name string
+	size int64
+}
+
+// Spool to keep ingested requests on disk until a pool of workers processes them
+// Each entry is a file in the spool directory, so entries not processed before a restart are replayed
+// With Commit, processed entries are only removed after Commit stores them, otherwise they are committed again
+// Workers are paused while committing, so Commit covers exactly the entries processed since the last commit
+type Spool struct {
+	Dir            string
+	MaxBytes       int64
+	Workers        int
+	Commit         func() error
+	CommitInterval time.Duration
+	process        func(SpoolEntry)
+	mutex          sync.Mutex
+	cond           *sync.Cond
+	queue          []spoolItem
+	acks           []spoolItem
+	pending        int64
+	bytes          int64
+	seq            uint64
+	active         int
+	paused         bool
+	closed         bool
+	wg             sync.WaitGroup
+	stop           chan struct{}
+	done           chan struct{}
+	drops          atomic.Int64
+	processed      atomic.Int64
+}
+
+// NewSpool to initialize a spool in a directory, loading the entries left by a previous run
+func NewSpool(dir string, maxBytes int64, workers int, process func(SpoolEntry)) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultSpoolMaxSize
+	}
+	if workers <= 0 {
+		workers = DefaultSpoolWorkers
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating spool directory %v", err)
+	}
+	s := &Spool{
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		Workers:  workers,
+		process:  process,
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay to queue the entries found in the spool directory, oldest first
+func (s *Spool) replay() error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("error reading spool directory %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch filepath.Ext(e.Name()) {
+		case spoolTmpExt:
+			// Incomplete writes were never acknowledged to nodes
+			if err := os.Remove(filepath.Join(s.Dir, e.Name())); err != nil {
+				log.Err(err).Msgf("error removing %s", e.Name())
+			}
+		case spoolExt:
+			info, err := e.Info()
+			if err != nil {
+				return fmt.Errorf("error reading spool entry %v", err)
+			}
+			s.queue = append(s.queue, spoolItem{name: e.Name(), size: info.Size()})
+			s.pending++
+			s.bytes += info.Size()
+		}
+	}
+	if len(s.queue) > 0 {
+		log.Info().Msgf("replaying %d entries (%d bytes) from spool %s", len(s.queue), s.bytes, s.Dir)
+	}
+	return nil
+}
+
+// Start to launch the workers processing entries and committing them
+func (s *Spool) Start() {
+	for i := 0; i < s.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	if s.Commit != nil {
+		if s.CommitInterval <= 0 {
+			s.CommitInterval = DefaultBatchInterval
+		}
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.committer()
+	}
+}
+
+// Close to stop accepting entries, wait for the workers and commit processed entries, pending entries stay on disk
+func (s *Spool) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	s.wg.Wait()
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
+
+func (s *Spool) committer() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.commit(true)
+		case <-s.stop:
+			s.commit(false)
+			return
+		}
+	}
+}
+
+// commit to remove processed entries once they are stored
+// When Commit fails, loggers keep the logs they could not write, so entries are kept until a later commit succeeds
+// Entries are only processed again when a logger dropped logs, or they stay on disk to be replayed
+func (s *Spool) commit(retry bool) {
+	s.mutex.Lock()
+	s.paused = true
+	for s.active > 0 {
+		s.cond.Wait()
+	}
+	acks := s.acks
+	s.acks = nil
+	s.mutex.Unlock()
+	err := s.Commit()
+	s.mutex.Lock()
+	s.paused = false
+	s.cond.Broadcast()
+	if err != nil {
+		log.Err(err).Msgf("error committing %d spool entries", len(acks))
+		if retry && errors.Is(err, ErrBatchDropped) {
+			s.queue = append(acks, s.queue...)
+		} else if retry {
+			s.acks = append(acks, s.acks...)
+		}
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+	for _, item := range acks {
+		s.remove(item)
+	}
+}
+
+// Enqueue to write an entry to disk before it is processed
+// It returns ErrSpoolFull when the entry does not fit, so nodes can send it again,
+// and ErrSpoolTooLarge when the entry is larger than the spool
+func (s *Spool) Enqueue(entry SpoolEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error serializing spool entry %v", err)
+	}
+	size := int64(len(data))
+	if size > s.MaxBytes {
+		s.drops.Add(1)
+		return ErrSpoolTooLarge
+	}
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrSpoolClosed
+	}
+	if s.bytes+size > s.MaxBytes {
+		s.mutex.Unlock()
+		s.drops.Add(1)
+		return ErrSpoolFull
+	}
+	// Reserve space before writing, so concurrent requests can not exceed the maximum
+	s.bytes += size
+	s.seq++
+	name := fmt.Sprintf("%020d-%08d%s", time.Now().UnixNano(), s.seq, spoolExt)
+	s.mutex.Unlock()
+	if err := s.write(name, data); err != nil {
+		s.mutex.Lock()
+		s.bytes -= size
+		s.mutex.Unlock()
+		s.drops.Add(1)
+		return err
+	}
+	s.mutex.Lock()
+	s.queue = append(s.queue, spoolItem{name: name, size: size})
+	s.pending++
+	s.cond.Signal()
+	s.mutex.Unlock()
+	return nil
+}
+
+// write to save an entry in a temporary file and rename it once it is synced to disk
+func (s *Spool) write(name string, data []byte) error {
+	tmp := filepath.Join(s.Dir, name+spoolTmpExt)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating spool entry %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error writing spool entry %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error syncing spool entry %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error closing spool entry %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error renaming spool entry %v", err)
+	}
+	return nil
+}
+
+func (s *Spool) worker() {
+	defer s.wg.Done()
+	for {
+		s.mutex.Lock()
+		for (len(s.queue) == 0 || s.paused) && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mutex.Unlock()
+			return
+		}
+		item := s.queue[0]
+		s.queue = s.queue[1:]
+		s.active++
+		s.mutex.Unlock()
+		s.handle(item)
+		s.mutex.Lock()
+		s.active--
+		if s.active == 0 {
+			s.cond.Broadcast()
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// handle to process one entry, it is removed from disk when it is committed
+func (s *Spool) handle(item spoolItem) {
+	path := filepath.Join(s.Dir, item.name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Err(err).Msgf("error reading spool entry %s", item.name)
+		s.remove(item)
+		return
+	}
+	var entry SpoolEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Err(err).Msgf("error parsing spool entry %s", item.name)
+		s.remove(item)
+		return
+	}
+	s.process(entry)
+	s.processed.Add(1)
+	if s.Commit == nil {
+		s.remove(item)
+		return
+	}
+	s.mutex.Lock()
+	s.acks = append(s.acks, item)
+	s.mutex.Unlock()
+}
+
+// remove to delete one entry from disk
+func (s *Spool) remove(item spoolItem) {
+	if err := os.Remove(filepath.Join(s.Dir, item.name)); err != nil && !os.IsNotExist(err) {
+		log.Err(err).Msgf("error removing spool entry %s", item.name)
+	}
+	s.mutex.Lock()
+	s.pending--
+	s.bytes -= item.size
+	s.mutex.Unlock()
+}
+
+// Depth to get the number of entries waiting or being processed
+func (s *Spool) Depth() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pending
+}
+
+// Bytes to get the size of the entries waiting or being processed
+func (s *Spool) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+// Drops to get the number of entries rejected because the spool was full or failed to write them
+func (s *Spool) Drops() int64 {
+	return s.drops.Load()
+}
+
+// Processed to get the number of entries processed
+func (s *Spool) Processed() int64 {
+	return s.processed.Load()
+}
+
+// StartSpool to process logs and query results from a disk-backed spool with a pool of workers
+// Entries left by a previous run are processed first
+func (l *LoggerTLS) StartSpool(dir string, maxBytes int64, workers int) error {
+	s, err := NewSpool(dir, maxBytes, workers, l.processSpoolEntry)
+	if err != nil {
+		return err
+	}
+	// Entries are removed once their logs are written by batched loggers
+	s.Commit = l.Sync
+	l.Spool = s
+	s.Start()
+	return nil
+}
+
+// processSpoolEntry to process one entry from the spool with the handler for its kind
+func (l *LoggerTLS) processSpoolEntry(entry SpoolEntry) {
+	switch entry.Kind {
+	case SpoolKindLog:
+		l.ProcessLogs(entry.Data, entry.LogType, entry.Environment, entry.IPAddress, entry.DataLen, entry.Debug)
+	case SpoolKindQuery:
+		if entry.Query != nil {
+			l.ProcessLogQueryResult(*entry.Query, entry.EnvID, entry.Debug)
+		}
+	default:
+		log.Error().Msgf("unknown spool entry kind %s", entry.Kind)
+	}
+}
+
+// IngestLogs to queue logs in the spool, or to process them in the background without spool
+func (l *LoggerTLS) IngestLogs(data json.RawMessage, logType, environment, ipaddress string, dataLen int, debug bool) error {
+	if l.Spool == nil {
+		go l.ProcessLogs(data, logType, environment, ipaddress, dataLen, debug)
+		return nil
+	}
+	return l.Spool.Enqueue(SpoolEntry{
+		Kind:        SpoolKindLog,
+		Data:        data,
+		LogType:     logType,
+		Environment: environment,
+		IPAddress:   ipaddress,
+		DataLen:     dataLen,
+		Debug:       debug,
+	})
+}
+
+// IngestQueryResult to queue on-demand query results in the spool, or to process them in the background without spool
+func (l *LoggerTLS) IngestQueryResult(queriesWrite types.QueryWriteRequest, envid uint, debug bool) error {
+	if l.Spool == nil {
+		go l.ProcessLogQueryResult(queriesWrite, envid, debug)
+		return nil
+	}
+	return l.Spool.Enqueue(SpoolEntry{
+		Kind:  SpoolKindQuery,
+		Query: &queriesWrite,
+		EnvID: envid,
+		Debug: debug,
+	})
+}
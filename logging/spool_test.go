@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoolFull(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 30, 1, func(SpoolEntry) {})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Data: json.RawMessage(`{}`)}))
+	assert.ErrorIs(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Data: json.RawMessage(`{}`)}), ErrSpoolFull)
+	assert.Equal(t, int64(1), s.Depth())
+	assert.Equal(t, int64(1), s.Drops())
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0, 1, func(SpoolEntry) {})
+	assert.NoError(t, err)
+	for _, env := range []string{"dev", "prod"} {
+		assert.NoError(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Environment: env, Data: json.RawMessage(`[]`)}))
+	}
+	// Spool was never started, so a new one processes the entries in order
+	var mutex sync.Mutex
+	var envs []string
+	s, err = NewSpool(dir, 0, 1, func(e SpoolEntry) {
+		mutex.Lock()
+		envs = append(envs, e.Environment)
+		mutex.Unlock()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), s.Depth())
+	s.Start()
+	assert.Eventually(t, func() bool { return s.Depth() == 0 }, time.Second, 10*time.Millisecond)
+	s.Close()
+	assert.Equal(t, []string{"dev", "prod"}, envs)
+	assert.Equal(t, int64(2), s.Processed())
+}
+
+func TestSpoolTooLarge(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 30, 1, func(SpoolEntry) {})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Data: json.RawMessage(`["too large for the spool"]`)}), ErrSpoolTooLarge)
+	assert.Equal(t, int64(0), s.Depth())
+}
+
+func TestSpoolCommit(t *testing.T) {
+	dir := t.TempDir()
+	var processed atomic.Int64
+	s, err := NewSpool(dir, 0, 1, func(SpoolEntry) { processed.Add(1) })
+	assert.NoError(t, err)
+	var failing atomic.Bool
+	var commits atomic.Int64
+	failing.Store(true)
+	s.Commit = func() error {
+		commits.Add(1)
+		if failing.Load() {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+	s.CommitInterval = 10 * time.Millisecond
+	s.Start()
+	assert.NoError(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Data: json.RawMessage(`[]`)}))
+	// Entries are kept, but not processed again, until they are committed
+	assert.Eventually(t, func() bool { return commits.Load() > 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(1), processed.Load())
+	assert.Equal(t, int64(1), s.Depth())
+	failing.Store(false)
+	assert.Eventually(t, func() bool { return s.Depth() == 0 }, time.Second, 5*time.Millisecond)
+	s.Close()
+	assert.Equal(t, int64(1), processed.Load())
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpoolCommitDropped(t *testing.T) {
+	var processed atomic.Int64
+	s, err := NewSpool(t.TempDir(), 0, 1, func(SpoolEntry) { processed.Add(1) })
+	assert.NoError(t, err)
+	s.Commit = func() error {
+		if processed.Load() == 1 {
+			return ErrBatchDropped
+		}
+		return nil
+	}
+	s.CommitInterval = 10 * time.Millisecond
+	s.Start()
+	assert.NoError(t, s.Enqueue(SpoolEntry{Kind: SpoolKindLog, Data: json.RawMessage(`[]`)}))
+	// Entries are processed again when loggers dropped their logs
+	assert.Eventually(t, func() bool { return s.Depth() == 0 }, time.Second, 5*time.Millisecond)
+	s.Close()
+	assert.Equal(t, int64(2), processed.Load())
+}
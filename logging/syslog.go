@@ -0,0 +1,401 @@
+package logging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	// SyslogUDP for syslog over UDP
+	SyslogUDP = "udp"
+	// SyslogTCP for syslog over TCP
+	SyslogTCP = "tcp"
+	// SyslogTLS for syslog over TLS
+	SyslogTLS = "tls"
+	// SyslogRFC5424 for messages formatted as RFC 5424
+	SyslogRFC5424 = "rfc5424"
+	// SyslogRFC3164 for messages formatted as RFC 3164 (BSD syslog)
+	SyslogRFC3164 = "rfc3164"
+	// SyslogOctetCounting for messages framed with their length over TCP and TLS (RFC 6587)
+	SyslogOctetCounting = "octet-counting"
+	// SyslogNonTransparent for messages framed with a new line over TCP and TLS (RFC 6587)
+	SyslogNonTransparent = "non-transparent"
+	// DefaultSyslogFacility as default facility of messages
+	DefaultSyslogFacility = "local0"
+	// DefaultSyslogAppName as default application name of messages
+	DefaultSyslogAppName = "osctrl"
+	// DefaultSyslogBuffer as default number of messages kept while the relay is not reachable
+	DefaultSyslogBuffer = 10000
+)
+
+const (
+	syslogTimeout     = 5 * time.Second
+	syslogMinBackoff  = 500 * time.Millisecond
+	syslogMaxBackoff  = 30 * time.Second
+	syslogCloseWait   = 10 * time.Second
+	syslogInfo        = 6
+	syslogWarning     = 4
+	syslogError       = 3
+	syslogCritical    = 2
+	syslogRFC3164Time = "Jan _2 15:04:05"
+	// Size of messages that relays must accept over UDP, see RFC 5426
+	syslogUDPSize = 2048
+)
+
+// SyslogFacilities to map names of syslog facilities to their codes
+var SyslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogConfiguration to hold all syslog configuration values
+type SyslogConfiguration struct {
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	Protocol   string `json:"protocol"`
+	Format     string `json:"format"`
+	Framing    string `json:"framing"`
+	Facility   string `json:"facility"`
+	AppName    string `json:"appName"`
+	Hostname   string `json:"hostname"`
+	CAFile     string `json:"caFile"`
+	Insecure   bool   `json:"insecure"`
+	BufferSize int    `json:"bufferSize"`
+}
+
+// LoggerSyslog will be used to log data using a syslog relay
+type LoggerSyslog struct {
+	Configuration SyslogConfiguration
+	Enabled       bool
+	facility      int
+	tlsConfig     *tls.Config
+	conn          net.Conn
+	queue         chan []byte
+	mutex         sync.RWMutex
+	closed        bool
+	stop          chan struct{}
+	done          chan struct{}
+	dropped       atomic.Int64
+	truncated     atomic.Int64
+}
+
+// LoadSyslog - Function to load the syslog configuration from JSON file
+func LoadSyslog(file string) (SyslogConfiguration, error) {
+	var _syslogCfg SyslogConfiguration
+	log.Info().Msgf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return _syslogCfg, err
+	}
+	cfgRaw := viper.Sub(settings.LoggingSyslog)
+	if cfgRaw == nil {
+		return _syslogCfg, fmt.Errorf("missing %s in %s", settings.LoggingSyslog, file)
+	}
+	if err := cfgRaw.Unmarshal(&_syslogCfg); err != nil {
+		return _syslogCfg, err
+	}
+	// No errors!
+	return _syslogCfg, nil
+}
+
+// CreateLoggerSyslog to initialize the logger
+func CreateLoggerSyslog(syslogFile string) (*LoggerSyslog, error) {
+	config, err := LoadSyslog(syslogFile)
+	if err != nil {
+		return nil, err
+	}
+	return CreateLoggerSyslogConfig(config)
+}
+
+// CreateLoggerSyslogConfig to initialize the logger without reading a config file
+func CreateLoggerSyslogConfig(config SyslogConfiguration) (*LoggerSyslog, error) {
+	if config.Protocol == "" {
+		config.Protocol = SyslogUDP
+	}
+	if config.Protocol != SyslogUDP && config.Protocol != SyslogTCP && config.Protocol != SyslogTLS {
+		return nil, fmt.Errorf("invalid syslog protocol %s", config.Protocol)
+	}
+	if config.Format == "" {
+		config.Format = SyslogRFC5424
+	}
+	if config.Format != SyslogRFC5424 && config.Format != SyslogRFC3164 {
+		return nil, fmt.Errorf("invalid syslog format %s", config.Format)
+	}
+	if config.Framing == "" {
+		config.Framing = SyslogOctetCounting
+	}
+	if config.Framing != SyslogOctetCounting && config.Framing != SyslogNonTransparent {
+		return nil, fmt.Errorf("invalid syslog framing %s", config.Framing)
+	}
+	if config.Facility == "" {
+		config.Facility = DefaultSyslogFacility
+	}
+	facility, ok := SyslogFacilities[config.Facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %s", config.Facility)
+	}
+	if config.AppName == "" {
+		config.AppName = DefaultSyslogAppName
+	}
+	if config.Hostname == "" {
+		if config.Hostname, _ = os.Hostname(); config.Hostname == "" {
+			config.Hostname = "-"
+		}
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultSyslogBuffer
+	}
+	l := &LoggerSyslog{
+		Configuration: config,
+		Enabled:       true,
+		facility:      facility,
+		queue:         make(chan []byte, config.BufferSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if config.Protocol == SyslogTLS {
+		l.tlsConfig = &tls.Config{
+			ServerName:         config.Host,
+			InsecureSkipVerify: config.Insecure,
+		}
+		if config.CAFile != "" {
+			ca, err := os.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading CA %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("invalid CA in %s", config.CAFile)
+			}
+			l.tlsConfig.RootCAs = pool
+		}
+	}
+	go l.writer()
+	return l, nil
+}
+
+// Settings - Function to prepare settings for the logger
+func (logSyslog *LoggerSyslog) Settings(mgr *settings.Settings) {
+	log.Info().Msg("No syslog logging settings")
+}
+
+// Send - Function that sends JSON logs to syslog, one message for each event
+func (logSyslog *LoggerSyslog) Send(logType string, data []byte, environment, uuid string, debug bool) {
+	if debug {
+		log.Debug().Msgf("DebugService: Sending %d bytes to syslog for %s - %s", len(data), environment, uuid)
+	}
+	var events []json.RawMessage
+	if logType == types.QueryLog {
+		events = append(events, json.RawMessage(data))
+	} else if err := json.Unmarshal(data, &events); err != nil {
+		log.Err(err).Msgf("error parsing log %s", string(data))
+		return
+	}
+	now := time.Now()
+	for _, e := range events {
+		severity := syslogInfo
+		if logType == types.StatusLog {
+			severity = SyslogSeverity(e)
+		}
+		msg := logSyslog.Format(now, severity, logType, e)
+		if !logSyslog.enqueue(msg) {
+			logSyslog.dropped.Add(1)
+			log.Error().Msg("syslog buffer is full, dropping message")
+		}
+	}
+}
+
+// SyslogSeverity to map the severity of osquery status logs to syslog severities
+func SyslogSeverity(event json.RawMessage) int {
+	var status struct {
+		Severity types.StringInt `json:"severity"`
+	}
+	if err := json.Unmarshal(event, &status); err != nil {
+		return syslogInfo
+	}
+	switch {
+	case status.Severity >= 3:
+		return syslogCritical
+	case status.Severity == types.SeverityError:
+		return syslogError
+	case status.Severity == 1:
+		return syslogWarning
+	}
+	return syslogInfo
+}
+
+// Format to prepare one syslog message, framed for the configured protocol
+func (logSyslog *LoggerSyslog) Format(t time.Time, severity int, msgID string, msg []byte) []byte {
+	cfg := logSyslog.Configuration
+	pri := logSyslog.facility*8 + severity
+	var header string
+	if cfg.Format == SyslogRFC3164 {
+		header = fmt.Sprintf("<%d>%s %s %s: ", pri, t.Format(syslogRFC3164Time), cfg.Hostname, cfg.AppName)
+	} else {
+		header = fmt.Sprintf("<%d>1 %s %s %s - %s - ", pri, t.UTC().Format(time.RFC3339Nano), cfg.Hostname, cfg.AppName, msgID)
+	}
+	frame := make([]byte, 0, len(header)+len(msg)+8)
+	if cfg.Protocol != SyslogUDP && cfg.Framing == SyslogOctetCounting {
+		frame = append(frame, strconv.Itoa(len(header)+len(msg))...)
+		frame = append(frame, ' ')
+	}
+	frame = append(frame, header...)
+	frame = append(frame, msg...)
+	if cfg.Protocol != SyslogUDP && cfg.Framing == SyslogNonTransparent {
+		frame = append(frame, '\n')
+	}
+	return frame
+}
+
+// enqueue to buffer one message for the writer, returning false if the buffer is full
+func (logSyslog *LoggerSyslog) enqueue(msg []byte) bool {
+	logSyslog.mutex.RLock()
+	defer logSyslog.mutex.RUnlock()
+	if logSyslog.closed {
+		return false
+	}
+	select {
+	case logSyslog.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// writer to send buffered messages, reconnecting with backoff when the relay is not reachable
+func (logSyslog *LoggerSyslog) writer() {
+	defer close(logSyslog.done)
+	backoff := syslogMinBackoff
+	for msg := range logSyslog.queue {
+		for {
+			err := logSyslog.write(msg)
+			if err == nil {
+				backoff = syslogMinBackoff
+				break
+			}
+			if !syslogTransient(err) {
+				// Sending again the same message would fail forever
+				if logSyslog.Configuration.Protocol == SyslogUDP && len(msg) > syslogUDPSize {
+					log.Err(err).Msgf("error sending %d bytes to syslog, truncating to %d", len(msg), syslogUDPSize)
+					logSyslog.truncated.Add(1)
+					msg = msg[:syslogUDPSize]
+					continue
+				}
+				log.Err(err).Msgf("error sending %d bytes to syslog, dropping message", len(msg))
+				logSyslog.dropped.Add(1)
+				break
+			}
+			log.Err(err).Msgf("error sending to syslog, retrying in %s", backoff)
+			logSyslog.disconnect()
+			select {
+			case <-time.After(backoff):
+			case <-logSyslog.stop:
+				return
+			}
+			if backoff *= 2; backoff > syslogMaxBackoff {
+				backoff = syslogMaxBackoff
+			}
+		}
+	}
+	logSyslog.disconnect()
+}
+
+// write to send one message, connecting first if needed
+func (logSyslog *LoggerSyslog) write(msg []byte) error {
+	if logSyslog.conn == nil {
+		addr := net.JoinHostPort(logSyslog.Configuration.Host, logSyslog.Configuration.Port)
+		dialer := &net.Dialer{Timeout: syslogTimeout}
+		var conn net.Conn
+		var err error
+		if logSyslog.tlsConfig != nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", addr, logSyslog.tlsConfig)
+		} else {
+			conn, err = dialer.Dial(logSyslog.Configuration.Protocol, addr)
+		}
+		if err != nil {
+			return fmt.Errorf("error connecting to syslog %v", err)
+		}
+		logSyslog.conn = conn
+	}
+	if err := logSyslog.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+		return err
+	}
+	_, err := logSyslog.conn.Write(msg)
+	return err
+}
+
+// syslogTransient to check if an error sending one message can be solved by sending it again
+// Errors with the message itself, like messages too long for UDP, are final
+func syslogTransient(err error) bool {
+	return !errors.Is(err, syscall.EMSGSIZE)
+}
+
+// Dropped to get the number of messages not sent because the buffer was full or the relay rejected them
+func (logSyslog *LoggerSyslog) Dropped() int64 {
+	return logSyslog.dropped.Load()
+}
+
+// Truncated to get the number of messages truncated to be sent over UDP
+func (logSyslog *LoggerSyslog) Truncated() int64 {
+	return logSyslog.truncated.Load()
+}
+
+func (logSyslog *LoggerSyslog) disconnect() {
+	if logSyslog.conn != nil {
+		logSyslog.conn.Close()
+		logSyslog.conn = nil
+	}
+}
+
+// Close to send buffered messages and stop the writer, giving up after a while if the relay is not reachable
+func (logSyslog *LoggerSyslog) Close() {
+	logSyslog.mutex.Lock()
+	if logSyslog.closed {
+		logSyslog.mutex.Unlock()
+		return
+	}
+	logSyslog.closed = true
+	close(logSyslog.queue)
+	logSyslog.mutex.Unlock()
+	select {
+	case <-logSyslog.done:
+	case <-time.After(syslogCloseWait):
+		log.Error().Msgf("dropping %d messages not sent to syslog", len(logSyslog.queue))
+		close(logSyslog.stop)
+		<-logSyslog.done
+	}
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 10, 20, 30, 0, time.UTC)
+	l, err := CreateLoggerSyslogConfig(SyslogConfiguration{Host: "127.0.0.1", Port: "1", Protocol: SyslogTCP, Hostname: "tls1"})
+	assert.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "65 <132>1 2024-03-05T10:20:30Z tls1 osctrl - status - {\"severity\":1}", string(l.Format(ts, syslogWarning, "status", []byte(`{"severity":1}`))))
+	l.Configuration.Format = SyslogRFC3164
+	l.Configuration.Framing = SyslogNonTransparent
+	assert.Equal(t, "<134>Mar  5 10:20:30 tls1 osctrl: {}\n", string(l.Format(ts, syslogInfo, "result", []byte(`{}`))))
+	_, err = CreateLoggerSyslogConfig(SyslogConfiguration{Facility: "local9"})
+	assert.Error(t, err)
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	assert.Equal(t, syslogInfo, SyslogSeverity([]byte(`{"severity":"0"}`)))
+	assert.Equal(t, syslogWarning, SyslogSeverity([]byte(`{"severity":1}`)))
+	assert.Equal(t, syslogError, SyslogSeverity([]byte(`{"severity":"2"}`)))
+	assert.Equal(t, syslogCritical, SyslogSeverity([]byte(`{"severity":3}`)))
+}
+
+func TestSyslogSendTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	l, err := CreateLoggerSyslogConfig(SyslogConfiguration{Host: host, Port: port, Protocol: SyslogTCP, Framing: SyslogNonTransparent})
+	assert.NoError(t, err)
+	l.Send("status", []byte(`[{"severity":"2","message":"a"},{"severity":"0","message":"b"}]`), "prod", "uuid", false)
+	l.Close()
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	first, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	second, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "<131>1 "))
+	assert.True(t, strings.HasSuffix(first, ` {"severity":"2","message":"a"}`+"\n"))
+	assert.True(t, strings.HasPrefix(second, "<134>1 "))
+}
+
+func TestSyslogSendUDPTooLong(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	host, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	l, err := CreateLoggerSyslogConfig(SyslogConfiguration{Host: host, Port: port, Protocol: SyslogUDP})
+	assert.NoError(t, err)
+	// Messages over the maximum size of UDP datagrams are truncated instead of retried forever
+	long := `{"message":"` + strings.Repeat("a", 70000) + `"}`
+	l.Send("result", []byte(`[`+long+`,{"message":"b"}]`), "prod", "uuid", false)
+	l.Close()
+	assert.Equal(t, int64(1), l.Truncated())
+	assert.Equal(t, int64(0), l.Dropped())
+	buf := make([]byte, 80000)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, syslogUDPSize, n)
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(buf[:n]), `{"message":"b"}`))
+}
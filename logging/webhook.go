@@ -0,0 +1,408 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// WebhookAuthBearer to authenticate requests with a bearer token
+	WebhookAuthBearer = "bearer"
+	// WebhookAuthBasic to authenticate requests with username and password
+	WebhookAuthBasic = "basic"
+	// WebhookAuthHMAC to sign the body of requests with a shared secret
+	WebhookAuthHMAC = "hmac"
+	// DefaultWebhookSignatureHeader as default header for the HMAC signature of requests
+	DefaultWebhookSignatureHeader = "X-Osctrl-Signature"
+	// DefaultWebhookTemplate as key for the body template used by log types without their own
+	DefaultWebhookTemplate = "default"
+	// DefaultWebhookRetries as default number of retries of failed requests
+	DefaultWebhookRetries = 3
+	// DefaultWebhookBackoff as default milliseconds to wait before the first retry, doubled on each retry
+	DefaultWebhookBackoff = 1000
+	// DefaultWebhookTimeout as default seconds to wait for each request
+	DefaultWebhookTimeout = 30
+)
+
+const webhookMaxBackoff = 30 * time.Second
+
+// WebhookAuth to hold the authentication of webhook requests
+type WebhookAuth struct {
+	Type     string `json:"type"`
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Secret   string `json:"secret"`
+	Header   string `json:"header"`
+}
+
+// WebhookConfiguration to hold all webhook configuration values
+// Templates are Go templates for the body of requests by log type, executed with a WebhookPayload
+type WebhookConfiguration struct {
+	URL           string            `json:"url"`
+	Method        string            `json:"method"`
+	Headers       map[string]string `json:"headers"`
+	Auth          WebhookAuth       `json:"auth"`
+	Templates     map[string]string `json:"templates"`
+	ContentType   string            `json:"contentType"`
+	Gzip          bool              `json:"gzip"`
+	BatchSize     int               `json:"batchSize"`
+	BatchBytes    int               `json:"batchBytes"`
+	BatchInterval int               `json:"batchInterval"`
+	Retries       int               `json:"retries"`
+	Backoff       int               `json:"backoff"`
+	Timeout       int               `json:"timeout"`
+	DeadLetter    string            `json:"deadLetter"`
+}
+
+// WebhookEvent to hold one event sent to a webhook
+type WebhookEvent struct {
+	LogType     string          `json:"log_type"`
+	Environment string          `json:"environment"`
+	UUID        string          `json:"uuid"`
+	Time        time.Time       `json:"time"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// WebhookPayload to hold the events of one request, as passed to body templates
+type WebhookPayload struct {
+	LogType string
+	Events  []WebhookEvent
+}
+
+// LoggerWebhook will be used to log data using a generic HTTP endpoint
+type LoggerWebhook struct {
+	Configuration WebhookConfiguration
+	Enabled       bool
+	Client        *http.Client
+	templates     map[string]*template.Template
+	batch         *Batcher[WebhookEvent]
+	deadMutex     sync.Mutex
+}
+
+// WebhookFuncs as functions available to body templates
+var WebhookFuncs = template.FuncMap{
+	// json to serialize any value, raw events are kept as they are
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// quote to serialize a raw event or any text as a JSON string
+	"quote": func(v interface{}) (string, error) {
+		var s string
+		switch t := v.(type) {
+		case json.RawMessage:
+			s = string(t)
+		case []byte:
+			s = string(t)
+		default:
+			s = fmt.Sprint(t)
+		}
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+}
+
+// LoadWebhook - Function to load the webhook configuration from JSON file
+func LoadWebhook(file string) (WebhookConfiguration, error) {
+	var cfg map[string]WebhookConfiguration
+	log.Info().Msgf("Loading %s", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return WebhookConfiguration{}, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return WebhookConfiguration{}, fmt.Errorf("error parsing %s %v", file, err)
+	}
+	webhookCfg, ok := cfg[settings.LoggingWebhook]
+	if !ok {
+		return WebhookConfiguration{}, fmt.Errorf("missing %s in %s", settings.LoggingWebhook, file)
+	}
+	return webhookCfg, nil
+}
+
+// CreateLoggerWebhook to initialize the logger
+func CreateLoggerWebhook(webhookFile string) (*LoggerWebhook, error) {
+	config, err := LoadWebhook(webhookFile)
+	if err != nil {
+		return nil, err
+	}
+	return CreateLoggerWebhookConfig(config)
+}
+
+// CreateLoggerWebhookConfig to initialize the logger without reading a config file
+func CreateLoggerWebhookConfig(config WebhookConfiguration) (*LoggerWebhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("missing webhook url")
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.ContentType == "" {
+		config.ContentType = utils.JSONApplicationUTF8
+	}
+	switch config.Auth.Type {
+	case "", WebhookAuthBearer, WebhookAuthBasic:
+	case WebhookAuthHMAC:
+		if config.Auth.Secret == "" {
+			return nil, fmt.Errorf("missing secret for webhook signature")
+		}
+		if config.Auth.Header == "" {
+			config.Auth.Header = DefaultWebhookSignatureHeader
+		}
+	default:
+		return nil, fmt.Errorf("invalid webhook auth %s", config.Auth.Type)
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	} else if config.Retries == 0 {
+		config.Retries = DefaultWebhookRetries
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultWebhookBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+	l := &LoggerWebhook{
+		Configuration: config,
+		Enabled:       true,
+		Client:        &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		templates:     make(map[string]*template.Template),
+	}
+	for logType, text := range config.Templates {
+		tmpl, err := template.New(logType).Funcs(WebhookFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template for %s %v", logType, err)
+		}
+		l.templates[logType] = tmpl
+	}
+	return l, nil
+}
+
+// Settings - Function to prepare settings for the logger
+func (logW *LoggerWebhook) Settings(mgr *settings.Settings) {
+	log.Info().Msg("No webhook logging settings")
+}
+
+// StartBatching to group events before sending them, limits in the configuration take precedence
+func (logW *LoggerWebhook) StartBatching(config BatchConfiguration) {
+	if logW.Configuration.BatchSize > 0 {
+		config.Size = logW.Configuration.BatchSize
+	}
+	if logW.Configuration.BatchBytes > 0 {
+		config.Bytes = logW.Configuration.BatchBytes
+	}
+	if logW.Configuration.BatchInterval > 0 {
+		config.Interval = time.Duration(logW.Configuration.BatchInterval) * time.Second
+	}
+	logW.batch = NewBatcher(config, logW.flush)
+}
+
+// Sync to send all batched events, returning an error if they could not be sent
+func (logW *LoggerWebhook) Sync() error {
+	if logW.batch == nil {
+		return nil
+	}
+	return logW.batch.Sync()
+}
+
+// Close to send all batched events
+func (logW *LoggerWebhook) Close() {
+	if logW.batch != nil {
+		logW.batch.Close()
+	}
+}
+
+// Send - Function that sends JSON logs to the webhook, status and result logs are split in events
+func (logW *LoggerWebhook) Send(logType string, data []byte, environment, uuid string, debug bool) {
+	if debug {
+		log.Debug().Msgf("DebugService: Sending %d bytes to webhook for %s - %s", len(data), environment, uuid)
+	}
+	var raw []json.RawMessage
+	if logType == types.QueryLog {
+		raw = append(raw, json.RawMessage(data))
+	} else if err := json.Unmarshal(data, &raw); err != nil {
+		log.Err(err).Msgf("error parsing log %s", string(data))
+		return
+	}
+	now := time.Now().UTC()
+	events := make([]WebhookEvent, 0, len(raw))
+	for _, r := range raw {
+		events = append(events, WebhookEvent{
+			LogType:     logType,
+			Environment: environment,
+			UUID:        uuid,
+			Time:        now,
+			Data:        r,
+		})
+	}
+	if logW.batch != nil {
+		logW.batch.Add(len(data), events...)
+		return
+	}
+	logW.flush(events)
+}
+
+// flush to send events with one request per log type, writing them to the dead letter file if it fails
+func (logW *LoggerWebhook) flush(events []WebhookEvent) error {
+	var flushErr error
+	var order []string
+	byType := make(map[string][]WebhookEvent)
+	for _, e := range events {
+		if _, ok := byType[e.LogType]; !ok {
+			order = append(order, e.LogType)
+		}
+		byType[e.LogType] = append(byType[e.LogType], e)
+	}
+	for _, logType := range order {
+		payload := WebhookPayload{LogType: logType, Events: byType[logType]}
+		body, err := logW.Body(payload)
+		if err == nil {
+			err = logW.post(body)
+		}
+		if err != nil {
+			log.Err(err).Msgf("error sending %d %s events to webhook", len(payload.Events), logType)
+			// Events saved in the dead letter file are not lost
+			if dErr := logW.deadLetter(payload.Events); dErr != nil && flushErr == nil {
+				flushErr = err
+			}
+		}
+	}
+	return flushErr
+}
+
+// Body to prepare the body of one request with the template of the log type
+// Without templates, the body is a JSON array of the events as received
+func (logW *LoggerWebhook) Body(payload WebhookPayload) ([]byte, error) {
+	tmpl, ok := logW.templates[payload.LogType]
+	if !ok {
+		tmpl, ok = logW.templates[DefaultWebhookTemplate]
+	}
+	if !ok {
+		raw := make([]json.RawMessage, 0, len(payload.Events))
+		for _, e := range payload.Events {
+			raw = append(raw, e.Data)
+		}
+		return json.Marshal(raw)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, payload); err != nil {
+		return nil, fmt.Errorf("error executing template %v", err)
+	}
+	return body.Bytes(), nil
+}
+
+// post to send one request, retrying with exponential backoff unless it is rejected
+func (logW *LoggerWebhook) post(body []byte) error {
+	encoding := ""
+	if logW.Configuration.Gzip {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		if _, err := w.Write(body); err != nil {
+			return fmt.Errorf("error compressing body %v", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error compressing body %v", err)
+		}
+		body = gz.Bytes()
+		encoding = "gzip"
+	}
+	backoff := time.Duration(logW.Configuration.Backoff) * time.Millisecond
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = logW.request(body, encoding); err == nil || !retry || attempt >= logW.Configuration.Retries {
+			return err
+		}
+		log.Err(err).Msgf("error sending to webhook, retrying in %s", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// request to send the body once, returning if a failed request can be retried
+func (logW *LoggerWebhook) request(body []byte, encoding string) (bool, error) {
+	cfg := logW.Configuration
+	req, err := http.NewRequest(cfg.Method, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error preparing request %v", err)
+	}
+	req.Header.Set(utils.UserAgent, utils.OsctrlUserAgent)
+	req.Header.Set(utils.ContentType, cfg.ContentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	switch cfg.Auth.Type {
+	case WebhookAuthBearer:
+		req.Header.Set(utils.Authorization, "Bearer "+cfg.Auth.Token)
+	case WebhookAuthBasic:
+		req.SetBasicAuth(cfg.Auth.Username, cfg.Auth.Password)
+	case WebhookAuthHMAC:
+		req.Header.Set(cfg.Auth.Header, WebhookSignature(cfg.Auth.Secret, body))
+	}
+	resp, err := logW.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error sending request %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// WebhookSignature to sign a body with HMAC-SHA256, as sent in the signature header
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter to append events that could not be sent to the dead letter file, one JSON per line
+func (logW *LoggerWebhook) deadLetter(events []WebhookEvent) error {
+	if logW.Configuration.DeadLetter == "" {
+		log.Error().Msgf("dropping %d events not sent to webhook", len(events))
+		return fmt.Errorf("no dead letter file")
+	}
+	logW.deadMutex.Lock()
+	defer logW.deadMutex.Unlock()
+	f, err := os.OpenFile(logW.Configuration.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Err(err).Msgf("error opening dead letter file, dropping %d events", len(events))
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			log.Err(err).Msg("error writing dead letter event")
+			return err
+		}
+	}
+	return nil
+}
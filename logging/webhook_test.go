@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookBody(t *testing.T) {
+	l, err := CreateLoggerWebhookConfig(WebhookConfiguration{
+		URL:       "http://localhost",
+		Templates: map[string]string{"status": `{"lines":[{{range $i, $e := .Events}}{{if $i}},{{end}}{{quote $e.Data}}{{end}}],"env":{{json (index .Events 0).Environment}}}`},
+	})
+	assert.NoError(t, err)
+	events := []WebhookEvent{
+		{LogType: "status", Environment: "prod", Data: json.RawMessage(`{"a":1}`)},
+		{LogType: "status", Environment: "prod", Data: json.RawMessage(`{"b":2}`)},
+	}
+	body, err := l.Body(WebhookPayload{LogType: "status", Events: events})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"lines":["{\"a\":1}","{\"b\":2}"],"env":"prod"}`, string(body))
+	body, err = l.Body(WebhookPayload{LogType: "result", Events: events})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"a":1},{"b":2}]`, string(body))
+	_, err = CreateLoggerWebhookConfig(WebhookConfiguration{URL: "http://localhost", Auth: WebhookAuth{Type: WebhookAuthHMAC}})
+	assert.Error(t, err)
+}
+
+func TestWebhookSend(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(gz)
+		assert.Equal(t, WebhookSignature("secret", mustGzip(t, body)), r.Header.Get(DefaultWebhookSignatureHeader))
+		assert.JSONEq(t, `[{"name":"q1"},{"name":"q2"}]`, string(body))
+	}))
+	defer server.Close()
+	l, err := CreateLoggerWebhookConfig(WebhookConfiguration{
+		URL:     server.URL,
+		Gzip:    true,
+		Auth:    WebhookAuth{Type: WebhookAuthHMAC, Secret: "secret"},
+		Backoff: 1,
+	})
+	assert.NoError(t, err)
+	l.Send("result", []byte(`[{"name":"q1"},{"name":"q2"}]`), "prod", "uuid", false)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	dead := filepath.Join(t.TempDir(), "dead.ndjson")
+	l, err := CreateLoggerWebhookConfig(WebhookConfiguration{URL: server.URL, DeadLetter: dead, Backoff: 1})
+	assert.NoError(t, err)
+	l.Send("query", []byte(`{"name":"q1"}`), "prod", "uuid", false)
+	data, err := os.ReadFile(dead)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 1)
+	var event WebhookEvent
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(t, "query", event.LogType)
+	assert.JSONEq(t, `{"name":"q1"}`, string(event.Data))
+}
+
+// mustGzip to compress a body the same way the webhook logger does
+func mustGzip(t *testing.T, body []byte) []byte {
+	var buf strings.Builder
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(body)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return []byte(buf.String())
+}
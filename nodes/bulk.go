@@ -0,0 +1,98 @@
+package nodes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BulkDelete to archive and delete nodes
+	BulkDelete = "delete"
+	// BulkTag to tag nodes
+	BulkTag = "tag"
+	// BulkUntag to untag nodes
+	BulkUntag = "untag"
+	// BulkMove to move nodes to a different environment
+	BulkMove = "move"
+)
+
+// BulkActions to check the supported bulk actions
+var BulkActions = map[string]bool{
+	BulkDelete: true,
+	BulkTag:    true,
+	BulkUntag:  true,
+	BulkMove:   true,
+}
+
+// DefaultBulkTokenExpiration as default time to confirm a bulk action after previewing it
+const DefaultBulkTokenExpiration = 10 * time.Minute
+
+// NodeSelector to pick the nodes affected by a bulk action
+type NodeSelector struct {
+	EnvironmentID uint
+	Platform      string
+	// Inactive selects nodes not seen for at least this many hours, zero for any node
+	Inactive int64
+	// Tagged restricts the selection to the node IDs in IDs
+	Tagged bool
+	IDs    []uint
+}
+
+// GetByNodeSelector to retrieve the nodes matching a selector
+func (n *NodeManager) GetByNodeSelector(sel NodeSelector) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	if sel.Tagged && len(sel.IDs) == 0 {
+		return nodes, nil
+	}
+	q := n.DB.Where("environment_id = ?", sel.EnvironmentID)
+	if sel.Platform != "" {
+		q = q.Where("platform = ?", sel.Platform)
+	}
+	if sel.Inactive > 0 {
+		q = q.Where("updated_at < ?", time.Now().Add(-time.Duration(sel.Inactive)*time.Hour))
+	}
+	if sel.Tagged {
+		q = q.Where("id IN ?", sel.IDs)
+	}
+	if err := q.Order("uuid").Find(&nodes).Error; err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
+
+// BulkToken to generate the confirmation token for a bulk action over a set of nodes
+// The token is signed with the secret, it expires and it changes if the action, its value or the selected nodes change
+func BulkToken(secret []byte, action, value string, nds []OsqueryNode, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + bulkSignature(secret, action, value, nds, exp)
+}
+
+// CheckBulkToken to verify the confirmation token for a bulk action over a set of nodes
+func CheckBulkToken(secret []byte, token, action, value string, nds []OsqueryNode, now time.Time) bool {
+	exp, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(bulkSignature(secret, action, value, nds, exp)))
+}
+
+// bulkSignature to sign a bulk action over a set of nodes with its expiration
+func bulkSignature(secret []byte, action, value string, nds []OsqueryNode, exp string) string {
+	uuids := make([]string, 0, len(nds))
+	for _, n := range nds {
+		uuids = append(uuids, n.UUID)
+	}
+	sort.Strings(uuids)
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s|%s|%s|%s", action, value, strings.Join(uuids, ","), exp)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
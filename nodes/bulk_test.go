@@ -0,0 +1,21 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	nds := []OsqueryNode{{UUID: "B"}, {UUID: "A"}}
+	token := BulkToken(secret, BulkTag, "prod", nds, now.Add(DefaultBulkTokenExpiration))
+	assert.True(t, CheckBulkToken(secret, token, BulkTag, "prod", []OsqueryNode{{UUID: "A"}, {UUID: "B"}}, now))
+	assert.False(t, CheckBulkToken([]byte("other"), token, BulkTag, "prod", nds, now))
+	assert.False(t, CheckBulkToken(secret, token, BulkUntag, "prod", nds, now))
+	assert.False(t, CheckBulkToken(secret, token, BulkTag, "prod", nds[:1], now))
+	assert.False(t, CheckBulkToken(secret, token, BulkTag, "prod", nds, now.Add(2*DefaultBulkTokenExpiration)))
+	assert.False(t, CheckBulkToken(secret, "", BulkTag, "prod", nds, now))
+}
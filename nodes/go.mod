@@ -2,12 +2,18 @@ module github.com/jmpsec/osctrl/nodes
 
 go 1.23
 
-require gorm.io/gorm v1.25.12
+require (
+	github.com/stretchr/testify v1.8.4
+	gorm.io/gorm v1.25.12
+)
 
 require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -1,5 +1,7 @@
 package nodes
 
+import "time"
+
 // NodeMetadata to hold metadata for a node
 type NodeMetadata struct {
 	IPAddress       string
@@ -13,6 +15,7 @@ type NodeMetadata struct {
 	Platform        string
 	PlatformVersion string
 	BytesReceived   int
+	LastStatusError time.Time
 }
 
 // GetMetadata to extract the metadata struct from a node
@@ -29,5 +32,6 @@ func (n *NodeManager) GetMetadata(node OsqueryNode) NodeMetadata {
 		Platform:        node.Platform,
 		PlatformVersion: node.PlatformVersion,
 		BytesReceived:   node.BytesReceived,
+		LastStatusError: node.LastStatusError,
 	}
 }
@@ -16,6 +16,8 @@ const (
 	InactiveNodes = "inactive"
 	// AllNodes to represent all nodes
 	AllNodes = "all"
+	// DefaultKeyGrace to accept rotated node keys while nodes re-enroll
+	DefaultKeyGrace = time.Hour
 )
 
 // OsqueryNode as abstraction of a node
@@ -47,6 +49,20 @@ type OsqueryNode struct {
 	UserID          uint
 	EnvironmentID   uint
 	ExtraData       string
+	// NodeKeyExpires is set when the node_key is rotated, the key is accepted until then
+	NodeKeyExpires time.Time
+	// PreviousNodeKey is still accepted until PreviousKeyExpires after re-enrolling
+	PreviousNodeKey    string `gorm:"index"`
+	PreviousKeyExpires time.Time
+	// Pending nodes are enrolled but waiting for approval
+	Pending bool
+	// Client certificate bound to the node at enrollment
+	ClientCertFingerprint string `gorm:"index"`
+	ClientCertSubject     string
+	ClientCertIssuer      string
+	ClientCertExpires     time.Time
+	// LastStatusError is the last time the node reported an error status log
+	LastStatusError time.Time
 }
 
 // ArchiveOsqueryNode as abstraction of an archived node
@@ -121,6 +137,10 @@ func CreateNodes(backend *gorm.DB) *NodeManager {
 	if err := backend.AutoMigrate(&NodeHistoryUsername{}); err != nil {
 		log.Fatal().Msgf("Failed to AutoMigrate table (node_history_username): %v", err)
 	}
+	// table schedule_stats
+	if err := backend.AutoMigrate(&ScheduleStat{}); err != nil {
+		log.Fatal().Msgf("Failed to AutoMigrate table (schedule_stats): %v", err)
+	}
 	return n
 }
 
@@ -164,10 +184,14 @@ func (n *NodeManager) CheckByHost(host string) bool {
 }
 
 // GetByKey to retrieve full node object from DB, by node_key
-// node_key is expected lowercase
+// node_key is expected lowercase, the previous node_key of rotated nodes also matches
 func (n *NodeManager) GetByKey(nodekey string) (OsqueryNode, error) {
 	var node OsqueryNode
-	if err := n.DB.Where("node_key = ?", strings.ToLower(nodekey)).First(&node).Error; err != nil {
+	if nodekey == "" {
+		return node, fmt.Errorf("empty node_key")
+	}
+	key := strings.ToLower(nodekey)
+	if err := n.DB.Where("node_key = ? OR previous_node_key = ?", key, key).First(&node).Error; err != nil {
 		return node, err
 	}
 	return node, nil
@@ -237,6 +261,21 @@ func (n *NodeManager) GetBySelector(stype, selector, target string, hours int64)
 	return nodes, nil
 }
 
+// SearchByEnv to retrieve nodes in an environment where UUID, hostname or localname match a term
+func (n *NodeManager) SearchByEnv(environment, term string) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	like := "%" + strings.ToLower(term) + "%"
+	if err := n.DB.Where("environment = ?", environment).Where(
+		"LOWER(uuid) LIKE ? OR LOWER(hostname) LIKE ? OR LOWER(localname) LIKE ?",
+		like,
+		like,
+		like,
+	).Find(&nodes).Error; err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
+
 // Gets to retrieve all/active/inactive nodes
 func (n *NodeManager) Gets(target string, hours int64) ([]OsqueryNode, error) {
 	var nodes []OsqueryNode
@@ -383,6 +422,9 @@ func (n *NodeManager) UpdateMetadataByUUID(uuid string, metadata NodeMetadata) e
 	if metadata.OsqueryUser != node.OsqueryUser && metadata.OsqueryUser != "" {
 		updates["osquery_user"] = metadata.OsqueryUser
 	}
+	if metadata.LastStatusError.After(node.LastStatusError) {
+		updates["last_status_error"] = metadata.LastStatusError
+	}
 	if err := n.MetadataRefresh(node, updates); err != nil {
 		return fmt.Errorf("MetadataRefresh %v", err)
 	}
@@ -459,6 +501,147 @@ func (n *NodeManager) UpdateByUUID(data OsqueryNode, uuid string) error {
 	return nil
 }
 
+// UpdateByUUIDEnv to update an existing node record by UUID in a specific environment
+func (n *NodeManager) UpdateByUUIDEnv(data OsqueryNode, uuid string, envID uint) error {
+	node, err := n.GetByUUIDEnv(uuid, envID)
+	if err != nil {
+		return fmt.Errorf("getNodeByUUIDEnv %v", err)
+	}
+	// Nodes never change environment when updated
+	data.Environment = node.Environment
+	data.EnvironmentID = node.EnvironmentID
+	if err := n.DB.Model(&node).Updates(data).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	// Updates skips false values, pending follows the approval mode of the environment
+	if err := n.DB.Model(&node).Update("pending", data.Pending).Error; err != nil {
+		return fmt.Errorf("Update %v", err)
+	}
+	return nil
+}
+
+// Move to reassign an existing node to a different environment, archiving the previous record
+func (n *NodeManager) Move(node OsqueryNode, environment string, envID uint) error {
+	archivedNode := nodeArchiveFromNode(node, "move")
+	if err := n.DB.Create(&archivedNode).Error; err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	updates := map[string]interface{}{
+		"environment":    environment,
+		"environment_id": envID,
+	}
+	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// MoveByUUID to reassign an existing node to a different environment by UUID
+func (n *NodeManager) MoveByUUID(uuid, environment string, envID uint) error {
+	node, err := n.GetByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("getNodeByUUID %v", err)
+	}
+	return n.Move(node, environment, envID)
+}
+
+// GetPending to retrieve all the nodes in an environment waiting for approval
+func (n *NodeManager) GetPending(envID uint) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	if err := n.DB.Where("environment_id = ? AND pending = ?", envID, true).Find(&nodes).Error; err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
+
+// GetAdoption to summarize the configuration adoption of the nodes in an environment
+func (n *NodeManager) GetAdoption(envID uint, hash string, since time.Time) (ConfigAdoption, error) {
+	var total, adopted, unknown, errs int64
+	envNodes := func() *gorm.DB {
+		return n.DB.Model(&OsqueryNode{}).Where("environment_id = ? AND pending = ?", envID, false)
+	}
+	if err := envNodes().Count(&total).Error; err != nil {
+		return ConfigAdoption{}, err
+	}
+	if err := envNodes().Where("config_hash = ?", hash).Count(&adopted).Error; err != nil {
+		return ConfigAdoption{}, err
+	}
+	if err := envNodes().Where("config_hash = ?", "").Count(&unknown).Error; err != nil {
+		return ConfigAdoption{}, err
+	}
+	if err := envNodes().Where("last_status_error > ?", since).Count(&errs).Error; err != nil {
+		return ConfigAdoption{}, err
+	}
+	return ConfigAdoption{
+		Total:   int(total),
+		Adopted: int(adopted),
+		Stale:   int(total - adopted - unknown),
+		Unknown: int(unknown),
+		Errors:  int(errs),
+	}, nil
+}
+
+// GetStaleConfig to retrieve the nodes in an environment reporting a config_hash different than expected
+func (n *NodeManager) GetStaleConfig(envID uint, hash string) ([]OsqueryNode, error) {
+	var nodes []OsqueryNode
+	if err := n.DB.Where("environment_id = ? AND pending = ? AND config_hash <> ? AND config_hash <> ?", envID, false, hash, "").Find(&nodes).Error; err != nil {
+		return nodes, err
+	}
+	return nodes, nil
+}
+
+// Approve to approve a pending node in an environment by UUID or hardware serial
+func (n *NodeManager) Approve(identifier string, envID uint) (int64, error) {
+	res := n.DB.Model(&OsqueryNode{}).
+		Where("environment_id = ? AND pending = ?", envID, true).
+		Where("uuid = ? OR hardware_serial = ?", strings.ToUpper(identifier), identifier).
+		Update("pending", false)
+	if res.Error != nil {
+		return 0, fmt.Errorf("Update %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// RotateKey to invalidate the node_key of a node, accepting it during the grace window
+func (n *NodeManager) RotateKey(node OsqueryNode, grace time.Duration) error {
+	if err := n.DB.Model(&node).Update("node_key_expires", time.Now().Add(grace)).Error; err != nil {
+		return fmt.Errorf("Update %v", err)
+	}
+	return nil
+}
+
+// RotateKeyByUUID to invalidate the node_key of a node by UUID
+func (n *NodeManager) RotateKeyByUUID(uuid string, grace time.Duration) error {
+	node, err := n.GetByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("getNodeByUUID %v", err)
+	}
+	return n.RotateKey(node, grace)
+}
+
+// RotateKeysByEnv to invalidate the node_key of all the nodes in an environment
+func (n *NodeManager) RotateKeysByEnv(envID uint, grace time.Duration) (int64, error) {
+	res := n.DB.Model(&OsqueryNode{}).Where("environment_id = ?", envID).Update("node_key_expires", time.Now().Add(grace))
+	if res.Error != nil {
+		return 0, fmt.Errorf("Update %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// KeyReenrolled to keep the rotated node_key of a re-enrolled node as previous key
+// until the end of its grace window
+func (n *NodeManager) KeyReenrolled(node OsqueryNode) error {
+	updates := map[string]interface{}{
+		"previous_node_key":    node.NodeKey,
+		"previous_key_expires": node.NodeKeyExpires,
+		"node_key_expires":     time.Time{},
+	}
+	if err := n.DB.Model(&OsqueryNode{}).Where("id = ?", node.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
 // ArchiveDeleteByUUID to archive and delete an existing node record by UUID
 func (n *NodeManager) ArchiveDeleteByUUID(uuid string) error {
 	node, err := n.GetByUUID(uuid)
@@ -0,0 +1,139 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// ScheduleStat to hold the latest osquery_schedule stats of one scheduled query in a node
+type ScheduleStat struct {
+	gorm.Model
+	NodeID        uint `gorm:"index"`
+	EnvironmentID uint `gorm:"index"`
+	Name          string
+	Interval      int
+	Executions    int64
+	Denylisted    bool
+	OutputSize    int64
+	WallTimeMs    int64
+	UserTime      int64
+	SystemTime    int64
+	AverageMemory int64
+}
+
+// SchedulePerf to hold the performance of one scheduled query aggregated for all nodes in an environment
+// Times are in milliseconds and memory in bytes
+type SchedulePerf struct {
+	Name          string  `json:"name"`
+	Interval      int     `json:"interval"`
+	Nodes         int     `json:"nodes"`
+	Executions    int64   `json:"executions"`
+	Denylisted    int     `json:"denylisted"`
+	OutputSize    int64   `json:"output_size"`
+	WallTimeMs    int64   `json:"wall_time_ms"`
+	AvgWallTimeMs float64 `json:"avg_wall_time_ms"`
+	UserTime      int64   `json:"user_time"`
+	SystemTime    int64   `json:"system_time"`
+	AverageMemory int64   `json:"average_memory"`
+	MaxMemory     int64   `json:"max_memory"`
+}
+
+// ScheduleStatFromRow to parse one row of the osquery_schedule table, where all values are strings
+func ScheduleStatFromRow(row map[string]string) ScheduleStat {
+	num := func(k string) int64 {
+		v, _ := strconv.ParseInt(row[k], 10, 64)
+		return v
+	}
+	return ScheduleStat{
+		Name:          row["name"],
+		Interval:      int(num("interval")),
+		Executions:    num("executions"),
+		Denylisted:    row["denylisted"] == "1",
+		OutputSize:    num("output_size"),
+		WallTimeMs:    num("wall_time_ms"),
+		UserTime:      num("user_time"),
+		SystemTime:    num("system_time"),
+		AverageMemory: num("average_memory"),
+	}
+}
+
+// RecordScheduleStats to replace the osquery_schedule stats of a node with the latest rows
+func (n *NodeManager) RecordScheduleStats(uuid string, rows []map[string]string) error {
+	node, err := n.GetByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("getNodeByUUID %v", err)
+	}
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("node_id = ?", node.ID).Delete(&ScheduleStat{}).Error; err != nil {
+			return fmt.Errorf("Delete ScheduleStat %v", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		stats := make([]ScheduleStat, 0, len(rows))
+		for _, r := range rows {
+			s := ScheduleStatFromRow(r)
+			s.NodeID = node.ID
+			s.EnvironmentID = node.EnvironmentID
+			stats = append(stats, s)
+		}
+		if err := tx.Create(&stats).Error; err != nil {
+			return fmt.Errorf("Create ScheduleStat %v", err)
+		}
+		return nil
+	})
+}
+
+// GetSchedulePerf to retrieve the performance of the scheduled queries of an environment, most expensive first
+func (n *NodeManager) GetSchedulePerf(envID uint) ([]SchedulePerf, error) {
+	var stats []ScheduleStat
+	// Stats of deleted nodes are not counted
+	nodeIDs := n.DB.Model(&OsqueryNode{}).Select("id")
+	if err := n.DB.Where("environment_id = ? AND node_id IN (?)", envID, nodeIDs).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	return AggregateSchedulePerf(stats), nil
+}
+
+// AggregateSchedulePerf to aggregate the stats of all nodes per scheduled query, most wall time first
+func AggregateSchedulePerf(stats []ScheduleStat) []SchedulePerf {
+	perf := make(map[string]*SchedulePerf)
+	memory := make(map[string]int64)
+	for _, s := range stats {
+		p, ok := perf[s.Name]
+		if !ok {
+			p = &SchedulePerf{Name: s.Name}
+			perf[s.Name] = p
+		}
+		p.Interval = s.Interval
+		p.Nodes++
+		p.Executions += s.Executions
+		if s.Denylisted {
+			p.Denylisted++
+		}
+		p.OutputSize += s.OutputSize
+		p.WallTimeMs += s.WallTimeMs
+		p.UserTime += s.UserTime
+		p.SystemTime += s.SystemTime
+		memory[s.Name] += s.AverageMemory
+		p.MaxMemory = max(p.MaxMemory, s.AverageMemory)
+	}
+	res := make([]SchedulePerf, 0, len(perf))
+	for name, p := range perf {
+		p.AverageMemory = memory[name] / int64(p.Nodes)
+		if p.Executions > 0 {
+			p.AvgWallTimeMs = float64(p.WallTimeMs) / float64(p.Executions)
+		}
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].WallTimeMs != res[j].WallTimeMs {
+			return res[i].WallTimeMs > res[j].WallTimeMs
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
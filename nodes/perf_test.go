@@ -0,0 +1,34 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleStatFromRow(t *testing.T) {
+	s := ScheduleStatFromRow(map[string]string{
+		"name":           "pack:it:uptime",
+		"interval":       "60",
+		"executions":     "10",
+		"denylisted":     "1",
+		"output_size":    "2048",
+		"wall_time_ms":   "150",
+		"user_time":      "90",
+		"system_time":    "40",
+		"average_memory": "1000",
+	})
+	assert.Equal(t, ScheduleStat{Name: "pack:it:uptime", Interval: 60, Executions: 10, Denylisted: true, OutputSize: 2048, WallTimeMs: 150, UserTime: 90, SystemTime: 40, AverageMemory: 1000}, s)
+}
+
+func TestAggregateSchedulePerf(t *testing.T) {
+	perf := AggregateSchedulePerf([]ScheduleStat{
+		{NodeID: 1, Name: "cheap", Interval: 60, Executions: 10, WallTimeMs: 10, AverageMemory: 100},
+		{NodeID: 1, Name: "costly", Interval: 300, Executions: 2, WallTimeMs: 500, AverageMemory: 3000},
+		{NodeID: 2, Name: "costly", Interval: 300, Executions: 3, WallTimeMs: 1000, AverageMemory: 1000, Denylisted: true},
+	})
+	assert.Equal(t, []SchedulePerf{
+		{Name: "costly", Interval: 300, Nodes: 2, Executions: 5, Denylisted: 1, WallTimeMs: 1500, AvgWallTimeMs: 300, AverageMemory: 2000, MaxMemory: 3000},
+		{Name: "cheap", Interval: 60, Nodes: 1, Executions: 10, WallTimeMs: 10, AvgWallTimeMs: 1, AverageMemory: 100, MaxMemory: 100},
+	}, perf)
+}
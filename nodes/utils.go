@@ -2,6 +2,7 @@ package nodes
 
 import (
 	"math"
+	"strings"
 	"time"
 )
 
@@ -40,3 +41,94 @@ func IsActive(n OsqueryNode, inactive int64) bool {
 	}
 	return false
 }
+
+// KeyStatus to check a node_key used by a node, returning if the key is accepted
+// and if the node must re-enroll because the key has been rotated
+func KeyStatus(n OsqueryNode, nodeKey string) (bool, bool) {
+	now := time.Now()
+	key := strings.ToLower(nodeKey)
+	switch {
+	case key == n.NodeKey && n.NodeKeyExpires.IsZero():
+		return true, false
+	case key == n.NodeKey:
+		return now.Before(n.NodeKeyExpires), true
+	case key == n.PreviousNodeKey && key != "":
+		return now.Before(n.PreviousKeyExpires), false
+	}
+	return false, true
+}
+
+const (
+	// ConfigAdopted for nodes reporting the expected config_hash
+	ConfigAdopted = "adopted"
+	// ConfigStale for nodes reporting a different config_hash, stale or failed to load
+	ConfigStale = "stale"
+	// ConfigUnknown for nodes that did not report any config_hash yet
+	ConfigUnknown = "unknown"
+)
+
+// ConfigAdoption to summarize how many nodes are running the expected configuration
+type ConfigAdoption struct {
+	Total   int `json:"total"`
+	Adopted int `json:"adopted"`
+	Stale   int `json:"stale"`
+	Unknown int `json:"unknown"`
+	Errors  int `json:"errors"`
+}
+
+// ConfigState to check if a node is running the configuration with the expected config_hash
+func ConfigState(n OsqueryNode, hash string) string {
+	switch n.ConfigHash {
+	case "":
+		return ConfigUnknown
+	case hash:
+		return ConfigAdopted
+	}
+	return ConfigStale
+}
+
+// Adoption to summarize the configuration adoption of nodes against the expected config_hash
+// Pending nodes are skipped and nodes that reported an error status log after since are counted as errors
+func Adoption(nds []OsqueryNode, hash string, since time.Time) ConfigAdoption {
+	var a ConfigAdoption
+	for _, n := range nds {
+		if n.Pending {
+			continue
+		}
+		a.Total++
+		switch ConfigState(n, hash) {
+		case ConfigAdopted:
+			a.Adopted++
+		case ConfigStale:
+			a.Stale++
+		case ConfigUnknown:
+			a.Unknown++
+		}
+		if n.LastStatusError.After(since) {
+			a.Errors++
+		}
+	}
+	return a
+}
+
+// Add to sum the configuration adoption of two groups of nodes
+func (a ConfigAdoption) Add(b ConfigAdoption) ConfigAdoption {
+	return ConfigAdoption{
+		Total:   a.Total + b.Total,
+		Adopted: a.Adopted + b.Adopted,
+		Stale:   a.Stale + b.Stale,
+		Unknown: a.Unknown + b.Unknown,
+		Errors:  a.Errors + b.Errors,
+	}
+}
+
+// StaleNodes to get the nodes that are not pending and reported a config_hash other than the expected one
+func StaleNodes(nds []OsqueryNode, hash string) []OsqueryNode {
+	var stale []OsqueryNode
+	for _, n := range nds {
+		if !n.Pending && ConfigState(n, hash) == ConfigStale {
+			stale = append(stale, n)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,60 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStatus(t *testing.T) {
+	n := OsqueryNode{NodeKey: "current"}
+	accepted, reenroll := KeyStatus(n, "current")
+	assert.True(t, accepted)
+	assert.False(t, reenroll)
+	accepted, reenroll = KeyStatus(n, "other")
+	assert.False(t, accepted)
+	assert.True(t, reenroll)
+	// Rotated key within and after the grace window
+	n.NodeKeyExpires = time.Now().Add(time.Hour)
+	accepted, reenroll = KeyStatus(n, "CURRENT")
+	assert.True(t, accepted)
+	assert.True(t, reenroll)
+	n.NodeKeyExpires = time.Now().Add(-time.Hour)
+	accepted, _ = KeyStatus(n, "current")
+	assert.False(t, accepted)
+	// Previous key after re-enrolling
+	n = OsqueryNode{NodeKey: "new", PreviousNodeKey: "old", PreviousKeyExpires: time.Now().Add(time.Hour)}
+	accepted, reenroll = KeyStatus(n, "old")
+	assert.True(t, accepted)
+	assert.False(t, reenroll)
+	n.PreviousKeyExpires = time.Now().Add(-time.Hour)
+	accepted, _ = KeyStatus(n, "old")
+	assert.False(t, accepted)
+	accepted, _ = KeyStatus(OsqueryNode{NodeKey: "new"}, "")
+	assert.False(t, accepted)
+}
+
+func TestAdoption(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	nds := []OsqueryNode{
+		{ConfigHash: "expected"},
+		{ConfigHash: "expected", LastStatusError: time.Now()},
+		{ConfigHash: "old", LastStatusError: since.Add(-time.Minute)},
+		{},
+		{ConfigHash: "old", Pending: true},
+	}
+	a := Adoption(nds, "expected", since)
+	assert.Equal(t, ConfigAdoption{Total: 4, Adopted: 2, Stale: 1, Unknown: 1, Errors: 1}, a)
+	assert.Equal(t, ConfigStale, ConfigState(nds[2], "expected"))
+	assert.Equal(t, ConfigUnknown, ConfigState(nds[3], "expected"))
+	assert.Equal(t, ConfigAdoption{}, Adoption(nil, "expected", since))
+	// Nodes outside of a canary are compared against the base revision
+	canary := Adoption(nds[:2], "expected", since)
+	others := Adoption(nds[2:], "old", since)
+	assert.Equal(t, ConfigAdoption{Total: 4, Adopted: 3, Unknown: 1, Errors: 1}, canary.Add(others))
+	stale := StaleNodes(nds, "expected")
+	assert.Len(t, stale, 1)
+	assert.Equal(t, "old", stale[0].ConfigHash)
+	assert.Empty(t, StaleNodes(nds[2:], "old"))
+}
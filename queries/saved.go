@@ -76,3 +76,12 @@ func (q *Queries) DeleteSaved(name, creator string, envid uint) error {
 	}
 	return nil
 }
+
+// GetSavedByEnv to get all saved queries of an environment
+func (q *Queries) GetSavedByEnv(envid uint) ([]SavedQuery, error) {
+	var saved []SavedQuery
+	if err := q.DB.Where("environment_id = ?", envid).Order("name").Find(&saved).Error; err != nil {
+		return saved, err
+	}
+	return saved, nil
+}
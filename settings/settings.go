@@ -47,6 +47,9 @@ const (
 	LoggingS3       string = "s3"
 	LoggingKafka    string = "kafka"
 	LoggingElastic  string = "elastic"
+	LoggingSyslog   string = "syslog"
+	LoggingWebhook  string = "webhook"
+	LoggingOTLP     string = "otlp"
 )
 
 // Types of carver
@@ -72,6 +75,13 @@ const (
 	SetMsiPackage   string = "set_msi"
 	SetDebPackage   string = "set_deb"
 	SetRpmPackage   string = "set_rpm"
+	ApprovalOn      string = "approval_on"
+	ApprovalOff     string = "approval_off"
+	TokenNew        string = "token_new"
+	TokenRevoke     string = "token_revoke"
+	ClientCertOn    string = "client_cert_on"
+	ClientCertOff   string = "client_cert_off"
+	SetClientCA     string = "set_client_ca"
 )
 
 // Types of query/carve actions
@@ -108,6 +118,7 @@ const (
 	PlatformDarwin  string = "darwin"
 	PlatformLinux   string = "linux"
 	PlatformWindows string = "windows"
+	PlatformFreeBSD string = "freebsd"
 )
 
 // Names for all possible settings values for services
@@ -314,6 +314,79 @@ func (m *TagManager) GetTags(node nodes.OsqueryNode) ([]AdminTag, error) {
 	return tags, nil
 }
 
+// GetTaggedNodeIDs to retrieve the IDs of all the nodes tagged with a tag in an environment
+func (m *TagManager) GetTaggedNodeIDs(name string, envID uint) ([]uint, error) {
+	var ids []uint
+	tag, err := m.Get(name, envID)
+	if err != nil {
+		return ids, fmt.Errorf("error getting tag %v", err)
+	}
+	var tagged []TaggedNode
+	if err := m.DB.Where("admin_tag_id = ?", tag.ID).Find(&tagged).Error; err != nil {
+		return ids, err
+	}
+	for _, t := range tagged {
+		ids = append(ids, t.NodeID)
+	}
+	return ids, nil
+}
+
+// GetTaggedNodes to retrieve all the nodes tagged with a tag in an environment
+func (m *TagManager) GetTaggedNodes(name string, envID uint) ([]nodes.OsqueryNode, error) {
+	var nds []nodes.OsqueryNode
+	ids, err := m.GetTaggedNodeIDs(name, envID)
+	if err != nil {
+		return nds, err
+	}
+	if len(ids) == 0 {
+		return nds, nil
+	}
+	if err := m.DB.Where("id IN ?", ids).Find(&nds).Error; err != nil {
+		return nds, err
+	}
+	return nds, nil
+}
+
+// MoveNodeTags to carry over the tags of a node moved to a different environment
+// The tag of the old environment is replaced with the tag of the new environment
+func (m *TagManager) MoveNodeTags(node nodes.OsqueryNode, envName string, envID uint, user string) error {
+	var tagged []TaggedNode
+	if err := m.DB.Where("node_id = ?", node.ID).Find(&tagged).Error; err != nil {
+		return err
+	}
+	oldEnv := node.Environment
+	node.Environment = envName
+	node.EnvironmentID = envID
+	for _, t := range tagged {
+		if err := m.DB.Unscoped().Delete(&t).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		name := t.Tag
+		if name == oldEnv {
+			name = envName
+		}
+		if name == "" || m.IsTaggedID(name, node.ID) {
+			continue
+		}
+		if err := m.TagNode(name, node, user, t.AutoTag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveNode to move a node to a different environment and carry over its tags in one transaction
+func (m *TagManager) MoveNode(node nodes.OsqueryNode, envName string, envID uint, user string) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		nodesTx := &nodes.NodeManager{DB: tx}
+		if err := nodesTx.Move(node, envName, envID); err != nil {
+			return err
+		}
+		tagsTx := &TagManager{DB: tx}
+		return tagsTx.MoveNodeTags(node, envName, envID, user)
+	})
+}
+
 // GetNodeTags to decorate tags for a given node
 func (m *TagManager) GetNodeTags(tagged []AdminTag) ([]AdminTagForNode, error) {
 	var tags []AdminTag
@@ -334,3 +407,24 @@ func (m *TagManager) GetNodeTags(tagged []AdminTag) ([]AdminTagForNode, error) {
 	}
 	return forNode, nil
 }
+
+// CreateTagsEnv to create tags with the given values in an environment, skipping tags that already exist
+func (m *TagManager) CreateTagsEnv(tags []AdminTag, envID uint, user string) error {
+	for _, t := range tags {
+		if exists, _ := m.ExistsGet(t.Name, envID); exists {
+			continue
+		}
+		tag := AdminTag{
+			Name:          t.Name,
+			Description:   t.Description,
+			Color:         t.Color,
+			Icon:          t.Icon,
+			CreatedBy:     user,
+			EnvironmentID: envID,
+		}
+		if err := m.Create(&tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+// Helper to generate the fingerprint of a certificate, as hex encoded SHA256
+func certFingerprint(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+}
+
+// Helper to verify the client certificate of a request against the CA of an environment
+func clientCertificate(r *http.Request, env environments.TLSEnvironment) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(env.ClientCA)) {
+		return nil, fmt.Errorf("invalid client CA for %s", env.Name)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	cert := r.TLS.PeerCertificates[0]
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, fmt.Errorf("client certificate verification failed %v", err)
+	}
+	return cert, nil
+}
+
+// Helper to bind the details of a client certificate to a node
+func bindClientCert(node *nodes.OsqueryNode, cert *x509.Certificate) {
+	node.ClientCertFingerprint = certFingerprint(cert)
+	node.ClientCertSubject = cert.Subject.String()
+	node.ClientCertIssuer = cert.Issuer.String()
+	node.ClientCertExpires = cert.NotAfter
+}
+
+// Helper to check that the client certificate of a request matches the one bound to the node
+func (h *HandlersTLS) checkNodeClientCert(r *http.Request, node nodes.OsqueryNode) error {
+	env, ok := (*h.EnvsMap)[node.Environment]
+	if !ok {
+		var err error
+		if env, err = h.Envs.GetByID(node.EnvironmentID); err != nil {
+			return fmt.Errorf("error getting environment %v", err)
+		}
+	}
+	if !env.RequireClientCert {
+		return nil
+	}
+	// Nodes enrolled before client certificates were required must enroll again to bind one
+	if node.ClientCertFingerprint == "" {
+		return fmt.Errorf("no client certificate bound to node %s", node.UUID)
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("no client certificate")
+	}
+	if certFingerprint(r.TLS.PeerCertificates[0]) != node.ClientCertFingerprint {
+		return fmt.Errorf("client certificate does not match node %s", node.UUID)
+	}
+	return nil
+}
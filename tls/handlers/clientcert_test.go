@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tpl, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(raw)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+func TestClientCertificate(t *testing.T) {
+	ca, caKey := testCertificate(t, "ca", nil, nil)
+	other, otherKey := testCertificate(t, "other-ca", nil, nil)
+	client, _ := testCertificate(t, "client", ca, caKey)
+	rogue, _ := testCertificate(t, "rogue", other, otherKey)
+	env := environments.TLSEnvironment{
+		Name:              "environment",
+		RequireClientCert: true,
+		ClientCA:          string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})),
+	}
+	r := httptest.NewRequest("POST", "/env/enroll", nil)
+	_, err := clientCertificate(r, env)
+	assert.Error(t, err)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}
+	cert, err := clientCertificate(r, env)
+	assert.NoError(t, err)
+	var node nodes.OsqueryNode
+	bindClientCert(&node, cert)
+	assert.Equal(t, certFingerprint(client), node.ClientCertFingerprint)
+	assert.Equal(t, "CN=client", node.ClientCertSubject)
+	assert.Equal(t, "CN=ca", node.ClientCertIssuer)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{rogue}}
+	_, err = clientCertificate(r, env)
+	assert.Error(t, err)
+}
+
+func TestCheckNodeClientCert(t *testing.T) {
+	ca, caKey := testCertificate(t, "ca", nil, nil)
+	client, _ := testCertificate(t, "client", ca, caKey)
+	other, _ := testCertificate(t, "other", ca, caKey)
+	envs := environments.MapEnvironments{
+		"required": {Name: "required", RequireClientCert: true},
+		"optional": {Name: "optional"},
+	}
+	h := &HandlersTLS{EnvsMap: &envs}
+	r := httptest.NewRequest("POST", "/env/log", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}
+	// Nodes enrolled before client certificates were required have none bound
+	node := nodes.OsqueryNode{UUID: "UUID", Environment: "required"}
+	assert.Error(t, h.checkNodeClientCert(r, node))
+	bindClientCert(&node, client)
+	assert.NoError(t, h.checkNodeClientCert(r, node))
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}
+	assert.Error(t, h.checkNodeClientCert(r, node))
+	node.Environment = "optional"
+	assert.NoError(t, h.checkNodeClientCert(r, node))
+}
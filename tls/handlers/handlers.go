@@ -65,6 +65,8 @@ const (
 	defOsqueryVersion = version.OsqueryVersion
 	// path for enroll packages
 	enrollPackagesPath = "packages"
+	// configuration served to nodes pending approval
+	emptyConfiguration = "{}"
 )
 
 // Valid values for actions in handlers
@@ -100,6 +102,8 @@ type HandlersTLS struct {
 	SettingsMap *settings.MapSettings
 	Metrics     *metrics.Metrics
 	Logs        *logging.LoggerTLS
+	Packages    *environments.PackageBuilder
+	Rollouts    *environments.RolloutCache
 }
 
 // TLSResponse to be returned to requests
@@ -180,12 +184,29 @@ func WithLogs(logs *logging.LoggerTLS) Option {
 	}
 }
 
+// WithPackages to pass value as option
+func WithPackages(packages *environments.PackageBuilder) Option {
+	return func(h *HandlersTLS) {
+		h.Packages = packages
+	}
+}
+
+// WithRollouts to pass value as option
+func WithRollouts(rollouts *environments.RolloutCache) Option {
+	return func(h *HandlersTLS) {
+		h.Rollouts = rollouts
+	}
+}
+
 // CreateHandlersTLS to initialize the TLS handlers struct
 func CreateHandlersTLS(opts ...Option) *HandlersTLS {
 	h := &HandlersTLS{}
 	for _, opt := range opts {
 		opt(h)
 	}
+	if h.Rollouts == nil && h.Envs != nil {
+		h.Rollouts = environments.NewRolloutCache(h.Envs)
+	}
 	return h
 }
 
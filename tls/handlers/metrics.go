@@ -1,6 +1,9 @@
 package handlers
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const (
 	RequestPath   = "path"
@@ -27,3 +30,47 @@ func RegisterMetrics(reg prometheus.Registerer) {
 	reg.MustRegister(requestDuration)
 	reg.MustRegister(requestSize)
 }
+
+// RegisterSpoolMetrics to expose the depth and drops of the ingest spool
+func RegisterSpoolMetrics(reg prometheus.Registerer, spool *logging.Spool) {
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "osctrl_tls_spool_depth",
+		Help: "The number of entries waiting in the ingest spool",
+	}, func() float64 { return float64(spool.Depth()) }))
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "osctrl_tls_spool_bytes",
+		Help: "The size of entries waiting in the ingest spool",
+	}, func() float64 { return float64(spool.Bytes()) }))
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_spool_drops_total",
+		Help: "The number of requests rejected by the ingest spool",
+	}, func() float64 { return float64(spool.Drops()) }))
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_spool_processed_total",
+		Help: "The number of entries processed from the ingest spool",
+	}, func() float64 { return float64(spool.Processed()) }))
+}
+
+// RegisterRedactionMetrics to expose the events redacted and dropped by rules before any logger
+func RegisterRedactionMetrics(reg prometheus.Registerer, redactor *logging.Redactor) {
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_log_redacted_total",
+		Help: "The number of events with values redacted by rules",
+	}, func() float64 { return float64(redactor.Redacted()) }))
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_log_dropped_total",
+		Help: "The number of events dropped by rules or because they could not be redacted",
+	}, func() float64 { return float64(redactor.Dropped()) }))
+}
+
+// RegisterDetectionMetrics to expose the alerts raised and suppressed by detection rules
+func RegisterDetectionMetrics(reg prometheus.Registerer, detector *logging.Detector) {
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_detection_alerts_total",
+		Help: "The number of alerts raised by detection rules",
+	}, func() float64 { return float64(detector.Alerts()) }))
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "osctrl_tls_detection_suppressed_total",
+		Help: "The number of alerts suppressed as duplicated by detection rules",
+	}, func() float64 { return float64(detector.Suppressed()) }))
+}
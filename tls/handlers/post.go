@@ -3,6 +3,7 @@ package handlers
 import (
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -65,23 +66,61 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 	var nodeKey string
 	var newNode nodes.OsqueryNode
 	nodeInvalid := true
-	if h.checkValidSecret(t.EnrollSecret, env) {
+	// Enroll secret can be the environment secret or an enrollment token
+	// One use of the token is reserved here and given back if the node is not enrolled
+	validSecret := h.checkValidSecret(t.EnrollSecret, env)
+	withToken := !validSecret && h.Envs.UseEnrollToken(t.EnrollSecret, env.ID)
+	if withToken {
+		defer func() {
+			if nodeInvalid && !h.Envs.ReleaseEnrollToken(t.EnrollSecret, env.ID) {
+				log.Error().Msgf("error releasing enrollment token for %s", t.HostIdentifier)
+			}
+		}()
+	}
+	if validSecret || withToken {
 		// Generate node_key using UUID as entropy
 		nodeKey = generateNodeKey(t.HostIdentifier, time.Now())
 		newNode = nodeFromEnroll(t, env, utils.GetIP(r), nodeKey, len(body))
+		// Nodes are pending approval if the environment requires it, also when they enroll again
+		newNode.Pending = env.RequireApproval
+		// Verify and bind the client certificate if the environment requires it
+		if env.RequireClientCert {
+			cert, err := clientCertificate(r, env)
+			if err != nil {
+				h.Inc(metricEnrollErr)
+				log.Err(err).Msgf("error enrolling %s", t.HostIdentifier)
+				utils.HTTPResponse(w, "", http.StatusForbidden, []byte(""))
+				return
+			}
+			bindClientCert(&newNode, cert)
+		}
 		// Check if UUID exists already, if so archive node and enroll new node
-		if h.Nodes.CheckByUUIDEnv(t.HostIdentifier, env.Name) {
+		// Nodes are only enrolled again in their own environment, moving them is done with the admin, API or CLI
+		existing, err := h.Nodes.GetByUUIDEnv(t.HostIdentifier, env.ID)
+		if err == nil {
 			if err := h.Nodes.Archive(t.HostIdentifier, "exists"); err != nil {
 				h.Inc(metricEnrollErr)
 				log.Err(err).Msg("error archiving node")
 			}
 			// Update existing with new enroll data
-			if err := h.Nodes.UpdateByUUID(newNode, t.HostIdentifier); err != nil {
+			if err := h.Nodes.UpdateByUUIDEnv(newNode, t.HostIdentifier, env.ID); err != nil {
 				h.Inc(metricEnrollErr)
 				log.Err(err).Msg("error updating existing node")
 			} else {
 				nodeInvalid = false
+				// Keep accepting the rotated node_key until the end of the grace window
+				if !existing.NodeKeyExpires.IsZero() {
+					if err := h.Nodes.KeyReenrolled(existing); err != nil {
+						h.Inc(metricEnrollErr)
+						log.Err(err).Msg("error completing node_key rotation")
+					}
+				}
 			}
+		} else if h.Nodes.CheckByUUID(t.HostIdentifier) {
+			h.Inc(metricEnrollErr)
+			log.Error().Msgf("error enrolling %s, node exists in another environment", t.HostIdentifier)
+			utils.HTTPResponse(w, "", http.StatusForbidden, []byte(""))
+			return
 		} else { // New node, persist it
 			if err := h.Nodes.Create(&newNode); err != nil {
 				h.Inc(metricEnrollErr)
@@ -146,7 +185,7 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, reenroll, err := h.nodeByKey(r, t.NodeKey); err == nil && !reenroll {
 		ip := utils.GetIP(r)
 		if err := h.Nodes.RecordIPAddress(ip, node); err != nil {
 			h.Inc(metricConfigErr)
@@ -160,7 +199,13 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "ConfigHandler").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for ConfigHandler endpoint", node.UUID, env.Name, len(body))
-		response = []byte(env.Configuration)
+		// Serve the configuration of the environment the node belongs to, empty until approved
+		// and following any staged rollout in that environment
+		if node.Pending {
+			response = []byte(emptyConfiguration)
+		} else {
+			response = []byte(h.nodeConfiguration(node, h.nodeEnvironment(node, env)))
+		}
 	} else {
 		response = types.ConfigResponse{NodeInvalid: true}
 	}
@@ -240,14 +285,20 @@ func (h *HandlersTLS) LogHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 	var nodeInvalid bool
 	// Check if provided node_key is valid and if so, update node
-	node, err := h.Nodes.GetByKey(t.NodeKey)
+	node, reenroll, err := h.nodeByKey(r, t.NodeKey)
 	if err == nil {
-		nodeInvalid = false
+		nodeInvalid = reenroll
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "LogHandler").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for LogHandler endpoint", node.UUID, env.Name, len(body))
-		// Process logs and update metadata
-		go h.Logs.ProcessLogs(t.Data, t.LogType, env.Name, utils.GetIP(r), len(body), (*h.EnvsMap)[env.Name].DebugHTTP)
+		// Process logs and update metadata, using the environment the node belongs to
+		if err := h.Logs.IngestLogs(t.Data, t.LogType, h.nodeEnvironment(node, env).Name, utils.GetIP(r), len(body), (*h.EnvsMap)[env.Name].DebugHTTP); err != nil {
+			// Node will send the logs again when it gets an error
+			h.Inc(metricLogErr)
+			log.Err(err).Msg("error ingesting logs")
+			utils.HTTPResponse(w, "", ingestStatus(err), []byte(""))
+			return
+		}
 	} else {
 		nodeInvalid = true
 	}
@@ -300,7 +351,7 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 	var nodeInvalid, accelerate bool
 	qs := make(queries.QueryReadQueries)
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, reenroll, err := h.nodeByKey(r, t.NodeKey); err == nil {
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "QueryRead").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for QueryReadHandler endpoint", node.UUID, env.Name, len(body))
@@ -309,11 +360,14 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 			h.Inc(metricReadErr)
 			log.Err(err).Msg("error recording IP address")
 		}
-		nodeInvalid = false
-		qs, accelerate, err = h.Queries.NodeQueries(node)
-		if err != nil {
-			h.Inc(metricReadErr)
-			log.Err(err).Msg("error getting queries from db")
+		nodeInvalid = reenroll
+		// Pending nodes do not receive queries until approved
+		if !node.Pending {
+			qs, accelerate, err = h.Queries.NodeQueries(node)
+			if err != nil {
+				h.Inc(metricReadErr)
+				log.Err(err).Msg("error getting queries from db")
+			}
 		}
 		// Refresh last query read request
 		if err := h.Nodes.QueryReadRefresh(node, ip, len(body)); err != nil {
@@ -321,7 +375,7 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 			log.Err(err).Msg("error refreshing last query read")
 		}
 	} else {
-		log.Err(err).Msg("GetByKey")
+		log.Err(err).Msg("nodeByKey")
 		nodeInvalid = true
 		accelerate = false
 	}
@@ -379,22 +433,30 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 	}
 	var nodeInvalid bool
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, reenroll, err := h.nodeByKey(r, t.NodeKey); err == nil {
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "QueryWrite").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for QueryWriteHandler endpoint", node.UUID, env.Name, len(body))
+		// Process submitted results and mark query as processed
+		// Results are ingested first, so nothing else is done twice when the node sends them again
+		if err := h.Logs.IngestQueryResult(t, h.nodeEnvironment(node, env).ID, (*h.EnvsMap)[env.Name].DebugHTTP); err != nil {
+			h.Inc(metricWriteErr)
+			log.Err(err).Msg("error ingesting query results")
+			utils.HTTPResponse(w, "", ingestStatus(err), []byte(""))
+			return
+		}
 		ip := utils.GetIP(r)
 		if err := h.Nodes.RecordIPAddress(ip, node); err != nil {
 			h.Inc(metricWriteErr)
 			log.Err(err).Msg("error recording IP address")
 		}
-		nodeInvalid = false
+		nodeInvalid = reenroll
 		for name, c := range t.Queries {
 			var carves []types.QueryCarveScheduled
 			if err := json.Unmarshal(c, &carves); err == nil {
 				for _, cc := range carves {
 					if cc.Carve == "1" {
-						if err := h.ProcessCarveWrite(cc, name, t.NodeKey, env.Name); err != nil {
+						if err := h.ProcessCarveWrite(cc, name, t.NodeKey, h.nodeEnvironment(node, env).Name); err != nil {
 							h.Inc(metricWriteErr)
 							log.Err(err).Msg("error scheduling carve")
 						}
@@ -406,8 +468,6 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricWriteErr)
 			log.Err(err).Msg("error refreshing last query write")
 		}
-		// Process submitted results and mark query as processed
-		go h.Logs.ProcessLogQueryResult(t, env.ID, (*h.EnvsMap)[env.Name].DebugHTTP)
 	} else {
 		nodeInvalid = true
 	}
@@ -487,7 +547,7 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	// Prepare response with the script
-	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, env)
+	quickScript, err := h.quickAddScript(env, script)
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Err(err).Msg("error getting script")
@@ -564,7 +624,7 @@ func (h *HandlersTLS) QuickRemoveHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	// Prepare response with the script
-	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, env)
+	quickScript, err := h.quickAddScript(env, script)
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Err(err).Msg("error getting script")
@@ -616,7 +676,7 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 	initCarve := false
 	var carveSessionID string
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, _, err := h.nodeByKey(r, t.NodeKey); err == nil && !node.Pending {
 		// Record ingested data
 		requestSize.WithLabelValues(string(env.UUID), "CarveInit").Observe(float64(len(body)))
 		log.Debug().Msgf("node UUID: %s in %s environment ingested %d bytes for CarveInitHandler endpoint", node.UUID, env.Name, len(body))
@@ -628,7 +688,7 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 		initCarve = true
 		carveSessionID = generateCarveSessionID()
 		// Process carve init
-		if err := h.ProcessCarveInit(t, carveSessionID, env.Name); err != nil {
+		if err := h.ProcessCarveInit(t, carveSessionID, h.nodeEnvironment(node, env).Name); err != nil {
 			h.Inc(metricInitErr)
 			log.Err(err).Msg("error procesing carve init")
 			initCarve = false
@@ -748,7 +808,7 @@ func (h *HandlersTLS) FlagsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Check if provided secret is valid and if so, prepare flags
 	if h.checkValidSecret(t.Secret, env) {
-		flagsStr, err := h.Envs.GenerateFlags(env, t.SecrefFile, t.CertFile)
+		flagsStr, err := h.Envs.GeneratePlatformFlags(env, t.Platform, t.SecrefFile, t.CertFile)
 		if err != nil {
 			h.Inc(metricFlagsErr)
 			log.Err(err).Msg("error generating flags")
@@ -861,7 +921,7 @@ func (h *HandlersTLS) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Check if provided secret is valid and if so, prepare flags
 	if h.checkValidSecret(t.Secret, env) {
-		flagsStr, err := h.Envs.GenerateFlags(env, t.SecrefFile, t.CertFile)
+		flagsStr, err := h.Envs.GeneratePlatformFlags(env, t.Platform, t.SecrefFile, t.CertFile)
 		if err != nil {
 			h.Inc(metricVerifyErr)
 			log.Err(err).Msg("error generating flags")
@@ -956,7 +1016,7 @@ func (h *HandlersTLS) ScriptHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Check if provided secret is valid and if so, prepare flags
 	if h.checkValidSecret(t.Secret, env) {
-		script, err := environments.QuickAddScript("osctrl-"+env.Name, actionVar, env)
+		script, err := h.quickAddScript(env, actionVar)
 		if err != nil {
 			h.Inc(metricScriptErr)
 			log.Err(err).Msg("error preparing script")
@@ -1030,6 +1090,11 @@ func (h *HandlersTLS) EnrollPackageHandler(w http.ResponseWriter, r *http.Reques
 		utils.HTTPResponse(w, "", http.StatusForbidden, []byte(""))
 		return
 	}
+	// Build DEB and RPM packages when the environment does not have them
+	if h.Packages != nil && ((packageVar == settings.PackageDeb && env.DebPackage == "") || (packageVar == settings.PackageRpm && env.RpmPackage == "")) {
+		h.buildEnrollPackage(w, env, packageVar)
+		return
+	}
 	// Prepare download
 	var fDesc, fName, fPath string
 	switch packageVar {
@@ -1091,3 +1156,38 @@ func (h *HandlersTLS) EnrollPackageHandler(w http.ResponseWriter, r *http.Reques
 	}
 	h.Inc(metricPackageOk)
 }
+
+// buildEnrollPackage to serve a DEB or RPM package built for the environment with the enroll secret and flags
+func (h *HandlersTLS) buildEnrollPackage(w http.ResponseWriter, env environments.TLSEnvironment, pkgType string) {
+	pkg, err := h.Packages.Build(env, pkgType)
+	if err != nil {
+		h.Inc(metricPackageErr)
+		log.Err(err).Msgf("error building %s package", pkgType)
+		code := http.StatusInternalServerError
+		if errors.Is(err, environments.ErrPackageClientCert) {
+			code = http.StatusBadRequest
+		}
+		utils.HTTPResponse(w, "", code, []byte(""))
+		return
+	}
+	defer pkg.Close()
+	fi, err := pkg.Stat()
+	if err != nil {
+		h.Inc(metricPackageErr)
+		log.Err(err).Msgf("error with %s package", pkgType)
+		utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte(""))
+		return
+	}
+	fDesc := "Enrolling DEB Package for Linux"
+	if pkgType == settings.PackageRpm {
+		fDesc = "Enrolling RPM Package for Linux"
+	}
+	utils.HTTPDownload(w, fDesc, genPackageFilename(env.Name, version.OsctrlVersion, version.OsqueryVersion, pkgType), fi.Size())
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, pkg); err != nil {
+		h.Inc(metricPackageErr)
+		log.Err(err).Msg("error writing package")
+		return
+	}
+	h.Inc(metricPackageOk)
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/rs/zerolog/log"
@@ -25,6 +28,23 @@ func generateNodeKey(uuid string, ts time.Time) string {
 	return fmt.Sprintf("%x", bs)
 }
 
+// Helper to retrieve a node by node_key, returning if the node must re-enroll because the key was rotated
+// Nodes without the client certificate required by their environment must re-enroll
+func (h *HandlersTLS) nodeByKey(r *http.Request, nodeKey string) (nodes.OsqueryNode, bool, error) {
+	node, err := h.Nodes.GetByKey(nodeKey)
+	if err != nil {
+		return node, true, err
+	}
+	accepted, reenroll := nodes.KeyStatus(node, nodeKey)
+	if !accepted {
+		return node, true, fmt.Errorf("expired node_key for %s", node.UUID)
+	}
+	if err := h.checkNodeClientCert(r, node); err != nil {
+		return node, true, err
+	}
+	return node, reenroll, nil
+}
+
 // Helper to generate a carve session_id using KSUID
 // See https://github.com/segmentio/ksuid for more info about KSUIDs
 func generateCarveSessionID() string {
@@ -67,6 +87,51 @@ func (h *HandlersTLS) checkExpiredPath(maybeExpired time.Time) bool {
 	return (!environments.IsItExpired(maybeExpired))
 }
 
+// Helper to get the environment of a node, which may not be the environment in the request
+// path if the node was moved to a different environment after enrolling
+func (h *HandlersTLS) nodeEnvironment(node nodes.OsqueryNode, env environments.TLSEnvironment) environments.TLSEnvironment {
+	if node.EnvironmentID == env.ID {
+		return env
+	}
+	nodeEnv, err := h.Envs.GetByID(node.EnvironmentID)
+	if err != nil {
+		log.Err(err).Msgf("error getting environment for node %s", node.UUID)
+		return env
+	}
+	return nodeEnv
+}
+
+// Helper to get the configuration served to a node, which is the base revision of the
+// active rollout of the environment unless the node is part of its canary
+func (h *HandlersTLS) nodeConfiguration(node nodes.OsqueryNode, env environments.TLSEnvironment) string {
+	rollout, base, active := h.Rollouts.Get(env)
+	if !active {
+		return env.Configuration
+	}
+	tagged := rollout.Tag != "" && h.Tags.IsTaggedID(rollout.Tag, node.ID)
+	if rollout.Selects(node.UUID, tagged) {
+		return env.Configuration
+	}
+	return base
+}
+
+// Helper to generate a quick add script with the flags and overrides of each platform
+func (h *HandlersTLS) quickAddScript(env environments.TLSEnvironment, script string) (string, error) {
+	flags, err := h.Envs.ScriptPlatformFlags(env)
+	if err != nil {
+		return "", fmt.Errorf("error generating flags %v", err)
+	}
+	return environments.QuickAddScript("osctrl-"+env.Name, script, env, flags)
+}
+
+// Helper to get the HTTP status for errors ingesting data, only entries larger than the spool are rejected for good
+func ingestStatus(err error) int {
+	if errors.Is(err, logging.ErrSpoolTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusServiceUnavailable
+}
+
 // Helper to convert an enrollment request into a osquery node
 func nodeFromEnroll(req types.EnrollRequest, env environments.TLSEnvironment, ipaddress, nodekey string, recBytes int) nodes.OsqueryNode {
 	// Prepare the enrollment request to be stored as raw JSON
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jmpsec/osctrl/backend"
@@ -71,6 +74,8 @@ const (
 	defaultBackendRetryTimeout int = 7
 	// Default timeout to attempt redis reconnect
 	defaultRedisRetryTimeout int = 7
+	// Default timeout in seconds to finish requests and flush logs when stopping
+	defaultShutdownTimeout int = 30
 )
 
 // Global variables
@@ -119,6 +124,22 @@ var (
 	loggerDbSame      bool
 	alwaysLog         bool
 	carverConfigFile  string
+	osqueryBinary     string
+	osqueryArch       string
+	osqueryPkgsDir    string
+	spoolDir          string
+	spoolMaxSize      int
+	spoolWorkers      int
+	logBatchSize      int
+	logBatchMaxSize   int
+	logBatchInterval  int
+	logEnrich         bool
+	logEnrichKey      string
+	logEnrichCache    int
+	logRoutesFile     string
+	logRedactionFile  string
+	detectionFile     string
+	resultsRetention  int
 )
 
 // Valid values for authentication in configuration
@@ -138,6 +159,9 @@ var validLogging = map[string]bool{
 	settings.LoggingKinesis:  true,
 	settings.LoggingS3:       true,
 	settings.LoggingElastic:  true,
+	settings.LoggingSyslog:   true,
+	settings.LoggingWebhook:  true,
+	settings.LoggingOTLP:     true,
 }
 
 // Valid values for carver in configuration
@@ -463,6 +487,55 @@ func init() {
 			EnvVars:     []string{"ALWAYS_LOG"},
 			Destination: &alwaysLog,
 		},
+		&cli.BoolFlag{
+			Name:        "log-enrich",
+			Value:       false,
+			Usage:       "Add environment, node and tags from osctrl to every event sent to the logger",
+			EnvVars:     []string{"LOG_ENRICH"},
+			Destination: &logEnrich,
+		},
+		&cli.StringFlag{
+			Name:        "log-enrich-key",
+			Value:       logging.DefaultEnrichKey,
+			Usage:       "Key in every event for the fields added by osctrl",
+			EnvVars:     []string{"LOG_ENRICH_KEY"},
+			Destination: &logEnrichKey,
+		},
+		&cli.IntFlag{
+			Name:        "log-enrich-cache",
+			Value:       int(logging.DefaultEnrichCache / time.Second),
+			Usage:       "Time in seconds to keep in memory the fields added to events for each node",
+			EnvVars:     []string{"LOG_ENRICH_CACHE"},
+			Destination: &logEnrichCache,
+		},
+		&cli.StringFlag{
+			Name:        "log-routes",
+			Value:       "",
+			Usage:       "Routing rules configuration file to send logs to multiple loggers",
+			EnvVars:     []string{"LOG_ROUTES"},
+			Destination: &logRoutesFile,
+		},
+		&cli.StringFlag{
+			Name:        "log-redaction",
+			Value:       "",
+			Usage:       "Redaction and drop rules configuration file applied to logs before any logger",
+			EnvVars:     []string{"LOG_REDACTION"},
+			Destination: &logRedactionFile,
+		},
+		&cli.StringFlag{
+			Name:        "detection-rules",
+			Value:       "",
+			Usage:       "Detection rules configuration file to raise alerts from result logs",
+			EnvVars:     []string{"DETECTION_RULES"},
+			Destination: &detectionFile,
+		},
+		&cli.IntFlag{
+			Name:        "results-retention",
+			Value:       0,
+			Usage:       "Days to keep result logs stored by DB loggers, dropping older daily partitions. Logs are kept forever if zero",
+			EnvVars:     []string{"RESULTS_RETENTION"},
+			Destination: &resultsRetention,
+		},
 		&cli.StringFlag{
 			Name:        "carver-type",
 			Value:       settings.CarverDB,
@@ -477,6 +550,69 @@ func init() {
 			EnvVars:     []string{"CARVER_FILE"},
 			Destination: &carverConfigFile,
 		},
+		&cli.StringFlag{
+			Name:        "osquery-binary",
+			Value:       "",
+			Usage:       "Path to the osqueryd binary for Linux to build DEB and RPM enrollment packages for environments",
+			EnvVars:     []string{"OSQUERY_BINARY"},
+			Destination: &osqueryBinary,
+		},
+		&cli.StringFlag{
+			Name:        "osquery-arch",
+			Value:       environments.DefaultPackageArch,
+			Usage:       "Architecture of the osqueryd binary used to build enrollment packages",
+			EnvVars:     []string{"OSQUERY_ARCH"},
+			Destination: &osqueryArch,
+		},
+		&cli.StringFlag{
+			Name:        "osquery-packages-dir",
+			Value:       "",
+			Usage:       "Keep the DEB and RPM enrollment packages built for environments in `DIR`, a temporary directory if empty",
+			EnvVars:     []string{"OSQUERY_PACKAGES_DIR"},
+			Destination: &osqueryPkgsDir,
+		},
+		&cli.StringFlag{
+			Name:        "spool-dir",
+			Value:       "",
+			Usage:       "Directory to spool ingested logs and query results on disk before processing them. Spool is disabled if empty",
+			EnvVars:     []string{"SPOOL_DIR"},
+			Destination: &spoolDir,
+		},
+		&cli.IntFlag{
+			Name:        "spool-max-size",
+			Value:       512,
+			Usage:       "Maximum size in MB of the spool, nodes will send requests again when it is full",
+			EnvVars:     []string{"SPOOL_MAX_SIZE"},
+			Destination: &spoolMaxSize,
+		},
+		&cli.IntFlag{
+			Name:        "spool-workers",
+			Value:       logging.DefaultSpoolWorkers,
+			Usage:       "Number of workers processing entries from the spool",
+			EnvVars:     []string{"SPOOL_WORKERS"},
+			Destination: &spoolWorkers,
+		},
+		&cli.IntFlag{
+			Name:        "log-batch-size",
+			Value:       logging.DefaultBatchSize,
+			Usage:       "Maximum number of events written at once by DB, Elastic and S3 loggers",
+			EnvVars:     []string{"LOG_BATCH_SIZE"},
+			Destination: &logBatchSize,
+		},
+		&cli.IntFlag{
+			Name:        "log-batch-max-size",
+			Value:       8,
+			Usage:       "Maximum size in MB of events written at once by DB, Elastic and S3 loggers",
+			EnvVars:     []string{"LOG_BATCH_MAX_SIZE"},
+			Destination: &logBatchMaxSize,
+		},
+		&cli.IntFlag{
+			Name:        "log-batch-interval",
+			Value:       int(logging.DefaultBatchInterval / time.Second),
+			Usage:       "Interval in seconds to write batched events by DB, Elastic and S3 loggers",
+			EnvVars:     []string{"LOG_BATCH_INTERVAL"},
+			Destination: &logBatchInterval,
+		},
 		&cli.StringFlag{
 			Name:        "log-s3-bucket",
 			Value:       "",
@@ -649,11 +785,57 @@ func osctrlService() {
 
 	// Initialize TLS logger
 	log.Info().Msg("Loading TLS logger")
+	batchConfig := logging.BatchConfiguration{
+		Size:     logBatchSize,
+		Bytes:    logBatchMaxSize * 1024 * 1024,
+		Interval: time.Duration(logBatchInterval) * time.Second,
+	}
 	loggerTLS, err = logging.CreateLoggerTLS(
-		tlsConfig.Logger, loggerFile, s3LogConfig, kafkaConfiguration, loggerDbSame, alwaysLog, dbConfig, settingsmgr, nodesmgr, queriesmgr)
+		tlsConfig.Logger, loggerFile, s3LogConfig, kafkaConfiguration, batchConfig, loggerDbSame, alwaysLog, dbConfig, settingsmgr, nodesmgr, queriesmgr)
 	if err != nil {
 		log.Fatal().Msgf("Error loading logger - %s: %v", tlsConfig.Logger, err)
 	}
+	if logEnrich {
+		log.Info().Msgf("Enriching logs with osctrl fields in %s", logEnrichKey)
+		loggerTLS.EnableEnrichment(logEnrichKey, time.Duration(logEnrichCache)*time.Second, nodeTagNames, environmentUUID)
+	}
+	if logRedactionFile != "" {
+		redaction, err := logging.LoadRedaction(logRedactionFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading redaction rules - %v", err)
+		}
+		if err := loggerTLS.EnableRedaction(redaction, nodeTagNames); err != nil {
+			log.Fatal().Msgf("Error loading redaction rules - %v", err)
+		}
+		log.Info().Msgf("Redacting logs with %d rules", len(redaction.Rules))
+	}
+	if detectionFile != "" {
+		detection, err := logging.LoadDetection(detectionFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading detection rules - %v", err)
+		}
+		if err := loggerTLS.EnableDetection(detection, logging.CreateAlerts(db.Conn), batchConfig); err != nil {
+			log.Fatal().Msgf("Error loading detection rules - %v", err)
+		}
+		log.Info().Msgf("Detecting alerts with %d rules", len(detection.Rules))
+	}
+	if logRoutesFile != "" {
+		routing, err := logging.LoadRouting(logRoutesFile)
+		if err != nil {
+			log.Fatal().Msgf("Error loading routing rules - %v", err)
+		}
+		if err := loggerTLS.EnableRouting(routing, batchConfig, dbConfig, settingsmgr, nodeTagNames); err != nil {
+			log.Fatal().Msgf("Error loading routing rules - %v", err)
+		}
+		log.Info().Msgf("Routing logs with %d rules to %d sinks", len(routing.Rules), len(loggerTLS.Sinks))
+	}
+	loggerTLS.StartPartitions(time.Duration(resultsRetention) * 24 * time.Hour)
+	if spoolDir != "" {
+		log.Info().Msgf("Spooling ingested data in %s", spoolDir)
+		if err := loggerTLS.StartSpool(spoolDir, int64(spoolMaxSize)*1024*1024, spoolWorkers); err != nil {
+			log.Fatal().Msgf("Error loading spool - %v", err)
+		}
+	}
 	// Sleep to reload environments
 	// FIXME Implement Redis cache
 	// FIXME splay this?
@@ -692,6 +874,15 @@ func osctrlService() {
 		log.Info().Msg("Metrics are enabled")
 		// Register Prometheus metrics
 		handlers.RegisterMetrics(prometheus.DefaultRegisterer)
+		if loggerTLS.Spool != nil {
+			handlers.RegisterSpoolMetrics(prometheus.DefaultRegisterer, loggerTLS.Spool)
+		}
+		if loggerTLS.Redactor != nil {
+			handlers.RegisterRedactionMetrics(prometheus.DefaultRegisterer, loggerTLS.Redactor)
+		}
+		if loggerTLS.Detector != nil {
+			handlers.RegisterDetectionMetrics(prometheus.DefaultRegisterer, loggerTLS.Detector)
+		}
 
 		// Creating a new prometheus service
 		prometheusServer := http.NewServeMux()
@@ -705,6 +896,15 @@ func osctrlService() {
 			}
 		}()
 	}
+	// Enrollment packages are built for environments only with an osquery binary
+	var packages *environments.PackageBuilder
+	if osqueryBinary != "" {
+		log.Info().Msgf("Building enrollment packages with %s", osqueryBinary)
+		packages, err = environments.NewPackageBuilder(envs, osqueryBinary, osqueryArch, osqueryPkgsDir)
+		if err != nil {
+			log.Fatal().Msgf("Failed to initialize enrollment packages - %v", err)
+		}
+	}
 	// Initialize TLS handlers before router
 	log.Info().Msg("Initializing handlers")
 	handlersTLS = handlers.CreateHandlersTLS(
@@ -718,6 +918,8 @@ func osctrlService() {
 		handlers.WithSettingsMap(&settingsmap),
 		handlers.WithMetrics(tlsMetrics),
 		handlers.WithLogs(loggerTLS),
+		handlers.WithPackages(packages),
+		handlers.WithRollouts(environments.NewRolloutCache(envs)),
 	)
 
 	// ///////////////////////// ALL CONTENT IS UNAUTHENTICATED FOR TLS
@@ -755,12 +957,33 @@ func osctrlService() {
 
 	// ////////////////////////////// Everything is ready at this point!
 	serviceListener := tlsConfig.Listener + ":" + tlsConfig.Port
+	srv := &http.Server{
+		Addr:    serviceListener,
+		Handler: muxTLS,
+	}
+	// Stop accepting requests and flush pending logs when the service is stopped
+	// Logs are flushed once requests in flight are done, after Shutdown returns
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Info().Msg("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(defaultShutdownTimeout)*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Err(err).Msg("error shutting down server")
+		}
+	}()
 	if tlsServer {
 		log.Info().Msg("TLS Termination is enabled")
-		cfg := &tls.Config{
+		// Client certificates are requested but verified per environment by the handlers
+		srv.TLSConfig = &tls.Config{
 			MinVersion:               tls.VersionTLS12,
 			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 			PreferServerCipherSuites: true,
+			ClientAuth:               tls.RequestClientCert,
 			CipherSuites: []uint16{
 				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
@@ -768,22 +991,20 @@ func osctrlService() {
 				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
 			},
 		}
-		srv := &http.Server{
-			Addr:         serviceListener,
-			Handler:      muxTLS,
-			TLSConfig:    cfg,
-			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
-		}
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
 		log.Info().Msgf("%s v%s - HTTPS listening %s", serviceName, serviceVersion, serviceListener)
-		if err := srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile); err != nil {
+		if err := srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Msgf("ListenAndServeTLS: %v", err)
 		}
 	} else {
 		log.Info().Msgf("%s v%s - HTTP listening %s", serviceName, serviceVersion, serviceListener)
-		if err := http.ListenAndServe(serviceListener, muxTLS); err != nil {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Msgf("ListenAndServeTLS: %v", err)
 		}
 	}
+	<-shutdownDone
+	loggerTLS.Close()
+	log.Info().Msg("Logs flushed")
 }
 
 // Action to run when no flags are provided to run checks and prepare data
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/rs/zerolog/log"
 )
@@ -57,3 +58,25 @@ func refreshSettings() settings.MapSettings {
 	}
 	return _settingsmap
 }
+
+// Helper to get the names of the tags of a node to enrich logs
+func nodeTagNames(node nodes.OsqueryNode) ([]string, error) {
+	tagged, err := tagsmgr.GetTags(node)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tagged))
+	for _, t := range tagged {
+		names = append(names, t.Name)
+	}
+	return names, nil
+}
+
+// Helper to get the UUID of an environment to enrich logs
+func environmentUUID(name string) (string, error) {
+	env, err := envs.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return env.UUID, nil
+}
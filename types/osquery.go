@@ -14,6 +14,17 @@ const (
 	QueryLog  string = "query"
 )
 
+// SeverityError is the lowest severity of osquery status logs reporting errors
+// https://osquery.readthedocs.io/en/stable/deployment/logging/#status-logs
+const SeverityError StringInt = 2
+
+const (
+	// SchedulePerfQuery is the name of the built-in scheduled query collecting osquery_schedule stats
+	SchedulePerfQuery string = "osctrl_schedule_perf"
+	// SchedulePerfSQL is the query to collect the performance of all scheduled queries in a node
+	SchedulePerfSQL string = "SELECT name, interval, executions, denylisted, output_size, wall_time_ms, user_time, system_time, average_memory FROM osquery_schedule;"
+)
+
 // OSVersionTable provided on enrollment, table os_version
 type OSVersionTable struct {
 	ID           string `json:"_id"`
@@ -155,6 +166,7 @@ type LogResultData struct {
 	Epoch          int64           `json:"epoch"`
 	Action         string          `json:"action"`
 	Columns        json.RawMessage `json:"columns"`
+	Snapshot       json.RawMessage `json:"snapshot,omitempty"`
 	Counter        int             `json:"counter"`
 	UnixTime       StringInt       `json:"unixTime"`
 	Decorations    LogDecorations  `json:"decorations"`
@@ -180,6 +192,7 @@ type LogGenericData struct {
 	HostIdentifier string         `json:"hostIdentifier"`
 	Decorations    LogDecorations `json:"decorations"`
 	Version        string         `json:"version"`
+	Severity       StringInt      `json:"severity"`
 }
 
 // QueryReadRequest received to get on-demand queries
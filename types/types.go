@@ -109,6 +109,7 @@ type FlagsRequest struct {
 	Secret     string `json:"secret"`
 	SecrefFile string `json:"secretFile"`
 	CertFile   string `json:"certFile"`
+	Platform   string `json:"platform,omitempty"`
 }
 
 // CertRequest to retrieve certificate
@@ -155,6 +156,133 @@ type ApiNodeGenericRequest struct {
 	UUID string `json:"uuid"`
 }
 
+// ApiNodeMoveRequest to receive requests to move nodes to a different environment
+type ApiNodeMoveRequest struct {
+	UUIDs       []string `json:"uuid_list"`
+	Tag         string   `json:"tag"`
+	Search      string   `json:"search"`
+	Environment string   `json:"environment"`
+}
+
+// ApiNodeApproveRequest to receive requests to approve pending nodes by UUID or hardware serial
+type ApiNodeApproveRequest struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+// ApiNodeRotateRequest to receive node key rotation requests, for one node or the whole environment
+type ApiNodeRotateRequest struct {
+	UUID  string `json:"uuid"`
+	All   bool   `json:"all"`
+	Grace int    `json:"grace"`
+}
+
+// ApiConfigAdoption to summarize how many nodes are running the expected configuration
+type ApiConfigAdoption struct {
+	Total   int `json:"total"`
+	Adopted int `json:"adopted"`
+	Stale   int `json:"stale"`
+	Unknown int `json:"unknown"`
+	Errors  int `json:"errors"`
+}
+
+// ApiAdoptionResponse to be returned to API requests for the configuration adoption of an environment
+type ApiAdoptionResponse struct {
+	ConfigHash string            `json:"config_hash"`
+	Since      time.Time         `json:"since"`
+	Adoption   ApiConfigAdoption `json:"adoption"`
+	StaleNodes []string          `json:"stale_nodes"`
+}
+
+// ApiValidationRequest to receive requests to validate osquery configuration
+// If section is empty, data is the full configuration, otherwise it is that part of it
+type ApiValidationRequest struct {
+	Section string `json:"section"`
+	Data    string `json:"data"`
+}
+
+// ApiConfigIssue to describe one problem found validating osquery configuration
+type ApiConfigIssue struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// ApiValidationResponse to be returned to API requests to validate osquery configuration
+type ApiValidationResponse struct {
+	Valid  bool             `json:"valid"`
+	Issues []ApiConfigIssue `json:"issues"`
+}
+
+// ApiPackSourceRequest to receive requests to manage pack sources of an environment
+type ApiPackSourceRequest struct {
+	Name     string `json:"name"`
+	Source   string `json:"source"`
+	Interval int    `json:"interval"`
+}
+
+// ApiPlatformFlagsRequest to receive requests to manage the flags overrides per platform of an environment
+type ApiPlatformFlagsRequest struct {
+	Platform string `json:"platform"`
+	Flags    string `json:"flags"`
+}
+
+// ApiSchedulePerfRequest to receive requests to collect the performance of scheduled queries
+type ApiSchedulePerfRequest struct {
+	Interval int `json:"interval"`
+}
+
+// ApiSchedulePerf to hold the performance of one scheduled query aggregated for all nodes in an environment
+// Times are in milliseconds and memory in bytes
+type ApiSchedulePerf struct {
+	Name          string  `json:"name"`
+	Interval      int     `json:"interval"`
+	Nodes         int     `json:"nodes"`
+	Executions    int64   `json:"executions"`
+	Denylisted    int     `json:"denylisted"`
+	OutputSize    int64   `json:"output_size"`
+	WallTimeMs    int64   `json:"wall_time_ms"`
+	AvgWallTimeMs float64 `json:"avg_wall_time_ms"`
+	UserTime      int64   `json:"user_time"`
+	SystemTime    int64   `json:"system_time"`
+	AverageMemory int64   `json:"average_memory"`
+	MaxMemory     int64   `json:"max_memory"`
+}
+
+// ApiSchedulePerfResponse to be returned to API requests for the performance of scheduled queries
+type ApiSchedulePerfResponse struct {
+	Enabled bool              `json:"enabled"`
+	Queries []ApiSchedulePerf `json:"queries"`
+}
+
+// ApiRolloutRequest to receive staged configuration rollout requests
+type ApiRolloutRequest struct {
+	Base       int    `json:"base"`
+	Percentage int    `json:"percentage"`
+	Tag        string `json:"tag"`
+}
+
+// ApiRolloutResponse to be returned to API requests for the status of a staged configuration rollout
+type ApiRolloutResponse struct {
+	Status       string            `json:"status"`
+	BaseRevision int               `json:"base_revision"`
+	Percentage   int               `json:"percentage"`
+	Tag          string            `json:"tag"`
+	CreatedBy    string            `json:"created_by"`
+	Started      time.Time         `json:"started"`
+	Canary       ApiConfigAdoption `json:"canary"`
+	Others       ApiConfigAdoption `json:"others"`
+}
+
+// ApiNodeBulkRequest to receive bulk node action requests by selector
+type ApiNodeBulkRequest struct {
+	Action   string `json:"action"`
+	Platform string `json:"platform"`
+	Tag      string `json:"tag"`
+	Inactive int64  `json:"inactive"`
+	Value    string `json:"value"`
+	Token    string `json:"token"`
+}
+
 // ApiLoginRequest to receive login requests
 type ApiLoginRequest struct {
 	Username string `json:"username"`
@@ -182,6 +310,30 @@ type ApiDataResponse struct {
 	Data string `json:"data"`
 }
 
+// ApiNodeBulkPreviewResponse to be returned to API requests previewing a bulk node action
+type ApiNodeBulkPreviewResponse struct {
+	Action string   `json:"action"`
+	Count  int      `json:"count"`
+	Token  string   `json:"token"`
+	UUIDs  []string `json:"uuid_list"`
+}
+
+// ApiNodeBulkResult to report the result of a bulk node action for one node
+type ApiNodeBulkResult struct {
+	UUID     string `json:"uuid"`
+	Hostname string `json:"hostname"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ApiNodeBulkResponse to be returned to API requests executing a bulk node action
+type ApiNodeBulkResponse struct {
+	Action  string              `json:"action"`
+	Count   int                 `json:"count"`
+	Failed  int                 `json:"failed"`
+	Results []ApiNodeBulkResult `json:"results"`
+}
+
 // ApiLoginResponse to be returned to API login requests with the generated token
 type ApiLoginResponse struct {
 	Token string `json:"token"`
@@ -194,4 +346,9 @@ type ApiActionsRequest struct {
 	MsiPkgURL   string `json:"url_msi_pkg"`
 	RpmPkgURL   string `json:"url_rpm_pkg"`
 	DebPkgURL   string `json:"url_deb_pkg"`
+	Token       string `json:"token"`
+	MaxUses     int    `json:"max_uses"`
+	ExpHours    int    `json:"exp_hours"`
+	Comment     string `json:"comment"`
+	ClientCA    string `json:"client_ca"`
 }
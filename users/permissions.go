@@ -353,3 +353,46 @@ func (m *UserManager) DeleteAllPermissions(username string) error {
 	}
 	return nil
 }
+
+// CopyEnvPermissions to grant all users with permissions in an environment the same permissions in another environment
+// Users that already have permissions in the destination environment are skipped
+func (m *UserManager) CopyEnvPermissions(srcEnv, dstEnv environments.TLSEnvironment, granted string) error {
+	perms, err := m.GetEnvAllPermissions(srcEnv.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting permissions for %s - %v", srcEnv.Name, err)
+	}
+	return m.GrantEnvPermissions(perms, dstEnv, granted)
+}
+
+// GrantEnvPermissions to create permissions with the given values in an environment
+// Users that do not exist or already have permissions in the environment are skipped
+func (m *UserManager) GrantEnvPermissions(perms []UserPermission, env environments.TLSEnvironment, granted string) error {
+	var existing []string
+	if err := m.DB.Model(&UserPermission{}).Where("environment = ?", env.UUID).Distinct().Pluck("username", &existing).Error; err != nil {
+		return fmt.Errorf("error getting permissions for %s - %v", env.Name, err)
+	}
+	skip := make(map[string]bool, len(existing))
+	for _, u := range existing {
+		skip[u] = true
+	}
+	for _, p := range perms {
+		if skip[p.Username] || !m.Exists(p.Username) {
+			continue
+		}
+		perm := m.GenUserPermission(p.Username, granted, env.UUID, p.AccessType, p.AccessValue)
+		perm.EnvironmentID = env.ID
+		if err := m.CreatePermission(perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEnvAllPermissions to extract permissions of all users in an environment
+func (m *UserManager) GetEnvAllPermissions(environment string) ([]UserPermission, error) {
+	var perms []UserPermission
+	if err := m.DB.Where("environment = ?", environment).Order("username").Find(&perms).Error; err != nil {
+		return perms, err
+	}
+	return perms, nil
+}